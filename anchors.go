@@ -0,0 +1,190 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keepAnchors enables writing the anchors and aliases of the input files to the output rather than expanding them, see --anchors
+var keepAnchors = false
+
+// anchors records the anchors, aliases, and merge keys of the input files by key path
+type anchors struct {
+	// names maps the key paths of anchored nodes to their anchor names
+	names map[string]string
+	// aliases maps the key paths of aliases to the key paths of their anchors
+	aliases map[string]string
+	// merges maps the key paths of mappings with a merge key to the key paths of the merged anchors, in order
+	merges map[string][]string
+	// paths maps the anchored nodes of the input files to their key paths
+	paths map[*yaml.Node]string
+	// written maps the key paths of anchors to the nodes written during an encoding, so aliases only refer to earlier anchors
+	written map[string]*yaml.Node
+	// used are the anchor names written during an encoding, and whether an alias refers to them
+	used map[string]bool
+}
+
+func newAnchors() anchors {
+	return anchors{names: map[string]string{}, aliases: map[string]string{}, merges: map[string][]string{}, paths: map[*yaml.Node]string{}}
+}
+
+// recordAnchor records the anchor of a node of an input file, or the anchor an alias refers to.
+// Anchors are recorded where they are defined, not where an alias repeats them.
+func (a anchors) recordAnchor(prefix string, node *yaml.Node) {
+	if _, ok := a.paths[node]; !ok && len(node.Anchor) > 0 {
+		a.names[prefix] = node.Anchor
+		a.paths[node] = prefix
+	}
+	if node.Kind == yaml.AliasNode {
+		if path, ok := a.paths[node.Alias]; ok {
+			a.aliases[prefix] = path
+		}
+	}
+}
+
+// recordMerge records the anchors merged into a mapping by the value of its merge key, a single alias or a list of them
+func (a anchors) recordMerge(prefix string, value *yaml.Node) {
+	aliases := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		aliases = value.Content
+	}
+	paths := []string{}
+	for _, alias := range aliases {
+		path, ok := a.paths[alias.Alias]
+		if alias.Kind != yaml.AliasNode || !ok {
+			return
+		}
+		paths = append(paths, path)
+	}
+	a.merges[prefix] = paths
+}
+
+// start resets the anchors written by the previous encoding
+func (a *anchors) start() {
+	a.written = map[string]*yaml.Node{}
+	a.used = map[string]bool{}
+}
+
+// alias replaces a node with an alias to its anchor if the anchor was written before and the node still has the value of the anchor
+func (a anchors) alias(node *yaml.Node, prefix string) bool {
+	anchor, ok := a.written[a.aliases[prefix]]
+	if !ok || !sameValue(node, anchor) {
+		return false
+	}
+	*node = yaml.Node{
+		Kind:        yaml.AliasNode,
+		Alias:       anchor,
+		Value:       anchor.Anchor,
+		HeadComment: node.HeadComment,
+		LineComment: node.LineComment,
+		FootComment: node.FootComment,
+	}
+	a.used[anchor.Anchor] = true
+	return true
+}
+
+// merge removes the keys of a mapping which have the values merged from the anchors of its merge key, and returns the merge key.
+// The mapping is only merged if the anchors were written before and it has all their keys, since the merge key would add them again.
+func (a anchors) merge(node *yaml.Node, prefix string) []*yaml.Node {
+	merged := map[string]*yaml.Node{}
+	aliases := []*yaml.Node{}
+	for _, path := range a.merges[prefix] {
+		anchor, ok := a.written[path]
+		if !ok || anchor.Kind != yaml.MappingNode {
+			return nil
+		}
+		// The first anchor with a key takes precedence
+		for i := 0; i+1 < len(anchor.Content); i += 2 {
+			if _, ok := merged[anchor.Content[i].Value]; !ok {
+				merged[anchor.Content[i].Value] = anchor.Content[i+1]
+			}
+		}
+		aliases = append(aliases, &yaml.Node{Kind: yaml.AliasNode, Alias: anchor, Value: anchor.Anchor})
+	}
+	if len(aliases) == 0 {
+		return nil
+	}
+	values := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		values[node.Content[i].Value] = node.Content[i+1]
+	}
+	for key := range merged {
+		if _, ok := values[key]; !ok {
+			return nil
+		}
+	}
+	content := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if anchor, ok := merged[key.Value]; ok && sameValue(value, anchor) {
+			continue
+		}
+		content = append(content, key, value)
+	}
+	node.Content = content
+	for _, alias := range aliases {
+		a.used[alias.Value] = true
+	}
+	value := aliases[0]
+	if len(aliases) > 1 {
+		value = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle, Content: aliases}
+	}
+	// Without a tag, the encoder writes the merge key as it is rather than as !!merge
+	return []*yaml.Node{{Kind: yaml.ScalarNode, Value: "<<"}, value}
+}
+
+// write sets the anchor of a node which was anchored in an input file, renamed if an earlier anchor has the same name
+func (a anchors) write(node *yaml.Node, prefix string) {
+	name, ok := a.names[prefix]
+	if !ok {
+		return
+	}
+	for i := 2; ; i++ {
+		if _, taken := a.used[name]; !taken {
+			break
+		}
+		name = fmt.Sprintf("%s%d", a.names[prefix], i)
+	}
+	node.Anchor = name
+	a.used[name] = false
+	a.written[prefix] = node
+}
+
+// finish removes the anchors no alias refers to
+func (a anchors) finish() {
+	for _, node := range a.written {
+		if !a.used[node.Anchor] {
+			node.Anchor = ""
+		}
+	}
+}
+
+// sameValue reports whether two nodes decode to the same value
+func sameValue(a *yaml.Node, b *yaml.Node) bool {
+	var aValue, bValue interface{}
+	if err := a.Decode(&aValue); err != nil {
+		return false
+	}
+	if err := b.Decode(&bValue); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(aValue, bValue)
+}
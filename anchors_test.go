@@ -0,0 +1,73 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/imdario/mergo"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEncodeAnchors verifies that anchors, aliases, and merge keys are kept for values no later file or transform changed
+func TestEncodeAnchors(t *testing.T) {
+	keepAnchors = true
+	defer func() { keepAnchors = false }()
+
+	sources := newProvenance()
+	data := map[string]interface{}{}
+	for i, content := range []string{
+		"defaults: &defaults\n  image: app\n  replicas: 1\nweb:\n  <<: *defaults\n  port: 80\nworker:\n  <<: *defaults\n  replicas: 3\nports: &ports [80, 443]\npublic: *ports\nadmin: *ports\n",
+		"public: [8080]\nworker:\n  image: worker\n",
+	} {
+		mergeData, document, err := parseInput(fmt.Sprintf("%d.yaml", i+1), []byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, mergo.Merge(&data, mergeData, mergo.WithOverride))
+		sources.addFile(fmt.Sprintf("%d.yaml", i+1), []byte(content))
+		sources.record(fmt.Sprintf("%d.yaml", i+1), "", mergeData, data)
+		sources.recordKeys("", document)
+	}
+
+	assert.Equal(t, []string{"2.yaml"}, sources.finalSources("worker.image"), "mappings with merge keys are merged key by key")
+
+	node, err := sources.encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `defaults: &defaults
+    image: app
+    replicas: 1
+web:
+    <<: *defaults
+    port: 80
+worker:
+    <<: *defaults
+    image: worker
+    replicas: 3
+ports: &ports
+    - 80
+    - 443
+public:
+    - 8080
+admin: *ports
+`, renderOutput(node, true, false))
+
+	// The anchor is written after the alias with sorted keys, so the value is expanded
+	sources.sortKeys()
+	node, err = sources.encode(data)
+	assert.NoError(t, err)
+	assert.NotContains(t, renderOutput(node, true, false), "*ports")
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/pkg/errors"
+)
+
+// awsClient calls the JSON APIs of AWS services, e.g. SSM Parameter Store and Secrets Manager.
+// The region and credentials are loaded by the AWS SDK on the first call.
+type awsClient struct {
+	region     string
+	profile    string
+	endpoint   string
+	config     *aws.Config
+	client     aws.HTTPClient
+	now        func() time.Time
+	parameters map[string]string
+	secrets    map[string]string
+}
+
+// newAWSClient configures the client with the --aws flags, which take precedence over the configuration of the AWS SDK
+func newAWSClient(cfg config) *awsClient {
+	return &awsClient{
+		region:     cfg.awsRegion,
+		profile:    cfg.awsProfile,
+		endpoint:   cfg.awsEndpoint,
+		client:     awshttp.NewBuildableClient().WithTimeout(30 * time.Second),
+		now:        time.Now,
+		parameters: map[string]string{},
+		secrets:    map[string]string{},
+	}
+}
+
+// load loads the configuration like the AWS CLI does, from the environment variables and the shared config and
+// credentials files. Credentials are those of the environment, the profile, including roles, SSO, and credential
+// processes, web identity, the container credentials endpoint, or the instance profile, in this order.
+func (a *awsClient) load() (aws.Config, error) {
+	if a.config != nil {
+		return *a.config, nil
+	}
+	options := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(a.client)}
+	if len(a.region) > 0 {
+		options = append(options, awsconfig.WithRegion(a.region))
+	}
+	if len(a.profile) > 0 {
+		options = append(options, awsconfig.WithSharedConfigProfile(a.profile))
+	}
+	loaded, err := awsconfig.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return aws.Config{}, errors.Wrap(err, "Error loading AWS configuration")
+	}
+	if len(loaded.Region) == 0 {
+		return aws.Config{}, errors.New("no AWS region, set --aws.region, AWS_REGION, or the region of the profile")
+	}
+	// The client of the configuration trusts the certificates of AWS_CA_BUNDLE
+	a.region, a.client = loaded.Region, loaded.HTTPClient
+	a.config = &loaded
+	return loaded, nil
+}
+
+// sign adds the authorization of the credentials to a request to a service
+func (a *awsClient) sign(request *http.Request, body []byte, service string) error {
+	loaded, err := a.load()
+	if err != nil {
+		return err
+	}
+	credentials, err := loaded.Credentials.Retrieve(request.Context())
+	if err != nil {
+		return errors.Wrap(err, "Error finding AWS credentials")
+	}
+	return signAWSRequest(request, body, credentials, a.region, service, a.now())
+}
+
+// call sends a request to an AWS JSON API, e.g. target 'AmazonSSM.GetParameter' of service 'ssm'
+func (a *awsClient) call(service string, target string, input interface{}, output interface{}) error {
+	if _, err := a.load(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	endpoint := a.endpoint
+	if len(endpoint) == 0 {
+		endpoint = "https://" + service + "." + a.region + ".amazonaws.com"
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", target)
+	if err := a.sign(request, body, service); err != nil {
+		return err
+	}
+
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&failure)
+		// The type is qualified by a namespace, e.g. 'com.amazonaws.ssm#ParameterNotFound'
+		kind := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+		return fmt.Errorf("%s: %s %s", response.Status, kind, failure.Message)
+	}
+	return json.NewDecoder(response.Body).Decode(output)
+}
+
+// signAWSRequest adds the Signature Version 4 authorization of the credentials to a request,
+// signing the host and all headers set on the request. Paths of S3 are signed as they are escaped.
+func signAWSRequest(request *http.Request, body []byte, credentials aws.Credentials, region string, service string, now time.Time) error {
+	bodyHash := sha256.Sum256(body)
+	signer := v4.NewSigner(func(options *v4.SignerOptions) {
+		options.DisableURIPathEscaping = service == "s3"
+	})
+	return signer.SignHTTP(request.Context(), credentials, request, hex.EncodeToString(bodyHash[:]), service, region, now)
+}
+
+// resolveParameter returns the decrypted value of an SSM parameter by name, e.g. '/app/database/password'
+func (a *awsClient) resolveParameter(name string) (string, error) {
+	if value, ok := a.parameters[name]; ok {
+		return value, nil
+	}
+	var output struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	err := a.call("ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true}, &output)
+	if err != nil {
+		return "", err
+	}
+	a.parameters[name] = output.Parameter.Value
+	return output.Parameter.Value, nil
+}
+
+// resolveSecret returns a Secrets Manager secret as <name>[#<key>].
+// With a key, the secret string is a JSON object and the value of the key is returned.
+func (a *awsClient) resolveSecret(reference string) (string, error) {
+	name, key, withKey := strings.Cut(reference, "#")
+	secret, ok := a.secrets[name]
+	if !ok {
+		var output struct {
+			SecretString string `json:"SecretString"`
+		}
+		if err := a.call("secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": name}, &output); err != nil {
+			return "", err
+		}
+		secret = output.SecretString
+		a.secrets[name] = secret
+	}
+	if !withKey {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", errors.Wrap(err, "secret is not a JSON object")
+	}
+	value, ok := fields[key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("key %s not found", key)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignAWSRequest verifies the signature against the get-vanilla case of the AWS Signature Version 4 test suite
+func TestSignAWSRequest(t *testing.T) {
+	request, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	credentials := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	assert.NoError(t, signAWSRequest(request, nil, credentials, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		request.Header.Get("Authorization"))
+}
+
+// useAWS hides the AWS configuration of the machine from the test, returning a directory for the shared config files
+func useAWS(t *testing.T) string {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_DEFAULT_PROFILE",
+		"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ROLE_ARN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_ENDPOINT_URL", "AWS_ENDPOINT_URL_STS", "AWS_EC2_METADATA_SERVICE_ENDPOINT"} {
+		// The AWS SDK checks whether some of them are set at all
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+// loadAWSCredentials returns the credentials the client signs requests with
+func loadAWSCredentials(t *testing.T, client *awsClient) (aws.Credentials, error) {
+	loaded, err := client.load()
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return loaded.Credentials.Retrieve(context.Background())
+}
+
+// fakeAWS serves an SSM parameter and a Secrets Manager secret, counting the calls of each target
+func fakeAWS(t *testing.T, calls map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		target := r.Header.Get("X-Amz-Target")
+		calls[target]++
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"__type":"UnrecognizedClientException","message":"The security token included in the request is invalid."}`)
+			return
+		}
+		switch {
+		case target == "AmazonSSM.GetParameter" && input["Name"] == "/app/database/password" && input["WithDecryption"] == true:
+			fmt.Fprint(w, `{"Parameter":{"Name":"/app/database/password","Type":"SecureString","Value":"s3cr3t"}}`)
+		case target == "secretsmanager.GetSecretValue" && input["SecretId"] == "app/api":
+			fmt.Fprint(w, `{"Name":"app/api","SecretString":"{\"key\":\"abc\",\"port\":443}"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"com.amazonaws.ssm#ParameterNotFound","message":"Parameter not found."}`)
+		}
+	}))
+}
+
+// TestResolveAWS verifies that SSM parameters and keys of Secrets Manager secrets are replaced and every one is read once
+func TestResolveAWS(t *testing.T) {
+	calls := map[string]int{}
+	server := fakeAWS(t, calls)
+	defer server.Close()
+	useAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "us-east-1")
+	cfg := cfgDefaults
+	cfg.awsEndpoint = server.URL
+	output := resolveSecretsIn(t, `password: ${ssm:/app/database/password}
+again: ${ssm:/app/database/password}
+key: ${aws-sm:app/api#key}
+port: ${aws-sm:app/api#port}
+api: '${aws-sm:app/api}'
+missing: ${ssm:/app/missing}
+`, newSecretResolvers(cfg), false)
+	assert.Equal(t, `password: s3cr3t
+again: s3cr3t
+key: abc
+port: "443"
+api: '{"key":"abc","port":443}'
+missing: ${ssm:/app/missing}
+`, output)
+	assert.Equal(t, map[string]int{"AmazonSSM.GetParameter": 2, "secretsmanager.GetSecretValue": 1}, calls)
+
+	_, err := newAWSClient(cfg).resolveParameter("/app/missing")
+	assert.EqualError(t, err, "400 Bad Request: ParameterNotFound Parameter not found.")
+}
+
+// TestAWSSharedCredentials verifies that the profile of the shared credentials file is read,
+// and that --aws.profile takes precedence over the keys of the environment
+func TestAWSSharedCredentials(t *testing.T) {
+	dir := useAWS(t)
+	content := "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = default\n\n[ci]\naws_access_key_id = AKID\naws_secret_access_key = SECRET\naws_session_token = session\n"
+	if err := os.WriteFile(filepath.Join(dir, "credentials"), []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing credentials file: %v", err)
+	}
+	t.Setenv("AWS_PROFILE", "ci")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	credentials, err := loadAWSCredentials(t, newAWSClient(cfgDefaults))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AKID", "SECRET", "session"}, []string{credentials.AccessKeyID, credentials.SecretAccessKey, credentials.SessionToken})
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVIRONMENT")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "environment")
+	t.Setenv("AWS_PROFILE", "")
+	cfg := cfgDefaults
+	cfg.awsProfile = "ci"
+	credentials, err = loadAWSCredentials(t, newAWSClient(cfg))
+	assert.NoError(t, err)
+	assert.Equal(t, "AKID", credentials.AccessKeyID)
+
+	cfg.awsProfile = "missing"
+	_, err = newAWSClient(cfg).load()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "missing")
+	}
+}
+
+// fakeSTS serves the credentials of the roles assumed with a web identity or other credentials
+func fakeSTS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		action := r.PostForm.Get("Action")
+		switch {
+		case action == "AssumeRoleWithWebIdentity" && r.PostForm.Get("WebIdentityToken") == "service-account-token" &&
+			r.PostForm.Get("RoleArn") == "arn:aws:iam::123456789012:role/app":
+		case action == "AssumeRole" && strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=BASE/") &&
+			r.PostForm.Get("RoleArn") == "arn:aws:iam::123456789012:role/app":
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>Not authorized</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprintf(w, `<%sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%sResult><Credentials>
+<AccessKeyId>AKID</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey><SessionToken>session</SessionToken>
+<Expiration>%s</Expiration></Credentials></%sResult></%sResponse>`, action, action, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), action, action)
+	}))
+}
+
+// TestAWSProfileCredentials verifies that roles, credential processes, and the region of the shared config file are used
+func TestAWSProfileCredentials(t *testing.T) {
+	sts := fakeSTS(t)
+	defer sts.Close()
+	process := fakeBinary(t, "credentials", `echo '{"Version":1,"AccessKeyId":"AKID","SecretAccessKey":"SECRET","SessionToken":"session"}'`)
+	for name, test := range map[string]struct {
+		config string
+		env    map[string]string
+	}{
+		"credential process": {
+			config: "[profile ci]\nregion = eu-west-1\ncredential_process = " + process + "\n",
+			env:    map[string]string{"AWS_PROFILE": "ci"},
+		},
+		"assume role": {
+			config: "[profile base]\naws_access_key_id = BASE\naws_secret_access_key = base\n\n" +
+				"[profile ci]\nregion = eu-west-1\nrole_arn = arn:aws:iam::123456789012:role/app\nsource_profile = base\n",
+			env: map[string]string{"AWS_PROFILE": "ci"},
+		},
+		"web identity": {
+			config: "[default]\nregion = eu-west-1\n",
+			env: map[string]string{
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/app",
+				"AWS_WEB_IDENTITY_TOKEN_FILE": "token",
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := useAWS(t)
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "config"), []byte(test.config), 0600))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("service-account-token"), 0600))
+			t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+			for key, value := range test.env {
+				if key == "AWS_WEB_IDENTITY_TOKEN_FILE" {
+					value = filepath.Join(dir, value)
+				}
+				t.Setenv(key, value)
+			}
+
+			client := newAWSClient(cfgDefaults)
+			credentials, err := loadAWSCredentials(t, client)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"AKID", "SECRET", "session"}, []string{credentials.AccessKeyID, credentials.SecretAccessKey, credentials.SessionToken})
+			assert.Equal(t, "eu-west-1", client.region)
+		})
+	}
+}
+
+// TestAWSInstanceCredentials verifies that the credentials of the instance profile are requested with an IMDSv2 token
+func TestAWSInstanceCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Header().Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+			fmt.Fprint(w, "imds-token")
+		case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "app-role")
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/app-role":
+			fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"AKID","SecretAccessKey":"SECRET","Token":"session","Expiration":"%s"}`,
+				time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	useAWS(t)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "false")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	credentials, err := loadAWSCredentials(t, newAWSClient(cfgDefaults))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AKID", "SECRET", "session"}, []string{credentials.AccessKeyID, credentials.SecretAccessKey, credentials.SessionToken})
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+)
+
+var (
+	// azureKeyVaultAddress is the address of a key vault by name
+	azureKeyVaultAddress = "https://%s.vault.azure.net"
+	// azureTransport sends the requests for credentials, secrets, and blobs
+	azureTransport = http.DefaultTransport
+)
+
+// Resources of access tokens for Key Vault and Blob Storage
+const (
+	azureKeyVaultResource = "https://vault.azure.net"
+	azureStorageResource  = "https://storage.azure.com"
+)
+
+// azureClient reads secrets from Azure Key Vault, or blobs from Blob Storage, with the credentials of DefaultAzureCredential.
+// Its access tokens are for a single resource.
+type azureClient struct {
+	resource string
+	token    string
+	tokenErr error
+	client   *http.Client
+	secrets  map[string]string
+}
+
+func newAzureClient(resource string) *azureClient {
+	return &azureClient{
+		resource: resource,
+		client:   &http.Client{Timeout: 30 * time.Second, Transport: azureTransport},
+		secrets:  map[string]string{},
+	}
+}
+
+// accessToken returns an access token for the resource of the first available credential of DefaultAzureCredential:
+// a client secret or certificate of the environment, workload identity, a managed identity, or the Azure CLI
+func (a *azureClient) accessToken() (string, error) {
+	if len(a.token) > 0 || a.tokenErr != nil {
+		return a.token, a.tokenErr
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: a.client},
+	})
+	if err != nil {
+		a.tokenErr = errors.Wrap(err, "Error finding Azure credentials")
+		return "", a.tokenErr
+	}
+	token, err := credential.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{a.resource + "/.default"}})
+	if err != nil {
+		a.tokenErr = errors.Wrap(err, "Error requesting Azure token")
+		return "", a.tokenErr
+	}
+	a.token = token.Token
+	return a.token, nil
+}
+
+// resolveSecret returns the value of a Key Vault secret as <vault>/<secret>[/<version>], the latest version by default
+func (a *azureClient) resolveSecret(reference string) (string, error) {
+	parts := strings.Split(reference, "/")
+	if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", fmt.Errorf("expected <vault>/<secret>[/<version>]")
+	}
+	if value, ok := a.secrets[reference]; ok {
+		return value, nil
+	}
+	token, err := a.accessToken()
+	if err != nil {
+		return "", err
+	}
+	address := fmt.Sprintf(azureKeyVaultAddress, parts[0]) + "/secrets/" + strings.Join(parts[1:], "/") + "?api-version=7.4"
+	request, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := a.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var result struct {
+		Value string `json:"value"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&result)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", response.Status, result.Error.Message)
+	}
+	a.secrets[reference] = result.Value
+	return result.Value, nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKeyVault serves the secret api of the key vault demo for the access token, counting the reads
+func fakeKeyVault(t *testing.T, token string, reads map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reads[r.URL.Path]++
+		switch {
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"code":"Unauthorized","message":"AKV10000: Request is missing a Bearer or PoP token."}}`)
+		case r.URL.Path == "/demo/secrets/api" && r.URL.Query().Get("api-version") == "7.4":
+			fmt.Fprint(w, `{"value":"s3cr3t","id":"https://demo.vault.azure.net/secrets/api/1"}`)
+		case r.URL.Path == "/demo/secrets/api/1":
+			fmt.Fprint(w, `{"value":"old"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":"SecretNotFound","message":"A secret with (name/id) missing was not found in this key vault."}}`)
+		}
+	}))
+}
+
+// azureHosts sends the requests for hosts to the addresses of fakes instead
+type azureHosts map[string]string
+
+func (h azureHosts) RoundTrip(request *http.Request) (*http.Response, error) {
+	if address, ok := h[request.URL.Host]; ok {
+		target, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.URL.Scheme, request.URL.Host, request.Host = target.Scheme, target.Host, ""
+	}
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// useAzure points the clients at fakes for the test, clearing the credentials of the environment.
+// The instance metadata service is unavailable unless a fake is given for it.
+func useAzure(t *testing.T, hosts azureHosts) {
+	if _, ok := hosts["169.254.169.254"]; !ok {
+		hosts["169.254.169.254"] = "http://127.0.0.1:1"
+	}
+	previous := azureTransport
+	azureTransport = hosts
+	t.Cleanup(func() { azureTransport = previous })
+	for _, name := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_CLIENT_CERTIFICATE_PATH",
+		"AZURE_USERNAME", "AZURE_FEDERATED_TOKEN_FILE", "AZURE_AUTHORITY_HOST", "IDENTITY_ENDPOINT", "IDENTITY_HEADER", "MSI_ENDPOINT", "IMDS_ENDPOINT"} {
+		// Azure SDK checks whether some of them are set at all
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+// useKeyVault points the client at a fake key vault and the hosts of credentials at fakes for the test
+func useKeyVault(t *testing.T, keyVault *httptest.Server, hosts azureHosts) {
+	previous := azureKeyVaultAddress
+	azureKeyVaultAddress = keyVault.URL + "/%s"
+	t.Cleanup(func() { azureKeyVaultAddress = previous })
+	useAzure(t, hosts)
+}
+
+// fakeAuthority serves the token endpoint of the tenant for the client credentials which check accepts
+func fakeAuthority(t *testing.T, check func(form url.Values) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/tenant/v2.0/.well-known/openid-configuration":
+			fmt.Fprint(w, `{"token_endpoint":"https://login.microsoftonline.com/tenant/oauth2/v2.0/token",
+"authorization_endpoint":"https://login.microsoftonline.com/tenant/oauth2/v2.0/authorize",
+"issuer":"https://login.microsoftonline.com/tenant/v2.0"}`)
+		case r.URL.Path == "/tenant/oauth2/v2.0/token" && r.ParseForm() == nil && r.PostForm.Get("client_id") == "client" &&
+			strings.Contains(r.PostForm.Get("scope"), "https://vault.azure.net/.default") && check(r.PostForm):
+			fmt.Fprint(w, `{"token_type":"Bearer","access_token":"client-token","expires_in":3600}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided."}`)
+		}
+	}))
+}
+
+// writeCertificate writes a self-signed certificate with its private key, like the one of an app registration
+func writeCertificate(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hierarchy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "client.pem")
+	content := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...)
+	assert.NoError(t, os.WriteFile(file, content, 0600))
+	return file
+}
+
+// TestResolveAzureClientSecret verifies that secrets are read with the client secret of the environment
+func TestResolveAzureClientSecret(t *testing.T) {
+	authority := fakeAuthority(t, func(form url.Values) bool { return form.Get("client_secret") == "secret" })
+	defer authority.Close()
+	reads := map[string]int{}
+	keyVault := fakeKeyVault(t, "client-token", reads)
+	defer keyVault.Close()
+	useKeyVault(t, keyVault, azureHosts{"login.microsoftonline.com": authority.URL})
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_CLIENT_SECRET", "secret")
+
+	output := resolveSecretsIn(t, `password: ${akv:demo/api}
+again: ${akv:demo/api}
+old: ${akv:demo/api/1}
+missing: ${akv:demo/missing}
+`, newSecretResolvers(cfgDefaults), false)
+	assert.Equal(t, `password: s3cr3t
+again: s3cr3t
+old: old
+missing: ${akv:demo/missing}
+`, output)
+	assert.Equal(t, 1, reads["/demo/secrets/api"])
+
+	_, err := newAzureClient(azureKeyVaultResource).resolveSecret("demo/missing")
+	assert.EqualError(t, err, "404 Not Found: A secret with (name/id) missing was not found in this key vault.")
+	_, err = newAzureClient(azureKeyVaultResource).resolveSecret("demo")
+	assert.EqualError(t, err, "expected <vault>/<secret>[/<version>]")
+
+	t.Setenv("AZURE_CLIENT_SECRET", "wrong")
+	_, err = newAzureClient(azureKeyVaultResource).resolveSecret("demo/api")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Error requesting Azure token")
+		assert.Contains(t, err.Error(), "AADSTS7000215: Invalid client secret provided.")
+	}
+}
+
+// TestResolveAzureServicePrincipal verifies that the certificate and the federated token of workload identity are used
+func TestResolveAzureServicePrincipal(t *testing.T) {
+	token := filepath.Join(t.TempDir(), "azure-identity-token")
+	assert.NoError(t, os.WriteFile(token, []byte("service-account-token"), 0600))
+	for name, test := range map[string]struct {
+		env   map[string]string
+		check func(form url.Values) bool
+	}{
+		"certificate": {
+			env: map[string]string{"AZURE_CLIENT_CERTIFICATE_PATH": writeCertificate(t)},
+			check: func(form url.Values) bool {
+				return form.Get("client_assertion_type") == "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" &&
+					strings.Count(form.Get("client_assertion"), ".") == 2
+			},
+		},
+		"workload identity": {
+			env: map[string]string{"AZURE_FEDERATED_TOKEN_FILE": token, "AZURE_AUTHORITY_HOST": "https://login.microsoftonline.com/"},
+			check: func(form url.Values) bool {
+				return form.Get("client_assertion") == "service-account-token"
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			authority := fakeAuthority(t, test.check)
+			defer authority.Close()
+			keyVault := fakeKeyVault(t, "client-token", map[string]int{})
+			defer keyVault.Close()
+			useKeyVault(t, keyVault, azureHosts{"login.microsoftonline.com": authority.URL})
+			t.Setenv("AZURE_TENANT_ID", "tenant")
+			t.Setenv("AZURE_CLIENT_ID", "client")
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			value, err := newAzureClient(azureKeyVaultResource).resolveSecret("demo/api")
+			assert.NoError(t, err)
+			assert.Equal(t, "s3cr3t", value)
+		})
+	}
+}
+
+// TestResolveAzureManagedIdentity verifies that the managed identity of the instance metadata service is used
+func TestResolveAzureManagedIdentity(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != "https://vault.azure.net" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"managed-identity-token","expires_in":"3599","token_type":"Bearer"}`)
+	}))
+	defer imds.Close()
+	keyVault := fakeKeyVault(t, "managed-identity-token", map[string]int{})
+	defer keyVault.Close()
+	useKeyVault(t, keyVault, azureHosts{"169.254.169.254": imds.URL})
+
+	value, err := newAzureClient(azureKeyVaultResource).resolveSecret("demo/api")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+}
+
+// TestResolveAzureCLI verifies that the token of the Azure CLI is used without a managed identity
+func TestResolveAzureCLI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	keyVault := fakeKeyVault(t, "cli-token", map[string]int{})
+	defer keyVault.Close()
+	useKeyVault(t, keyVault, azureHosts{})
+	script := fakeBinary(t, "az", `[ "$*" = "account get-access-token -o json --resource https://vault.azure.net" ] || { echo "unexpected arguments: $*" >&2; exit 2; }
+echo '{"accessToken":"cli-token","expiresOn":"2099-01-01 00:00:00.000000","tokenType":"Bearer"}'
+`)
+	t.Setenv("PATH", filepath.Dir(script)+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	value, err := newAzureClient(azureKeyVaultResource).resolveSecret("demo/api")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+}
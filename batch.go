@@ -0,0 +1,204 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// batchBaseEnv and batchOutputEnv pass the base path and output file of a release to the merge child
+	batchBaseEnv   = "HIERARCHY_BATCH_BASE"
+	batchOutputEnv = "HIERARCHY_BATCH_OUTPUT"
+)
+
+// batchManifest lists the releases rendered by the batch command, e.g.
+//
+//	environments: [dev, stage, prod]
+//	releases:
+//	  - name: payments
+//	    base: environments/{environment}/payments
+//	    output: values/{environment}/{release}.yaml
+type batchManifest struct {
+	Environments []string       `yaml:"environments"`
+	Releases     []batchRelease `yaml:"releases"`
+}
+
+// batchRelease is rendered once per environment, its environments override the ones of the manifest
+type batchRelease struct {
+	Name         string            `yaml:"name"`
+	Base         string            `yaml:"base"`
+	Output       string            `yaml:"output"`
+	Environments []string          `yaml:"environments"`
+	Variables    map[string]string `yaml:"variables"`
+}
+
+// batchJob is a release rendered for one environment
+type batchJob struct {
+	release     string
+	environment string
+	base        string
+	output      string
+	variables   map[string]string
+}
+
+// loadBatchManifest reads the manifest and returns its jobs, with paths relative to the manifest
+func loadBatchManifest(path string) ([]batchJob, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest batchManifest
+	if err := yaml.Unmarshal(content, &manifest); err != nil {
+		return nil, newParseError(path, content, err)
+	}
+	return manifest.jobs(filepath.Dir(path))
+}
+
+// jobs expands every release for each of its environments, replacing {release} and {environment}
+// in the base path and output file. A release without environments is rendered once.
+func (m batchManifest) jobs(dir string) ([]batchJob, error) {
+	jobs := []batchJob{}
+	outputs := map[string]string{}
+	for i, release := range m.Releases {
+		if len(release.Name) == 0 || len(release.Base) == 0 || len(release.Output) == 0 {
+			return nil, fmt.Errorf("release %d of the batch manifest needs a name, base, and output", i+1)
+		}
+		environments := release.Environments
+		if len(environments) == 0 {
+			environments = m.Environments
+		}
+		if len(environments) == 0 {
+			environments = []string{""}
+		}
+		for _, environment := range environments {
+			replacer := strings.NewReplacer("{release}", release.Name, "{environment}", environment)
+			job := batchJob{
+				release:     release.Name,
+				environment: environment,
+				base:        replacer.Replace(release.Base),
+				output:      replacer.Replace(release.Output),
+				variables:   release.Variables,
+			}
+			if !filepath.IsAbs(job.base) {
+				job.base = filepath.Join(dir, job.base)
+			}
+			if !filepath.IsAbs(job.output) {
+				job.output = filepath.Join(dir, job.output)
+			}
+			name := strings.Trim(release.Name+"/"+environment, "/")
+			if other, ok := outputs[job.output]; ok {
+				return nil, fmt.Errorf("releases %s and %s write the same output %s", other, name, job.output)
+			}
+			outputs[job.output] = name
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// runBatch renders every release of the manifest in a child process, see runBatchJobs
+func runBatch(cfg config) {
+	jobs, err := loadBatchManifest(cfg.batchManifest)
+	checkForErrorCode(err, exitParse)
+
+	executable, err := os.Executable()
+	checkForError(err)
+	// Every release is merged with the same arguments, only the base path and output file differ
+	command := func(job batchJob) *exec.Cmd {
+		cmd := exec.Command(executable, os.Args[1:]...)
+		cmd.Env = append(os.Environ(),
+			mergeChildEnv+"=1",
+			batchBaseEnv+"="+job.base,
+			batchOutputEnv+"="+job.output,
+			"RELEASE="+job.release,
+			"ENVIRONMENT="+job.environment,
+		)
+		for name, value := range job.variables {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd
+	}
+
+	// The exit code is the one shared by all failed releases, like with --keep-going
+	failures = append(failures, runBatchJobs(jobs, cfg.batchParallel, command)...)
+	exitOnFailures()
+	appLog.Info("Rendered all releases", "count", len(jobs))
+}
+
+// runBatchJobs runs the commands of the jobs, at most parallel at a time, and returns the exit codes of the failed ones.
+// Zero or less runs as many as there are CPUs.
+func runBatchJobs(jobs []batchJob, parallel int, command func(job batchJob) *exec.Cmd) []int {
+	if parallel <= 0 {
+		parallel = runtime.NumCPU()
+	}
+	var (
+		mutex sync.Mutex
+		wait  sync.WaitGroup
+		codes []int
+	)
+	slots := make(chan struct{}, parallel)
+	for _, job := range jobs {
+		wait.Add(1)
+		slots <- struct{}{}
+		go func(job batchJob) {
+			defer func() {
+				<-slots
+				wait.Done()
+			}()
+			appLog.Info("Rendering release",
+				"release", job.release,
+				"environment", job.environment,
+				"base", job.base,
+				"output", job.output,
+			)
+			// Outputs are usually grouped by environment, so their directories are created
+			err := os.MkdirAll(filepath.Dir(job.output), 0755)
+			code := exitWrite
+			if err == nil {
+				err = command(job).Run()
+				code = exitError
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					code = exitErr.ExitCode()
+				}
+			}
+			if err != nil {
+				appLog.Error("Release failed",
+					"release", job.release,
+					"environment", job.environment,
+					"error", err,
+					"exit_code", code,
+				)
+				mutex.Lock()
+				codes = append(codes, code)
+				mutex.Unlock()
+			}
+		}(job)
+	}
+	wait.Wait()
+	return codes
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadBatchManifest verifies that every release is expanded for each of its environments
+func TestLoadBatchManifest(t *testing.T) {
+	jobs, err := loadBatchManifest("testdata/batch/releases.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, []batchJob{
+		{release: "payments", environment: "dev", base: "testdata/batch/dev/payments", output: "testdata/batch/values/dev/payments.yaml"},
+		{release: "payments", environment: "prod", base: "testdata/batch/prod/payments", output: "testdata/batch/values/prod/payments.yaml"},
+		{release: "orders", environment: "prod", base: "testdata/batch/prod/orders", output: "testdata/batch/values/prod/orders.yaml",
+			variables: map[string]string{"REGION": "us-east-1"}},
+	}, jobs)
+}
+
+// TestBatchManifestInvalid verifies that releases must be complete and write different outputs
+func TestBatchManifestInvalid(t *testing.T) {
+	_, err := batchManifest{Releases: []batchRelease{{Name: "payments", Base: "payments"}}}.jobs(".")
+	assert.EqualError(t, err, "release 1 of the batch manifest needs a name, base, and output")
+
+	_, err = batchManifest{
+		Environments: []string{"dev", "prod"},
+		Releases:     []batchRelease{{Name: "payments", Base: "{environment}", Output: "values.yaml"}},
+	}.jobs(".")
+	assert.EqualError(t, err, "releases payments/dev and payments/prod write the same output values.yaml")
+}
+
+// TestRunBatchJobs verifies that every release is merged to its output and failures are reported by exit code.
+// The merges run in child processes of the test binary, like the merge children of batch.
+func TestRunBatchJobs(t *testing.T) {
+	if os.Getenv(mergeChildEnv) == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = os.Getenv(batchBaseEnv)
+		cfg.outputFile = os.Getenv(batchOutputEnv)
+		cfg.annotate = "none"
+		cfg.failMissingPath = true
+		runMerge(cfg)
+		return
+	}
+
+	jobs, err := loadBatchManifest("testdata/batch/releases.yaml")
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	for i := range jobs {
+		jobs[i].output = filepath.Join(dir, jobs[i].environment, jobs[i].release+".yaml")
+	}
+	jobs = append(jobs, batchJob{release: "missing", base: "testdata/batch/missing", output: filepath.Join(dir, "missing.yaml")})
+
+	codes := runBatchJobs(jobs, 2, func(job batchJob) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=TestRunBatchJobs")
+		cmd.Env = append(os.Environ(), mergeChildEnv+"=1", batchBaseEnv+"="+job.base, batchOutputEnv+"="+job.output)
+		for name, value := range job.variables {
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+		return cmd
+	})
+	assert.Equal(t, []int{exitPath}, codes)
+
+	for file, expected := range map[string]string{
+		"dev/payments.yaml":  "replicas: 1\nimage: payments:1.0\n",
+		"prod/payments.yaml": "replicas: 3\nimage: payments:1.0\n",
+		"prod/orders.yaml":   "replicas: 2\nregion: us-east-1\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("Error reading output file: %v", err)
+		}
+		assert.Equal(t, expected, string(content), file)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// externalizeBlobs replaces every string below data longer than threshold bytes, including decoded !!binary values,
+// with the '/'-separated path of a sidecar file in dir named after its key path, and returns the contents of the sidecar files by path.
+// The files are written verbatim, so binary values are written as bytes rather than base64.
+func externalizeBlobs(data map[string]interface{}, threshold int, dir string) (map[string]string, error) {
+	blobs := map[string]string{}
+	for key, value := range data {
+		replaced, err := externalizeValue(key, value, threshold, dir, blobs)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = replaced
+	}
+	return blobs, nil
+}
+
+// externalizeValue returns the value of a key path, or the path of its sidecar file if it is a string longer than threshold bytes
+func externalizeValue(keyPath string, value interface{}, threshold int, dir string, blobs map[string]string) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			replaced, err := externalizeValue(keyPath+"."+key, child, threshold, dir, blobs)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = replaced
+		}
+	case []interface{}:
+		for i, child := range value {
+			replaced, err := externalizeValue(fmt.Sprintf("%s[%d]", keyPath, i), child, threshold, dir, blobs)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = replaced
+		}
+	case string:
+		if len(value) <= threshold {
+			return value, nil
+		}
+		if len(keyPath) == 0 || keyPath == "." || keyPath == ".." || strings.ContainsAny(keyPath, `/\`) {
+			return nil, fmt.Errorf("key path %q cannot be used as the file name of a blob", keyPath)
+		}
+		file := path.Join(filepath.ToSlash(dir), keyPath)
+		blobs[file] = value
+		return file, nil
+	}
+	return value, nil
+}
+
+// writeBlobs writes the sidecar files of externalized values, see --blobs.threshold.
+// Relative paths are relative to the directory of the output file, like the paths the output refers to them with.
+func writeBlobs(cfg config, blobs map[string]string) {
+	dir := filepath.Dir(cfg.outputFile)
+	if cfg.outputFile == stdStream {
+		dir = "."
+	}
+	contents := map[string]string{}
+	for file, content := range blobs {
+		file = filepath.FromSlash(file)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		contents[file] = content
+	}
+	outputLog.Info("Writing blob files", "path", filepath.Join(dir, cfg.blobsDir), "count", len(contents))
+	checkForErrorCode(writeFilesTogether(contents), exitWrite)
+}
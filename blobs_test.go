@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExternalizeBlobs verifies that only values longer than the threshold are replaced with the paths of their files
+func TestExternalizeBlobs(t *testing.T) {
+	data := map[string]interface{}{
+		"app": map[string]interface{}{
+			"name":  "demo",
+			"certs": []interface{}{"0123456789", "short"},
+		},
+		"image": "\x89PNG\r\n",
+		"port":  8080,
+	}
+	blobs, err := externalizeBlobs(data, 5, "blobs")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"blobs/app.certs[0]": "0123456789",
+		"blobs/image":        "\x89PNG\r\n",
+	}, blobs)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{
+			"name":  "demo",
+			"certs": []interface{}{"blobs/app.certs[0]", "short"},
+		},
+		"image": "blobs/image",
+		"port":  8080,
+	}, data)
+
+	_, err = externalizeBlobs(map[string]interface{}{"a/b": "0123456789"}, 5, "blobs")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `key path "a/b" cannot be used as the file name of a blob`)
+	}
+}
+
+// TestEnd2EndBlobs verifies that binary values keep their tag, and large values are written to files next to the output
+func TestEnd2EndBlobs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/blobs"
+	cfg.outputFile = filepath.Join(dir, "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    name: demo\n    icon: !!binary aGVsbG8=\n    bundle: !!binary aGVsbG8sIHdvcmxkIQ==\n", string(content))
+
+	cfg.blobsThreshold = 8
+	cfg.blobsDir = "blobs"
+	runMerge(cfg)
+	content, err = os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    name: demo\n    icon: !!binary aGVsbG8=\n    bundle: blobs/app.bundle\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "blobs", "app.bundle"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello, world!", string(content))
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// assertionPattern matches an assertion comparing two operands, e.g. "prod.app.replicas >= stage.app.replicas"
+var assertionPattern = regexp.MustCompile(`^\s*(\S+)\s*(==|!=|>=|<=|>|<)\s*(\S+)\s*$`)
+
+// assertionFailure is an assertion that does not hold for the outputs, or cannot be evaluated
+type assertionFailure struct {
+	assertion string
+	message   string
+}
+
+// checkAssertions evaluates assertions comparing named outputs.
+// An operand starting with the name of an output followed by a dot-separated key path refers to a value of that output,
+// e.g. prod.app.replicas. Any other operand is a YAML literal, e.g. 3 or "demo".
+// Numbers and strings can be ordered, all values can be compared with == and !=.
+func checkAssertions(assertions []string, outputs map[string]map[string]interface{}) []assertionFailure {
+	failures := []assertionFailure{}
+	for _, assertion := range assertions {
+		holds, err := evaluateAssertion(assertion, outputs)
+		switch {
+		case err != nil:
+			failures = append(failures, assertionFailure{assertion: assertion, message: err.Error()})
+		case !holds:
+			failures = append(failures, assertionFailure{assertion: assertion, message: "assertion does not hold"})
+		}
+	}
+	return failures
+}
+
+// evaluateAssertion reports whether an assertion holds for the outputs
+func evaluateAssertion(assertion string, outputs map[string]map[string]interface{}) (bool, error) {
+	match := assertionPattern.FindStringSubmatch(assertion)
+	if match == nil {
+		return false, errors.New("expected '<operand> <operator> <operand>' with one of the operators ==, !=, >=, >, <=, <")
+	}
+	left, err := resolveOperand(match[1], outputs)
+	if err != nil {
+		return false, err
+	}
+	right, err := resolveOperand(match[3], outputs)
+	if err != nil {
+		return false, err
+	}
+
+	operator := match[2]
+	if leftNumber, ok := toFloat(left); ok {
+		if rightNumber, ok := toFloat(right); ok {
+			return compareOrdered(leftNumber, rightNumber, operator), nil
+		}
+	}
+	leftString, leftOk := left.(string)
+	rightString, rightOk := right.(string)
+	switch {
+	case leftOk && rightOk:
+		return compareOrdered(leftString, rightString, operator), nil
+	case operator == "==":
+		return reflect.DeepEqual(left, right), nil
+	case operator == "!=":
+		return !reflect.DeepEqual(left, right), nil
+	}
+	return false, fmt.Errorf("cannot compare %v and %v with %s", left, right, operator)
+}
+
+// resolveOperand returns the value of an output referred to by an operand, or the YAML literal of the operand
+func resolveOperand(operand string, outputs map[string]map[string]interface{}) (interface{}, error) {
+	parts := strings.SplitN(operand, ".", 2)
+	if output, ok := outputs[parts[0]]; ok && len(parts) == 2 {
+		if !hasKey(output, parts[1]) {
+			return nil, fmt.Errorf("key %s not found in output %s", parts[1], parts[0])
+		}
+		return lookupKey(output, parts[1]), nil
+	}
+	var literal interface{}
+	if err := yaml.Unmarshal([]byte(operand), &literal); err != nil {
+		return nil, errors.Wrapf(err, "invalid literal %s", operand)
+	}
+	return literal, nil
+}
+
+// compareOrdered compares two numbers or strings with an operator
+func compareOrdered[T float64 | string](left T, right T, operator string) bool {
+	switch operator {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case ">=":
+		return left >= right
+	case ">":
+		return left > right
+	case "<=":
+		return left <= right
+	default:
+		return left < right
+	}
+}
+
+// loadOutputs reads named output files given as name=path
+func loadOutputs(namedFiles []string) (map[string]map[string]interface{}, error) {
+	outputs := map[string]map[string]interface{}{}
+	for _, namedFile := range namedFiles {
+		parts := strings.SplitN(namedFile, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || strings.Contains(parts[0], ".") {
+			return nil, fmt.Errorf("invalid output %q, expected <name>=<path> with a name without dots", namedFile)
+		}
+		content, err := os.ReadFile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		output := map[string]interface{}{}
+		if err := yaml.Unmarshal(content, &output); err != nil {
+			return nil, newParseError(parts[1], content, err)
+		}
+		outputs[parts[0]] = output
+	}
+	return outputs, nil
+}
+
+// loadAssertions reads a YAML list of assertions
+func loadAssertions(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var assertions []string
+	if err := yaml.Unmarshal(content, &assertions); err != nil {
+		return nil, newParseError(file, content, err)
+	}
+	return assertions, nil
+}
+
+// runCompare checks the assertions comparing the outputs and fails if any of them does not hold
+func runCompare(cfg config) {
+	outputs, err := loadOutputs(cfg.compareOutputs)
+	checkForErrorCode(err, exitParse)
+	assertions := cfg.assertions
+	if len(cfg.assertionsFile) > 0 {
+		fromFile, err := loadAssertions(cfg.assertionsFile)
+		checkForErrorCode(err, exitParse)
+		assertions = append(fromFile, assertions...)
+	}
+
+	failures := checkAssertions(assertions, outputs)
+	for _, failure := range failures {
+		outputLog.Error("Assertion failed",
+			"assertion", strings.TrimSpace(failure.assertion),
+			"error", failure.message,
+		)
+	}
+	fields := []interface{}{
+		"assertions", len(assertions),
+		"failed", len(failures),
+	}
+	if len(failures) > 0 {
+		fatal(appLog, exitValidation, "Outputs are not consistent", fields...)
+	}
+	appLog.Info("Outputs are consistent", fields...)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckAssertions verifies assertions comparing outputs and literals
+func TestCheckAssertions(t *testing.T) {
+	outputs, err := loadOutputs([]string{"prod=testdata/compare/prod.yaml", "stage=testdata/compare/stage.yaml"})
+	if err != nil {
+		t.Fatalf("Error loading outputs: %v", err)
+	}
+
+	tests := []struct {
+		assertion string
+		message   string
+	}{
+		{assertion: "prod.app.replicas >= stage.app.replicas"},
+		{assertion: "prod.app.replicas>3"},
+		{assertion: "prod.app.region == stage.app.region"},
+		{assertion: "prod.app.image.tag < stage.app.image.tag"},
+		{assertion: "prod.app.image != stage.app.image"},
+		{assertion: "prod.app.region == us-east-1"},
+		{assertion: "prod.app.image.tag == stage.app.image.tag", message: "assertion does not hold"},
+		{assertion: "stage.app.replicas > 2", message: "assertion does not hold"},
+		{assertion: "prod.app.cpu == stage.app.cpu", message: "key app.cpu not found in output prod"},
+		{assertion: "prod.app.image >= stage.app.image", message: "cannot compare"},
+		{assertion: "prod.app.replicas", message: "expected '<operand> <operator> <operand>'"},
+	}
+	for _, test := range tests {
+		failures := checkAssertions([]string{test.assertion}, outputs)
+		if len(test.message) == 0 {
+			assert.Empty(t, failures, test.assertion)
+			continue
+		}
+		if assert.Len(t, failures, 1, test.assertion) {
+			assert.Contains(t, failures[0].message, test.message, test.assertion)
+		}
+	}
+}
+
+// TestLoadOutputsInvalidName verifies that outputs need a name without dots
+func TestLoadOutputsInvalidName(t *testing.T) {
+	for _, namedFile := range []string{"testdata/compare/prod.yaml", "=testdata/compare/prod.yaml", "us.prod=testdata/compare/prod.yaml"} {
+		_, err := loadOutputs([]string{namedFile})
+		assert.Error(t, err, namedFile)
+	}
+}
+
+// TestFailCompare ensures that the application is correctly failing
+// if an assertion comparing outputs does not hold.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailCompare(t *testing.T) {
+	if os.Getenv("TEST_FAIL_COMPARE") == "1" {
+		cfg := cfgDefaults
+		cfg.compareOutputs = []string{"prod=testdata/compare/prod.yaml", "stage=testdata/compare/stage.yaml"}
+		cfg.assertionsFile = "testdata/compare/assertions.yaml"
+
+		runCompare(cfg)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailCompare")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_COMPARE=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/imdario/mergo"
+)
+
+// compatLevels lists the compatibility levels of the merge semantics, oldest first.
+// A level freezes how files are merged, e.g. how lists and nulls of later layers are handled,
+// so a hierarchy pinned to a level keeps producing the same output when a later version changes the defaults.
+var compatLevels = []string{"1"}
+
+// latestCompat is the level used if neither --compat nor the hierarchy file set one
+var latestCompat = compatLevels[len(compatLevels)-1]
+
+// compat is the compatibility level of the merge, see --compat and "#! compat" in the hierarchy file.
+// It is empty until one of them sets it.
+var compat string
+
+// isCompatLevel reports whether level is a known compatibility level
+func isCompatLevel(level string) bool {
+	for _, known := range compatLevels {
+		if level == known {
+			return true
+		}
+	}
+	return false
+}
+
+// effectiveCompat returns the compatibility level of the merge
+func effectiveCompat() string {
+	if compat == "" {
+		return latestCompat
+	}
+	return compat
+}
+
+// mergeOptions returns the options of mergo implementing the merge semantics of a compatibility level
+func mergeOptions(level string) []func(*mergo.Config) {
+	switch level {
+	default:
+		// Level 1: a later layer overrides every key it sets, even with null or an empty value,
+		// and replaces lists as a whole
+		return []func(*mergo.Config){mergo.WithOverride}
+	}
+}
@@ -0,0 +1,378 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// completionShells are the shells the completion command writes a script for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionFlag is a flag of the completion scripts
+type completionFlag struct {
+	// names are the long and the short name with dashes, e.g. "--file" and "-f"
+	names []string
+	help  string
+	// value is set for flags which take a value, which is completed with file names
+	value bool
+}
+
+// completionCommand is a command of the completion scripts with the flags of the command
+type completionCommand struct {
+	// name is the full command, e.g. "import hiera" for the subcommand hiera of import
+	name        string
+	help        string
+	flags       []completionFlag
+	subcommands []completionCommand
+}
+
+// writeCompletion writes the completion script of a shell for all visible flags and commands of the application
+func writeCompletion(w io.Writer, model *kingpin.ApplicationModel, shell string) error {
+	flags := completionFlags(model.FlagGroupModel)
+	commands := completionCommands(model.Commands)
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion(model.Name, flags, commands)
+	case "zsh":
+		script = zshCompletion(model.Name, flags, commands)
+	case "fish":
+		script = fishCompletion(model.Name, flags, commands)
+	default:
+		return errors.Errorf("unknown shell %s, must be one of %s", shell, strings.Join(completionShells, ", "))
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+// completionCommands returns the visible commands with their visible subcommands
+func completionCommands(models []*kingpin.CmdModel) []completionCommand {
+	commands := []completionCommand{}
+	for _, command := range models {
+		if !command.Hidden {
+			commands = append(commands, completionCommand{
+				name:        command.FullCommand,
+				help:        summary(command.Help),
+				flags:       completionFlags(command.FlagGroupModel),
+				subcommands: completionCommands(command.Commands),
+			})
+		}
+	}
+	return commands
+}
+
+// flattenCommands returns the commands and all their subcommands
+func flattenCommands(commands []completionCommand) []completionCommand {
+	flattened := []completionCommand{}
+	for _, command := range commands {
+		flattened = append(flattened, command)
+		flattened = append(flattened, flattenCommands(command.subcommands)...)
+	}
+	return flattened
+}
+
+// groupCommands returns the commands with subcommands, e.g. import
+func groupCommands(commands []completionCommand) []completionCommand {
+	groups := []completionCommand{}
+	for _, command := range flattenCommands(commands) {
+		if len(command.subcommands) > 0 {
+			groups = append(groups, command)
+		}
+	}
+	return groups
+}
+
+// commandNames returns the names of commands without the names of their parents, e.g. "hiera" for "import hiera"
+func commandNames(commands []completionCommand) []string {
+	names := []string{}
+	for _, command := range commands {
+		names = append(names, command.name[strings.LastIndex(command.name, " ")+1:])
+	}
+	return names
+}
+
+// casePattern returns a command as a pattern of a shell case statement, quoting commands with subcommands
+func casePattern(command string) string {
+	if strings.Contains(command, " ") {
+		return shellQuote(command)
+	}
+	return command
+}
+
+// completionFlags returns the visible flags of a flag group
+func completionFlags(group *kingpin.FlagGroupModel) []completionFlag {
+	flags := []completionFlag{}
+	for _, flag := range group.Flags {
+		if flag.Hidden {
+			continue
+		}
+		names := []string{"--" + flag.Name}
+		if flag.Short != 0 {
+			names = append(names, "-"+string(flag.Short))
+		}
+		flags = append(flags, completionFlag{names: names, help: summary(flag.Help), value: !flag.IsBoolFlag()})
+	}
+	return flags
+}
+
+// summary returns the first sentence of a help text, abbreviations like "e.g." do not end it
+func summary(help string) string {
+	for start := 0; ; {
+		end := strings.Index(help[start:], ". ")
+		if end < 0 {
+			return help
+		}
+		end += start
+		if !strings.HasSuffix(help[:end], "e.g") && !strings.HasSuffix(help[:end], "i.e") {
+			return help[:end+1]
+		}
+		start = end + 2
+	}
+}
+
+// valueFlags returns the names of all flags taking a value as a shell case pattern, e.g. "--file|-f|--base|-b"
+func valueFlags(flags []completionFlag, commands []completionCommand) string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, group := range append([][]completionFlag{flags}, commandFlags(commands)...) {
+		for _, flag := range group {
+			for _, name := range flag.names {
+				if flag.value && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// commandFlags returns the flags of every command and subcommand
+func commandFlags(commands []completionCommand) [][]completionFlag {
+	flags := [][]completionFlag{}
+	for _, command := range flattenCommands(commands) {
+		flags = append(flags, command.flags)
+	}
+	return flags
+}
+
+// commandWord returns the shell commands of the completion scripts for a word which is neither a flag nor the value of a flag.
+// The word is the command, or the subcommand after a command with subcommands, and later words are arguments.
+func commandWord(commands []completionCommand) string {
+	groups := []string{}
+	for _, group := range groupCommands(commands) {
+		groups = append(groups, casePattern(group.name))
+	}
+	if len(groups) == 0 {
+		return `command="$word"; break`
+	}
+	return fmt.Sprintf(`command="${command:+$command }$word"; case "$command" in %s) ;; *) break ;; esac`, strings.Join(groups, "|"))
+}
+
+// fishSeen returns the fish condition that a command and its parents were given
+func fishSeen(command string) string {
+	conditions := []string{}
+	for _, name := range strings.Fields(command) {
+		conditions = append(conditions, "__fish_seen_subcommand_from "+name)
+	}
+	return strings.Join(conditions, "; and ")
+}
+
+// flagNames returns the names of the flags separated by spaces
+func flagNames(flags []completionFlag) string {
+	names := []string{}
+	for _, flag := range flags {
+		names = append(names, flag.names...)
+	}
+	return strings.Join(names, " ")
+}
+
+// shellQuote quotes a string for bash and zsh with single quotes
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes a string for fish with single quotes
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// bashCompletion returns the bash completion script. The first word which is neither a flag nor the value of a flag is the command.
+// Values of flags and arguments of commands are completed with file names.
+func bashCompletion(name string, flags []completionFlag, commands []completionCommand) string {
+	function := "_" + strings.ReplaceAll(name, "-", "_")
+	var script strings.Builder
+	fmt.Fprintf(&script, "# bash completion for %s, load with: source <(%s completion bash)\n", name, name)
+	fmt.Fprintf(&script, "%s() {\n", function)
+	script.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	script.WriteString("    local command=\"\" word i\n")
+	script.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	script.WriteString("        word=\"${COMP_WORDS[i]}\"\n")
+	script.WriteString("        case \"$word\" in\n")
+	// bash splits --file=value into three words
+	fmt.Fprintf(&script, "            %s) [[ \"${COMP_WORDS[i+1]}\" == \"=\" ]] && ((i++)); ((i++)) ;;\n", valueFlags(flags, commands))
+	script.WriteString("            -*) ;;\n")
+	fmt.Fprintf(&script, "            *) %s ;;\n", commandWord(commands))
+	script.WriteString("        esac\n")
+	script.WriteString("    done\n")
+	script.WriteString("    case \"$prev\" in\n")
+	fmt.Fprintf(&script, "        %s|=) COMPREPLY=($(compgen -f -- \"$cur\")); return 0 ;;\n", valueFlags(flags, commands))
+	script.WriteString("    esac\n")
+	script.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(&script, "        local flags=%s\n", shellQuote(flagNames(flags)))
+	script.WriteString("        case \"$command\" in\n")
+	for _, command := range flattenCommands(commands) {
+		if len(command.flags) > 0 {
+			fmt.Fprintf(&script, "            %s) flags=\"$flags \"%s ;;\n", casePattern(command.name), shellQuote(flagNames(command.flags)))
+		}
+	}
+	script.WriteString("        esac\n")
+	script.WriteString("        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	script.WriteString("    elif [[ -z \"$command\" ]]; then\n")
+	fmt.Fprintf(&script, "        COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(commandNames(commands), " ")))
+	for _, group := range groupCommands(commands) {
+		fmt.Fprintf(&script, "    elif [[ \"$command\" == %s ]]; then\n", shellQuote(group.name))
+		fmt.Fprintf(&script, "        COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(commandNames(group.subcommands), " ")))
+	}
+	script.WriteString("    else\n")
+	script.WriteString("        COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	script.WriteString("    fi\n")
+	script.WriteString("}\n")
+	fmt.Fprintf(&script, "complete -o filenames -F %s %s\n", function, name)
+	return script.String()
+}
+
+// zshCompletion returns the zsh completion script, which completes like the bash script, with the help of flags and commands
+func zshCompletion(name string, flags []completionFlag, commands []completionCommand) string {
+	function := "_" + strings.ReplaceAll(name, "-", "_")
+	describe := func(script *strings.Builder, indent string, flags []completionFlag) {
+		for _, flag := range flags {
+			for _, flagName := range flag.names {
+				fmt.Fprintf(script, "%s%s\n", indent, shellQuote(flagName+":"+flag.help))
+			}
+		}
+	}
+	var script strings.Builder
+	fmt.Fprintf(&script, "#compdef %s\n", name)
+	fmt.Fprintf(&script, "# zsh completion for %s, load with: source <(%s completion zsh)\n", name, name)
+	fmt.Fprintf(&script, "%s() {\n", function)
+	script.WriteString("    local command=\"\" word i\n")
+	script.WriteString("    local -a flags commands\n")
+	script.WriteString("    for ((i = 2; i < CURRENT; i++)); do\n")
+	script.WriteString("        word=\"${words[i]}\"\n")
+	script.WriteString("        case \"$word\" in\n")
+	fmt.Fprintf(&script, "            %s) ((i++)) ;;\n", valueFlags(flags, commands))
+	script.WriteString("            -*) ;;\n")
+	fmt.Fprintf(&script, "            *) %s ;;\n", commandWord(commands))
+	script.WriteString("        esac\n")
+	script.WriteString("    done\n")
+	script.WriteString("    case \"${words[CURRENT-1]}\" in\n")
+	fmt.Fprintf(&script, "        %s) _files; return ;;\n", valueFlags(flags, commands))
+	script.WriteString("    esac\n")
+	script.WriteString("    if [[ \"${words[CURRENT]}\" == --*=* ]]; then\n")
+	script.WriteString("        compset -P '*='; _files; return\n")
+	script.WriteString("    fi\n")
+	script.WriteString("    flags=(\n")
+	describe(&script, "        ", flags)
+	script.WriteString("    )\n")
+	script.WriteString("    case \"$command\" in\n")
+	for _, command := range flattenCommands(commands) {
+		if len(command.flags) > 0 {
+			fmt.Fprintf(&script, "        %s)\n", casePattern(command.name))
+			script.WriteString("            flags+=(\n")
+			describe(&script, "                ", command.flags)
+			script.WriteString("            ) ;;\n")
+		}
+	}
+	script.WriteString("    esac\n")
+	script.WriteString("    if [[ \"${words[CURRENT]}\" == -* ]]; then\n")
+	script.WriteString("        _describe -t flags 'flag' flags\n")
+	script.WriteString("    elif [[ -z \"$command\" ]]; then\n")
+	describeCommands := func(commands []completionCommand) {
+		script.WriteString("        commands=(\n")
+		for i, name := range commandNames(commands) {
+			fmt.Fprintf(&script, "            %s\n", shellQuote(name+":"+commands[i].help))
+		}
+		script.WriteString("        )\n")
+		script.WriteString("        _describe -t commands 'command' commands\n")
+	}
+	describeCommands(commands)
+	for _, group := range groupCommands(commands) {
+		fmt.Fprintf(&script, "    elif [[ \"$command\" == %s ]]; then\n", shellQuote(group.name))
+		describeCommands(group.subcommands)
+	}
+	script.WriteString("    else\n")
+	script.WriteString("        _files\n")
+	script.WriteString("    fi\n")
+	script.WriteString("}\n")
+	fmt.Fprintf(&script, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n", function)
+	fmt.Fprintf(&script, "    %s \"$@\"\n", function)
+	script.WriteString("else\n")
+	fmt.Fprintf(&script, "    compdef %s %s\n", function, name)
+	script.WriteString("fi\n")
+	return script.String()
+}
+
+// fishCompletion returns the fish completion script. Commands are only offered before a command was given,
+// and the flags of a command only after it.
+func fishCompletion(name string, flags []completionFlag, commands []completionCommand) string {
+	complete := func(script *strings.Builder, condition string, flag completionFlag) {
+		fmt.Fprintf(script, "complete -c %s", name)
+		if len(condition) > 0 {
+			fmt.Fprintf(script, " -n %s", fishQuote(condition))
+		}
+		for _, flagName := range flag.names {
+			if strings.HasPrefix(flagName, "--") {
+				fmt.Fprintf(script, " -l %s", strings.TrimPrefix(flagName, "--"))
+			} else {
+				fmt.Fprintf(script, " -s %s", strings.TrimPrefix(flagName, "-"))
+			}
+		}
+		if flag.value {
+			script.WriteString(" -r")
+		}
+		fmt.Fprintf(script, " -d %s\n", fishQuote(flag.help))
+	}
+	var script strings.Builder
+	fmt.Fprintf(&script, "# fish completion for %s, load with: %s completion fish | source\n", name, name)
+	offer := func(condition string, commands []completionCommand) {
+		names := commandNames(commands)
+		for i, command := range commands {
+			fmt.Fprintf(&script, "complete -c %s -f -n %s -a %s -d %s\n", name,
+				fishQuote(condition+"not __fish_seen_subcommand_from "+strings.Join(names, " ")), names[i], fishQuote(command.help))
+		}
+	}
+	offer("", commands)
+	for _, group := range groupCommands(commands) {
+		offer(fishSeen(group.name)+"; and ", group.subcommands)
+	}
+	for _, flag := range flags {
+		complete(&script, "", flag)
+	}
+	for _, command := range flattenCommands(commands) {
+		for _, flag := range command.flags {
+			complete(&script, fishSeen(command.name), flag)
+		}
+	}
+	return script.String()
+}
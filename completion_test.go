@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// completionModel returns the model of a small application with global and command flags
+func completionModel() *kingpin.ApplicationModel {
+	application := kingpin.New("hierarchy", "Hierarchy")
+	application.Flag("file", "Name of the hierarchy file.").Short('f').String()
+	application.Flag("sort-keys", "Write the keys in alphabetical order, e.g. for diffs. Second sentence.").Bool()
+	application.Flag("secret", "Hidden flag.").Hidden().String()
+	application.Command("merge", "Merge all files.").Default()
+	serve := application.Command("serve", "Serve the 'merged' document.")
+	serve.Flag("listen", "Address the HTTP server listens on.").String()
+	return application.Model()
+}
+
+// TestWriteCompletion verifies that the completion scripts contain all visible flags and commands
+func TestWriteCompletion(t *testing.T) {
+	var bash strings.Builder
+	assert.NoError(t, writeCompletion(&bash, completionModel(), "bash"))
+	assert.Contains(t, bash.String(), "local flags='--help --file -f --sort-keys'\n")
+	assert.Contains(t, bash.String(), `serve) flags="$flags "'--listen' ;;`)
+	assert.Contains(t, bash.String(), `--file|-f|--listen|=) COMPREPLY=($(compgen -f -- "$cur")); return 0 ;;`)
+	assert.Contains(t, bash.String(), "compgen -W 'merge serve'")
+	assert.Contains(t, bash.String(), "complete -o filenames -F _hierarchy hierarchy\n")
+	assert.NotContains(t, bash.String(), "--secret")
+
+	var zsh strings.Builder
+	assert.NoError(t, writeCompletion(&zsh, completionModel(), "zsh"))
+	assert.True(t, strings.HasPrefix(zsh.String(), "#compdef hierarchy\n"))
+	assert.Contains(t, zsh.String(), "'--sort-keys:Write the keys in alphabetical order, e.g. for diffs.'\n")
+	assert.Contains(t, zsh.String(), `'serve:Serve the '\''merged'\'' document.'`)
+	assert.Contains(t, zsh.String(), "compdef _hierarchy hierarchy\n")
+
+	var fish strings.Builder
+	assert.NoError(t, writeCompletion(&fish, completionModel(), "fish"))
+	assert.Contains(t, fish.String(), "complete -c hierarchy -f -n 'not __fish_seen_subcommand_from merge serve' -a serve -d 'Serve the \\'merged\\' document.'\n")
+	assert.Contains(t, fish.String(), "complete -c hierarchy -l file -s f -r -d 'Name of the hierarchy file.'\n")
+	assert.Contains(t, fish.String(), "complete -c hierarchy -n '__fish_seen_subcommand_from serve' -l listen -r -d 'Address the HTTP server listens on.'\n")
+
+	assert.Error(t, writeCompletion(&strings.Builder{}, completionModel(), "tcsh"))
+}
+
+// TestWriteCompletionSubcommands verifies that subcommands are completed after their command
+func TestWriteCompletionSubcommands(t *testing.T) {
+	application := kingpin.New("hierarchy", "Hierarchy")
+	application.Command("merge", "Merge all files.").Default()
+	importCommand := application.Command("import", "Convert the configuration of another tool.")
+	hiera := importCommand.Command("hiera", "Convert a Hiera configuration.")
+	hiera.Flag("datadir", "Data directory.").String()
+	model := application.Model()
+
+	var bash strings.Builder
+	assert.NoError(t, writeCompletion(&bash, model, "bash"))
+	assert.Contains(t, bash.String(), `*) command="${command:+$command }$word"; case "$command" in import) ;; *) break ;; esac ;;`)
+	assert.Contains(t, bash.String(), `'import hiera') flags="$flags "'--datadir' ;;`)
+	assert.Contains(t, bash.String(), "compgen -W 'merge import'")
+	assert.Contains(t, bash.String(), "elif [[ \"$command\" == 'import' ]]; then\n        COMPREPLY=($(compgen -W 'hiera' -- \"$cur\"))\n")
+
+	var zsh strings.Builder
+	assert.NoError(t, writeCompletion(&zsh, model, "zsh"))
+	assert.Contains(t, zsh.String(), "elif [[ \"$command\" == 'import' ]]; then\n        commands=(\n            'hiera:Convert a Hiera configuration.'\n")
+
+	var fish strings.Builder
+	assert.NoError(t, writeCompletion(&fish, model, "fish"))
+	assert.Contains(t, fish.String(), "complete -c hierarchy -f -n 'not __fish_seen_subcommand_from merge import' -a import -d 'Convert the configuration of another tool.'\n")
+	assert.Contains(t, fish.String(), "complete -c hierarchy -f -n '__fish_seen_subcommand_from import; and not __fish_seen_subcommand_from hiera' -a hiera -d 'Convert a Hiera configuration.'\n")
+	assert.Contains(t, fish.String(), "complete -c hierarchy -n '__fish_seen_subcommand_from import; and __fish_seen_subcommand_from hiera' -l datadir -r -d 'Data directory.'\n")
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// consulSourcePrefix starts hierarchy entries of Consul KV prefixes, e.g. 'consul://config/app'
+const consulSourcePrefix = "consul://"
+
+// consulTxnLimit is the maximum number of operations of a Consul transaction
+const consulTxnLimit = 64
+
+// consulPair is a key of the Consul KV store
+type consulPair struct {
+	Key         string `json:"Key"`
+	Value       []byte `json:"Value"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+}
+
+// consulClient reads and writes the Consul KV store
+type consulClient struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// newConsulClient configures the client with the --consul flags, falling back to the environment variables of the Consul CLI
+func newConsulClient(cfg config) *consulClient {
+	c := &consulClient{
+		address: cfg.consulAddress,
+		token:   cfg.consulToken,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	if len(c.address) == 0 {
+		c.address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if len(c.address) == 0 {
+		c.address = "127.0.0.1:8500"
+	}
+	if !strings.Contains(c.address, "://") {
+		scheme := "http://"
+		if os.Getenv("CONSUL_HTTP_SSL") == "true" {
+			scheme = "https://"
+		}
+		c.address = scheme + c.address
+	}
+	c.address = strings.TrimSuffix(c.address, "/")
+	if len(c.token) == 0 {
+		c.token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return c
+}
+
+// do sends a request to the Consul HTTP API and decodes the JSON response into result, unless it is nil
+func (c *consulClient) do(method string, path string, body interface{}, result interface{}) (int, error) {
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		content = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, c.address+path, content)
+	if err != nil {
+		return 0, err
+	}
+	if len(c.token) > 0 {
+		request.Header.Set("X-Consul-Token", c.token)
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound && response.StatusCode != http.StatusConflict {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1<<10))
+		return response.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, strings.TrimSpace(string(message)))
+	}
+	if result != nil && response.StatusCode != http.StatusNotFound {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			return response.StatusCode, err
+		}
+	}
+	return response.StatusCode, nil
+}
+
+// list returns all keys below prefix, which are none if the prefix does not exist
+func (c *consulClient) list(prefix string) ([]consulPair, error) {
+	pairs := []consulPair{}
+	_, err := c.do(http.MethodGet, "/v1/kv/"+escapeObjectPath(prefix)+"?recurse=true", nil, &pairs)
+	return pairs, err
+}
+
+// keyPrefix returns the prefix of keys below a path, which is empty or ends with '/'
+func keyPrefix(path string) string {
+	path = strings.Trim(path, "/")
+	if len(path) > 0 {
+		path += "/"
+	}
+	return path
+}
+
+// fetchConsulSource writes the keys below the prefix of an entry into dir as a single document.
+// The '/'-separated path of every key below the prefix is its key path in the document,
+// and its value is decoded as YAML, so '3' is a number and '[a, b]' a list.
+func fetchConsulSource(cfg config, entry string, dir string) error {
+	prefix := keyPrefix(strings.TrimPrefix(entry, consulSourcePrefix))
+	pairs, err := newConsulClient(cfg).list(prefix)
+	if err != nil {
+		return err
+	}
+	document := map[string]interface{}{}
+	for _, pair := range pairs {
+		path := strings.TrimPrefix(pair.Key, prefix)
+		// Folders have no value
+		if len(path) == 0 || strings.HasSuffix(path, "/") {
+			continue
+		}
+		var value interface{}
+		if err := yaml.Unmarshal(pair.Value, &value); err != nil || value == nil {
+			value = string(pair.Value)
+		}
+		node := document
+		segments := strings.Split(path, "/")
+		for _, segment := range segments[:len(segments)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				if _, exists := node[segment]; exists {
+					return fmt.Errorf("key %s is below the value of another key", pair.Key)
+				}
+				child = map[string]interface{}{}
+				node[segment] = child
+			}
+			node = child
+		}
+		if _, exists := node[segments[len(segments)-1]]; exists {
+			return fmt.Errorf("key %s is the parent of other keys", pair.Key)
+		}
+		node[segments[len(segments)-1]] = value
+	}
+	content, err := yaml.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "consul.yaml"), content, 0644)
+}
+
+// flattenKeys returns the keys and values of the leaves of a document below prefix.
+// Strings are stored as they are, all other values as JSON, which decodes as YAML again.
+func flattenKeys(prefix string, node interface{}, keys map[string]string) error {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if len(key) == 0 || strings.Contains(key, "/") {
+				return fmt.Errorf("key %q below %s cannot be part of a key path separated by '/'", key, prefix)
+			}
+			if err := flattenKeys(prefix+key+"/", child, keys); err != nil {
+				return err
+			}
+		}
+	case string:
+		keys[strings.TrimSuffix(prefix, "/")] = value
+	case nil:
+		keys[strings.TrimSuffix(prefix, "/")] = ""
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		keys[strings.TrimSuffix(prefix, "/")] = string(encoded)
+	}
+	return nil
+}
+
+// consulTxnOp is an operation of a Consul transaction
+type consulTxnOp struct {
+	KV struct {
+		Verb  string `json:"Verb"`
+		Key   string `json:"Key"`
+		Value []byte `json:"Value,omitempty"`
+		Index uint64 `json:"Index"`
+	} `json:"KV"`
+}
+
+// publish replaces the keys below prefix with the leaves of the merged document.
+// Only changed keys are written and keys no longer in the document are deleted, each with a check-and-set
+// against the index it was read with, so a concurrent change fails the publication instead of being overwritten.
+// Transactions are limited to 64 operations, larger changes are published in several transactions.
+func (c *consulClient) publish(prefix string, output string) error {
+	var document map[string]interface{}
+	if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+		return err
+	}
+	prefix = keyPrefix(prefix)
+	keys := map[string]string{}
+	if err := flattenKeys(prefix, document, keys); err != nil {
+		return err
+	}
+	pairs, err := c.list(prefix)
+	if err != nil {
+		return err
+	}
+	operations := []consulTxnOp{}
+	for _, pair := range pairs {
+		if _, ok := keys[pair.Key]; !ok && !strings.HasSuffix(pair.Key, "/") {
+			var op consulTxnOp
+			op.KV.Verb, op.KV.Key, op.KV.Index = "delete-cas", pair.Key, pair.ModifyIndex
+			operations = append(operations, op)
+		}
+	}
+	current := map[string]consulPair{}
+	for _, pair := range pairs {
+		current[pair.Key] = pair
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pair, exists := current[name]
+		if exists && string(pair.Value) == keys[name] {
+			continue
+		}
+		var op consulTxnOp
+		op.KV.Verb, op.KV.Key, op.KV.Value, op.KV.Index = "cas", name, []byte(keys[name]), pair.ModifyIndex
+		operations = append(operations, op)
+	}
+
+	outputLog.Info("Publishing to Consul", "address", c.address, "prefix", prefix, "changes", len(operations))
+	for start := 0; start < len(operations); start += consulTxnLimit {
+		end := start + consulTxnLimit
+		if end > len(operations) {
+			end = len(operations)
+		}
+		var result struct {
+			Errors []struct {
+				What string `json:"What"`
+			} `json:"Errors"`
+		}
+		status, err := c.do(http.MethodPut, "/v1/txn", operations[start:end], &result)
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			message := "check-and-set failed"
+			if len(result.Errors) > 0 {
+				message = result.Errors[0].What
+			}
+			return errors.Errorf("keys below %s changed concurrently: %s", prefix, message)
+		}
+	}
+	return nil
+}
+
+// publishConsul publishes the merged document below the --consul.publish prefix, failing with exitWrite
+func publishConsul(cfg config, output string) {
+	err := newConsulClient(cfg).publish(cfg.consulPublish, output)
+	checkForErrorCode(errors.Wrap(err, "Error publishing to Consul"), exitWrite)
+}
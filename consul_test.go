@@ -0,0 +1,171 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConsul serves a KV store, applying transactions with check-and-set like Consul does
+type fakeConsul struct {
+	mu    sync.Mutex
+	index uint64
+	kv    map[string]consulPair
+	txns  int
+}
+
+func (f *fakeConsul) put(key string, value string) {
+	f.index++
+	f.kv[key] = consulPair{Key: key, Value: []byte(value), ModifyIndex: f.index}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("X-Consul-Token") != "t0ken" {
+		http.Error(w, "ACL not found", http.StatusForbidden)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && len(r.URL.Path) > len("/v1/kv/") && r.URL.Query().Get("recurse") == "true":
+		prefix := r.URL.Path[len("/v1/kv/"):]
+		pairs := []consulPair{}
+		for key, pair := range f.kv {
+			if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+				pairs = append(pairs, pair)
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		_ = json.NewEncoder(w).Encode(pairs)
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/txn":
+		f.txns++
+		var operations []consulTxnOp
+		_ = json.NewDecoder(r.Body).Decode(&operations)
+		for i, op := range operations {
+			if f.kv[op.KV.Key].ModifyIndex != op.KV.Index {
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"Errors": []map[string]interface{}{{"OpIndex": i, "What": "failed to set key \"" + op.KV.Key + "\", index is stale"}},
+				})
+				return
+			}
+		}
+		for _, op := range operations {
+			if op.KV.Verb == "delete-cas" {
+				delete(f.kv, op.KV.Key)
+			} else {
+				f.put(op.KV.Key, string(op.KV.Value))
+			}
+		}
+		_, _ = w.Write([]byte(`{"Results":[]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// TestConsulSource verifies that a KV subtree is merged as a document with decoded values
+func TestConsulSource(t *testing.T) {
+	consul := &fakeConsul{kv: map[string]consulPair{}}
+	consul.put("config/app/", "")
+	consul.put("config/app/name", "demo")
+	consul.put("config/app/replicas", "3")
+	consul.put("config/app/hosts", "[a, b]")
+	consul.put("config/app/database/host", "db.example.com")
+	consul.put("config/application", "other")
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	cfg := writeRemoteHierarchy(t, "consul://config/app")
+	cfg.consulAddress = server.URL
+	cfg.consulToken = "t0ken"
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"name":     "demo",
+		"replicas": 3,
+		"hosts":    []interface{}{"a", "b"},
+		"database": map[string]interface{}{"host": "db.example.com"},
+	}, data)
+
+	consul.put("config/app/database", "conflict")
+	err := fetchConsulSource(cfg, "consul://config/app", t.TempDir())
+	assert.Error(t, err)
+}
+
+// TestPublishConsul verifies that only changed keys are written, removed keys are deleted, and concurrent changes fail
+func TestPublishConsul(t *testing.T) {
+	consul := &fakeConsul{kv: map[string]consulPair{}}
+	consul.put("published/app/name", "demo")
+	consul.put("published/app/debug", "true")
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	cfg := cfgDefaults
+	cfg.consulAddress = server.URL
+	cfg.consulToken = "t0ken"
+	client := newConsulClient(cfg)
+
+	unchanged := consul.kv["published/app/name"].ModifyIndex
+	assert.NoError(t, client.publish("/published/", "app:\n  name: demo\n  replicas: 3\n  hosts: [a, b]\n  owner: null\n"))
+	assert.Equal(t, unchanged, consul.kv["published/app/name"].ModifyIndex)
+	assert.Equal(t, "3", string(consul.kv["published/app/replicas"].Value))
+	assert.Equal(t, `["a","b"]`, string(consul.kv["published/app/hosts"].Value))
+	assert.Equal(t, "", string(consul.kv["published/app/owner"].Value))
+	assert.NotContains(t, consul.kv, "published/app/debug")
+	assert.Equal(t, 1, consul.txns)
+
+	// Nothing changed
+	assert.NoError(t, client.publish("published", "app:\n  name: demo\n  replicas: 3\n  hosts: [a, b]\n  owner: null\n"))
+	assert.Equal(t, 1, consul.txns)
+
+	assert.Error(t, client.publish("published", "app:\n  a/b: slash\n"))
+}
+
+// TestPublishConsulConflict verifies that a key changed after it was read is not overwritten
+func TestPublishConsulConflict(t *testing.T) {
+	consul := &fakeConsul{kv: map[string]consulPair{}}
+	consul.put("published/name", "demo")
+	changed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/txn" && !changed {
+			changed = true
+			consul.mu.Lock()
+			consul.put("published/name", "concurrent")
+			consul.mu.Unlock()
+		}
+		consul.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	cfg := cfgDefaults
+	cfg.consulAddress = server.URL
+	cfg.consulToken = "t0ken"
+
+	err := newConsulClient(cfg).publish("published", "name: hierarchy\n")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "changed concurrently")
+	}
+	assert.Equal(t, "concurrent", string(consul.kv["published/name"].Value))
+}
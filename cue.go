@@ -0,0 +1,48 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// applyCue unifies the YAML document with a CUE schema using the cue CLI.
+// With export set, the document exported by CUE is returned, including defaults and computed fields from the schema.
+// Otherwise the document is only validated and returned unchanged.
+func applyCue(cueBinary string, schemaPath string, document string, export bool) (string, error) {
+	args := []string{"vet", schemaPath, "yaml:", "-"}
+	if export {
+		args = []string{"export", schemaPath, "yaml:", "-", "--out", "yaml"}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(cueBinary, args...)
+	cmd.Stdin = strings.NewReader(document)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "cue %s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	if export {
+		return stdout.String(), nil
+	}
+	return document, nil
+}
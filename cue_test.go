@@ -0,0 +1,50 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestApplyCueVet verifies that a document is returned unchanged if cue vet succeeds
+func TestApplyCueVet(t *testing.T) {
+	cue := fakeCommand(t, "vet schema.cue yaml: -", "")
+
+	output, err := applyCue(cue, "schema.cue", "replicas: 2\n", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "replicas: 2\n", output)
+}
+
+// TestApplyCueExport verifies that the document exported by cue replaces the merged output
+func TestApplyCueExport(t *testing.T) {
+	cue := fakeCommand(t, "export schema.cue yaml: - --out yaml", "replicas: 2\nport: 8080\n")
+
+	output, err := applyCue(cue, "schema.cue", "replicas: 2\n", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "replicas: 2\nport: 8080\n", output)
+}
+
+// TestApplyCueFailure verifies that conflicts reported by cue are returned as error
+func TestApplyCueFailure(t *testing.T) {
+	cue := fakeCommand(t, "vet other.cue yaml: -", "")
+
+	_, err := applyCue(cue, "schema.cue", "replicas: 2\n", false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cue vet failed")
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/cache"
+	"github.com/KohlsTechnology/hierarchy/pkg/notify"
+	"github.com/pkg/errors"
+)
+
+// mergeChildEnv is set for the child processes of --daemon and --watch, which merge only once
+const mergeChildEnv = "HIERARCHY_MERGE_CHILD"
+
+// runDaemon merges the hierarchy and keeps running until it is stopped.
+// It merges again on SIGHUP, and with --watch whenever an input changes.
+// The state is reported to systemd, and on Windows it runs as a service if started by the service control manager.
+func runDaemon(cfg config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(cfg.pidFile) > 0 {
+		err := os.WriteFile(cfg.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+		checkForErrorCode(errors.Wrapf(err, "Error writing PID file %s", cfg.pidFile), exitWrite)
+		defer os.Remove(cfg.pidFile)
+	}
+
+	executable, err := os.Executable()
+	checkForError(err)
+	// Every merge runs in a child process with the same arguments, so a broken input only fails that merge
+	command := func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, executable, os.Args[1:]...)
+		cmd.Env = append(os.Environ(), mergeChildEnv+"=1")
+		return cmd
+	}
+
+	if len(cfg.metricsListen) > 0 {
+		go serveMetrics(ctx, cfg.metricsListen)
+	}
+
+	service, err := notify.RunService("hierarchy", func(stopService <-chan struct{}) error {
+		serviceCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-stopService:
+				cancel()
+			case <-serviceCtx.Done():
+			}
+		}()
+		return daemonLoop(serviceCtx, cfg, command)
+	})
+	checkForError(err)
+	if !service {
+		checkForError(daemonLoop(ctx, cfg, command))
+	}
+}
+
+// daemonLoop runs a merge with command, and runs it again on SIGHUP or a changed input, until ctx is done
+func daemonLoop(ctx context.Context, cfg config, command func(ctx context.Context) *exec.Cmd) error {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	var watchdog, poll <-chan time.Time
+	if interval := notify.WatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	// File system events trigger a check of the inputs right away. They are not delivered for changes made
+	// on NFS and other network file systems, so the inputs are also polled, which is cheap for the few files of a hierarchy.
+	var watcher *inputWatcher
+	if cfg.watch {
+		ticker := time.NewTicker(cfg.watchInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+		watcher = newInputWatcher()
+		defer watcher.close()
+	}
+
+	// The output file is compared with its content after every merge to notify the webhook of changes
+	var output []byte
+	if len(cfg.webhookURL) > 0 {
+		if cfg.outputFile == stdStream {
+			appLog.Warn("Webhook ignored, the output is written to standard output")
+			cfg.webhookURL = ""
+		} else {
+			output, _ = os.ReadFile(cfg.outputFile)
+		}
+	}
+
+	for {
+		// Record the state before merging, so changes made during the merge trigger the next one
+		var dirs, paths []string
+		var files cache.Files
+		// Remote sources which can change are fetched again by merging again
+		var refresh <-chan time.Time
+		if cfg.watch {
+			dirs = watchedDirs(cfg, nil)
+			paths = watchedFiles(cfg, dirs)
+			files = cache.Stat(paths...)
+			watcher.watch(dirs, paths)
+			if len(refetchedSources(cfg, nil)) > 0 {
+				refresh = time.After(cfg.remoteRefreshInterval)
+			}
+		}
+
+		childStats, err := runMergeChild(ctx, command(ctx))
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			notifyState(notify.Stopping())
+			return nil
+		}
+		if len(cfg.webhookURL) > 0 {
+			output = notifyOutputChange(cfg, output, childStats)
+		}
+		notifyState(notify.Ready())
+
+		// Files are changed, added, or removed
+		changed := func() bool {
+			return files.Changed() || !reflect.DeepEqual(paths, watchedFiles(cfg, dirs))
+		}
+		var settled <-chan time.Time
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				notifyState(notify.Stopping())
+				return nil
+			case <-watchdog:
+				notifyState(notify.Watchdog())
+			case <-reload:
+				appLog.Info("Received SIGHUP, merging again")
+				break waiting
+			case <-refresh:
+				appLog.Info("Fetching remote sources again, merging again")
+				break waiting
+			case event := <-watcher.events():
+				appLog.Debug("File system event", "path", event.Name, "operation", event.Op.String())
+				if settled == nil {
+					settled = time.After(watchSettle)
+				}
+			case err := <-watcher.errors():
+				appLog.Warn("Error watching the inputs, polling them", "error", err)
+			case <-settled:
+				settled = nil
+				if changed() {
+					appLog.Info("Input changed, merging again")
+					break waiting
+				}
+			case <-poll:
+				if changed() {
+					appLog.Info("Input changed, merging again")
+					break waiting
+				}
+			}
+		}
+		notifyState(notify.Reloading())
+	}
+}
+
+// runMergeChild runs a merge in a child process, logs its result, and returns its statistics.
+// A failed merge is not an error, the exit code of the failure is logged instead.
+func runMergeChild(ctx context.Context, cmd *exec.Cmd) (mergeStats, error) {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	childStats, err := childMetrics.run(cmd)
+	if ctx.Err() != nil {
+		return childStats, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		appLog.Error("Merge failed", "exit_code", exitErr.ExitCode())
+		return childStats, nil
+	}
+	if err != nil {
+		return childStats, err
+	}
+	appLog.Info("Merge completed")
+	return childStats, nil
+}
+
+// notifyState logs a failed notification of the service manager, which is not fatal
+func notifyState(err error) {
+	if err != nil {
+		appLog.Warn("Cannot notify the service manager", "error", err)
+	}
+}
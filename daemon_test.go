@@ -0,0 +1,153 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDaemonReload verifies that the daemon merges once at startup and again on every SIGHUP
+func TestDaemonReload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGHUP is not supported on Windows")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	merges := make(chan struct{}, 10)
+	command := func(ctx context.Context) *exec.Cmd {
+		merges <- struct{}{}
+		return exec.CommandContext(ctx, "true")
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- daemonLoop(ctx, cfgDefaults, command)
+	}()
+
+	waitForMerge := func() {
+		select {
+		case <-merges:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a merge")
+		}
+	}
+	waitForMerge()
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatalf("Error finding own process: %v", err)
+	}
+	assert.NoError(t, process.Signal(syscall.SIGHUP))
+	waitForMerge()
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+// TestDaemonWatchEvents verifies that a changed input is merged again on its file system event,
+// without waiting for the next poll of the inputs
+func TestDaemonWatchEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("true is not available on Windows")
+	}
+	base := t.TempDir()
+	file := filepath.Join(base, "app.yaml")
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 2\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	cfg := cfgDefaults
+	cfg.basePath = base
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.watch = true
+	cfg.watchInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	merges := make(chan struct{}, 10)
+	command := func(ctx context.Context) *exec.Cmd {
+		merges <- struct{}{}
+		return exec.CommandContext(ctx, "true")
+	}
+	done := make(chan error)
+	go func() {
+		done <- daemonLoop(ctx, cfg, command)
+	}()
+
+	waitForMerge := func() {
+		select {
+		case <-merges:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a merge")
+		}
+	}
+	waitForMerge()
+
+	// The change must be newer than the time of the file the merge started with
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 3\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Error changing input file: %v", err)
+	}
+	waitForMerge()
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+// TestDaemonRemoteRefresh verifies that --watch merges again after --remote.refresh-interval
+// to fetch the remote sources of the hierarchy again
+func TestDaemonRemoteRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("true is not available on Windows")
+	}
+	cfg := writeRemoteHierarchy(t, "https://example.invalid/config.yaml")
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.watch = true
+	cfg.watchInterval = time.Hour
+	cfg.remoteRefreshInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	merges := make(chan struct{}, 10)
+	command := func(ctx context.Context) *exec.Cmd {
+		merges <- struct{}{}
+		return exec.CommandContext(ctx, "true")
+	}
+	done := make(chan error)
+	go func() {
+		done <- daemonLoop(ctx, cfg, command)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-merges:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a merge")
+		}
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
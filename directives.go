@@ -0,0 +1,324 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// listDirectivesKey is the top-level key holding list directives.
+// It maps dot-separated key paths to the operations applied to the list at that path, e.g.
+//
+//	x-hierarchy-lists:
+//	  network.allowlist: [dedupe, sort]
+//
+// Like any other key it can be set and overridden by every layer.
+const listDirectivesKey = "x-hierarchy-lists"
+
+// aggregateDirectivesKey is the top-level key holding aggregation directives.
+// It maps dot-separated key paths of numbers to an operator combining the values of all layers, e.g.
+//
+//	x-hierarchy-aggregate:
+//	  quota.cpu: sum
+//
+// Supported operators are sum, max, and min.
+const aggregateDirectivesKey = "x-hierarchy-aggregate"
+
+// expiryDirectivesKey is the top-level key holding the expiry dates of temporary overrides.
+// It maps dot-separated key paths to the date the value set by the same file expires, e.g.
+//
+//	x-hierarchy-expires:
+//	  app.replicas: 2021-06-30
+//
+// Unlike the other directives, it only applies to the values of the file declaring it.
+const expiryDirectivesKey = "x-hierarchy-expires"
+
+// expiry is the date a value set by a file expires
+type expiry struct {
+	key  string
+	file string
+	date time.Time
+}
+
+// collectExpiries returns the expiry directives of a file.
+// Dates are either YAML timestamps or strings like 2021-06-30.
+func collectExpiries(file string, mergeData map[string]interface{}) []expiry {
+	directives, ok := mergeData[expiryDirectivesKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	expiries := []expiry{}
+	for _, keyPath := range sortedKeys(directives) {
+		var date time.Time
+		var err error
+		switch value := directives[keyPath].(type) {
+		case time.Time:
+			date = value
+		case string:
+			date, err = time.Parse("2006-01-02", value)
+		default:
+			err = fmt.Errorf("expected a date like 2021-06-30, got %v", value)
+		}
+		if err != nil {
+			mergerLog.Warn("Expiry directive ignored",
+				"key", keyPath,
+				"path", file,
+				"error", err,
+			)
+			continue
+		}
+		expiries = append(expiries, expiry{key: keyPath, file: file, date: date})
+	}
+	return expiries
+}
+
+// expiredOverrides returns the expired values that are still part of the merged data,
+// because no later file overrode them
+func expiredOverrides(sources *provenance, now time.Time) []expiry {
+	expired := []expiry{}
+	for _, e := range sources.expiries {
+		if !now.After(e.date) {
+			continue
+		}
+		for _, file := range sources.finalSources(e.key) {
+			if file == e.file {
+				expired = append(expired, e)
+				break
+			}
+		}
+	}
+	return expired
+}
+
+// collectNumbers appends every numeric leaf of mergeData to the values of its key path
+func collectNumbers(numbers map[string][]interface{}, prefix string, mergeData map[string]interface{}) {
+	for key, value := range mergeData {
+		keyPath := key
+		if len(prefix) > 0 {
+			keyPath = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			collectNumbers(numbers, keyPath, nested)
+		} else if _, ok := toFloat(value); ok {
+			numbers[keyPath] = append(numbers[keyPath], value)
+		}
+	}
+}
+
+// applyAggregateDirectives replaces the values of keys declared under aggregateDirectivesKey
+// with the aggregate of the values set by all layers and removes the directives from the data
+func applyAggregateDirectives(data map[string]interface{}, numbers map[string][]interface{}) {
+	directives, ok := data[aggregateDirectivesKey].(map[string]interface{})
+	delete(data, aggregateDirectivesKey)
+	if !ok {
+		return
+	}
+
+	for _, keyPath := range sortedKeys(directives) {
+		operator := directives[keyPath]
+		if _, ok := toFloat(lookupKey(data, keyPath)); !ok {
+			mergerLog.Warn("Aggregate directive ignored, key is not a number", "key", keyPath)
+			continue
+		}
+		result, err := aggregate(fmt.Sprint(operator), numbers[keyPath])
+		if err != nil {
+			mergerLog.Warn("Aggregate directive ignored",
+				"key", keyPath,
+				"error", err,
+			)
+			continue
+		}
+		mergerLog.Debug("Aggregating values",
+			"key", keyPath,
+			"operator", operator,
+			"values", numbers[keyPath],
+			"result", result,
+		)
+		setKey(data, keyPath, result)
+	}
+}
+
+// aggregate combines numbers with the operator.
+// The result is an int if all values are ints, otherwise a float64.
+func aggregate(operator string, values []interface{}) (interface{}, error) {
+	allInts := true
+	var result float64
+	for i, value := range values {
+		number, _ := toFloat(value)
+		if _, ok := value.(int); !ok {
+			allInts = false
+		}
+		switch {
+		case i == 0:
+			result = number
+		case operator == "sum":
+			result += number
+		case operator == "max" && number > result:
+			result = number
+		case operator == "min" && number < result:
+			result = number
+		}
+	}
+	if operator != "sum" && operator != "max" && operator != "min" {
+		return nil, fmt.Errorf("unknown operator %q", operator)
+	}
+	if allInts {
+		return int(result), nil
+	}
+	return result, nil
+}
+
+// applyListDirectives sorts and/or dedupes scalar lists as declared under listDirectivesKey
+// and removes the directives from the data
+func applyListDirectives(data map[string]interface{}) {
+	directives, ok := data[listDirectivesKey].(map[string]interface{})
+	delete(data, listDirectivesKey)
+	if !ok {
+		return
+	}
+
+	for _, keyPath := range sortedKeys(directives) {
+		operations := directives[keyPath]
+		list, ok := lookupKey(data, keyPath).([]interface{})
+		if !ok || !isScalarList(list) {
+			mergerLog.Warn("List directive ignored, key is not a list of scalar values", "key", keyPath)
+			continue
+		}
+		ops, ok := operations.([]interface{})
+		if !ok {
+			ops = []interface{}{operations}
+		}
+		for _, op := range ops {
+			switch op {
+			case "dedupe":
+				list = dedupeList(list)
+			case "sort":
+				sortList(list)
+			default:
+				mergerLog.Warn("Unknown list directive ignored",
+					"key", keyPath,
+					"operation", op,
+				)
+			}
+		}
+		setKey(data, keyPath, list)
+	}
+}
+
+// lookupKey returns the value at a dot-separated key path, or nil if it does not exist
+func lookupKey(data map[string]interface{}, keyPath string) interface{} {
+	var value interface{} = data
+	for _, key := range strings.Split(keyPath, ".") {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = node[key]
+	}
+	return value
+}
+
+// hasKey reports whether a dot-separated key path exists, even if its value is null
+func hasKey(data map[string]interface{}, keyPath string) bool {
+	keys := strings.Split(keyPath, ".")
+	node, ok := lookupKey(data, strings.Join(keys[:len(keys)-1], ".")).(map[string]interface{})
+	if len(keys) == 1 {
+		node, ok = data, true
+	}
+	if !ok {
+		return false
+	}
+	_, ok = node[keys[len(keys)-1]]
+	return ok
+}
+
+// setKey replaces the value at an existing dot-separated key path
+func setKey(data map[string]interface{}, keyPath string, value interface{}) {
+	keys := strings.Split(keyPath, ".")
+	node := data
+	for _, key := range keys[:len(keys)-1] {
+		node = node[key].(map[string]interface{})
+	}
+	node[keys[len(keys)-1]] = value
+}
+
+// isScalarList reports whether the list contains neither maps nor lists
+func isScalarList(list []interface{}) bool {
+	for _, value := range list {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// dedupeList removes repeated values, keeping the first occurrence
+func dedupeList(list []interface{}) []interface{} {
+	seen := map[string]bool{}
+	result := []interface{}{}
+	for _, value := range list {
+		id := fmt.Sprintf("%T:%v", value, value)
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// sortList sorts the list in place, numerically if all values are numbers, otherwise by their text
+func sortList(list []interface{}) {
+	numeric := true
+	for _, value := range list {
+		if _, ok := toFloat(value); !ok {
+			numeric = false
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if numeric {
+			a, _ := toFloat(list[i])
+			b, _ := toFloat(list[j])
+			return a < b
+		}
+		return fmt.Sprint(list[i]) < fmt.Sprint(list[j])
+	})
+}
+
+// toFloat converts the numeric types produced by the YAML decoder to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+// sortedKeys returns the keys of a map in sorted order, so maps are always processed and logged in the same order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
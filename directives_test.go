@@ -0,0 +1,163 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndListDirectivesSuccess runs through the full functionality end-to-end
+// It tests sorting and deduplication of lists declared in x-hierarchy-lists by several layers
+// It compares the generated final file with one stored in git
+func TestEnd2EndListDirectivesSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/list-directives"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	hierarchy := processHierarchy(cfg)
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/list-directives/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestListDirectivesIgnoreInvalidTargets verifies that directives for missing keys and lists of maps are skipped
+func TestListDirectivesIgnoreInvalidTargets(t *testing.T) {
+	data := map[string]interface{}{
+		listDirectivesKey: map[string]interface{}{
+			"missing": "sort",
+			"maps":    "sort",
+		},
+		"maps": []interface{}{
+			map[string]interface{}{"b": 2},
+			map[string]interface{}{"a": 1},
+		},
+	}
+	expected := map[string]interface{}{
+		"maps": []interface{}{
+			map[string]interface{}{"b": 2},
+			map[string]interface{}{"a": 1},
+		},
+	}
+
+	applyListDirectives(data)
+	assert.Equal(t, expected, data)
+}
+
+// TestSortListMixedTypes verifies that lists with mixed types are sorted by their text
+func TestSortListMixedTypes(t *testing.T) {
+	list := []interface{}{"b", 10, "a", 2.5}
+	sortList(list)
+	assert.Equal(t, []interface{}{10, 2.5, "a", "b"}, list)
+}
+
+// TestEnd2EndAggregateDirectivesSuccess runs through the full functionality end-to-end
+// It tests sum, max, and min aggregation of numbers set by several layers
+// It compares the generated final file with one stored in git
+func TestEnd2EndAggregateDirectivesSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/aggregate"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	hierarchy := processHierarchy(cfg)
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/aggregate/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestAggregate verifies the operators and the result type
+func TestAggregate(t *testing.T) {
+	result, err := aggregate("sum", []interface{}{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, result)
+
+	result, err = aggregate("max", []interface{}{1, 2.5, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, result)
+
+	result, err = aggregate("min", []interface{}{3, -1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, -1, result)
+
+	_, err = aggregate("avg", []interface{}{1})
+	assert.Error(t, err)
+}
+
+// TestExpiredOverrides verifies that only expired values still provided by the file declaring the expiry are reported
+func TestExpiredOverrides(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/expiry"
+
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"replicas": 5, "image": "demo:1.1", "debug": false},
+	}, data)
+
+	now := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []expiry{
+		{key: "app.replicas", file: "testdata/expiry/override/app.yaml", date: time.Date(2021, 6, 30, 0, 0, 0, 0, time.UTC)},
+	}, expiredOverrides(sources, now))
+	assert.Empty(t, expiredOverrides(sources, now.AddDate(0, 0, -2)))
+}
+
+// TestFailExpiredOverride ensures that the application is correctly failing
+// if an expired value is still set and --fail.expired is set.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailExpiredOverride(t *testing.T) {
+	if os.Getenv("TEST_FAIL_EXPIRED") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/expiry"
+
+		_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+		checkExpiries(sources, time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC), true)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailExpiredOverride")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_EXPIRED=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
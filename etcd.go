@@ -0,0 +1,231 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// etcdKV is a key of etcd as returned by the JSON gateway, with base64-encoded bytes
+type etcdKV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+// etcdClient writes to etcd through the JSON gateway of its v3 API, trying the endpoints in order
+type etcdClient struct {
+	endpoints []string
+	username  string
+	password  string
+	token     string
+	client    *http.Client
+}
+
+// newEtcdClient configures the client with the --etcd flags, falling back to the environment variables of etcdctl
+func newEtcdClient(cfg config) (*etcdClient, error) {
+	e := &etcdClient{endpoints: cfg.etcdEndpoints, username: cfg.etcdUsername, password: cfg.etcdPassword}
+	if len(e.endpoints) == 0 && len(os.Getenv("ETCDCTL_ENDPOINTS")) > 0 {
+		e.endpoints = strings.Split(os.Getenv("ETCDCTL_ENDPOINTS"), ",")
+	}
+	if len(e.endpoints) == 0 {
+		e.endpoints = []string{"http://127.0.0.1:2379"}
+	}
+	if len(e.username) == 0 {
+		e.username, e.password, _ = strings.Cut(os.Getenv("ETCDCTL_USER"), ":")
+	}
+	tlsConfig := &tls.Config{}
+	if len(cfg.etcdCAFile) > 0 {
+		ca, err := os.ReadFile(cfg.etcdCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading CA certificates of etcd")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no CA certificates found in %s", cfg.etcdCAFile)
+		}
+	}
+	if len(cfg.etcdCert) > 0 || len(cfg.etcdKey) > 0 {
+		pair, err := tls.LoadX509KeyPair(cfg.etcdCert, cfg.etcdKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading client certificate of etcd")
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	e.client = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return e, nil
+}
+
+// call posts a request to a method of the v3 API, e.g. 'kv/range', authenticating first with a username
+func (e *etcdClient) call(method string, input interface{}, output interface{}) error {
+	if len(e.username) > 0 && len(e.token) == 0 && method != "auth/authenticate" {
+		var result struct {
+			Token string `json:"token"`
+		}
+		if err := e.call("auth/authenticate", map[string]string{"name": e.username, "password": e.password}, &result); err != nil {
+			return errors.Wrap(err, "Error authenticating at etcd")
+		}
+		e.token = result.Token
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, endpoint := range e.endpoints {
+		request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(strings.TrimSpace(endpoint), "/")+"/v3/"+method, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if len(e.token) > 0 {
+			request.Header.Set("Authorization", e.token)
+		}
+		response, err := e.client.Do(request)
+		if err != nil {
+			// The next endpoint may be reachable
+			lastErr = err
+			continue
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			var failure struct {
+				Message string `json:"message"`
+			}
+			_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&failure)
+			return fmt.Errorf("%s: %s: %s", method, response.Status, failure.Message)
+		}
+		return json.NewDecoder(response.Body).Decode(output)
+	}
+	return lastErr
+}
+
+// prefixEnd returns the end of the range of all keys starting with prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// All keys
+	return []byte{0}
+}
+
+// publish replaces the keys below prefix with the merged document in a single transaction.
+// The 'flat' layout stores every leaf under its '/'-separated key path below the prefix, like --consul.publish,
+// the 'document' layout stores the whole document under the prefix as key.
+// Only changed keys are written and keys no longer in the document are deleted.
+// The transaction only succeeds if no key below the prefix changed since it was read.
+func (e *etcdClient) publish(prefix string, layout string, output string) error {
+	keys := map[string]string{}
+	if layout == "document" {
+		keys[prefix] = output
+	} else {
+		var document map[string]interface{}
+		if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		if err := flattenKeys(prefix, document, keys); err != nil {
+			return err
+		}
+	}
+	rangeEnd := prefixEnd(prefix)
+	if layout == "document" {
+		// Only the key itself
+		rangeEnd = append([]byte(prefix), 0)
+	}
+
+	var current struct {
+		Header struct {
+			Revision int64 `json:"revision,string"`
+		} `json:"header"`
+		Kvs []etcdKV `json:"kvs"`
+	}
+	if err := e.call("kv/range", map[string]interface{}{"key": []byte(prefix), "range_end": rangeEnd}, &current); err != nil {
+		return err
+	}
+	operations := []map[string]interface{}{}
+	existing := map[string]string{}
+	for _, kv := range current.Kvs {
+		existing[string(kv.Key)] = string(kv.Value)
+		if _, ok := keys[string(kv.Key)]; !ok {
+			operations = append(operations, map[string]interface{}{"request_delete_range": map[string]interface{}{"key": kv.Key}})
+		}
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := existing[name]; ok && value == keys[name] {
+			continue
+		}
+		operations = append(operations, map[string]interface{}{"request_put": map[string]interface{}{"key": []byte(name), "value": []byte(keys[name])}})
+	}
+
+	outputLog.Info("Publishing to etcd", "prefix", prefix, "layout", layout, "changes", len(operations))
+	if len(operations) == 0 {
+		return nil
+	}
+	var result struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := e.call("kv/txn", map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key":          []byte(prefix),
+			"range_end":    rangeEnd,
+			"target":       "MOD",
+			"result":       "LESS",
+			"mod_revision": fmt.Sprint(current.Header.Revision + 1),
+		}},
+		"success": operations,
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if !result.Succeeded {
+		return errors.Errorf("keys below %s changed concurrently", prefix)
+	}
+	return nil
+}
+
+// publishEtcd publishes the merged document below the --etcd.publish prefix, failing with exitWrite
+func publishEtcd(cfg config, output string) {
+	client, err := newEtcdClient(cfg)
+	checkForErrorCode(err, exitWrite)
+	err = client.publish(cfg.etcdPublish, cfg.etcdLayout, output)
+	checkForErrorCode(errors.Wrap(err, "Error publishing to etcd"), exitWrite)
+}
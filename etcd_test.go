@@ -0,0 +1,217 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEtcd serves the JSON gateway of etcd with authentication, applying transactions like etcd does
+type fakeEtcd struct {
+	mu       sync.Mutex
+	revision int64
+	kv       map[string]etcdKV
+	txns     int
+}
+
+func (f *fakeEtcd) put(key string, value string) {
+	f.revision++
+	f.kv[key] = etcdKV{Key: []byte(key), Value: []byte(value), ModRevision: f.revision}
+}
+
+// inRange reports whether key is in the range from key to rangeEnd, or the key itself without rangeEnd
+func inRange(key []byte, start []byte, rangeEnd []byte) bool {
+	if len(rangeEnd) == 0 {
+		return bytes.Equal(key, start)
+	}
+	return bytes.Compare(key, start) >= 0 && (bytes.Equal(rangeEnd, []byte{0}) || bytes.Compare(key, rangeEnd) < 0)
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/v3/auth/authenticate" {
+		var input map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		if input["name"] != "hierarchy" || input["password"] != "s3cr3t" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"message":"etcdserver: authentication failed, invalid user ID or password"}`)
+			return
+		}
+		fmt.Fprint(w, `{"token":"etcd-token"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "etcd-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"message":"etcdserver: user name is empty"}`)
+		return
+	}
+	header := map[string]string{"revision": strconv.FormatInt(f.revision, 10)}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var input struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		kvs := []map[string]interface{}{}
+		for _, kv := range f.kv {
+			if inRange(kv.Key, input.Key, input.RangeEnd) {
+				kvs = append(kvs, map[string]interface{}{"key": kv.Key, "value": kv.Value, "mod_revision": strconv.FormatInt(kv.ModRevision, 10)})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"header": header, "kvs": kvs})
+	case "/v3/kv/txn":
+		f.txns++
+		var input struct {
+			Compare []struct {
+				Key         []byte `json:"key"`
+				RangeEnd    []byte `json:"range_end"`
+				Target      string `json:"target"`
+				Result      string `json:"result"`
+				ModRevision int64  `json:"mod_revision,string"`
+			} `json:"compare"`
+			Success []struct {
+				Put *struct {
+					Key   []byte `json:"key"`
+					Value []byte `json:"value"`
+				} `json:"request_put"`
+				Delete *struct {
+					Key []byte `json:"key"`
+				} `json:"request_delete_range"`
+			} `json:"success"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+		succeeded := true
+		for _, compare := range input.Compare {
+			for _, kv := range f.kv {
+				if inRange(kv.Key, compare.Key, compare.RangeEnd) && kv.ModRevision >= compare.ModRevision {
+					succeeded = false
+				}
+			}
+		}
+		if succeeded {
+			for _, op := range input.Success {
+				if op.Put != nil {
+					f.put(string(op.Put.Key), string(op.Put.Value))
+				} else if op.Delete != nil {
+					delete(f.kv, string(op.Delete.Key))
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"header": header, "succeeded": succeeded})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// etcdConfig returns the configuration of a client of the fake etcd, after an unreachable endpoint
+func etcdConfig(server *httptest.Server) config {
+	cfg := cfgDefaults
+	cfg.etcdEndpoints = []string{"http://127.0.0.1:1", server.URL}
+	cfg.etcdUsername = "hierarchy"
+	cfg.etcdPassword = "s3cr3t"
+	return cfg
+}
+
+// TestPublishEtcdFlat verifies that leaves are published in one transaction, with only changed and removed keys
+func TestPublishEtcdFlat(t *testing.T) {
+	etcd := &fakeEtcd{kv: map[string]etcdKV{}}
+	etcd.put("/config/app/name", "demo")
+	etcd.put("/config/app/debug", "true")
+	etcd.put("/config/application", "other")
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	client, err := newEtcdClient(etcdConfig(server))
+	assert.NoError(t, err)
+
+	unchanged := etcd.kv["/config/app/name"].ModRevision
+	assert.NoError(t, client.publish("/config/app", "flat", "name: demo\nreplicas: 3\ndatabase:\n  host: db\n"))
+	assert.Equal(t, 1, etcd.txns)
+	assert.Equal(t, unchanged, etcd.kv["/config/app/name"].ModRevision)
+	assert.Equal(t, "3", string(etcd.kv["/config/app/replicas"].Value))
+	assert.Equal(t, "db", string(etcd.kv["/config/app/database/host"].Value))
+	assert.NotContains(t, etcd.kv, "/config/app/debug")
+	assert.Contains(t, etcd.kv, "/config/application")
+
+	assert.NoError(t, client.publish("/config/app/", "flat", "name: demo\nreplicas: 3\ndatabase:\n  host: db\n"))
+	assert.Equal(t, 1, etcd.txns)
+}
+
+// TestPublishEtcdDocument verifies that the whole document is published as the value of the prefix
+func TestPublishEtcdDocument(t *testing.T) {
+	etcd := &fakeEtcd{kv: map[string]etcdKV{}}
+	etcd.put("/config/app/name", "kept")
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	client, err := newEtcdClient(etcdConfig(server))
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.publish("/config/app", "document", "name: demo\n"))
+	assert.Equal(t, "name: demo\n", string(etcd.kv["/config/app"].Value))
+	assert.Equal(t, "kept", string(etcd.kv["/config/app/name"].Value))
+}
+
+// TestPublishEtcdConflict verifies that the transaction fails if a key below the prefix changed after it was read
+func TestPublishEtcdConflict(t *testing.T) {
+	etcd := &fakeEtcd{kv: map[string]etcdKV{}}
+	etcd.put("/config/app/name", "demo")
+	changed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v3/kv/txn" && !changed {
+			changed = true
+			etcd.mu.Lock()
+			etcd.put("/config/app/other", "concurrent")
+			etcd.mu.Unlock()
+		}
+		etcd.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := newEtcdClient(etcdConfig(server))
+	assert.NoError(t, err)
+
+	err = client.publish("/config/app", "flat", "name: hierarchy\n")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "changed concurrently")
+	}
+	assert.Equal(t, "demo", string(etcd.kv["/config/app/name"].Value))
+
+	cfg := etcdConfig(server)
+	cfg.etcdPassword = "wrong"
+	client, err = newEtcdClient(cfg)
+	assert.NoError(t, err)
+	err = client.publish("/config/app", "flat", "name: hierarchy\n")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "authentication failed")
+	}
+}
+
+// TestPrefixEnd verifies the end of the range of keys starting with a prefix
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("/config/app0"), prefixEnd("/config/app/"))
+	assert.Equal(t, []byte("b"), prefixEnd("a\xff"))
+	assert.Equal(t, []byte{0}, prefixEnd("\xff"))
+}
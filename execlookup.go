@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// execLookupTimeout limits how long a lookup command may run
+const execLookupTimeout = 30 * time.Second
+
+// execLookup runs allow-listed commands for ${exec:<command> [<arg>...]} references, see --enable-exec-lookups.
+// The command line is split at whitespace and run without a shell.
+type execLookup struct {
+	enabled bool
+	allowed map[string]bool
+}
+
+func newExecLookup(enabled bool, allowed []string) *execLookup {
+	e := &execLookup{enabled: enabled, allowed: map[string]bool{}}
+	for _, command := range allowed {
+		e.allowed[command] = true
+	}
+	return e
+}
+
+// resolve returns the trimmed standard output of the command, which may span several lines
+func (e *execLookup) resolve(reference string) (string, error) {
+	if !e.enabled {
+		return "", errors.New("exec lookups are disabled, see --enable-exec-lookups")
+	}
+	args := strings.Fields(reference)
+	if len(args) == 0 {
+		return "", errors.New("expected a command")
+	}
+	if !e.allowed[args[0]] {
+		return "", fmt.Errorf("command %s is not allowed, see --exec-lookups.allow", args[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), execLookupTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "%s failed: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
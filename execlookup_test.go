@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeLookupCommand creates a script which prints its arguments, each on a line of its own for the argument "lines",
+// or fails for the argument "fail"
+func fakeLookupCommand(t *testing.T) string {
+	return fakeBinary(t, "lookup", `[ "$1" = "fail" ] && { echo "no such key" >&2; exit 1; }
+[ "$1" = "lines" ] && { printf '%s\n' "$@"; exit 0; }
+printf '  %s\n\n' "$*"
+`)
+}
+
+// TestExecLookup verifies that allow-listed commands are run without a shell and their output is trimmed
+func TestExecLookup(t *testing.T) {
+	command := fakeLookupCommand(t)
+	e := newExecLookup(true, []string{command})
+
+	value, err := e.resolve(command + " payments  database")
+	assert.NoError(t, err)
+	assert.Equal(t, "payments database", value)
+
+	value, err = e.resolve(command + " $HOME;id")
+	assert.NoError(t, err)
+	assert.Equal(t, "$HOME;id", value)
+
+	_, err = e.resolve(command + " fail")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no such key")
+	}
+
+	value, err = e.resolve(command + " lines injected: true")
+	assert.NoError(t, err)
+	assert.Equal(t, "lines\ninjected:\ntrue", value)
+}
+
+// TestExecLookupNotAllowed verifies that only allow-listed commands are run, and none unless enabled
+func TestExecLookupNotAllowed(t *testing.T) {
+	command := fakeLookupCommand(t)
+
+	_, err := newExecLookup(true, []string{"lookup"}).resolve(command + " key")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not allowed")
+	}
+	_, err = newExecLookup(true, []string{command}).resolve("  ")
+	assert.Error(t, err)
+	_, err = newExecLookup(false, []string{command}).resolve(command + " key")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "disabled")
+	}
+}
+
+// TestReplaceExecReferences verifies that exec references are resolved next to secret references
+func TestReplaceExecReferences(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.enableExecLookups = true
+	cfg.execLookupsAllow = []string{fakeLookupCommand(t)}
+
+	resolvers := newSecretResolvers(cfg)
+	content := "version: ${exec:" + cfg.execLookupsAllow[0] + " 1.2.3}\n"
+	assert.Equal(t, "version: 1.2.3\n", resolveSecretsIn(t, content, resolvers, true))
+
+	content = "version: ${exec:" + cfg.execLookupsAllow[0] + " lines admin true}\n"
+	assert.Equal(t, "version: |-\n    lines\n    admin\n    true\n", resolveSecretsIn(t, content, resolvers, true))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcpSecretManagerAddress is the address of the Secret Manager API
+var gcpSecretManagerAddress = "https://secretmanager.googleapis.com"
+
+// gcpScope is the OAuth scope of access tokens for Google Cloud APIs
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcpClient reads secrets from Google Cloud Secret Manager with Application Default Credentials
+type gcpClient struct {
+	tokens    oauth2.TokenSource
+	tokensErr error
+	client    *http.Client
+	secrets   map[string]string
+}
+
+func newGCPClient() *gcpClient {
+	return &gcpClient{
+		client:  &http.Client{Timeout: 30 * time.Second},
+		secrets: map[string]string{},
+	}
+}
+
+// accessToken returns an access token of the Application Default Credentials, found by the Google auth library:
+// the credentials file named by GOOGLE_APPLICATION_CREDENTIALS, which can be a service account, a user,
+// or an external account of workload identity federation, the one of the gcloud CLI, or the service account of the metadata server.
+// The token is refreshed before it expires.
+func (g *gcpClient) accessToken() (string, error) {
+	if g.tokens == nil && g.tokensErr == nil {
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, g.client)
+		credentials, err := google.FindDefaultCredentials(ctx, gcpScope)
+		if err != nil {
+			g.tokensErr = errors.Wrap(err, "no Google credentials found")
+		} else {
+			g.tokens = credentials.TokenSource
+		}
+	}
+	if g.tokensErr != nil {
+		return "", g.tokensErr
+	}
+	token, err := g.tokens.Token()
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// resolveSecret returns the payload of a secret version, e.g. 'projects/p/secrets/s/versions/latest'.
+// Without a version, the latest one is returned.
+func (g *gcpClient) resolveSecret(name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	if value, ok := g.secrets[name]; ok {
+		return value, nil
+	}
+	token, err := g.accessToken()
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequest(http.MethodGet, gcpSecretManagerAddress+"/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := g.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var result struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&result)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", response.Status, result.Error.Message)
+	}
+	value, err := base64.StdEncoding.DecodeString(result.Payload.Data)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid payload")
+	}
+	g.secrets[name] = string(value)
+	return string(value), nil
+}
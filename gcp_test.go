@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretManager serves the secret projects/demo/secrets/api, counting the accesses, for the access token
+func fakeSecretManager(t *testing.T, token string, accesses map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accesses[r.URL.Path]++
+		switch {
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"Request had invalid authentication credentials."}}`)
+		case r.URL.Path == "/v1/projects/demo/secrets/api/versions/latest:access":
+			fmt.Fprintf(w, `{"payload":{"data":"%s"}}`, base64.StdEncoding.EncodeToString([]byte("s3cr3t")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"message":"Secret [projects/demo/secrets/missing] not found or has no versions."}}`)
+		}
+	}))
+}
+
+// TestResolveGCPServiceAccount verifies that a service account exchanges a signed JWT for the access token
+func TestResolveGCPServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var decoded map[string]interface{}
+		_ = json.Unmarshal(claims, &decoded)
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil || decoded["iss"] != "hierarchy@demo.iam.gserviceaccount.com" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error_description":"Invalid JWT Signature."}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"service-account-token","expires_in":3599}`)
+	}))
+	defer tokenServer.Close()
+	accesses := map[string]int{}
+	server := fakeSecretManager(t, "service-account-token", accesses)
+	defer server.Close()
+	previous := gcpSecretManagerAddress
+	gcpSecretManagerAddress = server.URL
+	defer func() { gcpSecretManagerAddress = previous }()
+
+	encoded, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %v", err)
+	}
+	credentials, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "hierarchy@demo.iam.gserviceaccount.com",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encoded})),
+		"private_key_id": "1",
+		"token_uri":      tokenServer.URL,
+	})
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(file, credentials, 0600); err != nil {
+		t.Fatalf("Error writing credentials: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+
+	output := resolveSecretsIn(t, `password: ${gcp-sm:projects/demo/secrets/api/versions/latest}
+again: ${gcp-sm:projects/demo/secrets/api}
+missing: ${gcp-sm:projects/demo/secrets/missing}
+`, newSecretResolvers(cfgDefaults), false)
+	assert.Equal(t, `password: s3cr3t
+again: s3cr3t
+missing: ${gcp-sm:projects/demo/secrets/missing}
+`, output)
+	assert.Equal(t, 1, accesses["/v1/projects/demo/secrets/api/versions/latest:access"])
+
+	_, err = newGCPClient().resolveSecret("projects/demo/secrets/missing")
+	assert.EqualError(t, err, "404 Not Found: Secret [projects/demo/secrets/missing] not found or has no versions.")
+}
+
+// TestResolveGCPMetadata verifies that the service account of the metadata server is used without a credentials file
+func TestResolveGCPMetadata(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	defer metadata.Close()
+	server := fakeSecretManager(t, "metadata-token", map[string]int{})
+	defer server.Close()
+	previous := gcpSecretManagerAddress
+	gcpSecretManagerAddress = server.URL
+	defer func() { gcpSecretManagerAddress = previous }()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+
+	value, err := newGCPClient().resolveSecret("projects/demo/secrets/api/versions/latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+}
+
+// TestResolveGCPExternalAccount verifies that workload identity federation exchanges the token of another identity provider
+func TestResolveGCPExternalAccount(t *testing.T) {
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("subject_token") != "oidc-token" || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"federated-token","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer sts.Close()
+	server := fakeSecretManager(t, "federated-token", map[string]int{})
+	defer server.Close()
+	previous := gcpSecretManagerAddress
+	gcpSecretManagerAddress = server.URL
+	defer func() { gcpSecretManagerAddress = previous }()
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("oidc-token"), 0600); err != nil {
+		t.Fatalf("Error writing token: %v", err)
+	}
+	credentials, _ := json.Marshal(map[string]interface{}{
+		"type":               "external_account",
+		"audience":           "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/ci/providers/github",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+		"token_url":          sts.URL,
+		"credential_source":  map[string]string{"file": tokenFile},
+	})
+	file := filepath.Join(dir, "credentials.json")
+	if err := os.WriteFile(file, credentials, 0600); err != nil {
+		t.Fatalf("Error writing credentials: %v", err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+
+	value, err := newGCPClient().resolveSecret("projects/demo/secrets/api")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cr3t", value)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// gitSourcePrefix starts hierarchy entries of git repositories,
+// e.g. 'git::https://github.com/org/config-defaults//base?ref=v1.2.0'
+const gitSourcePrefix = "git::"
+
+// parseGitSource splits a git entry into the URL of the repository, the subdirectory after '//', and the ref.
+// The ref defaults to HEAD, the default branch of the repository.
+func parseGitSource(entry string) (string, string, string, error) {
+	repository := strings.TrimPrefix(entry, gitSourcePrefix)
+	ref := "HEAD"
+	if i := strings.LastIndex(repository, "?"); i >= 0 {
+		query, err := url.ParseQuery(repository[i+1:])
+		if err != nil {
+			return "", "", "", errors.Wrapf(err, "invalid query of git source %s", entry)
+		}
+		for name := range query {
+			if name != "ref" {
+				return "", "", "", fmt.Errorf("unknown parameter %q of git source %s", name, entry)
+			}
+		}
+		if len(query.Get("ref")) > 0 {
+			ref = query.Get("ref")
+		}
+		repository = repository[:i]
+	}
+	// The '//' of the scheme is part of the repository
+	start := 0
+	if i := strings.Index(repository, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	subpath := ""
+	if i := strings.Index(repository[start:], "//"); i >= 0 {
+		subpath = strings.Trim(path.Clean(repository[start+i+2:]), "/")
+		repository = repository[:start+i]
+	}
+	if subpath == "." {
+		subpath = ""
+	}
+	if subpath == ".." || strings.HasPrefix(subpath, "../") {
+		return "", "", "", fmt.Errorf("subdirectory of git source %s is outside of the repository", entry)
+	}
+	if len(repository) == 0 || strings.HasPrefix(repository, "-") || strings.HasPrefix(ref, "-") {
+		return "", "", "", fmt.Errorf("invalid git source %s", entry)
+	}
+	return repository, subpath, ref, nil
+}
+
+// fetchGitSource fetches the ref of a git repository into a bare repository in the cache directory,
+// and writes the files of the subdirectory at that ref into dir.
+// Only the commit of the ref is fetched, without its history.
+func fetchGitSource(cfg config, entry string, dir string) error {
+	repository, subpath, ref, err := parseGitSource(entry)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(repository))
+	mirror := filepath.Join(cacheDir(cfg), "git", hex.EncodeToString(sum[:]))
+	if _, err := os.Stat(filepath.Join(mirror, "HEAD")); err != nil {
+		if err := os.MkdirAll(mirror, 0755); err != nil {
+			return errors.Wrap(err, "Error creating cache directory")
+		}
+		if _, err := gitOutput(mirror, "init", "--quiet", "--bare"); err != nil {
+			return err
+		}
+	}
+	sum = sha256.Sum256([]byte(ref))
+	local := "refs/hierarchy/" + hex.EncodeToString(sum[:])
+	if _, err := gitOutput(mirror, "fetch", "--quiet", "--depth=1", "--force", "--no-tags", repository, ref+":"+local); err != nil {
+		return err
+	}
+	treeish := local
+	if len(subpath) > 0 {
+		treeish += ":" + subpath
+	}
+	return extractRef(mirror, treeish, dir)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseGitSource verifies that the repository, subdirectory, and ref are split like go-getter does
+func TestParseGitSource(t *testing.T) {
+	for entry, expected := range map[string][]string{
+		"git::https://github.com/org/config-defaults//base?ref=v1.2.0":   {"https://github.com/org/config-defaults", "base", "v1.2.0"},
+		"git::https://github.com/org/config-defaults":                    {"https://github.com/org/config-defaults", "", "HEAD"},
+		"git::git@github.com:org/config-defaults.git//teams/a/?ref=main": {"git@github.com:org/config-defaults.git", "teams/a", "main"},
+		"git::file:///srv/git/config//?ref=0a1b2c3":                      {"file:///srv/git/config", "", "0a1b2c3"},
+	} {
+		repository, subpath, ref, err := parseGitSource(entry)
+		assert.NoError(t, err, entry)
+		assert.Equal(t, expected, []string{repository, subpath, ref}, entry)
+	}
+	for _, entry := range []string{
+		"git::https://github.com/org/config-defaults//../secrets",
+		"git::https://github.com/org/config-defaults?depth=1",
+		"git::https://github.com/org/config-defaults?ref=--upload-pack=touch",
+		"git::",
+	} {
+		_, _, _, err := parseGitSource(entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+// TestGitSource verifies that the subdirectory of a repository is merged at the pinned ref
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repository := t.TempDir()
+	commit := func(replicas string, tag string) {
+		path := filepath.Join(repository, "base", "app.yaml")
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		assert.NoError(t, os.WriteFile(path, []byte("app:\n  replicas: "+replicas+"\n"), 0600))
+		for _, args := range [][]string{
+			{"add", "."},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "replicas " + replicas},
+			{"tag", tag},
+		} {
+			if _, err := gitOutput(repository, args...); err != nil {
+				t.Fatalf("Error preparing repository: %v", err)
+			}
+		}
+	}
+	if _, err := gitOutput(repository, "init", "--quiet"); err != nil {
+		t.Fatalf("Error preparing repository: %v", err)
+	}
+	commit("1", "v1.0.0")
+	commit("2", "v2.0.0")
+
+	source := "git::file://" + filepath.ToSlash(repository) + "//base"
+	cfg := writeRemoteHierarchy(t, source+"?ref=v1.0.0", source)
+	hierarchy := processHierarchy(cfg)
+	if assert.Len(t, hierarchy, 2) {
+		assert.Equal(t, []string{filepath.Join(hierarchy[0].path, "app.yaml")}, getFiles(hierarchy[0].path, cfg.filterExtension))
+	}
+	data, _ := mergeFiles(hierarchy[:1], cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{"replicas": 1}, data["app"])
+	data, _ = mergeFiles(hierarchy[1:], cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{"replicas": 2}, data["app"])
+
+	err := fetchGitSource(cfg, source+"?ref=v3.0.0", t.TempDir())
+	assert.Error(t, err)
+	err = fetchGitSource(cfg, "git::file://"+filepath.ToSlash(repository)+"//missing", t.TempDir())
+	assert.Error(t, err)
+}
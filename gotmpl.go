@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// templateSuffix marks input files rendered with Go templates before they are merged, see --templates
+const templateSuffix = ".gotmpl"
+
+// renderTemplates enables rendering of template input files, see --templates
+var renderTemplates = false
+
+// isTemplateFile reports whether an input file is rendered before it is merged
+func isTemplateFile(file string) bool {
+	return renderTemplates && strings.HasSuffix(file, templateSuffix)
+}
+
+// parseTemplate parses the content of a template input file with the template functions
+func parseTemplate(file string, content []byte) (*template.Template, error) {
+	return template.New(filepath.Base(file)).Funcs(templateFuncs).Parse(string(content))
+}
+
+// renderTemplate renders a template input file with the data merged so far as its context,
+// so levels can compute values from the levels before them, e.g. '{{ add .replicas 1 }}'.
+func renderTemplate(file string, content []byte, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := parseTemplate(file, content)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
+}
+
+// templateFuncs are the functions of template input files: all functions of the Sprig library,
+// and toYaml, fromYaml, and required like in Helm
+var templateFuncs = func() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["toYaml"] = templateToYaml
+	funcs["fromYaml"] = templateFromYaml
+	funcs["required"] = templateRequired
+	return funcs
+}()
+
+// templateRequired fails with a message if a value is missing or an empty string, like in Helm
+func templateRequired(message string, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); value == nil || (ok && len(s) == 0) {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// templateToYaml encodes a value as YAML without the trailing newline, to be combined with indent
+func templateToYaml(value interface{}) (string, error) {
+	content, err := yaml.Marshal(value)
+	return strings.TrimSuffix(string(content), "\n"), err
+}
+
+// templateFromYaml decodes a YAML document
+func templateFromYaml(s string) (interface{}, error) {
+	var value interface{}
+	err := yaml.Unmarshal([]byte(s), &value)
+	return value, err
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// enableTemplates turns on rendering of template input files for the rest of a test
+func enableTemplates(t *testing.T) {
+	previous := renderTemplates
+	renderTemplates = true
+	t.Cleanup(func() { renderTemplates = previous })
+}
+
+// TestMergeTemplates verifies that template files are rendered with the data of the levels before them
+func TestMergeTemplates(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/templates"
+
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 2},
+	}, data, "template files are ignored without --templates")
+
+	enableTemplates(t)
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{
+			"name":     "demo",
+			"replicas": 4,
+			"url":      "https://demo.example.com",
+			"tags":     []interface{}{"demo", "prod"},
+		},
+	}, data)
+	assert.Equal(t, "testdata/templates/prod/app.yaml.gotmpl", sources.files[1].path)
+}
+
+// TestRenderTemplateErrors verifies that templates failing to parse or execute are reported with the file
+func TestRenderTemplateErrors(t *testing.T) {
+	_, err := renderTemplate("prod/app.yaml.gotmpl", []byte("replicas: {{ .app.replicas"), nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "app.yaml.gotmpl:1")
+	}
+	_, err = renderTemplate("prod/app.yaml.gotmpl", []byte(`name: {{ required "app.name is required" .app.name }}`), map[string]interface{}{"app": map[string]interface{}{}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "app.name is required")
+	}
+}
+
+// TestTemplateFuncs verifies the functions of Sprig and the ones added like in Helm
+func TestTemplateFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"name":  "demo",
+		"count": 3,
+		"empty": "",
+		"ports": []interface{}{80, 443},
+		"db":    map[string]interface{}{"host": "db", "port": 5432},
+	}
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{template: `{{ .missing | default "fallback" }}`, expected: "fallback"},
+		{template: `{{ .empty | default "fallback" }}`, expected: "fallback"},
+		{template: `{{ .name | default "fallback" }}`, expected: "demo"},
+		{template: `{{ coalesce .missing .empty .name }}`, expected: "demo"},
+		{template: `{{ empty .ports }}`, expected: "false"},
+		{template: `{{ ternary "yes" "no" (eq .name "demo") }}`, expected: "yes"},
+		{template: `{{ .name | upper | quote }}`, expected: `"DEMO"`},
+		{template: `{{ .name | squote }}`, expected: "'demo'"},
+		{template: `{{ "  demo  " | trim | trimPrefix "de" | trimSuffix "o" }}`, expected: "m"},
+		{template: `{{ .name | replace "demo" "prod" }}`, expected: "prod"},
+		{template: `{{ join "," .ports }}`, expected: "80,443"},
+		{template: `{{ splitList "." "a.b" | join "-" }}`, expected: "a-b"},
+		{template: `{{ (split "." "a.b")._0 }} {{ (split "." "a.b")._1 }} {{ len (split "." "a.b") }}`, expected: "a b 2"},
+		{template: `{{ add .count 1 }} {{ sub .count 1 }} {{ mul .count 2 }} {{ div .count 2 }}`, expected: "4 2 6 1"},
+		{template: `{{ add }} {{ add .count }} {{ add .count 1 2 }} {{ mul .count 2 "2" }}`, expected: "0 3 6 12"},
+		{template: `{{ max .count 5 }} {{ min .count "5" }}`, expected: "5 3"},
+		{template: `{{ max .count }} {{ max 1 .count 2 }} {{ min 4 .count 5 }}`, expected: "3 3 3"},
+		{template: `{{ hasKey .db "host" }} {{ get .db "port" }} {{ keys .db | sortAlpha }}`, expected: "true 5432 [host port]"},
+		{template: `{{ toJson (dict "a" 1 "b" (list 1 2)) }}`, expected: `{"a":1,"b":[1,2]}`},
+		{template: "db:\n{{ toYaml .db | indent 2 }}", expected: "db:\n  host: db\n  port: 5432"},
+		{template: "db:{{ toYaml .db | nindent 2 }}", expected: "db:\n  host: db\n  port: 5432"},
+		{template: `{{ (fromYaml "a: [1, 2]").a }}`, expected: "[1 2]"},
+		{template: `{{ "demo" | b64enc }} {{ "ZGVtbw==" | b64dec }}`, expected: "ZGVtbw== demo"},
+		{template: `{{ sha256sum "demo" }}`, expected: "2a97516c354b68848cdbd8f54a226a0a55b21ed138e207ad6c5cbb9c00aa5aea"},
+		{template: `{{ env "HIERARCHY_TEMPLATE_TEST" }}`, expected: "from env"},
+		{template: `{{ semverCompare ">=1.2" "1.4.0" }} {{ regexReplaceAll "[0-9]+" "db01" "N" }} {{ .name | title }}`, expected: "true dbN Demo"},
+		{template: `{{ required "name is required" .name }} {{ required "count is required" 0 }}`, expected: "demo 0"},
+	}
+	t.Setenv("HIERARCHY_TEMPLATE_TEST", "from env")
+	for _, test := range tests {
+		result, err := renderTemplate("test.gotmpl", []byte(test.template), data)
+		if assert.NoError(t, err, test.template) {
+			assert.Equal(t, test.expected, string(result), test.template)
+		}
+	}
+
+	_, err := renderTemplate("test.gotmpl", []byte(`{{ div 1 0 }}`), data)
+	assert.Error(t, err)
+	_, err = renderTemplate("test.gotmpl", []byte(`{{ fail "unsupported" }}`), data)
+	assert.Error(t, err)
+}
+
+// TestLintTemplates verifies that template files are checked for template syntax instead of YAML
+func TestLintTemplates(t *testing.T) {
+	enableTemplates(t)
+	base := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(base, "valid.yaml.gotmpl"), []byte("replicas: {{ .replicas | default 1 }}\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(base, "broken.yaml.gotmpl"), []byte("replicas: {{ .replicas\n"), 0644))
+
+	assert.Empty(t, lintFile(filepath.Join(base, "valid.yaml.gotmpl")))
+	issues := lintFile(filepath.Join(base, "broken.yaml.gotmpl"))
+	if assert.Len(t, issues, 1) {
+		assert.Contains(t, issues[0].message, "broken.yaml.gotmpl:1")
+	}
+}
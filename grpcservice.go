@@ -0,0 +1,105 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	hierarchyv1 "github.com/KohlsTechnology/hierarchy/api/hierarchy/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcVariablePattern restricts the names of variables sent by clients to environment variable names
+var grpcVariablePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// configService serves the merged documents of the config server over gRPC, see api/hierarchy/v1/config.proto
+type configService struct {
+	hierarchyv1.UnimplementedConfigServiceServer
+	server *configServer
+}
+
+// newGRPCServer creates a gRPC server with the config service of the config server
+func newGRPCServer(s *configServer) *grpc.Server {
+	server := grpc.NewServer()
+	hierarchyv1.RegisterConfigServiceServer(server, &configService{server: s})
+	return server
+}
+
+// GetConfig returns the document merged with the variables of the request
+func (c *configService) GetConfig(ctx context.Context, request *hierarchyv1.GetConfigRequest) (*hierarchyv1.Config, error) {
+	if err := checkVariables(request.GetVariables()); err != nil {
+		return nil, err
+	}
+	output, err := c.server.merge(ctx, request.GetVariables())
+	if err != nil {
+		appLog.Error("Merge failed", "error", err, "variables", request.GetVariables())
+		return nil, status.Error(codes.Internal, "merge failed")
+	}
+	return newConfigMessage(output)
+}
+
+// WatchConfig sends the document merged with the variables of the request, and again whenever it changed,
+// until the client cancels the stream
+func (c *configService) WatchConfig(request *hierarchyv1.WatchConfigRequest, stream grpc.ServerStreamingServer[hierarchyv1.Config]) error {
+	if err := checkVariables(request.GetVariables()); err != nil {
+		return err
+	}
+	for output := range c.server.watch(stream.Context(), request.GetVariables()) {
+		config, err := newConfigMessage(output)
+		if err != nil {
+			appLog.Error("Cannot convert merged document to JSON", "error", err)
+			continue
+		}
+		if err := stream.Send(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkVariables rejects variables which could change how the merge runs instead of what the hierarchy file selects:
+// variables of the environment of the server, flags, and values which are not a single path segment
+func checkVariables(variables map[string]string) error {
+	for name, value := range variables {
+		_, set := os.LookupEnv(name)
+		if !grpcVariablePattern.MatchString(name) || set || strings.HasPrefix(name, "HIERARCHY_") || strings.HasPrefix(name, "LD_") {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("invalid variable name %q", name))
+		}
+		if !springNamePattern.MatchString(value) {
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("invalid value %q of variable %s", value, name))
+		}
+	}
+	return nil
+}
+
+// newConfigMessage returns the merged YAML document with its JSON form and checksum
+func newConfigMessage(output []byte) (*hierarchyv1.Config, error) {
+	document, err := yamlToJSON(output)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "cannot convert merged document to JSON")
+	}
+	checksum := sha256.Sum256(output)
+	return &hierarchyv1.Config{Yaml: output, Json: document, Sha256: hex.EncodeToString(checksum[:])}, nil
+}
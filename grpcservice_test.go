@@ -0,0 +1,145 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	hierarchyv1 "github.com/KohlsTechnology/hierarchy/api/hierarchy/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// configServiceClient serves the config service of the config server on a local port and returns a client for it
+func configServiceClient(t *testing.T, s *configServer) hierarchyv1.ConfigServiceClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	server := newGRPCServer(s)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	connection, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	t.Cleanup(func() { _ = connection.Close() })
+	return hierarchyv1.NewConfigServiceClient(connection)
+}
+
+// TestGRPCGetConfig verifies that GetConfig merges with the variables of the request and rejects unsafe variables
+func TestGRPCGetConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	merge := fakeBinary(t, "merge", "printf 'app:\\n  profile: %s\\n' \"$PROFILE\"\n")
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	client := configServiceClient(t, newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	}))
+
+	config, err := client.GetConfig(context.Background(), &hierarchyv1.GetConfigRequest{Variables: map[string]string{"PROFILE": "prod"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "app:\n  profile: prod\n", string(config.GetYaml()))
+		assert.JSONEq(t, `{"app":{"profile":"prod"}}`, string(config.GetJson()))
+		assert.Equal(t, "7a1b9cbd97aef3f038860fd2661a994f0b003e96335b3c395e77f52e0e3f93e4", config.GetSha256())
+	}
+
+	t.Setenv("HIERARCHY_TEST_SET", "1")
+	for name, variables := range map[string]map[string]string{
+		"lowercase name": {"profile": "prod"},
+		"environment":    {"HIERARCHY_TEST_SET": "prod"},
+		"flag":           {"HIERARCHY_BASE_PATH": "prod"},
+		"loader":         {"LD_PRELOAD": "prod"},
+		"path in value":  {"PROFILE": "../prod"},
+		"empty value":    {"PROFILE": ""},
+	} {
+		_, err := client.GetConfig(context.Background(), &hierarchyv1.GetConfigRequest{Variables: variables})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+}
+
+// TestGRPCGetConfigFailure verifies that a failed merge is an internal error without its details
+func TestGRPCGetConfigFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	merge := fakeBinary(t, "merge", "echo 'secret detail' >&2\nexit 1\n")
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	client := configServiceClient(t, newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	}))
+
+	_, err := client.GetConfig(context.Background(), &hierarchyv1.GetConfigRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, err.Error(), "secret detail")
+}
+
+// TestGRPCWatchConfig verifies that WatchConfig sends the merged document again after an input changed
+func TestGRPCWatchConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	base := t.TempDir()
+	file := filepath.Join(base, "app.yaml")
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 2\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	merge := fakeBinary(t, "merge", "cat '"+file+"'\n")
+	cfg := cfgDefaults
+	cfg.basePath = base
+	cfg.watchInterval = 10 * time.Millisecond
+	client := configServiceClient(t, newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stream, err := client.WatchConfig(ctx, &hierarchyv1.WatchConfigRequest{})
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	config, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"app":{"replicas":2}}`, string(config.GetJson()))
+	}
+
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 3\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Error changing input file: %v", err)
+	}
+	config, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "app:\n  replicas: 3\n", string(config.GetYaml()))
+		assert.JSONEq(t, `{"app":{"replicas":3}}`, string(config.GetJson()))
+	}
+}
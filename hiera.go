@@ -0,0 +1,266 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// hieraConfig is a Hiera 5 configuration, see https://puppet.com/docs/puppet/latest/hiera_config_yaml_5.html
+type hieraConfig struct {
+	Version   int          `yaml:"version"`
+	Defaults  hieraLevel   `yaml:"defaults"`
+	Hierarchy []hieraLevel `yaml:"hierarchy"`
+}
+
+// hieraLevel is a level of the Hiera hierarchy, or the defaults of all levels
+type hieraLevel struct {
+	Name        string      `yaml:"name"`
+	Datadir     string      `yaml:"datadir"`
+	DataHash    string      `yaml:"data_hash"`
+	LookupKey   string      `yaml:"lookup_key"`
+	DataDig     string      `yaml:"data_dig"`
+	Path        string      `yaml:"path"`
+	Paths       []string    `yaml:"paths"`
+	Glob        string      `yaml:"glob"`
+	Globs       []string    `yaml:"globs"`
+	URI         string      `yaml:"uri"`
+	URIs        []string    `yaml:"uris"`
+	MappedPaths interface{} `yaml:"mapped_paths"`
+}
+
+// hieraEntry is a path or glob of a level, resolving to a directory of the imported hierarchy
+type hieraEntry struct {
+	level   string
+	datadir string
+	pattern string
+	glob    bool
+}
+
+// hieraInterpolation matches the interpolation of a variable in a path, e.g. %{facts.os.family} or %{::environment}
+var hieraInterpolation = regexp.MustCompile(`%\{(?:::)?([A-Za-z][A-Za-z0-9_]*(?:(?:\.|::)[A-Za-z0-9_]+)*)\}`)
+
+// hieraDataFormats are the data_hash backends whose files can be merged
+var hieraDataFormats = map[string]bool{"yaml_data": true, "json_data": true}
+
+// hieraVariable converts a Hiera variable to the environment variable of the hierarchy file, e.g. facts.os.family to FACTS_OS_FAMILY
+func hieraVariable(name string) string {
+	return strings.ToUpper(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(name, "_"))
+}
+
+// parseHieraConfig reads the levels of a Hiera 5 configuration. Data directories are relative to the configuration file.
+func parseHieraConfig(configFile string, content []byte) ([]hieraEntry, error) {
+	config := hieraConfig{}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", configFile)
+	}
+	if config.Version != 5 {
+		return nil, fmt.Errorf("%s: unsupported version %d, only Hiera 5 can be imported", configFile, config.Version)
+	}
+
+	entries := []hieraEntry{}
+	for i, level := range config.Hierarchy {
+		name := level.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("level %d", i+1)
+		}
+		if len(level.URI) > 0 || len(level.URIs) > 0 || level.MappedPaths != nil {
+			return nil, fmt.Errorf("%s: %s: uri, uris, and mapped_paths cannot be imported", configFile, name)
+		}
+		if len(level.LookupKey) > 0 || len(level.DataDig) > 0 {
+			return nil, fmt.Errorf("%s: %s: only data_hash backends can be imported", configFile, name)
+		}
+		dataHash := level.DataHash
+		if len(dataHash) == 0 {
+			dataHash = config.Defaults.DataHash
+		}
+		if len(dataHash) > 0 && !hieraDataFormats[dataHash] {
+			return nil, fmt.Errorf("%s: %s: unsupported data_hash %s", configFile, name, dataHash)
+		}
+		datadir := level.Datadir
+		if len(datadir) == 0 {
+			datadir = config.Defaults.Datadir
+		}
+		if len(datadir) == 0 {
+			datadir = "data"
+		}
+		if !filepath.IsAbs(datadir) {
+			datadir = filepath.Join(filepath.Dir(configFile), datadir)
+		}
+
+		patterns := append([]string{}, level.Paths...)
+		if len(level.Path) > 0 {
+			patterns = append(patterns, level.Path)
+		}
+		globs := append([]string{}, level.Globs...)
+		if len(level.Glob) > 0 {
+			globs = append(globs, level.Glob)
+		}
+		if len(patterns)+len(globs) == 0 {
+			return nil, fmt.Errorf("%s: %s: no path or glob", configFile, name)
+		}
+		for _, pattern := range patterns {
+			entries = append(entries, hieraEntry{level: name, datadir: datadir, pattern: pattern})
+		}
+		for _, pattern := range globs {
+			if strings.ContainsAny(hieraInterpolation.ReplaceAllString(path.Dir(pattern), ""), "*?[{") {
+				return nil, fmt.Errorf("%s: %s: glob %s must only match files of a single directory", configFile, name, pattern)
+			}
+			entries = append(entries, hieraEntry{level: name, datadir: datadir, pattern: pattern, glob: true})
+		}
+	}
+	for _, entry := range entries {
+		if unsupported := hieraInterpolation.ReplaceAllString(entry.pattern, ""); strings.Contains(unsupported, "%{") {
+			return nil, fmt.Errorf("%s: %s: unsupported interpolation in %s", configFile, entry.level, entry.pattern)
+		}
+	}
+	return entries, nil
+}
+
+// layerPath returns the path of a layer with the interpolations of the entry:
+// the path without its extension, e.g. nodes/%{trusted.certname} for nodes/%{trusted.certname}.yaml,
+// or the directory of the files a glob matches, e.g. common for common/*.yaml
+func (e hieraEntry) layerPath() string {
+	if e.glob {
+		return path.Dir(e.pattern)
+	}
+	return strings.TrimSuffix(e.pattern, path.Ext(e.pattern))
+}
+
+// directory returns the directory of the layer in the hierarchy file, with interpolations replaced by
+// environment variables, e.g. nodes/${TRUSTED_CERTNAME}
+func (e hieraEntry) directory() string {
+	return hieraInterpolation.ReplaceAllStringFunc(e.layerPath(), func(interpolation string) string {
+		return "${" + hieraVariable(hieraInterpolation.FindStringSubmatch(interpolation)[1]) + "}"
+	})
+}
+
+// match returns the directory of the layer a data file is copied to, with interpolations replaced by the values
+// in the path of the file, e.g. nodes/web1 for nodes/web1.yaml, or false if the entry does not match the file.
+// The path of the file is relative to the data directory and separated by slashes.
+func (e hieraEntry) match(file string) (string, bool) {
+	expression := ""
+	for i, literal := range hieraInterpolation.Split(e.pattern, -1) {
+		if i > 0 {
+			expression += `([^/]+)`
+		}
+		if e.glob {
+			expression += globPattern(literal)
+		} else {
+			expression += regexp.QuoteMeta(literal)
+		}
+	}
+	submatches := regexp.MustCompile("^" + expression + "$").FindStringSubmatch(file)
+	if submatches == nil {
+		return "", false
+	}
+	values := submatches[1:]
+	return hieraInterpolation.ReplaceAllStringFunc(e.layerPath(), func(string) string {
+		value := values[0]
+		values = values[1:]
+		return value
+	}), true
+}
+
+// quoteEntry quotes an entry of the hierarchy file if it contains whitespace, comments, quotes, or backslashes
+func quoteEntry(entry string) string {
+	if !strings.ContainsAny(entry, " \t#\"'\\") {
+		return entry
+	}
+	if !strings.Contains(entry, "'") {
+		return "'" + entry + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(entry) + `"`
+}
+
+// importHiera converts a Hiera 5 configuration and its data files to a hierarchy file and a directory per layer.
+// Every data file is copied to the layer of the first level matching it.
+func importHiera(configFile string, hierarchyFile string) ([]scaffoldFile, error) {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseHieraConfig(configFile, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Hiera looks up the first level first, while the hierarchy file lists the lowest priority first
+	lines := []string{fmt.Sprintf("# Imported from %s, from the lowest to the highest priority", configFile)}
+	for i := len(entries) - 1; i >= 0; i-- {
+		lines = append(lines, fmt.Sprintf("%s # %s", quoteEntry(entries[i].directory()), entries[i].level))
+	}
+	files := []scaffoldFile{{path: hierarchyFile, content: strings.Join(lines, "\n") + "\n"}}
+
+	walked := map[string]bool{}
+	for _, entry := range entries {
+		if walked[entry.datadir] {
+			continue
+		}
+		walked[entry.datadir] = true
+		err := filepath.WalkDir(entry.datadir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			relative, err := filepath.Rel(entry.datadir, file)
+			if err != nil {
+				return err
+			}
+			relative = filepath.ToSlash(relative)
+			for _, level := range entries {
+				if level.datadir != entry.datadir {
+					continue
+				}
+				if directory, ok := level.match(relative); ok {
+					content, err := os.ReadFile(file)
+					if err != nil {
+						return err
+					}
+					files = append(files, scaffoldFile{path: filepath.Join(filepath.FromSlash(directory), path.Base(relative)), content: string(content)})
+					return nil
+				}
+			}
+			appLog.Warn("Skipping data file not matched by any level", "path", file)
+			return nil
+		})
+		if os.IsNotExist(err) {
+			appLog.Warn("Data directory not found, skipping", "path", entry.datadir)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// runImportHiera imports a Hiera configuration into the base path
+func runImportHiera(cfg config) {
+	_, err := os.Stat(cfg.importConfig)
+	checkForErrorCode(err, exitPath)
+	files, err := importHiera(cfg.importConfig, cfg.hierarchyFile)
+	checkForErrorCode(err, exitParse)
+	_, err = writeScaffold(cfg.basePath, files)
+	checkForErrorCode(err, exitWrite)
+}
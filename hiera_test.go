@@ -0,0 +1,99 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestParseHieraConfigUnsupported verifies that levels which cannot be converted to directories fail
+func TestParseHieraConfigUnsupported(t *testing.T) {
+	for name, config := range map[string]string{
+		"version":       "version: 3\n",
+		"uri":           "version: 5\nhierarchy:\n  - name: Remote\n    uri: https://example.com\n",
+		"lookup_key":    "version: 5\nhierarchy:\n  - name: Secrets\n    lookup_key: eyaml_lookup_key\n    path: secrets.eyaml\n",
+		"data_hash":     "version: 5\ndefaults:\n  data_hash: hocon_data\nhierarchy:\n  - name: Common\n    path: common.conf\n",
+		"no path":       "version: 5\nhierarchy:\n  - name: Empty\n",
+		"interpolation": "version: 5\nhierarchy:\n  - name: Lookup\n    path: \"%{lookup('team')}.yaml\"\n",
+		"glob":          "version: 5\nhierarchy:\n  - name: Teams\n    glob: \"teams/*/common.yaml\"\n",
+	} {
+		_, err := parseHieraConfig("hiera.yaml", []byte(config))
+		assert.Error(t, err, name)
+	}
+}
+
+// TestHieraEntryMatch verifies that data files are copied to the directory of their level
+func TestHieraEntryMatch(t *testing.T) {
+	node := hieraEntry{pattern: "nodes/%{trusted.certname}.yaml"}
+	assert.Equal(t, "nodes/${TRUSTED_CERTNAME}", node.directory())
+	directory, ok := node.match("nodes/web1.example.com.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, "nodes/web1.example.com", directory)
+	_, ok = node.match("nodes/web1/extra.yaml")
+	assert.False(t, ok)
+
+	team := hieraEntry{pattern: "teams/%{::facts.team}/*.yaml", glob: true}
+	assert.Equal(t, "teams/${FACTS_TEAM}", team.directory())
+	directory, ok = team.match("teams/platform/web.yaml")
+	assert.True(t, ok)
+	assert.Equal(t, "teams/platform", directory)
+}
+
+// TestEnd2EndImportHiera verifies that an imported Hiera hierarchy merges with the precedence of Hiera
+func TestEnd2EndImportHiera(t *testing.T) {
+	basePath := t.TempDir()
+	files, err := importHiera("testdata/hiera/hiera.yaml", "hierarchy.lst")
+	assert.NoError(t, err)
+	_, err = writeScaffold(basePath, files)
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(basePath, "hierarchy.lst"))
+	assert.NoError(t, err)
+	assert.Equal(t, "# Imported from testdata/hiera/hiera.yaml, from the lowest to the highest priority\n"+
+		"common # Common data\n"+
+		"os/${FACTS_OS_FAMILY} # Per-OS defaults\n"+
+		"teams/${FACTS_TEAM} # Per-team data\n"+
+		"nodes/${TRUSTED_CERTNAME} # Per-node data\n", string(content))
+	for _, file := range []string{"common/common.yaml", "os/RedHat/RedHat.yaml", "teams/platform/web.yaml", "nodes/web1.example.com/web1.example.com.yaml"} {
+		assert.FileExists(t, filepath.Join(basePath, filepath.FromSlash(file)))
+	}
+
+	t.Setenv("FACTS_OS_FAMILY", "RedHat")
+	t.Setenv("FACTS_TEAM", "platform")
+	t.Setenv("TRUSTED_CERTNAME", "web1.example.com")
+	cfg := cfgDefaults
+	cfg.basePath = basePath
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	runMerge(cfg)
+
+	content, err = os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	output := map[string]interface{}{}
+	assert.NoError(t, yaml.Unmarshal(content, &output))
+	assert.Equal(t, map[string]interface{}{
+		"ntp::servers":          []interface{}{"0.pool.ntp.org", "1.pool.ntp.org"},
+		"profile::web::port":    8080,
+		"profile::web::workers": 4,
+		"profile::web::package": "httpd",
+	}, output)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// newHTTPSourceClient returns the client fetching HTTP sources, see --http.timeout and the TLS flags
+func newHTTPSourceClient(cfg config) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.httpInsecureSkipVerify}
+	if len(cfg.httpCAFile) > 0 {
+		ca, err := os.ReadFile(cfg.httpCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading CA certificates")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no CA certificates found in %s", cfg.httpCAFile)
+		}
+	}
+	if len(cfg.httpClientCert) > 0 || len(cfg.httpClientKey) > 0 {
+		pair, err := tls.LoadX509KeyPair(cfg.httpClientCert, cfg.httpClientKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error reading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// The client only drops Authorization and Cookie headers on redirects to other hosts, so all headers of --http.header are dropped too,
+	// and also on redirects to plain HTTP
+	checkRedirect := func(request *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !sameOrigin(request.URL, via[0].URL) {
+			for _, header := range cfg.httpHeaders {
+				name, _, _ := strings.Cut(header, ":")
+				request.Header.Del(strings.TrimSpace(name))
+			}
+		}
+		return nil
+	}
+	return &http.Client{Timeout: cfg.httpTimeout, Transport: transport, CheckRedirect: checkRedirect}, nil
+}
+
+// maxHTTPSourceSize limits the size of a file or index fetched from an HTTP source
+const maxHTTPSourceSize = 64 << 20
+
+// sameOrigin reports whether two URLs have the same scheme and host
+func sameOrigin(a *url.URL, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// fetchHTTPSource downloads a YAML or JSON file, or the files listed in an index, into dir.
+// A URL whose file name matches --filter is a file, any other URL is an index listing one URL per line,
+// relative to the index. Lines starting with '#' are comments, and listed files not matching --filter are skipped.
+// Headers are only sent with the scheme and host of the entry, so credentials do not leak to hosts an index
+// or a redirect refers to, or over plain HTTP.
+func fetchHTTPSource(cfg config, entry string, dir string) error {
+	client, err := newHTTPSourceClient(cfg)
+	if err != nil {
+		return err
+	}
+	base, err := url.Parse(entry)
+	if err != nil {
+		return err
+	}
+	filter, err := regexp.Compile(cfg.filterExtension)
+	if err != nil {
+		return err
+	}
+	get := func(location *url.URL) ([]byte, error) {
+		headers := cfg.httpHeaders
+		if !sameOrigin(location, base) {
+			headers = nil
+		}
+		return httpGet(client, location.String(), headers)
+	}
+
+	if filter.MatchString(path.Base(base.Path)) {
+		return downloadHTTPFile(get, base, dir)
+	}
+	index, err := get(base)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(index))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		location, err := base.Parse(line)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid URL in index %s", entry)
+		}
+		if !filter.MatchString(path.Base(location.Path)) {
+			resolverLog.Debug("Skipping file of index not matching the filter", "source", entry, "url", location.String())
+			continue
+		}
+		if err := downloadHTTPFile(get, location, dir); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// downloadHTTPFile stores a file in dir under the last element of its URL path
+func downloadHTTPFile(get func(*url.URL) ([]byte, error), location *url.URL, dir string) error {
+	name := path.Base(location.Path)
+	if name == "." || name == "/" || name == ".." || strings.ContainsAny(name, `\:`) {
+		return fmt.Errorf("no file name in URL %s", location)
+	}
+	file := filepath.Join(dir, name)
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("file name of URL %s is not unique", location)
+	}
+	content, err := get(location)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0644)
+}
+
+// httpGet returns the body of a successful GET request, with additional headers given as 'Name: value'
+func httpGet(client *http.Client, location string, headers []string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return nil, errors.Errorf("invalid HTTP header %q, expected 'Name: value'", header)
+		}
+		request.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("GET %s returned %s", location, response.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxHTTPSourceSize+1))
+	if err == nil && len(content) > maxHTTPSourceSize {
+		return nil, errors.Errorf("GET %s returned more than %d MiB", location, maxHTTPSourceSize>>20)
+	}
+	return content, err
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRemoteHierarchy writes a hierarchy file listing entries into a new base path
+func writeRemoteHierarchy(t *testing.T, entries ...string) config {
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	cfg.cacheDir = t.TempDir()
+	content := ""
+	for _, entry := range entries {
+		content += entry + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(cfg.basePath, cfg.hierarchyFile), []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing hierarchy file: %v", err)
+	}
+	return cfg
+}
+
+// absPath returns the absolute path of a directory of the test data
+func absPath(t *testing.T, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatalf("Error getting absolute path: %v", err)
+	}
+	return abs
+}
+
+// TestHTTPSourceFile verifies that a file is fetched with the configured headers and merged like a directory
+func TestHTTPSourceFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/org/defaults.yaml" || r.Header.Get("Authorization") != "Bearer t0ken" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "app:\n  name: demo\n  replicas: 2\n")
+	}))
+	defer server.Close()
+
+	cfg := writeRemoteHierarchy(t, server.URL+"/org/defaults.yaml", absPath(t, "testdata/default"))
+	cfg.httpHeaders = []string{"Authorization: Bearer t0ken"}
+	hierarchy := processHierarchy(cfg)
+	if assert.Len(t, hierarchy, 2) {
+		assert.Equal(t, filepath.Join(cfg.cacheDir, "sources"), filepath.Dir(hierarchy[0].path))
+	}
+	data, _ := mergeFiles(hierarchy, cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{"name": "demo", "replicas": 2}, data["app"])
+
+	// The same content is stored once
+	assert.Equal(t, hierarchy[0].path, processHierarchy(cfg)[0].path)
+}
+
+// TestHTTPSourceIndex verifies that the files listed in an index are fetched, and headers are only sent to the host of the index
+func TestHTTPSourceIndex(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "unexpected credentials", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "team: payments\n")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/index":
+			fmt.Fprintf(w, "# shared defaults\ncommon.yaml\n\n/teams/payments.yaml\n%s/extra/team.yaml\ntransform.star\n.order\n", other.URL)
+		case "/org/common.yaml":
+			fmt.Fprint(w, "app:\n  name: demo\n")
+		case "/teams/payments.yaml":
+			fmt.Fprint(w, "app:\n  team: payments\n")
+		case "/org/transform.star", "/org/.order":
+			http.Error(w, "unexpected request of a file not matching the filter", http.StatusBadRequest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := writeRemoteHierarchy(t, server.URL+"/org/index")
+	cfg.httpHeaders = []string{"Authorization: Bearer t0ken"}
+	hierarchy := processHierarchy(cfg)
+	if assert.Len(t, hierarchy, 1) {
+		assert.Equal(t, []string{
+			filepath.Join(hierarchy[0].path, "common.yaml"),
+			filepath.Join(hierarchy[0].path, "payments.yaml"),
+			filepath.Join(hierarchy[0].path, "team.yaml"),
+		}, getFiles(hierarchy[0].path, cfg.filterExtension))
+		assert.NoFileExists(t, filepath.Join(hierarchy[0].path, "transform.star"))
+	}
+}
+
+// TestSameOrigin verifies that headers are only sent with the scheme and host of the entry
+func TestSameOrigin(t *testing.T) {
+	base, _ := url.Parse("https://config.example.com/org/index")
+	for location, expected := range map[string]bool{
+		"https://config.example.com/org/common.yaml":  true,
+		"https://CONFIG.example.com/common.yaml":      true,
+		"http://config.example.com/org/common.yaml":   false,
+		"https://config.example.com:8443/common.yaml": false,
+		"https://other.example.com/org/common.yaml":   false,
+	} {
+		parsed, _ := url.Parse(location)
+		assert.Equal(t, expected, sameOrigin(parsed, base), location)
+	}
+}
+
+// TestHTTPSourceRedirect verifies that headers are not sent to another host a redirect refers to
+func TestHTTPSourceRedirect(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != "" || r.Header.Get("X-Api-Key") != "" {
+			http.Error(w, "unexpected credentials", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "app: demo\n")
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Private-Token") != "t0ken" || r.Header.Get("X-Api-Key") != "k3y" {
+			http.Error(w, "missing credentials", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/moved.yaml":
+			http.Redirect(w, r, "/defaults.yaml", http.StatusFound)
+		case "/defaults.yaml":
+			http.Redirect(w, r, other.URL+"/defaults.yaml", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := cfgDefaults
+	cfg.httpHeaders = []string{"PRIVATE-TOKEN: t0ken", "X-API-Key: k3y"}
+	dir := t.TempDir()
+	assert.NoError(t, fetchHTTPSource(cfg, server.URL+"/moved.yaml", dir))
+	assert.FileExists(t, filepath.Join(dir, "moved.yaml"))
+}
+
+// TestHTTPSourceTLS verifies that the server certificate is verified against --http.ca-file
+func TestHTTPSourceTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "app: demo\n")
+	}))
+	defer server.Close()
+
+	cfg := cfgDefaults
+	cfg.cacheDir = t.TempDir()
+	err := fetchHTTPSource(cfg, server.URL+"/defaults.yaml", t.TempDir())
+	assert.Error(t, err)
+
+	cfg.httpCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(cfg.httpCAFile, ca, 0644); err != nil {
+		t.Fatalf("Error writing CA file: %v", err)
+	}
+	dir := t.TempDir()
+	assert.NoError(t, fetchHTTPSource(cfg, server.URL+"/defaults.yaml", dir))
+	assert.FileExists(t, filepath.Join(dir, "defaults.yaml"))
+
+	cfg.httpCAFile = ""
+	cfg.httpInsecureSkipVerify = true
+	assert.NoError(t, fetchHTTPSource(cfg, server.URL+"/defaults.yaml", t.TempDir()))
+}
+
+// TestHTTPSourceErrors verifies that failed requests and ambiguous file names are reported
+func TestHTTPSourceErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/duplicate":
+			fmt.Fprint(w, "a/values.yaml\nb/values.yaml\n")
+		case "/a/values.yaml", "/b/values.yaml":
+			fmt.Fprint(w, "app: demo\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := cfgDefaults
+	err := fetchHTTPSource(cfg, server.URL+"/missing.yaml", t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "404 Not Found")
+	}
+	err = fetchHTTPSource(cfg, server.URL+"/duplicate", t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is not unique")
+	}
+
+	// Best-effort entries are skipped
+	cfg = writeRemoteHierarchy(t, "?"+server.URL+"/missing.yaml", absPath(t, "testdata/default"))
+	assert.Len(t, processHierarchy(cfg), 1)
+}
+
+// TestFailHTTPSourceRestrictToBase ensures that remote sources fail with `--restrict-to-base`.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitPath (4) is a problem
+func TestFailHTTPSourceRestrictToBase(t *testing.T) {
+	if os.Getenv("TEST_FAIL_HTTP_SOURCE_RESTRICT_TO_BASE") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/remote-restricted"
+		cfg.restrictToBase = true
+
+		processHierarchy(cfg)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailHTTPSourceRestrictToBase")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_HTTP_SOURCE_RESTRICT_TO_BASE=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ignoreFile is the optional file of the base path or a directory listing gitignore-style patterns of files not to merge
+const ignoreFile = ".hierarchyignore"
+
+// ignoreBase is the base path, whose ignoreFile and the ones of the directories below it apply to all files below them
+var ignoreBase = ""
+
+// ignoreRule is a pattern of an ignoreFile
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	// negate re-includes a path an earlier pattern ignored, written with a leading '!'
+	negate bool
+	// dirOnly only matches directories, written with a trailing '/'
+	dirOnly bool
+}
+
+// parseIgnoreFile returns the rules of an ignoreFile, one pattern per line.
+// Empty lines and lines starting with '#' are ignored, '\#' and '\!' start a pattern with '#' or '!'.
+func parseIgnoreFile(path string, content string) ([]ignoreRule, error) {
+	rules := []ignoreRule{}
+	for number, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		written := line
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A pattern with a slash is relative to the directory of the ignoreFile, otherwise it matches a name at any level
+		if strings.Contains(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else {
+			line = "**/" + line
+		}
+		if len(line) == 0 {
+			continue
+		}
+		pattern, err := regexp.Compile("^" + globPattern(line) + "$")
+		if err != nil {
+			return nil, errors.Errorf("%s:%d: invalid pattern %s", path, number+1, written)
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// globPattern converts a gitignore-style glob to a regular expression.
+// '*' and '?' match within a path segment, '**' matches any number of segments, and '[...]' a class of characters.
+func globPattern(glob string) string {
+	var pattern strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			pattern.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			pattern.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			pattern.WriteString(".*")
+			i++
+		case c == '*':
+			pattern.WriteString("[^/]*")
+		case c == '?':
+			pattern.WriteString("[^/]")
+		case c == '[' && strings.Contains(glob[i:], "]"):
+			end := i + strings.Index(glob[i:], "]")
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			pattern.WriteString("[" + class + "]")
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return pattern.String()
+}
+
+// matchIgnoreRules returns whether the rules match the slash-separated path of a file relative to their ignoreFile,
+// and whether the file is ignored. The last matching rule wins, and a file in an ignored directory is always ignored.
+func matchIgnoreRules(rules []ignoreRule, path string) (matched bool, ignored bool) {
+	segments := strings.Split(path, "/")
+	for i := range segments {
+		candidate := strings.Join(segments[:i+1], "/")
+		isDir := i < len(segments)-1
+		candidateMatched, candidateIgnored := false, false
+		for _, rule := range rules {
+			if (!rule.dirOnly || isDir) && rule.pattern.MatchString(candidate) {
+				candidateMatched, candidateIgnored = true, !rule.negate
+			}
+		}
+		if candidateMatched {
+			matched, ignored = true, candidateIgnored
+		}
+		if isDir && ignored {
+			return true, true
+		}
+	}
+	return matched, ignored
+}
+
+// ignoreDirs returns the directories whose ignoreFile applies to the files of a hierarchy directory:
+// ignoreBase and the directories below it down to the directory, or only the directory if it is outside of ignoreBase
+func ignoreDirs(includePath string) []string {
+	if len(ignoreBase) == 0 || !isWithinBase(ignoreBase, includePath) {
+		return []string{includePath}
+	}
+	absBase, _ := filepath.Abs(ignoreBase)
+	absPath, _ := filepath.Abs(includePath)
+	rel, _ := filepath.Rel(absBase, absPath)
+	dirs := []string{ignoreBase}
+	dir := ignoreBase
+	if rel != "." {
+		for _, segment := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, segment)
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// ignoredFiles returns the files of a hierarchy directory the applicable ignoreFiles exclude from the merge.
+// The ignoreFile of a deeper directory takes precedence, like in git.
+func ignoredFiles(includePath string, files []string) (map[string]bool, error) {
+	ignored := map[string]bool{}
+	for _, dir := range ignoreDirs(includePath) {
+		path := filepath.Join(dir, ignoreFile)
+		content, err := inputFS.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rules, err := parseIgnoreFile(path, string(content))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				continue
+			}
+			if matched, isIgnored := matchIgnoreRules(rules, filepath.ToSlash(rel)); matched {
+				ignored[file] = isIgnored
+			}
+		}
+	}
+	return ignored, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMatchIgnoreRules verifies the gitignore-style patterns of a .hierarchyignore file
+func TestMatchIgnoreRules(t *testing.T) {
+	rules, err := parseIgnoreFile(ignoreFile, "# comment\n*.bak.yaml\n!keep.bak.yaml\nfixtures/\n/README.yaml\ndocs/**/*.yaml\n\\#hash.yaml\n")
+	assert.NoError(t, err)
+
+	tests := map[string]bool{
+		"app.bak.yaml":          true,
+		"env/app.bak.yaml":      true,
+		"keep.bak.yaml":         false,
+		"fixtures/app.yaml":     true,
+		"env/fixtures/app.yaml": true,
+		"fixtures.yaml":         false,
+		"README.yaml":           true,
+		"env/README.yaml":       false,
+		"docs/a/b/app.yaml":     true,
+		"#hash.yaml":            true,
+		"app.yaml":              false,
+	}
+	for path, expected := range tests {
+		_, ignored := matchIgnoreRules(rules, path)
+		assert.Equal(t, expected, ignored, path)
+	}
+
+	_, err = parseIgnoreFile(ignoreFile, "[z-a].yaml\n")
+	assert.EqualError(t, err, ".hierarchyignore:1: invalid pattern [z-a].yaml")
+}
+
+// TestEnd2EndIgnoreFile verifies that the .hierarchyignore files of the base path and the directories exclude files
+func TestEnd2EndIgnoreFile(t *testing.T) {
+	defer func() { ignoreBase = "" }()
+	ignoreBase = "testdata/ignore"
+	assert.Equal(t, []string{"testdata/ignore/app/app.yaml"}, getFiles("testdata/ignore/app", defaultFileFilter))
+	assert.Empty(t, getFiles("testdata/ignore/fixtures", defaultFileFilter))
+
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/ignore"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "replicas: 2\n", string(content))
+}
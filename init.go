@@ -0,0 +1,162 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// defaultEnvironments are the environments scaffolded by the init command if none were given
+var defaultEnvironments = []string{"dev", "stage", "prod"}
+
+// environmentPattern restricts environments to names of a single directory below the base path
+var environmentPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// scaffoldFile is a file created by the init command, with a path relative to the base path
+type scaffoldFile struct {
+	path    string
+	content string
+}
+
+// parseEnvironments splits a list of environments separated by commas or spaces and checks their names
+func parseEnvironments(list []string) ([]string, error) {
+	environments := []string{}
+	seen := map[string]bool{}
+	for _, item := range list {
+		for _, name := range strings.FieldsFunc(item, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			if !environmentPattern.MatchString(name) || name == "defaults" {
+				return nil, fmt.Errorf("invalid environment %q", name)
+			}
+			if !seen[name] {
+				seen[name] = true
+				environments = append(environments, name)
+			}
+		}
+	}
+	return environments, nil
+}
+
+// promptEnvironments asks for the environments to scaffold, an empty answer selects the default environments
+func promptEnvironments(r io.Reader, w io.Writer) ([]string, error) {
+	fmt.Fprintf(w, "Environments, separated by commas [%s]: ", strings.Join(defaultEnvironments, ","))
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	environments, err := parseEnvironments([]string{answer})
+	if err != nil || len(environments) > 0 {
+		return environments, err
+	}
+	return defaultEnvironments, nil
+}
+
+// scaffold returns the files of a starter layout: shared defaults, and a directory per environment
+// with a hierarchy file and a sample override
+func scaffold(hierarchyFile string, environments []string) []scaffoldFile {
+	files := []scaffoldFile{{
+		path: filepath.Join("defaults", "defaults.yaml"),
+		content: "# Values shared by all environments, overridden by the files of the environments\n" +
+			"app:\n" +
+			"  name: demo\n" +
+			"  replicas: 1\n" +
+			"  logLevel: info\n",
+	}}
+	for _, environment := range environments {
+		files = append(files,
+			scaffoldFile{path: filepath.Join(environment, hierarchyFile), content: environmentHierarchy(environment)},
+			scaffoldFile{
+				path: filepath.Join(environment, "overrides.yaml"),
+				content: fmt.Sprintf("# Values of the %s environment, overriding ../defaults\n", environment) +
+					"app:\n" +
+					fmt.Sprintf("  environment: %s\n", environment),
+			})
+	}
+	return files
+}
+
+// environmentHierarchy returns the hierarchy file of an environment directory, merging the shared defaults first
+func environmentHierarchy(environment string) string {
+	return fmt.Sprintf("# Hierarchy of the %s environment, from the lowest to the highest priority\n", environment) +
+		"../defaults\n" +
+		"./\n"
+}
+
+// writeScaffold creates the files below the base path and returns the paths of the created files.
+// Existing files are kept, so init can add environments to a layout.
+func writeScaffold(basePath string, files []scaffoldFile) ([]string, error) {
+	created := []string{}
+	for _, file := range files {
+		path := filepath.Join(basePath, file.path)
+		if _, err := os.Lstat(path); err == nil {
+			appLog.Info("Keeping existing file", "path", path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return created, errors.Wrapf(err, "Error creating directory %s", filepath.Dir(path))
+		}
+		if err := os.WriteFile(path, []byte(file.content), 0644); err != nil {
+			return created, errors.Wrapf(err, "Error writing %s", path)
+		}
+		appLog.Info("Created file", "path", path)
+		created = append(created, path)
+	}
+	return created, nil
+}
+
+// isTerminal reports whether a file is an interactive terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runInit scaffolds a starter layout in the base path, asking for the environments on a terminal
+// unless they were given with --environment
+func runInit(cfg config) {
+	environments, err := parseEnvironments(cfg.initEnvironments)
+	checkForError(err)
+	if len(environments) == 0 && isTerminal(os.Stdin) {
+		environments, err = promptEnvironments(os.Stdin, os.Stderr)
+		checkForError(err)
+	}
+	if len(environments) == 0 {
+		environments = defaultEnvironments
+	}
+
+	_, err = writeScaffold(cfg.basePath, scaffold(cfg.hierarchyFile, environments))
+	checkForErrorCode(err, exitWrite)
+	printMergeCommands(os.Stdout, cfg, environments)
+}
+
+// printMergeCommands prints the commands merging the scaffolded environments
+func printMergeCommands(w io.Writer, cfg config, environments []string) {
+	fileFlag := ""
+	if cfg.hierarchyFile != "hierarchy.lst" {
+		fileFlag = " -f " + shellQuote(cfg.hierarchyFile)
+	}
+	for _, environment := range environments {
+		fmt.Fprintf(w, "hierarchy -b %s%s -o %s.yaml\n", shellQuote(filepath.Join(cfg.basePath, environment)), fileFlag, environment)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestParseEnvironments verifies that environments can be separated by commas or spaces and must be directory names
+func TestParseEnvironments(t *testing.T) {
+	environments, err := parseEnvironments([]string{"dev, qa", "prod", "dev"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev", "qa", "prod"}, environments)
+
+	for _, name := range []string{"../prod", "a/b", ".hidden", "defaults"} {
+		_, err := parseEnvironments([]string{name})
+		assert.Error(t, err, name)
+	}
+}
+
+// TestPromptEnvironments verifies that an empty answer selects the default environments
+func TestPromptEnvironments(t *testing.T) {
+	var prompt bytes.Buffer
+	environments, err := promptEnvironments(strings.NewReader("\n"), &prompt)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultEnvironments, environments)
+	assert.Equal(t, "Environments, separated by commas [dev,stage,prod]: ", prompt.String())
+
+	environments, err = promptEnvironments(strings.NewReader("test,live"), &prompt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test", "live"}, environments)
+}
+
+// TestEnd2EndScaffold verifies that a scaffolded environment merges, and that existing files are kept
+func TestEnd2EndScaffold(t *testing.T) {
+	basePath := t.TempDir()
+	override := filepath.Join(basePath, "prod", "overrides.yaml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(override), 0755))
+	assert.NoError(t, os.WriteFile(override, []byte("app:\n  replicas: 3\n"), 0644))
+
+	created, err := writeScaffold(basePath, scaffold("hierarchy.lst", []string{"dev", "prod"}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(basePath, "defaults", "defaults.yaml"),
+		filepath.Join(basePath, "dev", "hierarchy.lst"),
+		filepath.Join(basePath, "dev", "overrides.yaml"),
+		filepath.Join(basePath, "prod", "hierarchy.lst"),
+	}, created)
+
+	for environment, expected := range map[string]map[string]interface{}{
+		"dev":  {"name": "demo", "replicas": 1, "logLevel": "info", "environment": "dev"},
+		"prod": {"name": "demo", "replicas": 3, "logLevel": "info"},
+	} {
+		cfg := cfgDefaults
+		cfg.basePath = filepath.Join(basePath, environment)
+		cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+		cfg.annotate = "none"
+		runMerge(cfg)
+
+		content, err := os.ReadFile(cfg.outputFile)
+		assert.NoError(t, err)
+		output := map[string]map[string]interface{}{}
+		assert.NoError(t, yaml.Unmarshal(content, &output))
+		assert.Equal(t, expected, output["app"], environment)
+	}
+}
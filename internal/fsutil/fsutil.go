@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fsutil is the file system layer hierarchy reads its input through.
+// It allows tests to use an in-memory file system, and --sandbox to confine reading to the base path.
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/sandbox"
+)
+
+// FS reads files and directories. Names are operating system paths, like for the os package.
+type FS interface {
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	// Lstat does not follow a symlink as the last element of the name
+	Lstat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	// ReadDir lists a directory sorted by file name
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// OS is the file system of the operating system
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// Sandbox reads only files below the directory of root, see sandbox.Root
+func Sandbox(root *sandbox.Root) FS {
+	return sandboxFS{root: root}
+}
+
+type sandboxFS struct {
+	root *sandbox.Root
+}
+
+func (s sandboxFS) Open(name string) (fs.File, error)          { return s.root.Open(name) }
+func (s sandboxFS) Stat(name string) (fs.FileInfo, error)      { return s.root.Stat(name) }
+func (s sandboxFS) Lstat(name string) (fs.FileInfo, error)     { return s.root.Lstat(name) }
+func (s sandboxFS) ReadFile(name string) ([]byte, error)       { return s.root.ReadFile(name) }
+func (s sandboxFS) ReadDir(name string) ([]fs.DirEntry, error) { return s.root.ReadDir(name) }
+
+// FromFS adapts an fs.FS, e.g. a testing/fstest.MapFS. Names are cleaned and made relative to the root of fsys,
+// so "./a/../b/c.yaml" reads "b/c.yaml". Names leaving the root with ".." are invalid. An fs.FS has no symlinks, so Lstat is Stat.
+func FromFS(fsys fs.FS) FS {
+	return ioFS{fsys: fsys}
+}
+
+type ioFS struct {
+	fsys fs.FS
+}
+
+// name converts an operating system path to a name in fsys
+func (f ioFS) name(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+func (f ioFS) Open(name string) (fs.File, error)          { return f.fsys.Open(f.name(name)) }
+func (f ioFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(f.fsys, f.name(name)) }
+func (f ioFS) Lstat(name string) (fs.FileInfo, error)     { return fs.Stat(f.fsys, f.name(name)) }
+func (f ioFS) ReadFile(name string) ([]byte, error)       { return fs.ReadFile(f.fsys, f.name(name)) }
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, f.name(name)) }
@@ -0,0 +1,51 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsutil
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFromFS verifies that operating system paths are mapped to names in the file system
+func TestFromFS(t *testing.T) {
+	fsys := FromFS(fstest.MapFS{
+		"base/hierarchy.lst":   {Data: []byte("app\n")},
+		"base/app/a.yaml":      {Data: []byte("a: 1\n")},
+		"base/app/sub/b.yaml":  {Data: []byte("b: 1\n")},
+		"defaults/common.yaml": {Data: []byte("c: 1\n")},
+	})
+
+	content, err := fsys.ReadFile("./base/app/../hierarchy.lst")
+	assert.NoError(t, err)
+	assert.Equal(t, "app\n", string(content))
+
+	info, err := fsys.Stat("/base/app")
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	entries, err := fsys.ReadDir("base/app")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a.yaml", entries[0].Name())
+	assert.True(t, entries[1].IsDir())
+
+	_, err = fsys.Open("../defaults/common.yaml")
+	assert.Error(t, err)
+}
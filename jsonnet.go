@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonnetOptions configures the evaluation of Jsonnet input files, see --jsonnet
+type jsonnetOptions struct {
+	enabled      bool
+	binary       string
+	extVars      []string
+	libraryPaths []string
+}
+
+// jsonnet is the configuration of the evaluation of Jsonnet input files
+var jsonnet = jsonnetOptions{binary: "jsonnet"}
+
+// isJsonnetFile reports whether an input file is evaluated with Jsonnet before it is merged.
+// Libraries ending in .libsonnet are only imported by them.
+func isJsonnetFile(file string) bool {
+	return jsonnet.enabled && strings.EqualFold(filepath.Ext(file), ".jsonnet")
+}
+
+// evaluateJsonnet evaluates a Jsonnet input file with the jsonnet CLI and returns its JSON output.
+// The content already read is evaluated from standard input with the directory of the file first
+// in the library paths, so imports of files next to it, like shared .libsonnet files, work as usual.
+func evaluateJsonnet(options jsonnetOptions, file string, content []byte) ([]byte, error) {
+	args := []string{"--jpath", filepath.Dir(file)}
+	for _, path := range options.libraryPaths {
+		args = append(args, "--jpath", path)
+	}
+	for _, extVar := range options.extVars {
+		args = append(args, "--ext-str", extVar)
+	}
+	args = append(args, "-")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(options.binary, args...)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "jsonnet evaluation of %s failed: %s", file, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeJsonnet creates a script standing in for the jsonnet binary, which records its arguments
+// and prints the output if the Jsonnet source on standard input imports the library
+func fakeJsonnet(t *testing.T, output string) (string, string) {
+	args := filepath.Join(t.TempDir(), "args")
+	script := fakeBinary(t, "jsonnet", `echo "$*" > "`+args+`"
+grep -q "import 'lib.libsonnet'" || { echo "RUNTIME ERROR: couldn't open import" >&2; exit 1; }
+printf '%s' '`+output+`'
+`)
+	return script, args
+}
+
+// TestMergeJsonnet verifies that Jsonnet files are evaluated with the configured external variables and library paths,
+// and that libraries are not merged themselves
+func TestMergeJsonnet(t *testing.T) {
+	binary, args := fakeJsonnet(t, `{"app": {"replicas": 4, "url": "https://demo.example.com"}}`)
+	previous := jsonnet
+	defer func() { jsonnet = previous }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/jsonnet"
+	jsonnet = jsonnetOptions{binary: binary}
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 2},
+	}, data, "Jsonnet files are ignored without --jsonnet")
+
+	jsonnet = jsonnetOptions{enabled: true, binary: binary, extVars: []string{"env=prod"}, libraryPaths: []string{"vendor"}}
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 4, "url": "https://demo.example.com"},
+	}, data)
+	assert.Len(t, sources.files, 2)
+	assert.Equal(t, "testdata/jsonnet/prod/app.jsonnet", sources.files[1].path)
+
+	recorded, err := os.ReadFile(args)
+	assert.NoError(t, err)
+	assert.Equal(t, "--jpath testdata/jsonnet/prod --jpath vendor --ext-str env=prod -\n", string(recorded))
+}
+
+// TestEvaluateJsonnetError verifies that errors of the jsonnet CLI are reported with the file
+func TestEvaluateJsonnetError(t *testing.T) {
+	binary, _ := fakeJsonnet(t, "{}")
+	_, err := evaluateJsonnet(jsonnetOptions{binary: binary}, "prod/app.jsonnet", []byte("{}"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "jsonnet evaluation of prod/app.jsonnet failed: RUNTIME ERROR: couldn't open import")
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// jsonNumber matches the numbers JSON and HCL can take as they are
+	jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+	// zeroPadded matches integers with leading zeros, e.g. IDs or abbreviated commit hashes
+	zeroPadded = regexp.MustCompile(`^[-+]?0[0-9]+$`)
+)
+
+// yamlToJSON converts a merged YAML document to JSON, keeping its key order and the text of its scalars, see scalarLiteral.
+// An empty document is null.
+func yamlToJSON(output []byte) ([]byte, error) {
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(output, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return []byte("null"), nil
+	}
+	return nodeToJSON(document.Content[0])
+}
+
+// nodeToJSON converts a YAML node to compact JSON, keeping its key order and the text of its scalars
+func nodeToJSON(node *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, node); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeJSON(b *bytes.Buffer, node *yaml.Node) error {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		b.WriteByte('{')
+		for i, pair := range mappingPairs(node) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(pair.key)
+			b.Write(key)
+			b.WriteByte(':')
+			if err := writeJSON(b, pair.value); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	case yaml.SequenceNode:
+		b.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeJSON(b, item); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	default:
+		literal, isString, err := scalarLiteral(node)
+		if err != nil {
+			return err
+		}
+		if isString {
+			content, _ := json.Marshal(literal)
+			b.Write(content)
+		} else {
+			b.WriteString(literal)
+		}
+	}
+	return nil
+}
+
+// scalarLiteral returns the value of a scalar node as a string, or the JSON literal of a number, boolean, or null.
+// Numbers are kept as they were written, so large integers and floats like 1.10 do not lose digits.
+// Integers with leading zeros are strings, since JSON has no such numbers, and other scalars like
+// timestamps or values with custom tags are strings of their text, like in the YAML output.
+func scalarLiteral(node *yaml.Node) (string, bool, error) {
+	switch node.ShortTag() {
+	case "!!null":
+		return "null", false, nil
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return "", false, err
+		}
+		return strconv.FormatBool(value), false, nil
+	case "!!int", "!!float":
+		if jsonNumber.MatchString(node.Value) {
+			return node.Value, false, nil
+		}
+		if zeroPadded.MatchString(node.Value) {
+			return node.Value, true, nil
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return "", false, err
+		}
+		if number, ok := value.(float64); ok {
+			if math.IsInf(number, 0) || math.IsNaN(number) {
+				return "", false, fmt.Errorf("line %d: %s is not a finite number and cannot be converted", node.Line, node.Value)
+			}
+			return strconv.FormatFloat(number, 'g', -1, 64), false, nil
+		}
+		return fmt.Sprint(value), false, nil
+	default:
+		return node.Value, true, nil
+	}
+}
+
+// nodePair is a key of a mapping node with its value
+type nodePair struct {
+	key   string
+	value *yaml.Node
+}
+
+// mappingPairs returns the keys of a mapping node with their values in order.
+// The keys of merge keys take the place of the merge key, unless the mapping or an earlier merged mapping sets them.
+func mappingPairs(node *yaml.Node) []nodePair {
+	explicit := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) {
+			explicit[resolveAlias(node.Content[i]).Value] = true
+		}
+	}
+	var pairs []nodePair
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		if !isMergeKey(key) {
+			pairs = append(pairs, nodePair{key: resolveAlias(key).Value, value: value})
+			continue
+		}
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, source := range merged {
+			source = resolveAlias(source)
+			if source.Kind != yaml.MappingNode {
+				continue
+			}
+			for _, pair := range mappingPairs(source) {
+				if !explicit[pair.key] && !seen[pair.key] {
+					seen[pair.key] = true
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	return pairs
+}
+
+// isMergeKey reports whether a mapping key is the merge key '<<'
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+// resolveAlias returns the node an alias refers to, or the node itself
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
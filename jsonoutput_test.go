@@ -0,0 +1,50 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestYamlToJSON verifies that scalars keep their text and tags, and maps keep their key order
+func TestYamlToJSON(t *testing.T) {
+	for document, expected := range map[string]string{
+		"":                           `null`,
+		"sha: 0123\n":                `{"sha":"0123"}`,
+		"id: 12345678901234567890\n": `{"id":12345678901234567890}`,
+		"version: 1.10\n":            `{"version":1.10}`,
+		"mask: 0x1F\n":               `{"mask":31}`,
+		"half: .5\n":                 `{"half":0.5}`,
+		"quoted: \"true\"\n":         `{"quoted":"true"}`,
+		"tagged: !!str 123\n":        `{"tagged":"123"}`,
+		"custom: !secret 123\n":      `{"custom":"123"}`,
+		"on: yes\n":                  `{"on":"yes"}`,
+		"date: 2024-01-02\n":         `{"date":"2024-01-02"}`,
+		"b: 1\na: [true, ~]\n":       `{"b":1,"a":[true,null]}`,
+		"base: &base\n  a: 1\n  b: 2\napp:\n  b: 3\n  <<: *base\n  c: *base\n": `{"base":{"a":1,"b":2},"app":{"b":3,"a":1,"c":{"a":1,"b":2}}}`,
+		"a: &a {x: 1}\nb: &b {x: 2, y: 2}\nc:\n  <<: [*a, *b]\n":               `{"a":{"x":1},"b":{"x":2,"y":2},"c":{"x":1,"y":2}}`,
+	} {
+		content, err := yamlToJSON([]byte(document))
+		assert.NoError(t, err, document)
+		assert.Equal(t, expected, string(content), document)
+	}
+
+	_, err := yamlToJSON([]byte("limit: .inf\n"))
+	assert.EqualError(t, err, "line 1: .inf is not a finite number and cannot be converted")
+}
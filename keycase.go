@@ -0,0 +1,77 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keyCase is how keys which only differ in case are merged, see --key-case:
+// 'sensitive' merges them as different keys, 'first' as the spelling of the first file that defined a key, and 'lower' in lower case
+var keyCase = "sensitive"
+
+// foldKey returns a key or key path the way keys are compared with --key-case,
+// so queries of get, keys, and explain match keys which only differ in case from their canonical spelling
+func foldKey(key string) string {
+	if keyCase == "sensitive" {
+		return key
+	}
+	return strings.ToLower(key)
+}
+
+// canonicalKeys renames the keys of the mappings of an input file to their canonical spelling,
+// so keys which only differ in case are merged, and reported as duplicates within the same mapping.
+// spellings maps the key paths in lower case to the spelling of the first file that defined them.
+func canonicalKeys(node *yaml.Node, prefix string, spellings map[string]string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			canonicalKeys(child, prefix, spellings)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			canonicalKeys(child, fmt.Sprintf("%s[%d]", prefix, i), spellings)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			keyPath := strings.ToLower(key.Value)
+			if len(prefix) > 0 {
+				keyPath = prefix + "." + keyPath
+			}
+			spelling, ok := spellings[keyPath]
+			switch {
+			case keyCase == "lower":
+				spelling = strings.ToLower(key.Value)
+			case !ok:
+				spelling = key.Value
+				spellings[keyPath] = spelling
+			}
+			if spelling != key.Value {
+				mergerLog.Debug("Renaming key to its canonical case", "key", key.Value, "canonical", spelling, "line", key.Line)
+				key.Value = spelling
+			}
+			canonicalKeys(value, keyPath, spellings)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndKeyCase verifies that keys which only differ in case are merged as one key with --key-case
+func TestEnd2EndKeyCase(t *testing.T) {
+	defer func() { keyCase = "sensitive" }()
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/keycase"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	for policy, expected := range map[string]string{
+		"sensitive": "app:\n    LogLevel: info\n    Name: demo\nAPP:\n    loglevel: debug\n",
+		"first":     "app:\n    LogLevel: debug\n    Name: demo\n",
+		"lower":     "app:\n    loglevel: debug\n    name: demo\n",
+	} {
+		keyCase = policy
+		runMerge(cfg)
+		content, err := os.ReadFile(cfg.outputFile)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content), policy)
+	}
+}
+
+// TestCanonicalKeysDuplicates verifies that keys which only differ in case are duplicates within the same mapping
+func TestCanonicalKeysDuplicates(t *testing.T) {
+	defer func() { keyCase = "sensitive" }()
+	keyCase = "first"
+	content := []byte("app:\n  LogLevel: info\n  loglevel: debug\n")
+	_, document, err := parseInput("app.yaml", content)
+	assert.NoError(t, err)
+	canonicalKeys(document, "", map[string]string{})
+	_, err = decodeInput("app.yaml", content, document)
+	if assert.Error(t, err) {
+		assert.Equal(t, "app.yaml:3: key app.LogLevel already defined at line 2", err.Error())
+	}
+}
+
+// TestKeyCaseQueries verifies that explain, get, and keys find keys which only differ in case from the query with --key-case
+func TestKeyCaseQueries(t *testing.T) {
+	defer func() { keyCase = "sensitive" }()
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/keycase"
+
+	for _, policy := range []string{"first", "lower"} {
+		keyCase = policy
+		data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+		output := renderOutput(data, false, false)
+
+		var explained bytes.Buffer
+		assert.NoError(t, sources.explain(&explained, "APP.loglevel"), policy)
+		assert.Contains(t, explained.String(), "set by:    testdata/keycase/prod/app.yaml", policy)
+		assert.EqualError(t, sources.explain(&explained, "app.missing"), `key "app.missing" not found in merged data`, policy)
+
+		var value bytes.Buffer
+		assert.NoError(t, writeQuery(&value, output, "App.LOGLEVEL", "raw"), policy)
+		assert.Equal(t, "debug\n", value.String(), policy)
+
+		var keys bytes.Buffer
+		assert.NoError(t, writeKeys(&keys, output, "APP", sources, false, true), policy)
+		assert.Contains(t, keys.String(), "\ttestdata/keycase/prod/app.yaml\n", policy)
+	}
+
+	keyCase = "sensitive"
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Error(t, sources.explain(&bytes.Buffer{}, "app.loglevel"))
+}
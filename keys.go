@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// leafKey is a leaf of a merged document: a scalar, an empty map, or an empty list
+type leafKey struct {
+	steps []queryStep
+	node  *yaml.Node
+}
+
+// leafKeys returns the leaves below a node in the order of the document
+func leafKeys(node *yaml.Node, steps []queryStep) []leafKey {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	leaves := []leafKey{}
+	switch {
+	case node.Kind == yaml.MappingNode && len(node.Content) > 0:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			leaves = append(leaves, leafKeys(node.Content[i+1], appendStep(steps, queryStep{key: node.Content[i].Value}))...)
+		}
+	case node.Kind == yaml.SequenceNode && len(node.Content) > 0:
+		for i, item := range node.Content {
+			leaves = append(leaves, leafKeys(item, appendStep(steps, queryStep{index: i, isIndex: true}))...)
+		}
+	default:
+		leaves = append(leaves, leafKey{steps: steps, node: node})
+	}
+	return leaves
+}
+
+// appendStep returns a copy of the steps with another step, so the steps of sibling keys do not share an array
+func appendStep(steps []queryStep, step queryStep) []queryStep {
+	return append(append([]queryStep{}, steps...), step)
+}
+
+// leafType returns the type of a leaf, e.g. str, int, map for an empty map, or list for an empty list
+func leafType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "map"
+	case yaml.SequenceNode:
+		return "list"
+	}
+	return strings.TrimPrefix(node.ShortTag(), "!!")
+}
+
+// provenanceKey returns the key path the provenance records the sources of a leaf under,
+// which is the list for items of lists, as lists are replaced as a whole
+func provenanceKey(steps []queryStep) string {
+	keys := []string{}
+	for _, step := range steps {
+		if step.isIndex {
+			break
+		}
+		keys = append(keys, step.key)
+	}
+	return strings.Join(keys, ".")
+}
+
+// writeKeys prints the path of every leaf below a query of a merged YAML document, one per line,
+// optionally followed by its type and the files that provided its value, separated by tabs.
+// Values no file provided, e.g. the ones added by transforms, have the source '-'.
+func writeKeys(w io.Writer, output string, query string, sources *provenance, types bool, files bool) error {
+	node, steps, err := queryDocument(output, query)
+	if err != nil {
+		return err
+	}
+
+	for _, leaf := range leafKeys(node, steps) {
+		columns := []string{formatQuery(leaf.steps)}
+		if len(leaf.steps) == 0 {
+			columns[0] = "."
+		}
+		if types {
+			columns = append(columns, leafType(leaf.node))
+		}
+		if files {
+			provided := "-"
+			if key := provenanceKey(leaf.steps); len(key) > 0 && len(sources.finalSources(key)) > 0 {
+				provided = strings.Join(sources.finalSources(key), ",")
+			}
+			columns = append(columns, provided)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(columns, "\t")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
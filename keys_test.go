@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteKeys verifies the paths, types, and sources of the leaves of a merged document
+func TestWriteKeys(t *testing.T) {
+	output := "app:\n  name: demo\n  replicas: 3\n  hosts:\n    - name: web1\n    - web2\n  labels: {}\n  volumes: []\n  \"log.level\": ~\nadded: true\n"
+	sources := newProvenance()
+	sources.files = []mergedFile{{path: "defaults/app.yaml"}, {path: "prod/app.yaml"}}
+	sources.keys = map[string][]string{
+		"app.name":      {"defaults/app.yaml"},
+		"app.replicas":  {"defaults/app.yaml", "prod/app.yaml"},
+		"app.hosts":     {"prod/app.yaml"},
+		"app.labels":    {"defaults/app.yaml"},
+		"app.volumes":   {"defaults/app.yaml"},
+		"app.log.level": {"prod/app.yaml"},
+	}
+
+	var keys strings.Builder
+	assert.NoError(t, writeKeys(&keys, output, ".", sources, false, false))
+	assert.Equal(t, "app.name\napp.replicas\napp.hosts[0].name\napp.hosts[1]\napp.labels\napp.volumes\napp[\"log.level\"]\nadded\n", keys.String())
+
+	var detailed strings.Builder
+	assert.NoError(t, writeKeys(&detailed, output, "app", sources, true, true))
+	assert.Equal(t, "app.name\tstr\tdefaults/app.yaml\n"+
+		"app.replicas\tint\tprod/app.yaml\n"+
+		"app.hosts[0].name\tstr\tprod/app.yaml\n"+
+		"app.hosts[1]\tstr\tprod/app.yaml\n"+
+		"app.labels\tmap\tdefaults/app.yaml\n"+
+		"app.volumes\tlist\tdefaults/app.yaml\n"+
+		"app[\"log.level\"]\tnull\tprod/app.yaml\n", detailed.String())
+
+	var added strings.Builder
+	assert.NoError(t, writeKeys(&added, output, "added", sources, true, true))
+	assert.Equal(t, "added\tbool\t-\n", added.String())
+
+	assert.Error(t, writeKeys(&strings.Builder{}, output, "app.missing", sources, false, false))
+}
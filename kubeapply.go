@@ -0,0 +1,103 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	// Registers the auth-provider plugins of kubeconfig users, oidc and the removed gcp and azure ones explaining their replacement
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeTimeout limits how long a request to the API server may take
+const kubeTimeout = 30 * time.Second
+
+// kubeClient applies manifests with the dynamic client of client-go
+type kubeClient struct {
+	client    dynamic.Interface
+	namespace string
+}
+
+// newKubeClient connects to the cluster of the kubeconfig found like kubectl does: the file of path,
+// else all files of $KUBECONFIG merged, else ~/.kube/config, and only without any of them the service account of a pod.
+// A non-empty context is used instead of the current context of the kubeconfig.
+func newKubeClient(path string, context string) (*kubeClient, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: context})
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading kubeconfig")
+	}
+	config.Timeout = kubeTimeout
+	namespace, _, err := loader.Namespace()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading kubeconfig")
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeClient{client: client, namespace: namespace}, nil
+}
+
+// apply creates or updates a ConfigMap or Secret with a server-side apply. With forceConflicts, fields owned by other
+// managers are taken over like with 'kubectl apply --server-side --force-conflicts', otherwise the apply fails with a conflict.
+func (c *kubeClient) apply(kind string, name string, namespace string, manifest string, fieldManager string, forceConflicts bool, dryRun bool) error {
+	if len(namespace) == 0 {
+		namespace = c.namespace
+	}
+	resource := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	if kind == "secret" {
+		resource.Resource = "secrets"
+	}
+	object := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(manifest), &object.Object); err != nil {
+		return err
+	}
+	options := metav1.ApplyOptions{FieldManager: fieldManager, Force: forceConflicts}
+	if dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+	_, err := c.client.Resource(resource).Namespace(namespace).Apply(context.Background(), name, object, options)
+	return err
+}
+
+// applyManifest applies the manifest to the cluster instead of writing it, see --kubernetes.apply
+func applyManifest(cfg config, manifest string) {
+	outputLog.Info("Applying manifest to cluster",
+		"kind", cfg.kubernetesKind,
+		"name", cfg.kubernetesName,
+		"namespace", cfg.kubernetesNamespace,
+		"context", cfg.kubernetesContext,
+		"forceConflicts", cfg.kubernetesForce,
+		"dryRun", cfg.kubernetesDryRun,
+	)
+	client, err := newKubeClient(cfg.kubeconfig, cfg.kubernetesContext)
+	checkForErrorCode(err, exitWrite)
+	err = client.apply(cfg.kubernetesKind, cfg.kubernetesName, cfg.kubernetesNamespace, manifest,
+		cfg.kubernetesFieldManager, cfg.kubernetesForce, cfg.kubernetesDryRun)
+	checkForErrorCode(errors.Wrapf(err, "Error applying %s %s", cfg.kubernetesKind, cfg.kubernetesName), exitWrite)
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKubeconfig writes a kubeconfig file for the test server with a token and the namespace of the current context,
+// and another context with basic authentication and impersonation
+func writeKubeconfig(t *testing.T, server *httptest.Server) string {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	path := filepath.Join(t.TempDir(), "config")
+	content := fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+  - name: other
+    context: {cluster: test, user: other, namespace: prod}
+  - name: test
+    context: {cluster: test, user: test, namespace: stage}
+clusters:
+  - name: test
+    cluster:
+      server: %s/
+      certificate-authority-data: %s
+users:
+  - name: test
+    user:
+      token: secret-token
+  - name: other
+    user:
+      username: deployer
+      password: s3cr3t
+      as: system:serviceaccount:prod:hierarchy
+`, server.URL, base64.StdEncoding.EncodeToString(ca))
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing kubeconfig: %v", err)
+	}
+	return path
+}
+
+// kubeRequest is a request received by the API server of a test
+type kubeRequest struct {
+	method, path, contentType, authorization, impersonate, body string
+	query                                                       url.Values
+}
+
+// newKubeServer starts an API server recording the last request and answering it with an empty object
+func newKubeServer(t *testing.T) (*httptest.Server, *kubeRequest) {
+	last := &kubeRequest{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := io.ReadAll(r.Body)
+		*last = kubeRequest{
+			method:        r.Method,
+			path:          r.URL.Path,
+			query:         r.URL.Query(),
+			contentType:   r.Header.Get("Content-Type"),
+			authorization: r.Header.Get("Authorization"),
+			impersonate:   r.Header.Get("Impersonate-User"),
+			body:          string(content),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"app"}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, last
+}
+
+// TestKubeApply verifies that the manifest is sent as a server-side apply to the namespace of the current context
+func TestKubeApply(t *testing.T) {
+	server, last := newKubeServer(t)
+
+	client, err := newKubeClient(writeKubeconfig(t, server), "")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	assert.NoError(t, client.apply("secret", "app", "", "apiVersion: v1\nkind: Secret\nmetadata: {name: app}\n", "hierarchy", false, true))
+	assert.Equal(t, http.MethodPatch, last.method)
+	assert.Equal(t, "/api/v1/namespaces/stage/secrets/app", last.path)
+	assert.Equal(t, []string{"All"}, last.query["dryRun"])
+	assert.Equal(t, "hierarchy", last.query.Get("fieldManager"))
+	assert.Equal(t, "false", last.query.Get("force"))
+	assert.Equal(t, "application/apply-patch+yaml", last.contentType)
+	assert.Equal(t, "Bearer secret-token", last.authorization)
+	assert.JSONEq(t, `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"app"}}`, last.body)
+
+	assert.NoError(t, client.apply("configmap", "app", "prod", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: app}\n", "ci", true, false))
+	assert.Equal(t, "/api/v1/namespaces/prod/configmaps/app", last.path)
+	assert.Empty(t, last.query["dryRun"])
+	assert.Equal(t, "ci", last.query.Get("fieldManager"))
+	assert.Equal(t, "true", last.query.Get("force"))
+}
+
+// TestKubeApplyContext verifies that --kubernetes.context selects another context of the kubeconfig,
+// with its namespace, basic authentication, and impersonation
+func TestKubeApplyContext(t *testing.T) {
+	server, last := newKubeServer(t)
+
+	client, err := newKubeClient(writeKubeconfig(t, server), "other")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	assert.NoError(t, client.apply("configmap", "app", "", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: app}\n", "hierarchy", false, false))
+	assert.Equal(t, "/api/v1/namespaces/prod/configmaps/app", last.path)
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("deployer:s3cr3t")), last.authorization)
+	assert.Equal(t, "system:serviceaccount:prod:hierarchy", last.impersonate)
+
+	_, err = newKubeClient(writeKubeconfig(t, server), "missing")
+	assert.Error(t, err)
+}
+
+// TestKubeconfigCredentialPlugin verifies that the token of an exec plugin is used, and that a failing plugin
+// and the auth-provider plugins removed from client-go fail the apply
+func TestKubeconfigCredentialPlugin(t *testing.T) {
+	server, last := newKubeServer(t)
+	dir := t.TempDir()
+	plugin := fakeBinary(t, "get-token", `[ "$*" = "--cluster prod" ] || { echo "unexpected arguments: $*" >&2; exit 2; }
+[ "$REGION" = "us-east-1" ] || { echo "unexpected REGION $REGION" >&2; exit 2; }
+echo "$KUBERNETES_EXEC_INFO" | grep -q '"interactive":false' || { echo "unexpected exec info" >&2; exit 2; }
+echo '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"token":"plugin-token"}}'
+`)
+	if err := os.Rename(plugin, filepath.Join(dir, "get-token")); err != nil {
+		t.Fatalf("Error moving plugin: %v", err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	for name, test := range map[string]struct {
+		user     string
+		expected string
+	}{
+		"exec": {
+			user: `exec: {apiVersion: client.authentication.k8s.io/v1, command: ./get-token, args: [--cluster, prod], env: [{name: REGION, value: us-east-1}], interactiveMode: Never}`,
+		},
+		"failing exec": {
+			user:     `exec: {apiVersion: client.authentication.k8s.io/v1, command: ./get-token, interactiveMode: Never}`,
+			expected: "exit code 2",
+		},
+		"auth-provider": {
+			user:     "auth-provider: {name: gcp}",
+			expected: "gke-gcloud-auth-plugin",
+		},
+	} {
+		path := filepath.Join(dir, "config")
+		content := `current-context: test
+contexts:
+  - name: test
+    context: {cluster: test, user: test}
+clusters:
+  - name: test
+    cluster: {server: "` + server.URL + `", certificate-authority-data: ` + base64.StdEncoding.EncodeToString(ca) + `}
+users:
+  - name: test
+    user: {` + test.user + `}
+`
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing kubeconfig: %v", err)
+		}
+		client, err := newKubeClient(path, "")
+		if err == nil {
+			err = client.apply("configmap", "app", "", "apiVersion: v1\nkind: ConfigMap\n", "hierarchy", false, false)
+		}
+		if len(test.expected) > 0 {
+			if assert.Error(t, err, name) {
+				assert.Contains(t, err.Error(), test.expected, name)
+			}
+			continue
+		}
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, "Bearer plugin-token", last.authorization, name)
+		}
+	}
+}
+
+// TestKubeconfigMerge verifies that all files of $KUBECONFIG are merged, the first file defining an entry winning,
+// and that they take precedence over the service account of a pod
+func TestKubeconfigMerge(t *testing.T) {
+	server, last := newKubeServer(t)
+	dir := t.TempDir()
+	contexts := filepath.Join(dir, "contexts")
+	if err := os.WriteFile(contexts, []byte(`current-context: test
+contexts:
+  - name: test
+    context: {cluster: test, user: test, namespace: first}
+`), 0600); err != nil {
+		t.Fatalf("Error writing kubeconfig: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600); err != nil {
+		t.Fatalf("Error writing token: %v", err)
+	}
+	users := filepath.Join(dir, "users")
+	if err := os.WriteFile(users, []byte(`current-context: other
+contexts:
+  - name: test
+    context: {cluster: test, user: test, namespace: second}
+users:
+  - name: test
+    user: {tokenFile: token}
+`), 0600); err != nil {
+		t.Fatalf("Error writing kubeconfig: %v", err)
+	}
+	t.Setenv("KUBECONFIG", strings.Join([]string{contexts, filepath.Join(dir, "missing"), users, writeKubeconfig(t, server)}, string(filepath.ListSeparator)))
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	client, err := newKubeClient("", "")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	assert.NoError(t, client.apply("configmap", "app", "", "apiVersion: v1\nkind: ConfigMap\n", "hierarchy", false, false))
+	assert.Equal(t, "/api/v1/namespaces/first/configmaps/app", last.path)
+	assert.Equal(t, "Bearer file-token", last.authorization)
+
+	_, err = newKubeClient(filepath.Join(dir, "missing"), "")
+	assert.Error(t, err, "a missing --kubeconfig is not skipped")
+}
+
+// TestKubeApplyError verifies that the message of a failed request is reported
+func TestKubeApplyError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"apiVersion":"v1","kind":"Status","status":"Failure","reason":"Forbidden","code":403,"message":"configmaps \"app\" is forbidden"}`)
+	}))
+	defer server.Close()
+
+	client, err := newKubeClient(writeKubeconfig(t, server), "")
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	assert.EqualError(t, client.apply("configmap", "app", "", "apiVersion: v1\nkind: ConfigMap\n", "hierarchy", false, false), `configmaps "app" is forbidden`)
+}
+
+// TestKubeconfigMissing verifies that the apply fails without a kubeconfig outside of a pod
+func TestKubeconfigMissing(t *testing.T) {
+	t.Setenv("KUBECONFIG", "")
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	_, err := newKubeClient("", "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Error loading kubeconfig")
+	}
+}
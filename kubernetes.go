@@ -0,0 +1,72 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubernetesManifest is a ConfigMap or Secret holding the merged document
+type kubernetesManifest struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   kubernetesMetadata `yaml:"metadata"`
+	Type       string             `yaml:"type,omitempty"`
+	Data       map[string]string  `yaml:"data"`
+}
+
+type kubernetesMetadata struct {
+	Name      string            `yaml:"name"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+// kubernetesDataKey returns the key of the merged document in the manifest, see --kubernetes.key
+func kubernetesDataKey(cfg config) string {
+	switch {
+	case len(cfg.kubernetesKey) > 0:
+		return cfg.kubernetesKey
+	case cfg.outputFile == stdStream:
+		return "config.yaml"
+	default:
+		return filepath.Base(cfg.outputFile)
+	}
+}
+
+// wrapManifest wraps the merged document into a ConfigMap, or a base64-encoded Opaque Secret
+func wrapManifest(cfg config, output string) (string, error) {
+	manifest := kubernetesManifest{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata: kubernetesMetadata{
+			Name:      cfg.kubernetesName,
+			Namespace: cfg.kubernetesNamespace,
+			Labels:    cfg.kubernetesLabels,
+		},
+		Data: map[string]string{kubernetesDataKey(cfg): output},
+	}
+	if cfg.kubernetesKind == "secret" {
+		manifest.Kind = "Secret"
+		manifest.Type = "Opaque"
+		manifest.Data[kubernetesDataKey(cfg)] = base64.StdEncoding.EncodeToString([]byte(output))
+	}
+	content, err := yaml.Marshal(&manifest)
+	return string(content), err
+}
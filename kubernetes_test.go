@@ -0,0 +1,71 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWrapManifestConfigMap verifies that the merged document is stored as a string in a ConfigMap
+func TestWrapManifestConfigMap(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.outputFile = "deploy/app.yaml"
+	cfg.kubernetesKind = "configmap"
+	cfg.kubernetesName = "app"
+	cfg.kubernetesNamespace = "prod"
+	cfg.kubernetesLabels = map[string]string{"app": "demo", "tier": "web"}
+
+	manifest, err := wrapManifest(cfg, "app:\n  replicas: 2\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+    name: app
+    namespace: prod
+    labels:
+        app: demo
+        tier: web
+data:
+    app.yaml: |
+        app:
+          replicas: 2
+`, manifest)
+}
+
+// TestWrapManifestSecret verifies that the merged document is stored base64-encoded in an Opaque Secret
+func TestWrapManifestSecret(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.outputFile = stdStream
+	cfg.kubernetesKind = "secret"
+	cfg.kubernetesName = "app"
+
+	manifest, err := wrapManifest(cfg, "password: secret\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+    name: app
+type: Opaque
+data:
+    config.yaml: cGFzc3dvcmQ6IHNlY3JldAo=
+`, manifest)
+
+	cfg.kubernetesKey = "settings.yaml"
+	assert.Equal(t, "settings.yaml", kubernetesDataKey(cfg))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
+	"github.com/pkg/errors"
+)
+
+// OpenLineage schemas of the run event and the dataset version facet
+const (
+	lineageSchemaURL  = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+	lineageFacetURL   = "https://openlineage.io/spec/facets/1-0-1/DatasetVersionDatasetFacet.json#/$defs/DatasetVersionDatasetFacet"
+	lineageProducer   = "https://github.com/KohlsTechnology/hierarchy/tree/"
+	lineageFileSystem = "file"
+)
+
+// lineageTimeout limits how long the lineage endpoint may take to accept an event
+var lineageTimeout = 10 * time.Second
+
+// lineageEvent is an OpenLineage run event of a merge
+type lineageEvent struct {
+	EventType string           `json:"eventType"`
+	EventTime string           `json:"eventTime"`
+	Run       lineageRun       `json:"run"`
+	Job       lineageJob       `json:"job"`
+	Inputs    []lineageDataset `json:"inputs"`
+	Outputs   []lineageDataset `json:"outputs"`
+	Producer  string           `json:"producer"`
+	SchemaURL string           `json:"schemaURL"`
+}
+
+type lineageRun struct {
+	RunID string `json:"runId"`
+}
+
+type lineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// lineageDataset is a file read or written by the merge.
+// Its version is the SHA-256 checksum of the content.
+type lineageDataset struct {
+	Namespace string               `json:"namespace"`
+	Name      string               `json:"name"`
+	Facets    lineageDatasetFacets `json:"facets"`
+}
+
+type lineageDatasetFacets struct {
+	Version lineageVersionFacet `json:"version"`
+}
+
+type lineageVersionFacet struct {
+	Producer       string `json:"_producer"`
+	SchemaURL      string `json:"_schemaURL"`
+	DatasetVersion string `json:"datasetVersion"`
+}
+
+// newLineageEvent creates the event of a completed merge, with the merged files as inputs
+// and the output file as output. Files are named by their absolute paths.
+func newLineageEvent(namespace string, job string, runID string, now time.Time, sources *provenance, outputFile string, output string) lineageEvent {
+	producer := lineageProducer + version.Version
+	dataset := func(file string, sha256 string) lineageDataset {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		return lineageDataset{
+			Namespace: lineageFileSystem,
+			Name:      filepath.ToSlash(file),
+			Facets: lineageDatasetFacets{Version: lineageVersionFacet{
+				Producer:       producer,
+				SchemaURL:      lineageFacetURL,
+				DatasetVersion: sha256,
+			}},
+		}
+	}
+
+	event := lineageEvent{
+		EventType: "COMPLETE",
+		EventTime: now.UTC().Format(time.RFC3339Nano),
+		Run:       lineageRun{RunID: runID},
+		Job:       lineageJob{Namespace: namespace, Name: job},
+		Inputs:    []lineageDataset{},
+		Outputs:   []lineageDataset{},
+		Producer:  producer,
+		SchemaURL: lineageSchemaURL,
+	}
+	for _, file := range sources.files {
+		event.Inputs = append(event.Inputs, dataset(file.path, file.sha256))
+	}
+	if outputFile != stdStream {
+		sum := sha256.Sum256([]byte(output))
+		event.Outputs = append(event.Outputs, dataset(outputFile, hex.EncodeToString(sum[:])))
+	}
+	return event
+}
+
+// newRunID returns a random UUID identifying a run
+func newRunID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	// Version 4, variant RFC 4122
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// sendLineage posts the event to an OpenLineage HTTP endpoint, e.g. http://marquez:5000/api/v1/lineage
+func sendLineage(url string, event lineageEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: lineageTimeout}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("lineage endpoint %s returned %s", url, response.Status)
+	}
+	return nil
+}
+
+// exportLineage sends the lineage of a completed merge.
+// Lineage is not essential for the output, so a failure is only logged.
+func exportLineage(cfg config, sources *provenance, output string) {
+	runID, err := newRunID()
+	if err == nil {
+		job := cfg.lineageJob
+		if len(job) == 0 {
+			job = filepath.ToSlash(filepath.Join(cfg.basePath, cfg.hierarchyFile))
+		}
+		event := newLineageEvent(cfg.lineageNamespace, job, runID, time.Now(), sources, cfg.outputFile, output)
+		outputLog.Info("Sending lineage event", "url", cfg.lineageURL, "run", runID)
+		err = sendLineage(cfg.lineageURL, event)
+	}
+	if err != nil {
+		outputLog.Warn("Cannot send lineage event", "url", cfg.lineageURL, "error", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLineageEvent verifies the inputs, output, and digests of the lineage event posted to the endpoint
+func TestLineageEvent(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/list-directives"
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	event := newLineageEvent("ci", "demo", "0b0e3c8e-5f7a-4a3e-9c4e-6f1d2b3a4c5d", now, sources, "output.yaml", "network: {}\n")
+	assert.NoError(t, sendLineage(server.URL, event))
+
+	assert.Equal(t, "COMPLETE", received["eventType"])
+	assert.Equal(t, "2021-06-01T12:00:00Z", received["eventTime"])
+	assert.Equal(t, map[string]interface{}{"namespace": "ci", "name": "demo"}, received["job"])
+
+	team, err := filepath.Abs("testdata/list-directives/team.yaml")
+	assert.NoError(t, err)
+	inputs := received["inputs"].([]interface{})
+	assert.Len(t, inputs, 2)
+	input := inputs[1].(map[string]interface{})
+	assert.Equal(t, "file", input["namespace"])
+	assert.Equal(t, filepath.ToSlash(team), input["name"])
+	assert.Equal(t, sources.files[1].sha256, input["facets"].(map[string]interface{})["version"].(map[string]interface{})["datasetVersion"])
+
+	outputs := received["outputs"].([]interface{})
+	assert.Len(t, outputs, 1)
+	// SHA-256 of "network: {}\n"
+	assert.Equal(t, "169d2d0f4409f254793e7092697c0eae4e30a3ef0ac7573fff6e9686f41f76af", outputs[0].(map[string]interface{})["facets"].(map[string]interface{})["version"].(map[string]interface{})["datasetVersion"])
+}
+
+// TestSendLineageError verifies that a rejected event is reported
+func TestSendLineageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := sendLineage(server.URL, lineageEvent{})
+	assert.EqualError(t, err, "lineage endpoint "+server.URL+" returned 400 Bad Request")
+}
+
+// TestNewRunID verifies that run IDs are random version 4 UUIDs
+func TestNewRunID(t *testing.T) {
+	first, err := newRunID()
+	assert.NoError(t, err)
+	second, err := newRunID()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), first)
+	assert.NotEqual(t, first, second)
+}
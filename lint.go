@@ -0,0 +1,339 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
+	"gopkg.in/yaml.v3"
+)
+
+// lintIssue is a problem found by the lint command
+type lintIssue struct {
+	path    string
+	line    int
+	warning bool
+	message string
+	// context shows the lines around a parse error
+	context string
+}
+
+func (i lintIssue) String() string {
+	location := i.path
+	if i.line > 0 {
+		location += ":" + strconv.Itoa(i.line)
+	}
+	severity := "error"
+	if i.warning {
+		severity = "warning"
+	}
+	issue := fmt.Sprintf("%s: %s: %s", location, severity, i.message)
+	if len(i.context) > 0 {
+		issue += "\n" + i.context
+	}
+	return issue
+}
+
+// parseIssue converts a parse error to an issue
+func parseIssue(e *parseError) lintIssue {
+	return lintIssue{path: e.file, line: e.line, message: e.message, context: e.context}
+}
+
+// duplicateKey is a key defined more than once in the same mapping of a document
+type duplicateKey struct {
+	key       string
+	line      int
+	firstLine int
+}
+
+// lint checks the hierarchy file and every file in the hierarchy without merging them
+func lint(cfg config) []lintIssue {
+	issues := []lintIssue{}
+	hierarchyFilePath := filepath.Join(cfg.basePath, cfg.hierarchyFile)
+
+	hierarchy := []layer{}
+	content, err := inputFS.ReadFile(hierarchyFilePath)
+	if err != nil {
+		issues = append(issues, lintIssue{
+			path:    hierarchyFilePath,
+			warning: !cfg.failMissingHierarchy,
+			message: "hierarchy file not found, only the base directory is merged",
+		})
+		hierarchy = append(hierarchy, layer{path: cfg.basePath})
+	} else {
+		var layerIssues []lintIssue
+		hierarchy, layerIssues = lintHierarchyFile(cfg, hierarchyFilePath, string(content))
+		issues = append(issues, layerIssues...)
+	}
+
+	for _, includeLayer := range hierarchy {
+		if includeLayer.remote {
+			continue
+		}
+		for _, file := range getFiles(includeLayer.path, cfg.filterExtension) {
+			for _, issue := range lintFile(file) {
+				// Broken files are skipped in best-effort layers
+				issue.warning = issue.warning || includeLayer.bestEffort
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	if err == nil {
+		issues = append(issues, lintUnusedDirectories(cfg, hierarchy)...)
+	}
+	return issues
+}
+
+// lintHierarchyFile checks the syntax of the hierarchy file and that every directory in it exists
+func lintHierarchyFile(cfg config, hierarchyFilePath string, content string) ([]layer, []lintIssue) {
+	hierarchy := []layer{}
+	issues := []lintIssue{}
+	for number, line := range strings.SplitAfter(content, "\n") {
+		issue := lintIssue{path: hierarchyFilePath, line: number + 1}
+		if name, argument, ok := parseHierarchyDirective(line); ok {
+			issues = append(issues, lintDirective(issue, name, argument)...)
+			continue
+		}
+		includePath, prefix, bestEffort, err := parseHierarchyLine(line)
+		if err != nil {
+			issue.message = err.Error()
+			issues = append(issues, issue)
+			continue
+		}
+		includePath = replaceEnvironmentVariables(includePath, false)
+		if len(includePath) == 0 {
+			continue
+		}
+		// Remote sources are not fetched, only the syntax of their entry is checked
+		if _, ok := findRemoteSource(includePath); ok {
+			if err := checkRemoteEntry(cfg, includePath); err != nil {
+				issue.message = err.Error()
+				issues = append(issues, issue)
+				continue
+			}
+			hierarchy = append(hierarchy, layer{path: includePath, prefix: prefix, bestEffort: bestEffort, remote: true})
+			continue
+		}
+		if includePath, err = normalizeEntryPath(includePath); err != nil {
+			issue.message = err.Error()
+			issues = append(issues, issue)
+			continue
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(cfg.basePath, includePath)
+		}
+		if cfg.restrictToBase && !isWithinBase(cfg.basePath, includePath) {
+			issue.message = fmt.Sprintf("directory %s is outside of the base path", includePath)
+			issues = append(issues, issue)
+			continue
+		}
+		if !followSymlinks && isSymlink(includePath) {
+			issue.message = fmt.Sprintf("directory %s is a symlink", includePath)
+			issues = append(issues, issue)
+			continue
+		}
+		if stat, err := inputFS.Stat(includePath); err != nil || !stat.IsDir() {
+			issue.message = fmt.Sprintf("directory %s not found", includePath)
+			issues = append(issues, issue)
+			continue
+		}
+		hierarchy = append(hierarchy, layer{path: includePath, prefix: prefix, bestEffort: bestEffort})
+	}
+	return hierarchy, issues
+}
+
+// checkRemoteEntry checks the syntax of a remote hierarchy entry without fetching it
+func checkRemoteEntry(cfg config, entry string) error {
+	var err error
+	switch {
+	case strings.HasPrefix(entry, gitSourcePrefix):
+		_, _, _, err = parseGitSource(entry)
+	case strings.HasPrefix(entry, ociSourcePrefix):
+		_, _, _, err = parseOCISource(entry)
+	case strings.HasPrefix(entry, consulSourcePrefix):
+		// Every key prefix is valid, including the empty one of all keys
+	case strings.HasPrefix(entry, "http://") || strings.HasPrefix(entry, "https://"):
+		var location *url.URL
+		if location, err = url.Parse(entry); err == nil && len(location.Host) == 0 {
+			err = fmt.Errorf("no host in URL %s", entry)
+		}
+	default:
+		_, _, err = parseObjectStoreSource(cfg, entry)
+	}
+	return err
+}
+
+// lintDirective checks a directive of the hierarchy file, see checkHierarchyDirective
+func lintDirective(issue lintIssue, name string, argument string) []lintIssue {
+	switch name {
+	case "requires":
+		satisfied, err := version.Satisfies(argument)
+		if err != nil {
+			issue.message = err.Error()
+		} else if !satisfied {
+			issue.message = fmt.Sprintf("hierarchy %s is required, this is %s", argument, version.Version)
+		} else {
+			return nil
+		}
+	case "compat":
+		if isCompatLevel(argument) {
+			return nil
+		}
+		issue.message = fmt.Sprintf("unknown compatibility level %q", argument)
+	default:
+		issue.warning = true
+		issue.message = fmt.Sprintf("unknown directive %s", name)
+	}
+	return []lintIssue{issue}
+}
+
+// lintFile parses a file and checks it for duplicate keys
+func lintFile(file string) []lintIssue {
+	content, err := inputFS.ReadFile(file)
+	if err != nil {
+		return []lintIssue{{path: file, message: err.Error()}}
+	}
+	// Template files are only valid YAML once rendered with the data of the levels before them
+	if isTemplateFile(file) {
+		if _, err := parseTemplate(file, content); err != nil {
+			return []lintIssue{{path: file, message: err.Error()}}
+		}
+		return []lintIssue{}
+	}
+	// Jsonnet files are checked by evaluating them
+	if isJsonnetFile(file) {
+		if content, err = evaluateJsonnet(jsonnet, file, content); err != nil {
+			return []lintIssue{{path: file, message: err.Error()}}
+		}
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return []lintIssue{parseIssue(newParseError(file, content, err))}
+	}
+	issues := []lintIssue{}
+	for _, duplicate := range findDuplicateKeys(&document, "") {
+		issues = append(issues, lintIssue{
+			path:    file,
+			line:    duplicate.line,
+			message: fmt.Sprintf("key %s already defined at line %d", duplicate.key, duplicate.firstLine),
+		})
+	}
+	if len(issues) > 0 {
+		return issues
+	}
+	// Catch documents that are valid YAML, but no map
+	if err := document.Decode(&map[string]interface{}{}); err != nil {
+		issues = append(issues, parseIssue(newParseError(file, content, err)))
+	}
+	return issues
+}
+
+// findDuplicateKeys returns every key defined more than once in the same mapping of a document
+func findDuplicateKeys(node *yaml.Node, prefix string) []duplicateKey {
+	duplicates := []duplicateKey{}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			duplicates = append(duplicates, findDuplicateKeys(child, prefix)...)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			duplicates = append(duplicates, findDuplicateKeys(child, fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+	case yaml.MappingNode:
+		seen := map[string]int{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := key.Value
+			if prefix != "" {
+				keyPath = prefix + "." + key.Value
+			}
+			// Merge keys may be repeated to merge several anchors
+			if key.Value != "<<" {
+				if firstLine, ok := seen[key.Value]; ok {
+					duplicates = append(duplicates, duplicateKey{key: keyPath, line: key.Line, firstLine: firstLine})
+				} else {
+					seen[key.Value] = key.Line
+				}
+			}
+			duplicates = append(duplicates, findDuplicateKeys(value, keyPath)...)
+		}
+	}
+	return duplicates
+}
+
+// lintUnusedDirectories reports directories below the base path with files to merge, which are not in the hierarchy
+func lintUnusedDirectories(cfg config, hierarchy []layer) []lintIssue {
+	used := map[string]bool{}
+	for _, includeLayer := range hierarchy {
+		if includeLayer.remote {
+			continue
+		}
+		absPath, _ := filepath.Abs(includeLayer.path)
+		used[absPath] = true
+	}
+	issues := []lintIssue{}
+	err := filepath.Walk(cfg.basePath, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		// Skip hidden directories like .git
+		if dir != cfg.basePath && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		absDir, _ := filepath.Abs(dir)
+		if !used[absDir] && len(getFiles(dir, cfg.filterExtension)) > 0 {
+			issues = append(issues, lintIssue{path: dir, warning: true, message: "directory is not in the hierarchy"})
+		}
+		return nil
+	})
+	if err != nil {
+		issues = append(issues, lintIssue{path: cfg.basePath, message: err.Error()})
+	}
+	return issues
+}
+
+// runLint checks the hierarchy and its files, prints all issues, and fails if any of them is an error
+func runLint(cfg config, w io.Writer) {
+	errorCount := 0
+	issues := lint(cfg)
+	for _, issue := range issues {
+		fmt.Fprintln(w, issue)
+		if !issue.warning {
+			errorCount++
+		}
+	}
+	fields := []interface{}{
+		"errors", errorCount,
+		"warnings", len(issues) - errorCount,
+	}
+	if errorCount > 0 {
+		fatal(appLog, exitValidation, "Lint found errors", fields...)
+	}
+	appLog.Info("Lint passed", fields...)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/cache"
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+	"github.com/KohlsTechnology/hierarchy/pkg/resolver"
+	"github.com/KohlsTechnology/hierarchy/pkg/schema"
+	"github.com/KohlsTechnology/hierarchy/pkg/transform"
+)
+
+// Loggers of the program and its components, see setupLogging
+var (
+	appLog          *slog.Logger
+	resolverLog     *slog.Logger
+	mergerLog       *slog.Logger
+	substitutionLog *slog.Logger
+	outputLog       *slog.Logger
+	// logOutput is where log messages are written, which also receives the diff of --diff
+	logOutput io.Writer
+)
+
+func init() {
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+}
+
+// setupLogging creates the loggers of all components in the log format, each filtered by its own level,
+// and hands them to the hierarchy packages
+func setupLogging(w io.Writer, format string, levels logging.Levels) {
+	logOutput = w
+	appLog = logging.New(w, format, levels)
+	resolverLog = appLog.With(logging.ComponentKey, logging.Resolver)
+	mergerLog = appLog.With(logging.ComponentKey, logging.Merger)
+	substitutionLog = appLog.With(logging.ComponentKey, logging.Substitution)
+	outputLog = appLog.With(logging.ComponentKey, logging.Output)
+	cache.SetLogger(appLog)
+	resolver.SetLogger(substitutionLog)
+	schema.SetLogger(outputLog)
+	transform.SetLogger(outputLog)
+}
+
+// logWriter returns where log messages are written, see --log-file.
+// Logs go to standard output, unless the merged document is written there with '--output=-' or '--dry-run',
+// or the pr-report, get, keys, or explain command writes its result there.
+// The returned function closes a log file.
+func logWriter(cfg config) (io.Writer, func() error, error) {
+	switch {
+	case cfg.logFile == stdStream:
+		return os.Stderr, func() error { return nil }, nil
+	case len(cfg.logFile) > 0:
+		file, err := os.OpenFile(cfg.logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0660)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, file.Close, nil
+	case cfg.outputFile == stdStream, cfg.dryRun, cfg.command == "pr-report", cfg.command == "get", cfg.command == "keys", cfg.command == "explain":
+		return os.Stderr, func() error { return nil }, nil
+	default:
+		return os.Stdout, func() error { return nil }, nil
+	}
+}
+
+// fatal logs an error and exits the program with the exit code of the failure class
+func fatal(logger *slog.Logger, code int, msg string, args ...interface{}) {
+	logger.Error(msg, append(args, "exit_code", code)...)
+	os.Exit(code)
+}
+
+// trace logs a message at trace level, e.g. large diffs
+func trace(logger *slog.Logger, msg string, args ...interface{}) {
+	logger.Log(context.Background(), logging.LevelTrace, msg, args...)
+}
+
+// keepGoing makes fail record failures instead of exiting, see --keep-going
+var keepGoing bool
+
+// failures are the exit codes of the failures recorded with --keep-going
+var failures []int
+
+// fail logs an error and exits the program, like fatal.
+// With --keep-going it records the failure instead, and the caller skips whatever failed.
+func fail(logger *slog.Logger, code int, msg string, args ...interface{}) {
+	if !keepGoing {
+		fatal(logger, code, msg, args...)
+	}
+	logger.Error(msg, append(args, "exit_code", code)...)
+	failures = append(failures, code)
+}
+
+// exitOnFailures exits the program if any failures were recorded with --keep-going.
+// The exit code is the one of the failures if they are all of the same class, otherwise exitError.
+func exitOnFailures() {
+	if len(failures) == 0 {
+		return
+	}
+	code := failures[0]
+	for _, c := range failures {
+		if c != code {
+			code = exitError
+		}
+	}
+	fatal(appLog, code, "Stopping after all failures were reported", "count", len(failures))
+}
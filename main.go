@@ -18,40 +18,218 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"log/slog"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/KohlsTechnology/hierarchy/internal/fsutil"
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+	"github.com/KohlsTechnology/hierarchy/pkg/sandbox"
+	"github.com/KohlsTechnology/hierarchy/pkg/schema"
+	"github.com/KohlsTechnology/hierarchy/pkg/transform"
 	"github.com/KohlsTechnology/hierarchy/pkg/version"
 	"github.com/imdario/mergo"
 	"github.com/kylelemons/godebug/diff"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
+	"github.com/pmezard/go-difflib/difflib"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"gopkg.in/yaml.v3"
 )
 
 type config struct {
-	hierarchyFile        string
-	basePath             string
-	outputFile           string
-	filterExtension      string
-	printVersion         bool
-	diffOutput           bool
-	logDebug             bool
-	logTrace             bool
-	failMissingHierarchy bool
-	failMissingPath      bool
-	failMissingEnvVar    bool
-	skipEnvVarContent    bool
+	command                string
+	explainKey             string
+	hierarchyFile          string
+	basePath               string
+	reverseHierarchy       bool
+	outputFile             string
+	outputsManifest        string
+	splitByTopLevelKey     bool
+	nestByDirectory        bool
+	nestByFile             bool
+	fileOrder              string
+	outputDir              string
+	documents              []string
+	sortKeys               bool
+	comments               bool
+	anchors                string
+	yamlIndent             int
+	yamlLineWidth          int
+	yamlQuote              string
+	yamlSequenceStyle      string
+	blobsThreshold         int
+	blobsDir               string
+	outputFormat           string
+	schemaFile             string
+	cueSchema              string
+	cueExport              bool
+	cueBinary              string
+	policyPath             string
+	policyQuery            string
+	opaBinary              string
+	sopsBinary             string
+	templates              bool
+	jsonnet                bool
+	jsonnetExtVars         []string
+	jsonnetLibraryPaths    []string
+	jsonnetBinary          string
+	starlark               bool
+	starlarkScript         string
+	starlarkBinary         string
+	provenanceFile         string
+	filterExtension        string
+	stripKeys              string
+	transforms             []string
+	passthrough            string
+	kubernetesKind         string
+	kubernetesName         string
+	kubernetesNamespace    string
+	kubernetesLabels       map[string]string
+	kubernetesKey          string
+	kubernetesApply        bool
+	kubernetesDryRun       bool
+	kubernetesFieldManager string
+	kubernetesForce        bool
+	kubernetesContext      string
+	kubeconfig             string
+	passthroughKey         string
+	printVersion           bool
+	versionJSON            bool
+	completionShell        string
+	initEnvironments       []string
+	importConfig           string
+	importFiles            []string
+	query                  string
+	queryFormat            string
+	keysTypes              bool
+	keysSources            bool
+	listenAddress          string
+	grpcListenAddress      string
+	serveCacheTTL          time.Duration
+	compareOutputs         []string
+	reportBase             string
+	reportHead             string
+	assertions             []string
+	assertionsFile         string
+	batchManifest          string
+	batchParallel          int
+	diffOutput             bool
+	annotate               string
+	dryRun                 bool
+	// readOnly merges and checks the output without writing or publishing it, see the validate and diff commands
+	readOnly               bool
+	logDebug               bool
+	logTrace               bool
+	logLevel               string
+	logLevels              string
+	logFormat              string
+	logFile                string
+	compat                 string
+	nullPolicy             string
+	keyCase                string
+	ownersFile             string
+	lineageURL             string
+	lineageNamespace       string
+	lineageJob             string
+	postHook               string
+	daemon                 bool
+	pidFile                string
+	webhookURL             string
+	metricsListen          string
+	webhookHeaders         []string
+	watch                  bool
+	watchInterval          time.Duration
+	failMissingHierarchy   bool
+	failMissingPath        bool
+	failMissingEnvVar      bool
+	failMissingSecret      bool
+	failExpired            bool
+	restrictToBase         bool
+	keepGoing              bool
+	sandbox                bool
+	noFollowSymlinks       bool
+	skipEnvVarContent      bool
+	skipSecrets            bool
+	vaultAddress           string
+	vaultNamespace         string
+	vaultAuth              string
+	vaultAuthPath          string
+	vaultToken             string
+	vaultRoleID            string
+	vaultSecretID          string
+	vaultRole              string
+	awsRegion              string
+	awsProfile             string
+	awsEndpoint            string
+	cacheDir               string
+	offline                bool
+	remoteRefreshInterval  time.Duration
+	httpTimeout            time.Duration
+	consulAddress          string
+	consulToken            string
+	consulPublish          string
+	etcdEndpoints          []string
+	etcdUsername           string
+	etcdPassword           string
+	etcdCAFile             string
+	etcdCert               string
+	etcdKey                string
+	etcdPublish            string
+	etcdLayout             string
+	httpHeaders            []string
+	httpCAFile             string
+	httpClientCert         string
+	httpClientKey          string
+	httpInsecureSkipVerify bool
+	enableExecLookups      bool
+	execLookupsAllow       []string
+}
+
+// layer is a directory listed in the hierarchy
+type layer struct {
+	path string
+	// prefix is the key path the files of the layer are merged under, or empty for the root
+	prefix string
+	// remote layers are fetched into the cache directory, so they are not merged under the name of their directory
+	remote bool
+	// bestEffort layers skip files that cannot be read or parsed instead of failing
+	bestEffort bool
 }
 
 // Default file filter
 const defaultFileFilter = "(.yaml|.yml|.json)$"
 
+// Name of the optional JSON Schema in a hierarchy directory, which every file of the directory must match
+const layerSchemaFile = ".schema.json"
+
+// Exit codes of the failure classes, so wrapper scripts can branch on the reason.
+const (
+	// exitError is any failure without a more specific class
+	exitError = 1
+	// exitUsage is invalid command-line arguments
+	exitUsage = 2
+	// exitParse is an invalid hierarchy file, input file, schema, .order file, .hierarchyignore file, or imported configuration
+	exitParse = 3
+	// exitPath is a missing or unreadable hierarchy file, directory, or input file, or one outside of the base path
+	exitPath = 4
+	// exitVariable is an environment variable that is not defined, see --fail.missingvariable
+	exitVariable = 5
+	// exitWrite is a failure writing or removing the output or provenance file
+	exitWrite = 6
+	// exitValidation is output violating the schema, CUE schema, or policies, a layer schema violation, or a lint error
+	exitValidation = 7
+)
+
+// stdStream stands for standard output in --output, and for standard error in --log-file
+const stdStream = "-"
+
+// inputFS is the file system the hierarchy and its files are read from, see --sandbox
+var inputFS = fsutil.OS
+
 func parseFlags() config {
 	application := kingpin.New(filepath.Base(os.Args[0]), "Hierarchy")
 	application.HelpFlag.Short('h')
@@ -62,69 +240,423 @@ func parseFlags() config {
 		Envar("HIERARCHY_FILE").Default("hierarchy.lst").StringVar(&cfg.hierarchyFile)
 	application.Flag("base", "Base path.").Short('b').
 		Envar("HIERARCHY_BASE").Default("./").StringVar(&cfg.basePath)
-	application.Flag("output", "Path and name of the output file.").Short('o').
+	application.Flag("reverse-hierarchy", "Merge the hierarchy file from the last entry to the first, so the first entry has the highest priority.").
+		Envar("HIERARCHY_REVERSE_HIERARCHY").Default("false").BoolVar(&cfg.reverseHierarchy)
+	application.Flag("output", "Path and name of the output file, or '-' for standard output, written as --output=- or -o-. ${VAR} is replaced with environment variables, and %Y, %m, %d, %H, %M, %S with the time of the run.").Short('o').
 		Envar("HIERARCHY_OUTPUT").Default("./output.yaml").StringVar(&cfg.outputFile)
+	application.Flag("output-format", "Format of the output file, 'yaml', Terraform variables as 'tfvars.json', or HCL 'tfvars'.").
+		Envar("HIERARCHY_OUTPUT_FORMAT").Default("yaml").EnumVar(&cfg.outputFormat, "yaml", "tfvars.json", "tfvars")
+	application.Flag("outputs", "Path of a manifest mapping key paths of the merged document to output files of their own, written together in addition to the output file.").
+		Envar("HIERARCHY_OUTPUTS").Default("").StringVar(&cfg.outputsManifest)
+	application.Flag("split-by-top-level-key", "Also write one file per top-level key of the merged document, named after the key, to --output-dir.").
+		Envar("HIERARCHY_SPLIT_BY_TOP_LEVEL_KEY").Default("false").BoolVar(&cfg.splitByTopLevelKey)
+	application.Flag("nest-by-directory", "Merge the files of every directory of the hierarchy under a key named after the directory, unless the entry has a key prefix.").
+		Envar("HIERARCHY_NEST_BY_DIRECTORY").Default("false").BoolVar(&cfg.nestByDirectory)
+	application.Flag("nest-by-file", "Merge every file under a key named after the file without its extension, e.g. 'database' for database.yaml.").
+		Envar("HIERARCHY_NEST_BY_FILE").Default("false").BoolVar(&cfg.nestByFile)
+	application.Flag("file-order", "Order the files of a directory are merged in, 'lexical' by name, or 'natural' comparing numbers in names by their value. A .order file of the directory lists files to merge first.").
+		Envar("HIERARCHY_FILE_ORDER").Default("lexical").EnumVar(&cfg.fileOrder, "lexical", "natural")
+	application.Flag("output-dir", "Directory of the files of --split-by-top-level-key, outside the directories of the hierarchy.").
+		Envar("HIERARCHY_OUTPUT_DIR").Default("").StringVar(&cfg.outputDir)
+	application.Flag("documents", "Write a multi-document YAML stream with the value of a key path as a document, or every element of a list as a document of its own. Can be repeated, documents are written in order.").
+		Envar("HIERARCHY_DOCUMENTS").StringsVar(&cfg.documents)
+	application.Flag("sort-keys", "Write the keys in alphabetical order at every level instead of the order they were first defined in.").
+		Envar("HIERARCHY_SORT_KEYS").Default("false").BoolVar(&cfg.sortKeys)
+	application.Flag("comments", "Write the comments of every key from the last input file that defined it.").
+		Envar("HIERARCHY_COMMENTS").Default("false").BoolVar(&cfg.comments)
+	application.Flag("anchors", "Write the values of YAML aliases of the input files in full ('expand'), or 'keep' the anchors and aliases of values which were not changed by a later file.").
+		Envar("HIERARCHY_ANCHORS").Default("expand").EnumVar(&cfg.anchors, "expand", "keep")
+	application.Flag("yaml.indent", "Number of spaces per indentation level of the YAML output, from 2 to 9.").
+		Envar("HIERARCHY_YAML_INDENT").Default("4").IntVar(&cfg.yamlIndent)
+	application.Flag("yaml.line-width", "Wrap strings longer than this width as folded block scalars in the YAML output. 0 never wraps.").
+		Envar("HIERARCHY_YAML_LINE_WIDTH").Default("0").IntVar(&cfg.yamlLineWidth)
+	application.Flag("yaml.quote", "Quote strings in the YAML output only where needed ('auto'), or always in 'single' or 'double' quotes.").
+		Envar("HIERARCHY_YAML_QUOTE").Default("auto").EnumVar(&cfg.yamlQuote, "auto", "single", "double")
+	application.Flag("yaml.sequence-style", "Write lists in the YAML output in 'block' or 'flow' style.").
+		Envar("HIERARCHY_YAML_SEQUENCE_STYLE").Default("block").EnumVar(&cfg.yamlSequenceStyle, "block", "flow")
+	application.Flag("blobs.threshold", "Write strings and binary values longer than this number of bytes to files of their own in --blobs.dir, and their paths to the output instead. 0 keeps all values in the output.").
+		Envar("HIERARCHY_BLOBS_THRESHOLD").Default("0").IntVar(&cfg.blobsThreshold)
+	application.Flag("blobs.dir", "Directory of the files of --blobs.threshold, relative to the directory of the output file.").
+		Envar("HIERARCHY_BLOBS_DIR").Default("blobs").StringVar(&cfg.blobsDir)
+	application.Flag("provenance", "Path and name of a JSON file listing every merged file, its SHA-256, and the top-level keys it provided.").
+		Envar("HIERARCHY_PROVENANCE").Default("").StringVar(&cfg.provenanceFile)
+	application.Flag("lineage.url", "OpenLineage HTTP endpoint receiving a run event with the merged files and the output file, e.g. 'http://marquez:5000/api/v1/lineage'.").
+		Envar("HIERARCHY_LINEAGE_URL").Default("").StringVar(&cfg.lineageURL)
+	application.Flag("lineage.namespace", "OpenLineage namespace of the job.").
+		Envar("HIERARCHY_LINEAGE_NAMESPACE").Default("hierarchy").StringVar(&cfg.lineageNamespace)
+	application.Flag("lineage.job", "OpenLineage name of the job. Defaults to the path of the hierarchy file.").
+		Envar("HIERARCHY_LINEAGE_JOB").Default("").StringVar(&cfg.lineageJob)
+	application.Flag("post-hook", "Shell command run after the output file is written, with the output file as $1 and 'changed' or 'unchanged' as $2, e.g. 'systemctl reload app'.").
+		Envar("HIERARCHY_POST_HOOK").Default("").StringVar(&cfg.postHook)
+	application.Flag("files", "Pass the files of every layer's 'files' directory through without merging: 'copy' them next to the output file, or 'embed' them as strings.").
+		Envar("HIERARCHY_FILES").Default("none").EnumVar(&cfg.passthrough, "none", "copy", "embed")
+	application.Flag("files.key", "Dot-separated key path the files are embedded below with '--files=embed'.").
+		Envar("HIERARCHY_FILES_KEY").Default("files").StringVar(&cfg.passthroughKey)
+	application.Flag("kubernetes.kind", "Wrap the merged document into a Kubernetes 'configmap' or 'secret' manifest.").
+		Envar("HIERARCHY_KUBERNETES_KIND").Default("none").EnumVar(&cfg.kubernetesKind, "none", "configmap", "secret")
+	application.Flag("kubernetes.name", "Name of the ConfigMap or Secret, required with --kubernetes.kind.").
+		Envar("HIERARCHY_KUBERNETES_NAME").Default("").StringVar(&cfg.kubernetesName)
+	application.Flag("kubernetes.namespace", "Namespace of the ConfigMap or Secret.").
+		Envar("HIERARCHY_KUBERNETES_NAMESPACE").Default("").StringVar(&cfg.kubernetesNamespace)
+	cfg.kubernetesLabels = map[string]string{}
+	application.Flag("kubernetes.label", "Label of the ConfigMap or Secret as 'name=value'. Can be repeated.").
+		Envar("HIERARCHY_KUBERNETES_LABEL").StringMapVar(&cfg.kubernetesLabels)
+	application.Flag("kubernetes.key", "Key of the merged document in the ConfigMap or Secret. Defaults to the name of the output file.").
+		Envar("HIERARCHY_KUBERNETES_KEY").Default("").StringVar(&cfg.kubernetesKey)
+	application.Flag("kubernetes.apply", "Apply the ConfigMap or Secret to the cluster with a server-side apply instead of writing the output file.").
+		Envar("HIERARCHY_KUBERNETES_APPLY").Default("false").BoolVar(&cfg.kubernetesApply)
+	application.Flag("kubernetes.dry-run", "Apply the ConfigMap or Secret as a server-side dry run, which validates it without persisting it.").
+		Envar("HIERARCHY_KUBERNETES_DRY_RUN").Default("false").BoolVar(&cfg.kubernetesDryRun)
+	application.Flag("kubernetes.field-manager", "Field manager of the server-side apply.").
+		Envar("HIERARCHY_KUBERNETES_FIELD_MANAGER").Default("hierarchy").StringVar(&cfg.kubernetesFieldManager)
+	application.Flag("kubernetes.force-conflicts", "Take over fields of the ConfigMap or Secret owned by other field managers instead of failing with a conflict.").
+		Envar("HIERARCHY_KUBERNETES_FORCE_CONFLICTS").Default("false").BoolVar(&cfg.kubernetesForce)
+	application.Flag("kubernetes.context", "Context of the kubeconfig used with --kubernetes.apply instead of its current context.").
+		Envar("HIERARCHY_KUBERNETES_CONTEXT").Default("").StringVar(&cfg.kubernetesContext)
+	application.Flag("kubeconfig", "Path and name of the kubeconfig file used with --kubernetes.apply. Defaults to the files of $KUBECONFIG, ~/.kube/config, or the service account in a pod.").
+		Envar("HIERARCHY_KUBECONFIG").Default("").StringVar(&cfg.kubeconfig)
+	application.Flag("annotate", "Add comments naming the source files to the 'top'-level keys or 'all' leaf keys of the output.").
+		Envar("HIERARCHY_ANNOTATE").Default("none").EnumVar(&cfg.annotate, "none", "top", "all")
+	application.Flag("schema", "Path and name of a JSON Schema file the merged output must match.").
+		Envar("HIERARCHY_SCHEMA").Default("").StringVar(&cfg.schemaFile)
+	application.Flag("cue", "Path of a CUE schema the merged output is validated against with the cue CLI.").
+		Envar("HIERARCHY_CUE").Default("").StringVar(&cfg.cueSchema)
+	application.Flag("cue.export", "Write the document exported by CUE, including defaults and computed fields of the schema.").
+		Envar("HIERARCHY_CUE_EXPORT").Default("false").BoolVar(&cfg.cueExport)
+	application.Flag("cue.binary", "Path and name of the cue binary.").
+		Envar("HIERARCHY_CUE_BINARY").Default("cue").StringVar(&cfg.cueBinary)
+	application.Flag("policy", "Path of Rego policy files or directories evaluated against the merged output with the opa CLI.").
+		Envar("HIERARCHY_POLICY").Default("").StringVar(&cfg.policyPath)
+	application.Flag("policy.query", "Rego query returning the deny messages of the policies.").
+		Envar("HIERARCHY_POLICY_QUERY").Default(defaultPolicyQuery).StringVar(&cfg.policyQuery)
+	application.Flag("policy.opa", "Path and name of the opa binary.").
+		Envar("HIERARCHY_POLICY_OPA").Default("opa").StringVar(&cfg.opaBinary)
+	application.Flag("sops.binary", "Path and name of the sops binary decrypting SOPS-encrypted input files.").
+		Envar("HIERARCHY_SOPS_BINARY").Default("sops").StringVar(&cfg.sopsBinary)
+	application.Flag("templates", "Render input files ending in .gotmpl, e.g. 'values.yaml.gotmpl', as Go templates with the data merged so far before merging them.").
+		Envar("HIERARCHY_TEMPLATES").Default("false").BoolVar(&cfg.templates)
+	application.Flag("jsonnet", "Evaluate input files ending in .jsonnet with the jsonnet CLI and merge their JSON output.").
+		Envar("HIERARCHY_JSONNET").Default("false").BoolVar(&cfg.jsonnet)
+	application.Flag("jsonnet.ext-str", "External variable of Jsonnet files as <name>=<value>, or <name> to take the value from the environment variable. Can be repeated.").
+		Envar("HIERARCHY_JSONNET_EXT_STR").StringsVar(&cfg.jsonnetExtVars)
+	application.Flag("jsonnet.jpath", "Library path searched for imports of Jsonnet files after the directory of the file. Can be repeated.").
+		Envar("HIERARCHY_JSONNET_JPATH").StringsVar(&cfg.jsonnetLibraryPaths)
+	application.Flag("jsonnet.binary", "Path and name of the jsonnet binary.").
+		Envar("HIERARCHY_JSONNET_BINARY").Default("jsonnet").StringVar(&cfg.jsonnetBinary)
+	application.Flag("starlark", "Run the transform(doc) function of the transform.star script of a hierarchy directory on the document merged so far, after the files of the directory.").
+		Envar("HIERARCHY_STARLARK").Default("false").BoolVar(&cfg.starlark)
+	application.Flag("starlark.script", "Path of a Starlark script whose transform(doc) function is run on the document after all directories are merged.").
+		Envar("HIERARCHY_STARLARK_SCRIPT").Default("").StringVar(&cfg.starlarkScript)
+	application.Flag("starlark.binary", "Path and name of the starlark binary of go.starlark.net.").
+		Envar("HIERARCHY_STARLARK_BINARY").Default("starlark").StringVar(&cfg.starlarkBinary)
+	application.Flag("compat", "Compatibility level of the merge semantics, e.g. '1'. Overrides '#! compat' in the hierarchy file. Defaults to the latest level.").
+		Envar("HIERARCHY_COMPAT").Default("").EnumVar(&cfg.compat, append([]string{""}, compatLevels...)...)
+	application.Flag("null-policy", "What an explicit null value of a key in an input file means: 'keep' it as a literal null, 'delete-key' to unset the key, or 'error' to fail the merge.").
+		Envar("HIERARCHY_NULL_POLICY").Default("keep").EnumVar(&cfg.nullPolicy, "keep", "delete-key", "error")
+	application.Flag("key-case", "Merge keys which only differ in case as one key, spelled like the 'first' file that defined it or in 'lower' case. 'sensitive' merges them as different keys.").
+		Envar("HIERARCHY_KEY_CASE").Default("sensitive").EnumVar(&cfg.keyCase, "sensitive", "first", "lower")
+	application.Flag("owners", "Path and name of a YAML file mapping key path globs to the teams owning them. The final value of an owned key must be set by a file in a directory of an owning team.").
+		Envar("HIERARCHY_OWNERS").Default("").StringVar(&cfg.ownersFile)
 	application.Flag("output-no-variables", "Do not find and replace environment variables in output file.").
 		Envar("HIERARCHY_OUTPUT_NO_VARIABLES").Default("false").BoolVar(&cfg.skipEnvVarContent)
+	application.Flag("output-no-secrets", "Do not find and replace references to secrets, e.g. '${vault:secret/data/app#password}', in output file.").
+		Envar("HIERARCHY_OUTPUT_NO_SECRETS").Default("false").BoolVar(&cfg.skipSecrets)
 	application.Flag("filter", "Regex for allowed file extension(s) of files being merged.").Short('i').
 		Envar("HIERARCHY_FILTER").Default(defaultFileFilter).StringVar(&cfg.filterExtension)
+	application.Flag("strip-keys", "Regex for keys removed from the merged output at any level, e.g. '^(x-hierarchy-.*|_comment)$'.").
+		Envar("HIERARCHY_STRIP_KEYS").Default("").StringVar(&cfg.stripKeys)
+	application.Flag("transform", "Transform applied to the merged data as <name>=<argument>, e.g. 'redact=**.password'. Can be repeated, transforms run in order.").
+		Envar("HIERARCHY_TRANSFORM").StringsVar(&cfg.transforms)
+	application.Flag("cache-dir", "Directory remote hierarchy sources are stored in. Defaults to 'hierarchy' in the user cache directory.").
+		Envar("HIERARCHY_CACHE_DIR").Default("").StringVar(&cfg.cacheDir)
+	application.Flag("offline", "Use only remote hierarchy sources stored in the cache directory, and fail if one would have to be fetched.").
+		Envar("HIERARCHY_OFFLINE").Default("false").BoolVar(&cfg.offline)
+	application.Flag("remote.refresh-interval", "How often remote hierarchy sources which can change are fetched again with --watch and by serve. 0 never fetches them again.").
+		Envar("HIERARCHY_REMOTE_REFRESH_INTERVAL").Default("5m").DurationVar(&cfg.remoteRefreshInterval)
+	application.Flag("http.timeout", "Timeout of every request fetching an HTTP or OCI hierarchy source.").
+		Envar("HIERARCHY_HTTP_TIMEOUT").Default("30s").DurationVar(&cfg.httpTimeout)
+	application.Flag("http.header", "Header sent with requests fetching HTTP hierarchy sources as 'Name: value', e.g. for authorization. Can be repeated.").
+		Envar("HIERARCHY_HTTP_HEADER").StringsVar(&cfg.httpHeaders)
+	application.Flag("http.ca-file", "Path and name of a PEM file with the CA certificates trusted for HTTP and OCI hierarchy sources, instead of the system ones.").
+		Envar("HIERARCHY_HTTP_CA_FILE").Default("").StringVar(&cfg.httpCAFile)
+	application.Flag("http.client-cert", "Path and name of a PEM client certificate sent to HTTP and OCI hierarchy sources.").
+		Envar("HIERARCHY_HTTP_CLIENT_CERT").Default("").StringVar(&cfg.httpClientCert)
+	application.Flag("http.client-key", "Path and name of the PEM private key of --http.client-cert.").
+		Envar("HIERARCHY_HTTP_CLIENT_KEY").Default("").StringVar(&cfg.httpClientKey)
+	application.Flag("http.insecure-skip-verify", "Do not verify the TLS certificates of HTTP and OCI hierarchy sources.").
+		Envar("HIERARCHY_HTTP_INSECURE_SKIP_VERIFY").Default("false").BoolVar(&cfg.httpInsecureSkipVerify)
+	application.Flag("consul.address", "Address of the Consul agent of 'consul://' hierarchy sources and --consul.publish. Defaults to CONSUL_HTTP_ADDR or '127.0.0.1:8500'.").
+		Envar("HIERARCHY_CONSUL_ADDRESS").Default("").StringVar(&cfg.consulAddress)
+	application.Flag("consul.token", "ACL token of Consul. Defaults to CONSUL_HTTP_TOKEN.").
+		Envar("HIERARCHY_CONSUL_TOKEN").Default("").StringVar(&cfg.consulToken)
+	application.Flag("consul.publish", "Consul KV prefix the leaves of the merged document are published below, in addition to writing the output file.").
+		Envar("HIERARCHY_CONSUL_PUBLISH").Default("").StringVar(&cfg.consulPublish)
+	application.Flag("etcd.endpoints", "Endpoint of etcd used by --etcd.publish, e.g. 'https://etcd-0:2379'. Can be repeated, endpoints are tried in order. Defaults to ETCDCTL_ENDPOINTS or 'http://127.0.0.1:2379'.").
+		Envar("HIERARCHY_ETCD_ENDPOINTS").StringsVar(&cfg.etcdEndpoints)
+	application.Flag("etcd.username", "Username of etcd authentication. Defaults to the user of ETCDCTL_USER.").
+		Envar("HIERARCHY_ETCD_USERNAME").Default("").StringVar(&cfg.etcdUsername)
+	application.Flag("etcd.password", "Password of --etcd.username.").
+		Envar("HIERARCHY_ETCD_PASSWORD").Default("").StringVar(&cfg.etcdPassword)
+	application.Flag("etcd.ca-file", "Path and name of a PEM file with the CA certificates of etcd.").
+		Envar("HIERARCHY_ETCD_CA_FILE").Default("").StringVar(&cfg.etcdCAFile)
+	application.Flag("etcd.cert", "Path and name of a PEM client certificate sent to etcd.").
+		Envar("HIERARCHY_ETCD_CERT").Default("").StringVar(&cfg.etcdCert)
+	application.Flag("etcd.key", "Path and name of the PEM private key of --etcd.cert.").
+		Envar("HIERARCHY_ETCD_KEY").Default("").StringVar(&cfg.etcdKey)
+	application.Flag("etcd.publish", "etcd key prefix the merged document is published below in a single transaction, in addition to writing the output file.").
+		Envar("HIERARCHY_ETCD_PUBLISH").Default("").StringVar(&cfg.etcdPublish)
+	application.Flag("etcd.layout", "Layout of the published document, one key per leaf below the prefix with 'flat', or the whole 'document' as the value of the prefix.").
+		Envar("HIERARCHY_ETCD_LAYOUT").Default("flat").EnumVar(&cfg.etcdLayout, "flat", "document")
 	application.Flag("fail.missinghierarchy", "Fail if a hierarchy file is not found, otherwise merge all files in base folder.").
 		Envar("HIERARCHY_FAIL_MISSING_HIERARCHY").Default("false").BoolVar(&cfg.failMissingHierarchy)
 	application.Flag("fail.missingpath", "Fail if a directory in the hierarchy is missing.").
 		Envar("HIERARCHY_FAIL_MISSING_PATH").Default("false").BoolVar(&cfg.failMissingPath)
 	application.Flag("fail.missingvariable", "Fail if an environment variable defined in the final yaml is not found.").
 		Envar("HIERARCHY_FAIL_MISSING_VARIABLE").Default("false").BoolVar(&cfg.failMissingEnvVar)
-	application.Flag("debug", "Print debug output.").Short('d').
+	application.Flag("fail.missingsecret", "Fail if a secret referenced in the final yaml cannot be read.").
+		Envar("HIERARCHY_FAIL_MISSING_SECRET").Default("false").BoolVar(&cfg.failMissingSecret)
+	application.Flag("vault.address", "Address of the Vault server, e.g. 'https://vault.example.com:8200'. Defaults to VAULT_ADDR.").
+		Envar("HIERARCHY_VAULT_ADDRESS").Default("").StringVar(&cfg.vaultAddress)
+	application.Flag("vault.namespace", "Vault Enterprise namespace of the secrets. Defaults to VAULT_NAMESPACE.").
+		Envar("HIERARCHY_VAULT_NAMESPACE").Default("").StringVar(&cfg.vaultNamespace)
+	application.Flag("vault.auth", "Vault auth method, 'token', 'approle', or 'kubernetes'.").
+		Envar("HIERARCHY_VAULT_AUTH").Default("token").EnumVar(&cfg.vaultAuth, "token", "approle", "kubernetes")
+	application.Flag("vault.auth-path", "Path the auth method is mounted at. Defaults to the name of the auth method.").
+		Envar("HIERARCHY_VAULT_AUTH_PATH").Default("").StringVar(&cfg.vaultAuthPath)
+	application.Flag("vault.token", "Vault token of the token auth method. Defaults to VAULT_TOKEN or the token of the vault CLI.").
+		Envar("HIERARCHY_VAULT_TOKEN").Default("").StringVar(&cfg.vaultToken)
+	application.Flag("vault.role-id", "Role ID of the approle auth method.").
+		Envar("HIERARCHY_VAULT_ROLE_ID").Default("").StringVar(&cfg.vaultRoleID)
+	application.Flag("vault.secret-id", "Secret ID of the approle auth method.").
+		Envar("HIERARCHY_VAULT_SECRET_ID").Default("").StringVar(&cfg.vaultSecretID)
+	application.Flag("vault.role", "Role of the kubernetes auth method, which logs in with the service account of the pod.").
+		Envar("HIERARCHY_VAULT_ROLE").Default("").StringVar(&cfg.vaultRole)
+	application.Flag("aws.region", "AWS region of SSM parameters and Secrets Manager secrets. Defaults to AWS_REGION or the region of the profile.").
+		Envar("HIERARCHY_AWS_REGION").Default("").StringVar(&cfg.awsRegion)
+	application.Flag("aws.profile", "Profile of the shared AWS config and credentials files. Defaults to AWS_PROFILE.").
+		Envar("HIERARCHY_AWS_PROFILE").Default("").StringVar(&cfg.awsProfile)
+	application.Flag("aws.endpoint", "Endpoint of the AWS APIs, e.g. of a VPC endpoint or LocalStack. Defaults to the regional endpoint of each service.").
+		Envar("HIERARCHY_AWS_ENDPOINT").Default("").StringVar(&cfg.awsEndpoint)
+	application.Flag("enable-exec-lookups", "Replace ${exec:<command> [<arg>...]} references with the output of allow-listed commands.").
+		Envar("HIERARCHY_ENABLE_EXEC_LOOKUPS").Default("false").BoolVar(&cfg.enableExecLookups)
+	application.Flag("exec-lookups.allow", "Command allowed in exec lookups, as written in the reference. Can be repeated.").
+		Envar("HIERARCHY_EXEC_LOOKUPS_ALLOW").StringsVar(&cfg.execLookupsAllow)
+	application.Flag("fail.expired", "Fail if a value is still set by a file after the date of its expiry directive, otherwise only warn.").
+		Envar("HIERARCHY_FAIL_EXPIRED").Default("false").BoolVar(&cfg.failExpired)
+	application.Flag("diff", "Print a unified diff between the existing output file and the newly merged result to the log output.").
+		Envar("HIERARCHY_DIFF").Default("false").BoolVar(&cfg.diffOutput)
+	application.Flag("dry-run", "Print the merge order and the merged result to stdout without writing the output file.").
+		Envar("HIERARCHY_DRY_RUN").Default("false").BoolVar(&cfg.dryRun)
+	application.Flag("keep-going", "Report all failures of the hierarchy, its files, environment variables, and validations before exiting, instead of stopping at the first one.").Short('k').
+		Envar("HIERARCHY_KEEP_GOING").Default("false").BoolVar(&cfg.keepGoing)
+	application.Flag("daemon", "Keep running after the merge and merge again on SIGHUP, until interrupted.").
+		Envar("HIERARCHY_DAEMON").Default("false").BoolVar(&cfg.daemon)
+	application.Flag("pidfile", "Path and name of a file the process ID is written to with --daemon or --watch.").
+		Envar("HIERARCHY_PIDFILE").Default("").StringVar(&cfg.pidFile)
+	application.Flag("watch", "Merge again whenever the hierarchy file, a file or directory in the hierarchy, or a schema, policy, or ownership file changes, until interrupted. Implies --daemon.").Short('w').
+		Envar("HIERARCHY_WATCH").Default("false").BoolVar(&cfg.watch)
+	application.Flag("watch.interval", "How often the inputs are polled for changes with --watch, in addition to their file system events, which are not delivered on network file systems.").
+		Envar("HIERARCHY_WATCH_INTERVAL").Default("1s").DurationVar(&cfg.watchInterval)
+	application.Flag("metrics.listen", "Address serving Prometheus metrics of the merges at /metrics with --daemon or --watch, e.g. ':9090'.").
+		Envar("HIERARCHY_METRICS_LISTEN").Default("").StringVar(&cfg.metricsListen)
+	application.Flag("webhook.url", "URL receiving a POST with the diff and SHA-256 of the output file whenever it changed with --daemon or --watch.").
+		Envar("HIERARCHY_WEBHOOK_URL").Default("").StringVar(&cfg.webhookURL)
+	application.Flag("webhook.header", "Header sent to the webhook as 'Name: value', e.g. for authorization. Can be repeated.").
+		Envar("HIERARCHY_WEBHOOK_HEADER").StringsVar(&cfg.webhookHeaders)
+	application.Flag("restrict-to-base", "Fail if a directory in the hierarchy is outside of the base path, e.g. an absolute path, one using '..', or a symlink pointing outside.").
+		Envar("HIERARCHY_RESTRICT_TO_BASE").Default("false").BoolVar(&cfg.restrictToBase)
+	application.Flag("sandbox", "Read the hierarchy only through a sandbox rooted at the base path, which no path or symlink can leave. Implies --restrict-to-base. Cannot be used with --jsonnet.").
+		Envar("HIERARCHY_SANDBOX").Default("false").BoolVar(&cfg.sandbox)
+	application.Flag("no-follow-symlinks", "Ignore symlinked files and passthrough directories, and fail on symlinked directories in the hierarchy.").
+		Envar("HIERARCHY_NO_FOLLOW_SYMLINKS").Default("false").BoolVar(&cfg.noFollowSymlinks)
+	application.Flag("log-level", "Minimum level of logged messages: trace, debug, info, warn, error, or quiet. Trace prints a diff after processing each file, which generates A LOT of output.").Short('l').
+		Envar("HIERARCHY_LOG_LEVEL").Default("info").EnumVar(&cfg.logLevel, "trace", "debug", "info", "warn", "error", "quiet")
+	// Deprecated: --debug and --trace are replaced by --log-level
+	application.Flag("debug", "Print debug output, same as --log-level=debug.").Short('d').Hidden().
 		Envar("HIERARCHY_DEBUG").Default("false").BoolVar(&cfg.logDebug)
-	application.Flag("trace", "Prints a diff after processing each file. This generates A LOT of output.").
+	application.Flag("trace", "Print a diff after processing each file, same as --log-level=trace.").Hidden().
 		Envar("HIERARCHY_TRACE").Default("false").BoolVar(&cfg.logTrace)
+	application.Flag("log-levels", "Comma-separated log levels of single components, e.g. 'merger=debug,output=warn', overriding --log-level. Components are resolver, merger, substitution, and output.").
+		Envar("HIERARCHY_LOG_LEVELS").Default("").StringVar(&cfg.logLevels)
+	application.Flag("log-file", "Path and name of a file the log messages are appended to, or '-' for standard error. Defaults to standard output, or standard error with '--output=-' and the get, keys, and pr-report commands.").
+		Envar("HIERARCHY_LOG_FILE").Default("").StringVar(&cfg.logFile)
+	application.Flag("log-format", "Format of the log output, 'text' or 'json'.").
+		Envar("HIERARCHY_LOG_FORMAT").Default(logging.FormatText).EnumVar(&cfg.logFormat, logging.Formats...)
 	application.Flag("version", "Print version and build information, then exit.").Short('V').
 		Default("false").BoolVar(&cfg.printVersion)
 
-	_, err := application.Parse(os.Args[1:])
+	application.Command("merge", "Merge all files in the hierarchy into the output file.").Default()
+	application.Command("validate", "Merge the hierarchy and run all checks of the output, like --schema and --policy, without writing anything.")
+	application.Command("diff", "Print a unified diff between the existing output file and the newly merged result to the log output without writing anything.")
+	getCommand := application.Command("get", "Merge the hierarchy in memory and print the value of a query without writing anything, e.g. 'app.hosts[0].name'.")
+	getCommand.Arg("query", "Dot-separated keys and list indices, e.g. 'app.hosts[0].name', or '.' for the whole document.").Required().StringVar(&cfg.query)
+	getCommand.Flag("format", "Format of the value, 'yaml', 'json', or 'raw' printing strings without quotes and other values as JSON.").
+		Envar("HIERARCHY_GET_FORMAT").Default("yaml").EnumVar(&cfg.queryFormat, queryFormats...)
+	keysCommand := application.Command("keys", "List the path of every leaf key of the merged document without writing anything, e.g. to audit an environment.")
+	keysCommand.Arg("query", "Only list the keys below a query, e.g. 'app.database'.").Default(".").StringVar(&cfg.query)
+	keysCommand.Flag("types", "Print the type of every value, e.g. 'str' or 'int'.").
+		Default("false").BoolVar(&cfg.keysTypes)
+	keysCommand.Flag("sources", "Print the files that provided every value.").
+		Default("false").BoolVar(&cfg.keysSources)
+	explainCommand := application.Command("explain", "Report which files provided the final value of a key and which files it overrode.")
+	explainCommand.Arg("key", "Dot-separated key path, e.g. 'app.database.host'.").Required().StringVar(&cfg.explainKey)
+	application.Command("lint", "Check the hierarchy file and all files in the hierarchy without writing any output.")
+	compareCommand := application.Command("compare", "Check assertions comparing merged outputs, e.g. of several environments.")
+	compareCommand.Arg("outputs", "Merged outputs as <name>=<path>, e.g. 'prod=prod.yaml'.").Required().StringsVar(&cfg.compareOutputs)
+	compareCommand.Flag("assert", "Assertion comparing outputs, e.g. 'prod.app.replicas >= stage.app.replicas'. Can be repeated.").
+		StringsVar(&cfg.assertions)
+	compareCommand.Flag("assertions", "Path and name of a YAML file listing assertions.").
+		Envar("HIERARCHY_ASSERTIONS").Default("").StringVar(&cfg.assertionsFile)
+	batchCommand := application.Command("batch", "Merge every release and environment listed in a manifest file, e.g. the values.yaml files of Helm releases.")
+	batchCommand.Arg("manifest", "Path and name of the YAML file listing the releases.").Required().StringVar(&cfg.batchManifest)
+	batchCommand.Flag("parallel", "Number of releases merged at the same time. Defaults to the number of CPUs.").
+		Envar("HIERARCHY_PARALLEL").Default("0").IntVar(&cfg.batchParallel)
+	reportCommand := application.Command("pr-report", "Render every environment below the base path at two git refs and print a Markdown summary of the changed keys.")
+	reportCommand.Arg("base", "Git ref before the change, e.g. 'origin/main'.").Required().StringVar(&cfg.reportBase)
+	reportCommand.Arg("head", "Git ref after the change.").Default("HEAD").StringVar(&cfg.reportHead)
+	serveCommand := application.Command("serve", "Serve the merged document over HTTP at /config, merging again whenever an input changed.")
+	serveCommand.Flag("listen", "Address the HTTP server listens on.").
+		Envar("HIERARCHY_LISTEN").Default(":8080").StringVar(&cfg.listenAddress)
+	serveCommand.Flag("grpc.listen", "Address the gRPC config service listens on, e.g. ':9090'. Not served by default.").
+		Envar("HIERARCHY_GRPC_LISTEN").Default("").StringVar(&cfg.grpcListenAddress)
+	serveCommand.Flag("serve.cache-ttl", "How long a merged document is served before merging again, even if no input changed. 0 keeps it until an input changes, but merges a document resolving secrets on every request.").
+		Envar("HIERARCHY_SERVE_CACHE_TTL").Default("5m").DurationVar(&cfg.serveCacheTTL)
+	initCommand := application.Command("init", "Scaffold a starter layout in the base path with shared defaults and a directory per environment.")
+	initCommand.Flag("environment", "Environment to scaffold a directory for, e.g. 'dev'. Can be repeated or separated by commas. Asked for on a terminal, defaults to dev, stage, and prod.").
+		StringsVar(&cfg.initEnvironments)
+	importCommand := application.Command("import", "Convert the configuration of another tool to a hierarchy in the base path.")
+	hieraCommand := importCommand.Command("hiera", "Convert a Hiera 5 configuration and its data files to a hierarchy file and a directory per level.")
+	hieraCommand.Arg("config", "Path and name of the Hiera configuration.").Default("hiera.yaml").StringVar(&cfg.importConfig)
+	springCommand := importCommand.Command("spring", "Split Spring configuration files with profile-activated documents into a directory per profile.")
+	springCommand.Arg("files", "Spring configuration files, e.g. application.yml and application-prod.yml.").Default("application.yml").StringsVar(&cfg.importFiles)
+	completionCommand := application.Command("completion", "Print the completion script of a shell for all flags and commands, e.g. 'source <(hierarchy completion bash)'.")
+	completionCommand.Arg("shell", "Shell of the completion script, 'bash', 'zsh', or 'fish'.").Required().EnumVar(&cfg.completionShell, completionShells...)
+	versionCommand := application.Command("version", "Print version and build information.")
+	versionCommand.Flag("json", "Print the version and build information as JSON.").
+		Default("false").BoolVar(&cfg.versionJSON)
+
+	application.Validate(func(*kingpin.Application) error {
+		if cfg.kubernetesKind != "none" && len(cfg.kubernetesName) == 0 {
+			return errors.New("--kubernetes.name is required with --kubernetes.kind")
+		}
+		if cfg.kubernetesApply && cfg.kubernetesKind == "none" {
+			return errors.New("--kubernetes.kind is required with --kubernetes.apply")
+		}
+		// The jsonnet CLI reads imports itself, so they could leave the sandbox
+		if cfg.sandbox && cfg.jsonnet {
+			return errors.New("--jsonnet cannot be used with --sandbox, the jsonnet CLI imports files outside of the sandbox")
+		}
+		return nil
+	})
+
+	args := joinStreamValues(os.Args[1:], application.Model())
+	command, err := application.Parse(args)
+	cfg.command = command
 
-	if cfg.printVersion {
+	if cfg.printVersion || (err == nil && command == "version" && !cfg.versionJSON) {
 		version.Print()
 		os.Exit(0)
 	}
+	if err == nil && command == "version" {
+		checkForError(version.WriteJSON(os.Stdout))
+		os.Exit(0)
+	}
+	if err == nil && command == "completion" {
+		checkForError(writeCompletion(os.Stdout, application.Model(), cfg.completionShell))
+		os.Exit(0)
+	}
 
 	if err != nil {
 		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing command-line arguments"))
-		application.Usage(os.Args[1:])
-		os.Exit(2)
+		printUsage(application, args)
+		os.Exit(exitUsage)
 	}
 	return cfg
 }
 
+// printUsage writes the usage of the command selected by args to standard error.
+// kingpin's Usage fails with exit code 1 if args do not parse, so the usage of the arguments parsed so far is written instead.
+func printUsage(application *kingpin.Application, args []string) {
+	context, _ := application.ParseContext(args)
+	if context == nil {
+		return
+	}
+	_ = application.UsageForContext(context)
+}
+
 // checkForError fails the program with a fatal error message if e != nil
 func checkForError(e error) {
+	checkForErrorCode(e, exitError)
+}
+
+// checkForErrorCode fails the program with a fatal error message and the exit code of the failure class if e != nil
+func checkForErrorCode(e error, code int) {
 	if e != nil {
-		log.Fatal(e)
+		fatal(appLog, code, e.Error())
+	}
+}
+
+// joinStreamValues joins flags taking a value with a separate '-' following them, e.g. '--output -' or '-o -',
+// into '--output=-' and '-o-', since kingpin takes a separate '-' for a flag and fails
+func joinStreamValues(args []string, model *kingpin.ApplicationModel) []string {
+	valueFlags := map[string]bool{}
+	var addFlags func(flags []*kingpin.FlagModel, commands []*kingpin.CmdModel)
+	addFlags = func(flags []*kingpin.FlagModel, commands []*kingpin.CmdModel) {
+		for _, flag := range flags {
+			if !flag.IsBoolFlag() {
+				valueFlags["--"+flag.Name] = true
+				if flag.Short != 0 {
+					valueFlags["-"+string(flag.Short)] = true
+				}
+			}
+		}
+		for _, command := range commands {
+			addFlags(command.Flags, command.Commands)
+		}
+	}
+	addFlags(model.Flags, model.Commands)
+
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			return append(joined, args[i:]...)
+		}
+		if valueFlags[args[i]] && i+1 < len(args) && args[i+1] == stdStream {
+			if strings.HasPrefix(args[i], "--") {
+				joined = append(joined, args[i]+"="+stdStream)
+			} else {
+				joined = append(joined, args[i]+stdStream)
+			}
+			i++
+			continue
+		}
+		joined = append(joined, args[i])
 	}
+	return joined
 }
 
-// processHierarchy loads the hierarchy file and generates a list of file paths
+// processHierarchy loads the hierarchy file and generates a list of layers
 // of folders to be processed
-func processHierarchy(cfg config) []string {
-	hierarchy := []string{}
-	hierarchyFilePath := path.Join(cfg.basePath, cfg.hierarchyFile)
+func processHierarchy(cfg config) []layer {
+	hierarchy := []layer{}
+	hierarchyFilePath := filepath.Join(cfg.basePath, cfg.hierarchyFile)
 
 	// If no hierarchy is found and failMissingHierarchy is 'false',
 	// then return the base directory as the only one to process
-	if _, err := os.Stat(hierarchyFilePath); err != nil && !cfg.failMissingHierarchy {
-		log.WithFields(log.Fields{
-			"path": hierarchyFilePath,
-			"base": cfg.basePath,
-		}).Warning("No hierarchy file found, only processing base directory for merge.")
-		hierarchy = append(hierarchy, cfg.basePath)
+	if _, err := inputFS.Stat(hierarchyFilePath); err != nil && !cfg.failMissingHierarchy {
+		resolverLog.Warn("No hierarchy file found, only processing base directory for merge.",
+			"path", hierarchyFilePath,
+			"base", cfg.basePath,
+		)
+		hierarchy = append(hierarchy, layer{path: cfg.basePath})
 		// Fail if the base directory does not exist
 		// Because something must have gone horribly wrong
 		cfg.failMissingPath = true
 		return hierarchy
 	}
 
-	hierarchyFile, err := os.Open(hierarchyFilePath)
-	checkForError(err)
+	hierarchyFile, err := inputFS.Open(hierarchyFilePath)
+	checkForErrorCode(err, exitPath)
 	defer hierarchyFile.Close()
 
 	// Start reading from the file with a reader
@@ -136,30 +668,68 @@ func processHierarchy(cfg config) []string {
 			break
 		}
 
-		// Trim spaces and comments
-		includePath := strings.Split(line, "#")[0]
-		includePath = strings.TrimSpace(includePath)
+		// Directives are comments to older versions, e.g. "#! requires >=1.4.0"
+		if name, argument, ok := parseHierarchyDirective(line); ok {
+			checkHierarchyDirective(hierarchyFilePath, name, argument)
+		}
+
+		// Trim spaces, quotes and comments
+		includePath, prefix, bestEffort, parseErr := parseHierarchyLine(line)
+		if parseErr != nil {
+			fail(resolverLog, exitParse, "Invalid line in hierarchy file",
+				"path", hierarchyFilePath,
+				"line", strings.TrimSpace(line),
+				"error", parseErr,
+			)
+			includePath = ""
+		}
+		// Environment variables are only replaced in the entry, never in comments
 		includePath = replaceEnvironmentVariables(includePath, true)
+		// Remote sources are fetched into the cache directory
+		if source, ok := findRemoteSource(includePath); ok {
+			if dir, ok := processRemoteSource(cfg, source, includePath, bestEffort); ok {
+				hierarchy = append(hierarchy, layer{path: dir, prefix: prefix, bestEffort: bestEffort, remote: true})
+			}
+			includePath = ""
+		}
+		if len(includePath) > 0 {
+			var pathErr error
+			if includePath, pathErr = normalizeEntryPath(includePath); pathErr != nil {
+				fail(resolverLog, exitParse, "Invalid line in hierarchy file",
+					"path", hierarchyFilePath,
+					"line", strings.TrimSpace(line),
+					"error", pathErr,
+				)
+			}
+		}
 		// Process path
 		if len(includePath) > 0 {
-			includePath = path.Join(cfg.basePath, includePath)
-			// Check if directory exists
-			if stat, err := os.Stat(includePath); err == nil && stat.IsDir() {
-				hierarchy = append(hierarchy, includePath)
+			// Absolute paths are used as is, relative paths are relative to the base path
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(cfg.basePath, includePath)
+			}
+			// Check if directory is allowed and exists
+			if cfg.restrictToBase && !isWithinBase(cfg.basePath, includePath) {
+				fail(resolverLog, exitPath, "Hierarchy directory is outside of the base path",
+					"path", includePath,
+					"base", cfg.basePath,
+				)
+			} else if !followSymlinks && isSymlink(includePath) {
+				fail(resolverLog, exitPath, "Hierarchy directory is a symlink", "path", includePath)
+			} else if stat, err := inputFS.Stat(includePath); err == nil && stat.IsDir() {
+				hierarchy = append(hierarchy, layer{path: includePath, prefix: prefix, bestEffort: bestEffort})
 				absPath, _ := filepath.Abs(includePath)
-				log.WithFields(log.Fields{
-					"path":     includePath,
-					"abs_path": absPath,
-				}).Debug("Adding path to hierarchy")
+				resolverLog.Debug("Adding path to hierarchy",
+					"path", includePath,
+					"abs_path", absPath,
+					"prefix", prefix,
+					"best_effort", bestEffort,
+				)
 			} else {
 				if cfg.failMissingPath {
-					log.WithFields(log.Fields{
-						"path": includePath,
-					}).Fatal("Hierarchy directory not found")
+					fail(resolverLog, exitPath, "Hierarchy directory not found", "path", includePath)
 				} else {
-					log.WithFields(log.Fields{
-						"path": includePath,
-					}).Warning("Ignoring missing hierarchy directory")
+					resolverLog.Warn("Ignoring missing hierarchy directory", "path", includePath)
 				}
 			}
 		}
@@ -172,91 +742,713 @@ func processHierarchy(cfg config) []string {
 	if err != io.EOF {
 		checkForError(err)
 	}
+	// Most-specific-first hierarchies, e.g. of Hiera, list the entry with the highest priority first
+	if cfg.reverseHierarchy {
+		for i, j := 0, len(hierarchy)-1; i < j; i, j = i+1, j-1 {
+			hierarchy[i], hierarchy[j] = hierarchy[j], hierarchy[i]
+		}
+	}
 	return hierarchy
 }
 
+// processRemoteSource fetches a remote hierarchy entry and returns the directory of its files.
+// Remote sources are outside of the base path, so they fail with --restrict-to-base.
+// A best-effort entry which cannot be fetched is skipped with a warning.
+func processRemoteSource(cfg config, source remoteSource, entry string, bestEffort bool) (string, bool) {
+	if cfg.restrictToBase {
+		fail(resolverLog, exitPath, "Remote hierarchy source is outside of the base path",
+			"source", entry,
+			"base", cfg.basePath,
+		)
+		return "", false
+	}
+	dir, err := fetchRemoteSource(cfg, source, entry)
+	if err != nil {
+		if bestEffort {
+			resolverLog.Warn("Ignoring remote hierarchy source in best-effort layer", "source", entry, "error", err)
+		} else {
+			fail(resolverLog, exitPath, "Error fetching remote hierarchy source", "source", entry, "error", err)
+		}
+		return "", false
+	}
+	resolverLog.Debug("Adding remote source to hierarchy",
+		"source", entry,
+		"path", dir,
+		"best_effort", bestEffort,
+	)
+	return dir, true
+}
+
+// parseHierarchyDirective returns the name and argument of a directive line in the hierarchy file,
+// which starts with "#!", e.g. "#! requires >=1.4.0"
+func parseHierarchyDirective(line string) (string, string, bool) {
+	directive := strings.TrimSpace(line)
+	if !strings.HasPrefix(directive, "#!") {
+		return "", "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(directive, "#!"))
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	return fields[0], strings.Join(fields[1:], " "), true
+}
+
+// checkHierarchyDirective applies a directive of the hierarchy file.
+// A hierarchy requiring a different version of hierarchy fails, unknown directives are ignored with a warning.
+// The compatibility level is only set if --compat or an earlier directive did not set it.
+func checkHierarchyDirective(hierarchyFilePath string, name string, argument string) {
+	switch name {
+	case "requires":
+		satisfied, err := version.Satisfies(argument)
+		if err != nil {
+			fail(resolverLog, exitParse, "Invalid directive in hierarchy file",
+				"path", hierarchyFilePath,
+				"directive", name,
+				"error", err,
+			)
+		} else if !satisfied {
+			fail(resolverLog, exitValidation, "Hierarchy file requires a different version of hierarchy",
+				"path", hierarchyFilePath,
+				"requires", argument,
+				"version", version.Version,
+			)
+		}
+	case "compat":
+		switch {
+		case !isCompatLevel(argument):
+			fail(resolverLog, exitParse, "Invalid directive in hierarchy file",
+				"path", hierarchyFilePath,
+				"directive", name,
+				"error", fmt.Sprintf("unknown compatibility level %q", argument),
+			)
+		case compat == "":
+			compat = argument
+		case compat != argument:
+			resolverLog.Info("Compatibility level of the hierarchy file is overridden",
+				"path", hierarchyFilePath,
+				"compat", argument,
+				"override", compat,
+			)
+		}
+	default:
+		resolverLog.Warn("Ignoring unknown directive in hierarchy file",
+			"path", hierarchyFilePath,
+			"directive", name,
+		)
+	}
+}
+
+// isWithinBase reports whether includePath is the base path or a directory below it,
+// also after resolving symlinks, since a symlink below the base path may point outside of it
+func isWithinBase(basePath string, includePath string) bool {
+	if !isBelow(basePath, includePath) {
+		return false
+	}
+	resolvedBase, err := filepath.EvalSymlinks(basePath)
+	if err != nil {
+		return true
+	}
+	// Directories which cannot be resolved are reported as not found
+	resolvedPath, err := filepath.EvalSymlinks(includePath)
+	if err != nil {
+		return true
+	}
+	return isBelow(resolvedBase, resolvedPath)
+}
+
+// isBelow reports whether includePath is the base path or a directory below it, without resolving symlinks
+func isBelow(basePath string, includePath string) bool {
+	absBase, err := filepath.Abs(basePath)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(includePath)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absBase, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// parseHierarchyLine returns the entry of a line in the hierarchy file, the key prefix its files are merged under,
+// and whether it is marked as best-effort with a leading '?'.
+// A '#' outside of quotes starts a comment, and a '=>' outside of quotes starts the key prefix, e.g. 'services/payments => payments.'.
+// Whitespace around the entry is removed, unless it is quoted or escaped.
+// Within double quotes, '\"' and '\\' are escapes; single quotes keep everything literally.
+// Outside of quotes, a backslash escapes whitespace and the characters #"'?\.
+// Any other backslash is kept, so Windows paths do not need escaping.
+func parseHierarchyLine(line string) (string, string, bool, error) {
+	var entry strings.Builder
+	bestEffort := false
+	started := false
+	escaped := false
+	// the quote character of the current quoted section, or 0 outside of quotes
+	var quote rune
+	// length of the entry up to the last quoted, escaped or non-whitespace character
+	significant := 0
+
+	keep := func(char rune) {
+		entry.WriteRune(char)
+		started = true
+		significant = entry.Len()
+	}
+
+	// the key prefix after '=>', see parseKeyPrefix
+	var prefix *string
+	runes := []rune(strings.TrimRight(line, "\r\n"))
+scan:
+	for i, char := range runes {
+		switch {
+		case escaped:
+			escaped = false
+			if !isEscapable(char, quote) {
+				entry.WriteRune('\\')
+			}
+			keep(char)
+		case char == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			keep(char)
+		case char == '"' || char == '\'':
+			quote = char
+			started = true
+			significant = entry.Len()
+		case char == '#':
+			break scan
+		case char == '=' && i+1 < len(runes) && runes[i+1] == '>':
+			rest := string(runes[i+2:])
+			if comment := strings.IndexRune(rest, '#'); comment >= 0 {
+				rest = rest[:comment]
+			}
+			prefix = &rest
+			break scan
+		case unicode.IsSpace(char):
+			if started {
+				entry.WriteRune(char)
+			}
+		case char == '?' && !started && !bestEffort:
+			bestEffort = true
+		default:
+			keep(char)
+		}
+	}
+	if escaped {
+		return "", "", false, errors.New("line ends with an escape character")
+	}
+	if quote != 0 {
+		return "", "", false, errors.New("missing closing quote")
+	}
+	keyPath := ""
+	if prefix != nil {
+		var err error
+		if keyPath, err = parseKeyPrefix(*prefix); err != nil {
+			return "", "", false, err
+		}
+	}
+	return entry.String()[:significant], keyPath, bestEffort, nil
+}
+
+// normalizeEntryPath converts the separators of a local hierarchy entry to the ones of the operating system.
+// Slashes are separators on every platform, backslashes only on Windows, so a directory name on Linux and macOS can contain one.
+// A path relative to the current directory of a drive, e.g. 'C:config', is an error, since it depends on the shell it runs in.
+func normalizeEntryPath(entry string) (string, error) {
+	path := filepath.FromSlash(entry)
+	if volume := filepath.VolumeName(path); len(volume) > 0 && !filepath.IsAbs(path) {
+		return "", errors.Errorf("path %s is relative to the current directory of drive %s", entry, volume)
+	}
+	return path, nil
+}
+
+// parseKeyPrefix returns the key path of the key prefix of a hierarchy entry, which may end with a '.'
+func parseKeyPrefix(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), ".")
+	if len(prefix) == 0 {
+		return "", errors.New("missing key prefix after '=>'")
+	}
+	for _, key := range strings.Split(prefix, ".") {
+		if len(key) == 0 || strings.TrimSpace(key) != key {
+			return "", fmt.Errorf("invalid key prefix %q", prefix+".")
+		}
+	}
+	return prefix, nil
+}
+
+// isEscapable reports whether a backslash followed by char is an escape sequence
+// inside a quoted section started with quote, or outside of quotes if quote is 0
+func isEscapable(char rune, quote rune) bool {
+	if quote != 0 {
+		return char == quote || char == '\\'
+	}
+	return unicode.IsSpace(char) || strings.ContainsRune("#\"'?\\", char)
+}
+
 // mergeFilesInHierarchy walks through all the folders in the hierarchy
 // and merges all files matching the pattern into the structure,
 // overwriting any existing values
 // and exports the merged content to a new YAML file
-func mergeFilesInHierarchy(hierarchy []string, fileFilter string, outputFile string, skipEnvVarContent bool, failMissingEnvVar bool) {
+func mergeFilesInHierarchy(hierarchy []layer, fileFilter string, outputFile string, skipEnvVarContent bool, failMissingEnvVar bool) {
+	data, sources := mergeFiles(hierarchy, fileFilter)
+	document, err := sources.encode(data)
+	checkForError(err)
+	writeOutput(outputFile, renderOutput(document, skipEnvVarContent, failMissingEnvVar))
+}
+
+// mergeFiles walks through all the folders in the hierarchy
+// and merges all files matching the pattern into the structure,
+// overwriting any existing values.
+// It also returns which files set the value of each key.
+func mergeFiles(hierarchy []layer, fileFilter string) (map[string]interface{}, *provenance) {
 	// Initialize variables
+	start := time.Now()
 	var data map[string]interface{}
+	sources := newProvenance()
+	numbers := map[string][]interface{}{}
+	spellings := map[string]string{}
 	counter := 0
+	options := mergeOptions(effectiveCompat())
+	mergerLog.Debug("Merging with compatibility level", "compat", effectiveCompat())
 
-	for _, includePath := range hierarchy {
-		log.WithFields(log.Fields{
-			"path": includePath,
-		}).Debug("Inspecting folder")
+	for _, includeLayer := range hierarchy {
+		mergerLog.Debug("Inspecting folder", "path", includeLayer.path)
+		layerSchema := loadLayerSchema(includeLayer.path)
 
 		// Merge in every file matching the pattern
-		for _, file := range getFiles(includePath, fileFilter) {
+		for _, file := range getFiles(includeLayer.path, fileFilter) {
 			// Generate an old version of YAML for comparison
 			oldYaml, err := yaml.Marshal(&data)
 			checkForError(err)
 
 			// Import the next file
-			log.WithFields(log.Fields{
-				"path": file,
-			}).Info("Importing file")
-			mergeFile, err := ioutil.ReadFile(file)
-			checkForError(err)
-			mergeData := make(map[string]interface{})
-			err = yaml.Unmarshal([]byte(mergeFile), &mergeData)
-			checkForError(err)
+			mergerLog.Info("Importing file", "path", file)
+			var mergeData map[string]interface{}
+			var document *yaml.Node
+			// The exit code is the one of the last step, the one that failed
+			code := exitPath
+			mergeFile, err := inputFS.ReadFile(file)
+			// Encrypted files are merged decrypted, but recorded with the checksum of the file
+			content := mergeFile
+			if err == nil && isSopsEncrypted(mergeFile) {
+				mergerLog.Debug("Decrypting file with sops", "path", file)
+				content, err = decryptSops(sopsBinary, file, mergeFile)
+				code = exitError
+			}
+			if err == nil && isTemplateFile(file) {
+				mergerLog.Debug("Rendering template file", "path", file)
+				content, err = renderTemplate(file, content, data)
+				code = exitParse
+			}
+			if err == nil && isJsonnetFile(file) {
+				mergerLog.Debug("Evaluating Jsonnet file", "path", file)
+				content, err = evaluateJsonnet(jsonnet, file, content)
+				code = exitParse
+			}
+			if err == nil {
+				mergeData, document, err = parseInput(file, content)
+				code = exitParse
+			}
+			if keys := inputKeys(includeLayer, file); err == nil && len(keys) > 0 {
+				nestDocument(document, keys)
+				mergeData, err = decodeInput(file, content, document)
+			}
+			if err == nil && keyCase != "sensitive" {
+				canonicalKeys(document, "", spellings)
+				mergeData, err = decodeInput(file, content, document)
+			}
+			if err == nil && nullPolicy == "error" {
+				err = checkNullKeys(file, document)
+				code = exitValidation
+			}
+			if err == nil && layerSchema != nil {
+				err = validateLayerFile(layerSchema, file, mergeData)
+				code = exitValidation
+			}
+			if err != nil {
+				fields := []interface{}{"path", file, "error", err}
+				var parseErr *parseError
+				if errors.As(err, &parseErr) && len(parseErr.context) > 0 {
+					fields = append(fields, "context", parseErr.context)
+				}
+				if includeLayer.bestEffort {
+					mergerLog.Warn("Skipping unreadable file in best-effort layer", fields...)
+					continue
+				}
+				fail(mergerLog, code, "Invalid input file", fields...)
+				continue
+			}
 
-			err = mergo.Merge(&data, mergeData, mergo.WithOverride)
+			err = mergo.Merge(&data, mergeData, options...)
 			checkForError(err)
+			if nullPolicy == "delete-key" {
+				deleteNullKeys(data, document)
+			}
+			sources.addFile(file, mergeFile)
+			sources.record(file, "", mergeData, data)
+			sources.recordKeys("", document)
+			collectNumbers(numbers, "", mergeData)
+			sources.expiries = append(sources.expiries, collectExpiries(file, mergeData)...)
 
 			// Generate the new YAML and print the unified diff to the trace output
 			newYaml, err := yaml.Marshal(&data)
 			checkForError(err)
-			log.Trace(diff.Diff(string(oldYaml), string(newYaml)))
+			trace(mergerLog, "Merged file",
+				"path", file,
+				"diff", diff.Diff(string(oldYaml), string(newYaml)),
+			)
 
 			counter++
 		}
+
+		// The script of the directory sees the files of the directory merged
+		if starlark.enabled {
+			scriptPath := filepath.Join(includeLayer.path, starlarkScriptFile)
+			if _, err := inputFS.Stat(scriptPath); err == nil {
+				data = applyStarlarkScript(scriptPath, data, sources, includeLayer.bestEffort)
+			}
+		}
+	}
+
+	if len(starlark.script) > 0 {
+		data = applyStarlarkScript(starlark.script, data, sources, false)
+	}
+
+	stats.Files += counter
+	mergerLog.Info("Completed merging all files",
+		"count", counter,
+		"duration", time.Since(start),
+	)
+
+	applyListDirectives(data)
+	applyAggregateDirectives(data, numbers)
+	applyNormalizeDirectives(data)
+	delete(data, expiryDirectivesKey)
+	sources.prune(data)
+
+	return data, sources
+}
+
+// unmarshalInput parses the content of an input file.
+// Errors are reported with the file and line, see parseError.
+// Duplicate keys are reported with the file, the full key path, and both line numbers.
+func unmarshalInput(file string, content []byte) (map[string]interface{}, error) {
+	mergeData, _, err := parseInput(file, content)
+	return mergeData, err
+}
+
+// parseInput parses the content of an input file like unmarshalInput,
+// and also returns its YAML node, which has the order of the keys.
+func parseInput(file string, content []byte) (map[string]interface{}, *yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, newParseError(file, content, err)
+	}
+	mergeData, err := decodeInput(file, content, &document)
+	if err != nil {
+		return nil, nil, err
 	}
+	return mergeData, &document, nil
+}
 
-	log.WithFields(log.Fields{
-		"count": counter,
-	}).Info("Completed merging all files")
+// decodeInput decodes the parsed document of an input file, failing on keys defined twice in the same mapping
+func decodeInput(file string, content []byte, document *yaml.Node) (map[string]interface{}, error) {
+	mergeData := make(map[string]interface{})
+	duplicates := []string{}
+	for _, duplicate := range findDuplicateKeys(document, "") {
+		duplicates = append(duplicates, fmt.Sprintf("%s:%d: key %s already defined at line %d", file, duplicate.line, duplicate.key, duplicate.firstLine))
+	}
+	if len(duplicates) > 0 {
+		return nil, errors.New(strings.Join(duplicates, "\n"))
+	}
+	if err := document.Decode(&mergeData); err != nil {
+		return nil, newParseError(file, content, err)
+	}
+	return stringKeys(mergeData).(map[string]interface{}), nil
+}
 
-	// Write to output file
-	log.WithFields(log.Fields{
-		"path": outputFile,
-	}).Info("Writing output file")
-	yamlDoc, err := yaml.Marshal(&data)
+// nestByDirectory merges the files of every local directory under the name of the directory, see --nest-by-directory
+var nestByDirectory = false
+
+// nestByFile merges every file under its name without the extension, see --nest-by-file
+var nestByFile = false
+
+// inputKeys returns the keys an input file of a layer is merged under: the key prefix of the layer,
+// or the name of its local directory with nestByDirectory, followed by the name of the file with nestByFile
+func inputKeys(includeLayer layer, file string) []string {
+	keys := []string{}
+	switch {
+	case len(includeLayer.prefix) > 0:
+		keys = strings.Split(includeLayer.prefix, ".")
+	case nestByDirectory && !includeLayer.remote:
+		keys = []string{filepath.Base(includeLayer.path)}
+	}
+	if nestByFile {
+		name := strings.TrimSuffix(filepath.Base(file), templateSuffix)
+		keys = append(keys, strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	return keys
+}
+
+// nestDocument moves the keys of the document of an input file below the keys of its layer.
+// Expiry directives stay at the top level, with the key paths below the keys.
+func nestDocument(document *yaml.Node, keys []string) {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return
+	}
+	prefix := strings.Join(keys, ".")
+	root := document.Content[0]
+	top := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if root.Kind == yaml.MappingNode {
+		content := []*yaml.Node{}
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			if key.Value == expiryDirectivesKey && value.Kind == yaml.MappingNode {
+				for j := 0; j+1 < len(value.Content); j += 2 {
+					value.Content[j].Value = prefix + "." + value.Content[j].Value
+				}
+				top.Content = append(top.Content, key, value)
+				continue
+			}
+			content = append(content, key, value)
+		}
+		root.Content = content
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		root = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: keys[i]}, root}}
+	}
+	top.Content = append(root.Content, top.Content...)
+	document.Content = []*yaml.Node{top}
+}
+
+// stringKeys converts the mappings below a value to maps with string keys.
+// The decoder returns a mapping with a merge key as a map with keys of any type, which would not be merged key by key.
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			value[key] = stringKeys(child)
+		}
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			converted[fmt.Sprint(key)] = stringKeys(child)
+		}
+		return converted
+	case []interface{}:
+		for i, child := range value {
+			value[i] = stringKeys(child)
+		}
+	}
+	return value
+}
+
+// stripKeys removes all keys matching the pattern from the merged data, including nested maps and lists of maps.
+// This is used for metadata keys, which only serve as documentation or merge directives.
+func stripKeys(data interface{}, pattern *regexp.Regexp) {
+	switch node := data.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(node) {
+			value := node[key]
+			if pattern.MatchString(key) {
+				outputLog.Debug("Stripping key from output", "key", key)
+				delete(node, key)
+				continue
+			}
+			stripKeys(value, pattern)
+		}
+	case []interface{}:
+		for _, value := range node {
+			stripKeys(value, pattern)
+		}
+	}
+}
+
+// loadLayerSchema returns the schema stored in a hierarchy directory, or nil if there is none
+func loadLayerSchema(includePath string) *schema.Schema {
+	schemaPath := filepath.Join(includePath, layerSchemaFile)
+	content, err := inputFS.ReadFile(schemaPath)
+	if err != nil {
+		return nil
+	}
+	mergerLog.Debug("Validating files in folder with schema", "path", schemaPath)
+	layerSchema, err := schema.Parse(content)
+	checkForErrorCode(errors.Wrapf(err, "Error parsing %s", schemaPath), exitParse)
+	return layerSchema
+}
+
+// validateLayerFile checks the content of a file against the schema of its hierarchy directory
+// and logs every violation
+func validateLayerFile(layerSchema *schema.Schema, file string, mergeData map[string]interface{}) error {
+	violations := layerSchema.Validate(mergeData)
+	for _, violation := range violations {
+		mergerLog.Error("Schema violation",
+			"file", file,
+			"path", violation.Path,
+			"error", violation.Message,
+		)
+	}
+	if len(violations) > 0 {
+		return errors.Errorf("%s does not match %s in its directory", file, layerSchemaFile)
+	}
+	return nil
+}
+
+// renderOutput converts the merged data, or a YAML node of it, into the final YAML document
+// and replaces environment variables in it unless skipEnvVarContent is set
+func renderOutput(data interface{}, skipEnvVarContent bool, failMissingEnvVar bool) string {
+	yamlDoc, err := outputStyle.marshal(data)
 	checkForError(err)
 	yamlDocStr := string(yamlDoc)
 	if !skipEnvVarContent {
 		yamlDocStr = replaceEnvironmentVariables(yamlDocStr, failMissingEnvVar)
 	}
-	err = ioutil.WriteFile(outputFile, []byte(yamlDocStr), 0660)
+	return yamlDocStr
+}
+
+// writeOutput writes the final YAML document to the output file, or standard output for "-"
+func writeOutput(outputFile string, content string) {
+	if outputFile == stdStream {
+		outputLog.Info("Writing output to standard output")
+		_, err := io.WriteString(os.Stdout, content)
+		checkForErrorCode(err, exitWrite)
+		return
+	}
+	outputLog.Info("Writing output file", "path", outputFile)
+	err := os.WriteFile(outputFile, []byte(content), 0660)
+	checkForErrorCode(err, exitWrite)
+}
+
+// checkExpiries warns about values still set by a file after the date of its expiry directive,
+// or fails if failExpired is set
+func checkExpiries(sources *provenance, now time.Time, failExpired bool) {
+	for _, e := range expiredOverrides(sources, now) {
+		fields := []interface{}{
+			"key", e.key,
+			"path", e.file,
+			"expired", e.date.Format("2006-01-02"),
+		}
+		if failExpired {
+			fail(mergerLog, exitValidation, "Override expired", fields...)
+		} else {
+			mergerLog.Warn("Override expired", fields...)
+		}
+	}
+}
+
+// validateOutput checks the final YAML document, after replacing environment variables, against a JSON Schema file
+func validateOutput(schemaFile string, output string) ([]schema.Error, error) {
+	s, err := schema.Load(schemaFile)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+		return nil, err
+	}
+	return s.Validate(document), nil
+}
+
+// listHierarchy writes the directories and files of the hierarchy in merge order.
+// The lines are YAML comments, so they can precede the merged document.
+func listHierarchy(w io.Writer, hierarchy []layer, fileFilter string) {
+	for _, includeLayer := range hierarchy {
+		if len(includeLayer.prefix) > 0 {
+			fmt.Fprintf(w, "# %s => %s.\n", includeLayer.path, includeLayer.prefix)
+		} else {
+			fmt.Fprintf(w, "# %s\n", includeLayer.path)
+		}
+		for _, file := range getFiles(includeLayer.path, fileFilter) {
+			fmt.Fprintf(w, "#   %s\n", file)
+		}
+	}
+}
 
+// readPreviousOutput returns the content of an existing output file,
+// or an empty string if there is none yet
+func readPreviousOutput(outputFile string) string {
+	if outputFile == stdStream {
+		return ""
+	}
+	content, err := os.ReadFile(outputFile)
+	if os.IsNotExist(err) {
+		return ""
+	}
 	checkForError(err)
+	return string(content)
+}
+
+// diffLines splits text into lines that keep their newline, without the empty line
+// difflib.SplitLines adds after a trailing newline
+func diffLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff returns the unified diff of two versions of a file with 3 lines of context,
+// which patch and git apply can read, or an empty string if they are equal
+func unifiedDiff(file string, previous string, current string) string {
+	text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(previous),
+		B:        diffLines(current),
+		FromFile: file,
+		ToFile:   file,
+		Context:  3,
+	})
+	return text
 }
 
 // getFiles gets all files in a given path and returns a list of files with extensions matching the fileFilter
 func getFiles(includePath string, fileFilter string) []string {
 	var includeFiles []string
-	files, err := ioutil.ReadDir(includePath)
-	checkForError(err)
-	for _, fileInfo := range files {
-		if !fileInfo.IsDir() {
-			filePath := path.Join(includePath, fileInfo.Name())
-			r, err := regexp.MatchString(fileFilter, fileInfo.Name())
-			if err == nil && r {
+	files, err := inputFS.ReadDir(includePath)
+	checkForErrorCode(err, exitPath)
+	for _, entry := range files {
+		filePath := filepath.Join(includePath, entry.Name())
+		symlink, dir := isSymlinkedDir(filePath, entry)
+		if symlink && !followSymlinks {
+			mergerLog.Debug("Ignoring symlink", "file", filePath)
+			continue
+		}
+		if mergeOutputs.contains(filePath) {
+			mergerLog.Debug("Ignoring file", "file", filePath, "reason", "output")
+			continue
+		}
+		if !dir && entry.Name() != layerSchemaFile && entry.Name() != starlarkScriptFile && entry.Name() != orderFile && entry.Name() != ignoreFile {
+			// Template files match the filter with the name they are rendered to
+			name := entry.Name()
+			if isTemplateFile(name) {
+				name = strings.TrimSuffix(name, templateSuffix)
+			}
+			r, err := regexp.MatchString(fileFilter, name)
+			if (err == nil && r) || isJsonnetFile(name) {
 				includeFiles = append(includeFiles, filePath)
-				log.WithFields(log.Fields{
-					"file": filePath,
-				}).Debug("Adding file to list")
+				mergerLog.Debug("Adding file to list", "file", filePath)
+			} else {
+				mergerLog.Debug("Ignoring file", "file", filePath)
+			}
+		}
+	}
+	ignored, err := ignoredFiles(includePath, includeFiles)
+	checkForErrorCode(err, exitParse)
+	if len(ignored) > 0 {
+		kept := []string{}
+		for _, file := range includeFiles {
+			if ignored[file] {
+				mergerLog.Debug("Ignoring file", "file", file, "reason", ignoreFile)
 			} else {
-				log.WithFields(log.Fields{
-					"file": filePath,
-				}).Debug("Ignoring file")
+				kept = append(kept, file)
 			}
 		}
+		includeFiles = kept
 	}
+	sortFiles(includeFiles)
+	names, err := readOrderFile(includePath)
+	checkForErrorCode(err, exitPath)
+	includeFiles, err = orderFiles(includePath, includeFiles, names)
+	checkForErrorCode(err, exitParse)
 	return includeFiles
 }
 
@@ -273,14 +1465,11 @@ func replaceEnvironmentVariables(str string, failMissing bool) string {
 		envVarName = strings.TrimSuffix(envVarName, "}")
 		envVar := os.Getenv(strings.ToUpper(envVarName))
 		if len(envVar) == 0 {
+			stats.SubstitutionFailures++
 			if failMissing {
-				log.WithFields(log.Fields{
-					"name": envVarName,
-				}).Fatal("Environment variable not defined")
+				fail(substitutionLog, exitVariable, "Environment variable not defined", "name", envVarName)
 			} else {
-				log.WithFields(log.Fields{
-					"name": envVarName,
-				}).Warning("Environment variable not defined, skipping")
+				substitutionLog.Warn("Environment variable not defined, skipping", "name", envVarName)
 			}
 		} else {
 			str = strings.ReplaceAll(str, varName, envVar)
@@ -291,45 +1480,489 @@ func replaceEnvironmentVariables(str string, failMissing bool) string {
 
 func main() {
 	cfg := parseFlags()
+	// The merges of serve write the document to standard output
+	if cfg.command == "serve" && os.Getenv(mergeChildEnv) == "1" {
+		cfg.outputFile = stdStream
+		cfg.outputFormat = "yaml"
+	}
+	// The merges of batch render a single release
+	if cfg.command == "batch" && os.Getenv(mergeChildEnv) == "1" {
+		cfg.basePath = os.Getenv(batchBaseEnv)
+		cfg.outputFile = os.Getenv(batchOutputEnv)
+	}
 
-	// Configure logging level
-	log.SetOutput(os.Stdout)
+	// Configure logging levels, --log-levels overrides the level of single components
+	defaultLevel, err := logging.ParseLevel(cfg.logLevel)
+	checkForError(err)
 	if cfg.logTrace {
-		log.SetLevel(log.TraceLevel)
-	} else if cfg.logDebug {
-		log.SetLevel(log.DebugLevel)
-	} else {
-		log.SetLevel(log.InfoLevel)
-	}
-
-	version.Log()
-
-	log.WithFields(log.Fields{
-		"hierarchyFile":        cfg.hierarchyFile,
-		"basePath":             cfg.basePath,
-		"outputFile":           cfg.outputFile,
-		"outputPermissions":    cfg.outputFile,
-		"filterExtension":      cfg.filterExtension,
-		"failMissingHierarchy": cfg.failMissingHierarchy,
-		"failMissingPath":      cfg.failMissingPath,
-		"failMissingEnvVar":    cfg.failMissingEnvVar,
-		"skipEnvVarContent":    cfg.skipEnvVarContent,
-	}).Debug("Configuration settings")
+		defaultLevel = logging.LevelTrace
+	} else if cfg.logDebug && defaultLevel > slog.LevelDebug {
+		defaultLevel = slog.LevelDebug
+	}
+	levels, err := logging.ParseLevels(cfg.logLevels, defaultLevel)
+	checkForError(err)
+	logs, closeLogs, err := logWriter(cfg)
+	checkForErrorCode(errors.Wrapf(err, "Error opening log file %s", cfg.logFile), exitWrite)
+	defer closeLogs()
+	setupLogging(logs, cfg.logFormat, levels)
+
+	version.Log(appLog)
+
+	cfg.outputFile = expandOutputPath(cfg.outputFile, time.Now())
+
+	appLog.Debug("Configuration settings",
+		"hierarchyFile", cfg.hierarchyFile,
+		"basePath", cfg.basePath,
+		"reverseHierarchy", cfg.reverseHierarchy,
+		"outputFile", cfg.outputFile,
+		"outputFormat", cfg.outputFormat,
+		"outputsManifest", cfg.outputsManifest,
+		"splitByTopLevelKey", cfg.splitByTopLevelKey,
+		"nestByDirectory", cfg.nestByDirectory,
+		"nestByFile", cfg.nestByFile,
+		"fileOrder", cfg.fileOrder,
+		"outputDir", cfg.outputDir,
+		"documents", strings.Join(cfg.documents, " "),
+		"sortKeys", cfg.sortKeys,
+		"comments", cfg.comments,
+		"anchors", cfg.anchors,
+		"yamlIndent", cfg.yamlIndent,
+		"yamlLineWidth", cfg.yamlLineWidth,
+		"yamlQuote", cfg.yamlQuote,
+		"yamlSequenceStyle", cfg.yamlSequenceStyle,
+		"blobsThreshold", cfg.blobsThreshold,
+		"blobsDir", cfg.blobsDir,
+		"outputPermissions", cfg.outputFile,
+		"provenanceFile", cfg.provenanceFile,
+		"schemaFile", cfg.schemaFile,
+		"cueSchema", cfg.cueSchema,
+		"cueExport", cfg.cueExport,
+		"policyPath", cfg.policyPath,
+		"policyQuery", cfg.policyQuery,
+		"ownersFile", cfg.ownersFile,
+		"lineageURL", cfg.lineageURL,
+		"lineageNamespace", cfg.lineageNamespace,
+		"lineageJob", cfg.lineageJob,
+		"postHook", cfg.postHook,
+		"annotate", cfg.annotate,
+		"filterExtension", cfg.filterExtension,
+		"templates", cfg.templates,
+		"jsonnet", cfg.jsonnet,
+		"jsonnetExtVars", strings.Join(cfg.jsonnetExtVars, " "),
+		"jsonnetLibraryPaths", strings.Join(cfg.jsonnetLibraryPaths, " "),
+		"jsonnetBinary", cfg.jsonnetBinary,
+		"starlark", cfg.starlark,
+		"starlarkScript", cfg.starlarkScript,
+		"starlarkBinary", cfg.starlarkBinary,
+		"stripKeys", cfg.stripKeys,
+		"transforms", strings.Join(cfg.transforms, " "),
+		"passthrough", cfg.passthrough,
+		"passthroughKey", cfg.passthroughKey,
+		"kubernetesKind", cfg.kubernetesKind,
+		"kubernetesName", cfg.kubernetesName,
+		"kubernetesNamespace", cfg.kubernetesNamespace,
+		"kubernetesLabels", cfg.kubernetesLabels,
+		"kubernetesKey", cfg.kubernetesKey,
+		"kubernetesApply", cfg.kubernetesApply,
+		"kubernetesDryRun", cfg.kubernetesDryRun,
+		"kubernetesFieldManager", cfg.kubernetesFieldManager,
+		"kubernetesForce", cfg.kubernetesForce,
+		"kubernetesContext", cfg.kubernetesContext,
+		"kubeconfig", cfg.kubeconfig,
+		"failMissingHierarchy", cfg.failMissingHierarchy,
+		"failMissingPath", cfg.failMissingPath,
+		"failMissingEnvVar", cfg.failMissingEnvVar,
+		"failMissingSecret", cfg.failMissingSecret,
+		"failExpired", cfg.failExpired,
+		"restrictToBase", cfg.restrictToBase,
+		"noFollowSymlinks", cfg.noFollowSymlinks,
+		"keepGoing", cfg.keepGoing,
+		"compat", cfg.compat,
+		"nullPolicy", cfg.nullPolicy,
+		"keyCase", cfg.keyCase,
+		"sandbox", cfg.sandbox,
+		"skipEnvVarContent", cfg.skipEnvVarContent,
+		"skipSecrets", cfg.skipSecrets,
+		"vaultAddress", cfg.vaultAddress,
+		"vaultNamespace", cfg.vaultNamespace,
+		"vaultAuth", cfg.vaultAuth,
+		"vaultAuthPath", cfg.vaultAuthPath,
+		"vaultRoleID", cfg.vaultRoleID,
+		"vaultRole", cfg.vaultRole,
+		"awsRegion", cfg.awsRegion,
+		"awsProfile", cfg.awsProfile,
+		"awsEndpoint", cfg.awsEndpoint,
+		"cacheDir", cfg.cacheDir,
+		"offline", cfg.offline,
+		"remoteRefreshInterval", cfg.remoteRefreshInterval,
+		"httpTimeout", cfg.httpTimeout,
+		"httpCAFile", cfg.httpCAFile,
+		"httpClientCert", cfg.httpClientCert,
+		"httpInsecureSkipVerify", cfg.httpInsecureSkipVerify,
+		"consulAddress", cfg.consulAddress,
+		"consulPublish", cfg.consulPublish,
+		"etcdEndpoints", strings.Join(cfg.etcdEndpoints, " "),
+		"etcdUsername", cfg.etcdUsername,
+		"etcdCAFile", cfg.etcdCAFile,
+		"etcdCert", cfg.etcdCert,
+		"etcdPublish", cfg.etcdPublish,
+		"etcdLayout", cfg.etcdLayout,
+		"enableExecLookups", cfg.enableExecLookups,
+		"execLookupsAllow", strings.Join(cfg.execLookupsAllow, " "),
+		"diffOutput", cfg.diffOutput,
+		"dryRun", cfg.dryRun,
+		"daemon", cfg.daemon,
+		"pidFile", cfg.pidFile,
+		"watch", cfg.watch,
+		"watchInterval", cfg.watchInterval,
+		"webhookURL", cfg.webhookURL,
+		"metricsListen", cfg.metricsListen,
+		"logLevels", levels,
+		"logFormat", cfg.logFormat,
+		"logFile", cfg.logFile,
+	)
+
+	keepGoing = cfg.keepGoing
+	compat = cfg.compat
+	nullPolicy = cfg.nullPolicy
+	keyCase = cfg.keyCase
+	nestByDirectory = cfg.nestByDirectory
+	nestByFile = cfg.nestByFile
+	fileOrder = cfg.fileOrder
+	ignoreBase = cfg.basePath
+	followSymlinks = !cfg.noFollowSymlinks
+	sopsBinary = cfg.sopsBinary
+	renderTemplates = cfg.templates
+	keepComments = cfg.comments
+	keepAnchors = cfg.anchors == "keep"
+	if cfg.yamlIndent < 2 || cfg.yamlIndent > 9 {
+		fatal(appLog, exitError, "The indentation of the YAML output must be from 2 to 9 spaces", "indent", cfg.yamlIndent)
+	}
+	if cfg.yamlLineWidth < 0 {
+		fatal(appLog, exitError, "The line width of the YAML output must not be negative", "width", cfg.yamlLineWidth)
+	}
+	outputStyle = yamlStyle{
+		indent:    cfg.yamlIndent,
+		lineWidth: cfg.yamlLineWidth,
+		quote:     cfg.yamlQuote,
+		sequences: cfg.yamlSequenceStyle,
+	}
+	jsonnet = jsonnetOptions{
+		enabled:      cfg.jsonnet,
+		binary:       cfg.jsonnetBinary,
+		extVars:      cfg.jsonnetExtVars,
+		libraryPaths: cfg.jsonnetLibraryPaths,
+	}
+	starlark = starlarkOptions{
+		enabled: cfg.starlark,
+		script:  cfg.starlarkScript,
+		binary:  cfg.starlarkBinary,
+	}
+
+	if cfg.sandbox {
+		root, err := sandbox.Open(cfg.basePath)
+		checkForErrorCode(errors.Wrapf(err, "Error opening sandbox at %s", cfg.basePath), exitPath)
+		defer root.Close()
+		inputFS = fsutil.Sandbox(root)
+		cfg.restrictToBase = true
+	}
+
+	switch cfg.command {
+	case "validate":
+		cfg.readOnly = true
+		runMerge(cfg)
+		outputLog.Info("Merged output is valid")
+	case "diff":
+		cfg.readOnly = true
+		cfg.diffOutput = true
+		runMerge(cfg)
+	case "get", "keys":
+		// A typo in the query fails before the hierarchy is merged
+		_, err := parseQuery(cfg.query)
+		checkForError(err)
+		cfg.readOnly = true
+		runMerge(cfg)
+	case "explain":
+		runExplain(cfg)
+	case "init":
+		runInit(cfg)
+	case "import hiera":
+		runImportHiera(cfg)
+	case "import spring":
+		runImportSpring(cfg)
+	case "lint":
+		runLint(cfg, os.Stdout)
+	case "compare":
+		runCompare(cfg)
+	case "pr-report":
+		runPullRequestReport(cfg, os.Stdout)
+	case "batch":
+		if os.Getenv(mergeChildEnv) == "1" {
+			runMerge(cfg)
+			return
+		}
+		runBatch(cfg)
+	case "serve":
+		if os.Getenv(mergeChildEnv) == "1" {
+			runMerge(cfg)
+			return
+		}
+		runServe(cfg)
+	default:
+		if (cfg.daemon || cfg.watch) && os.Getenv(mergeChildEnv) != "1" {
+			runDaemon(cfg)
+			return
+		}
+		runMerge(cfg)
+	}
+}
+
+// runExplain merges the hierarchy in memory and reports where the value of a key came from
+func runExplain(cfg config) {
+	hierarchy := processHierarchy(cfg)
+	_, sources := mergeFiles(hierarchy, cfg.filterExtension)
+	exitOnFailures()
+	err := sources.explain(os.Stdout, cfg.explainKey)
+	checkForError(err)
+}
+
+// runMerge merges the hierarchy and writes the result to the output file
+func runMerge(cfg config) {
+	// A merge child reports its statistics to the parent exporting metrics
+	defer writeMergeStats()
+
+	// Keep the previous output, so it can be compared with the new result
+	previousOutput := ""
+	if cfg.diffOutput || len(cfg.postHook) > 0 || len(cfg.webhookURL) > 0 {
+		previousOutput = readPreviousOutput(cfg.outputFile)
+	}
 
 	// Make sure we remove the output file if it already exists
 	// Just in case the program ends for any reason other than success
 	// We don't want to give the impression that we completed the merging
-	if _, err := os.Stat(cfg.outputFile); err == nil {
-		log.WithFields(log.Fields{
-			"path": cfg.outputFile,
-		}).Info("Removing existing output file")
+	if _, err := os.Stat(cfg.outputFile); err == nil && !cfg.dryRun && !cfg.readOnly && cfg.outputFile != stdStream {
+		outputLog.Info("Removing existing output file", "path", cfg.outputFile)
 		err := os.Remove(cfg.outputFile)
-		checkForError(err)
+		checkForErrorCode(err, exitWrite)
 	}
 
 	// Process the hierarchy and get the list of files to be included
 	hierarchy := processHierarchy(cfg)
+	layerDirs := make([]string, 0, len(hierarchy))
+	for _, includeLayer := range hierarchy {
+		layerDirs = append(layerDirs, includeLayer.path)
+	}
+	mergeOutputs = newOutputPaths(cfg, layerDirs)
+	if cfg.splitByTopLevelKey {
+		checkForErrorCode(checkSplitOutputDir(cfg.outputDir, hierarchy), exitWrite)
+	}
+	if cfg.dryRun {
+		listHierarchy(os.Stdout, hierarchy, cfg.filterExtension)
+	}
 
 	// Proceed with merging configuration files
-	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, cfg.skipEnvVarContent, cfg.failMissingEnvVar)
+	data, sources := mergeFiles(hierarchy, cfg.filterExtension)
+	passthroughFiles := []passthroughFile{}
+	if cfg.passthrough != "none" {
+		passthroughFiles = collectPassthroughFiles(hierarchy)
+	}
+	if cfg.passthrough == "embed" {
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		embedPassthroughFiles(data, cfg.passthroughKey, passthroughFiles, sources)
+	}
+	if cfg.passthrough == "copy" && cfg.outputFile == stdStream {
+		fatal(outputLog, exitWrite, "Files cannot be copied next to standard output, use --files=embed")
+	}
+	chain, err := transform.Parse(cfg.transforms)
+	checkForErrorCode(err, exitParse)
+	if len(cfg.stripKeys) > 0 {
+		stripPattern, err := regexp.Compile(cfg.stripKeys)
+		checkForError(err)
+		strip := func(node map[string]interface{}, _ transform.Sources) (map[string]interface{}, error) {
+			stripKeys(node, stripPattern)
+			return node, nil
+		}
+		chain = append(transform.Chain{{Name: "strip-keys", Transform: strip}}, chain...)
+	}
+	if len(chain) > 0 {
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		data, err = chain.Apply(data, sources)
+		checkForError(err)
+		sources.prune(data)
+	}
+	// Large values are externalized before the output is rendered, so they never end up in its diff
+	blobs := map[string]string{}
+	if cfg.blobsThreshold > 0 && data != nil {
+		blobs, err = externalizeBlobs(data, cfg.blobsThreshold, cfg.blobsDir)
+		checkForError(err)
+	}
+	if cfg.sortKeys {
+		sources.sortKeys()
+	}
+	document, err := sources.encode(data)
+	checkForError(err)
+	if cfg.annotate != "none" {
+		document, err = sources.annotate(data, cfg.annotate == "all")
+		checkForError(err)
+	}
+	output := renderOutput(document, cfg.skipEnvVarContent, cfg.failMissingEnvVar)
+	if !cfg.skipSecrets {
+		output = resolveSecrets(cfg, output)
+	}
+
+	if len(cfg.cueSchema) > 0 {
+		outputLog.Info("Applying CUE schema",
+			"schema", cfg.cueSchema,
+			"export", cfg.cueExport,
+		)
+		exported, err := applyCue(cfg.cueBinary, cfg.cueSchema, output, cfg.cueExport)
+		if err != nil {
+			fail(outputLog, exitValidation, err.Error())
+		} else {
+			output = exported
+		}
+	}
+
+	if len(cfg.schemaFile) > 0 {
+		violations, err := validateOutput(cfg.schemaFile, output)
+		checkForError(err)
+		for _, violation := range violations {
+			outputLog.Error("Schema violation",
+				"path", violation.Path,
+				"error", violation.Message,
+			)
+		}
+		if len(violations) > 0 {
+			fail(outputLog, exitValidation, "Merged output does not match the schema",
+				"schema", cfg.schemaFile,
+				"count", len(violations),
+			)
+		}
+	}
+
+	if len(cfg.policyPath) > 0 {
+		var document interface{}
+		err := yaml.Unmarshal([]byte(output), &document)
+		checkForError(err)
+		messages, err := evaluatePolicies(cfg.opaBinary, cfg.policyPath, cfg.policyQuery, document)
+		checkForError(err)
+		for _, message := range messages {
+			outputLog.Error(message, "policy", cfg.policyPath)
+		}
+		if len(messages) > 0 {
+			fail(outputLog, exitValidation, "Merged output violates policies",
+				"policy", cfg.policyPath,
+				"count", len(messages),
+			)
+		}
+	}
+
+	if len(cfg.ownersFile) > 0 {
+		checkOwnership(cfg.ownersFile, sources, cfg.basePath)
+	}
+
+	checkExpiries(sources, time.Now(), cfg.failExpired)
+
+	// Nothing is written if --keep-going recorded any failures
+	exitOnFailures()
+
+	// Consul, etcd, named outputs, and split outputs get the validated YAML document, whatever the format of the output file
+	published := output
+
+	// The document is validated as a whole before it is split into documents
+	if len(cfg.documents) > 0 {
+		if cfg.outputFormat != "yaml" || cfg.kubernetesKind != "none" {
+			fatal(outputLog, exitParse, "A multi-document stream can only be written as YAML without --kubernetes.kind")
+		}
+		stream, err := renderDocuments(output, cfg.documents)
+		checkForError(err)
+		output = stream
+	}
+
+	// The document is validated as YAML before it is converted
+	if cfg.outputFormat != "yaml" {
+		formatted, err := formatOutput(cfg.outputFormat, output)
+		checkForError(err)
+		output = formatted
+	}
+
+	// The manifest is written instead of the document, which was validated before
+	if cfg.kubernetesKind != "none" {
+		manifest, err := wrapManifest(cfg, output)
+		checkForError(err)
+		output = manifest
+	}
+
+	// The diff goes to the log writer, so it never mixes with the merged document on standard output
+	if cfg.diffOutput {
+		if changes := redactDiff(unifiedDiff(cfg.outputFile, previousOutput, output)); len(changes) > 0 {
+			_, err := io.WriteString(logOutput, changes)
+			checkForError(err)
+		} else {
+			outputLog.Info("Merged output is unchanged", "path", cfg.outputFile)
+		}
+	}
+
+	// The webhook of --daemon and --watch gets the diff from the merge, which knows the secrets to redact
+	if len(cfg.webhookURL) > 0 && stats.Secrets > 0 {
+		stats.Diff = redactDiff(unifiedDiff(cfg.outputFile, previousOutput, output))
+	}
+
+	if cfg.dryRun {
+		fmt.Print(redactSecrets(output))
+		return
+	}
+	if cfg.readOnly {
+		switch cfg.command {
+		case "get":
+			checkForError(writeQuery(os.Stdout, published, cfg.query, cfg.queryFormat))
+		case "keys":
+			checkForError(writeKeys(os.Stdout, published, cfg.query, sources, cfg.keysTypes, cfg.keysSources))
+		}
+		return
+	}
+	if cfg.kubernetesApply {
+		applyManifest(cfg, output)
+		return
+	}
+	writeOutput(cfg.outputFile, output)
+	if cfg.passthrough == "copy" {
+		copyPassthroughFiles(filepath.Dir(cfg.outputFile), passthroughFiles, sources)
+	}
+	if len(blobs) > 0 {
+		writeBlobs(cfg, blobs)
+	}
+	if len(cfg.outputsManifest) > 0 {
+		writeNamedOutputs(cfg, published)
+	}
+	if cfg.splitByTopLevelKey {
+		writeSplitOutputs(cfg, published)
+	}
+	if len(cfg.consulPublish) > 0 {
+		publishConsul(cfg, published)
+	}
+	if len(cfg.etcdPublish) > 0 {
+		publishEtcd(cfg, published)
+	}
+
+	if len(cfg.provenanceFile) > 0 {
+		outputLog.Info("Writing provenance file", "path", cfg.provenanceFile)
+		err := sources.writeManifest(cfg.provenanceFile, cfg.outputFile, output)
+		checkForErrorCode(err, exitWrite)
+	}
+
+	if len(cfg.lineageURL) > 0 {
+		exportLineage(cfg, sources, output)
+	}
+
+	if len(cfg.postHook) > 0 {
+		changed := output != previousOutput
+		outputLog.Info("Running post hook", "command", cfg.postHook, "changed", changed)
+		err := runPostHook(cfg.postHook, cfg.outputFile, changed)
+		checkForError(errors.Wrap(err, "Post hook failed"))
+	}
 }
@@ -16,28 +16,52 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/KohlsTechnology/hierarchy/internal/fsutil"
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+	"github.com/KohlsTechnology/hierarchy/pkg/sandbox"
+	"github.com/KohlsTechnology/hierarchy/pkg/schema"
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"gopkg.in/yaml.v3"
 )
 
 var cfgDefaults = config{
-	hierarchyFile:        "hierarchy.lst",
-	basePath:             "",
-	outputFile:           "output.yaml",
-	filterExtension:      defaultFileFilter,
-	printVersion:         false,
-	diffOutput:           false,
-	logDebug:             false,
-	logTrace:             false,
-	failMissingHierarchy: false,
-	failMissingPath:      false,
-	failMissingEnvVar:    false,
-	skipEnvVarContent:    false,
+	hierarchyFile:          "hierarchy.lst",
+	basePath:               "",
+	outputFile:             "output.yaml",
+	filterExtension:        defaultFileFilter,
+	printVersion:           false,
+	diffOutput:             false,
+	logDebug:               false,
+	logTrace:               false,
+	logLevel:               "info",
+	failMissingHierarchy:   false,
+	failMissingPath:        false,
+	failMissingEnvVar:      false,
+	skipEnvVarContent:      false,
+	passthrough:            "none",
+	passthroughKey:         "files",
+	outputFormat:           "yaml",
+	vaultAuth:              "token",
+	kubernetesKind:         "none",
+	kubernetesFieldManager: "hierarchy",
+	httpTimeout:            30 * time.Second,
+	etcdLayout:             "flat",
 }
 
 // TestGetFilesSuccess verifies that we receive the correct list of files to be merged
@@ -59,14 +83,37 @@ func TestProcessHierarchySuccess(t *testing.T) {
 	cfg := cfgDefaults
 	cfg.basePath = "testdata/test1"
 
-	expected := []string{"testdata/default", "testdata/yaml", "testdata/json", "testdata/empty", "testdata/test1"}
+	expected := []layer{
+		{path: "testdata/default"},
+		{path: "testdata/yaml"},
+		{path: "testdata/json"},
+		{path: "testdata/empty"},
+		{path: "testdata/test1"},
+	}
+	result := processHierarchy(cfg)
+	assert.Equal(t, expected, result)
+}
+
+// TestProcessHierarchyReverse verifies that --reverse-hierarchy merges the first entry last
+func TestProcessHierarchyReverse(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.reverseHierarchy = true
+
+	expected := []layer{
+		{path: "testdata/test1"},
+		{path: "testdata/empty"},
+		{path: "testdata/json"},
+		{path: "testdata/yaml"},
+		{path: "testdata/default"},
+	}
 	result := processHierarchy(cfg)
 	assert.Equal(t, expected, result)
 }
 
 // TestFailMissingPath tests the correct behavior of the `--failmissing` command line option
 // It spawns a new process to determine the exit code of the application.
-// Anything other than a 1 is a problem
+// Anything other than exitPath (4) is a problem
 // It uses the environment variable TEST_FAIL_EMPTY to signal the actual execution of the functionality
 func TestFailMissingPath(t *testing.T) {
 	if os.Getenv("TEST_FAIL_EMPTY") == "1" {
@@ -83,11 +130,11 @@ func TestFailMissingPath(t *testing.T) {
 	cmd.Env = append(os.Environ(), "TEST_FAIL_EMPTY=1")
 	output, err := cmd.CombinedOutput()
 	fmt.Printf("%s\n", output)
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
 		fmt.Printf("Process correctly failed with %v\n", e)
 		return
 	}
-	t.Fatalf("process ran with err %v, want exit status 1.", err)
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
 }
 
 // TestEnd2EndSuccess runs through the full functionality end-to-end
@@ -96,6 +143,7 @@ func TestEnd2EndSuccess(t *testing.T) {
 
 	cfg := cfgDefaults
 	cfg.basePath = "testdata/test1"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
 
 	// process the hierarchy and get the list of include files
 	hierarchy := processHierarchy(cfg)
@@ -103,21 +151,276 @@ func TestEnd2EndSuccess(t *testing.T) {
 	// Lets do the deed
 	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
 
-	expected, err := ioutil.ReadFile("testdata/test1/result/expected.yaml")
+	expected, err := os.ReadFile("testdata/test1/result/expected.yaml")
 	if err != nil {
 		t.Fatalf("Error reading file with expected test results: %v", err)
 	}
-	result, err := ioutil.ReadFile(cfg.outputFile)
+	result, err := os.ReadFile(cfg.outputFile)
 	if err != nil {
 		t.Fatalf("Error reading output file: %v", err)
 	}
 	assert.Equal(t, string(expected), string(result))
 }
 
+// TestEnd2EndStandardOutputSuccess writes the merged document to standard output with '--output=-'
+// and verifies that standard output only contains the document
+func TestEnd2EndStandardOutputSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.outputFile = "-"
+	cfg.annotate = "none"
+
+	logs, closeLogs, err := logWriter(cfg)
+	assert.NoError(t, err)
+	defer closeLogs()
+	assert.Equal(t, os.Stderr, logs)
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	setupLogging(logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	runMerge(cfg)
+	os.Stdout = stdout
+	writer.Close()
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+
+	result, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	expected, err := os.ReadFile("testdata/test1/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestEnd2EndDryRunStandardOutput verifies that standard output only contains the hierarchy and the document with '--dry-run'
+func TestEnd2EndDryRunStandardOutput(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.dryRun = true
+
+	logs, closeLogs, err := logWriter(cfg)
+	assert.NoError(t, err)
+	defer closeLogs()
+	assert.Equal(t, os.Stderr, logs)
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	setupLogging(logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	runMerge(cfg)
+	os.Stdout = stdout
+	writer.Close()
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+
+	result, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	expected, err := os.ReadFile("testdata/test1/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	document := []string{}
+	for _, line := range strings.SplitAfter(string(result), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			document = append(document, line)
+		}
+	}
+	assert.Equal(t, string(expected), strings.Join(document, ""))
+	assert.NoFileExists(t, cfg.outputFile)
+}
+
+// TestEnd2EndReadOnly verifies that the validate and diff commands merge without writing the output file
+func TestEnd2EndReadOnly(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.readOnly = true
+	cfg.diffOutput = true
+	err := os.WriteFile(cfg.outputFile, []byte("previous: output\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing output file: %v", err)
+	}
+
+	var logs bytes.Buffer
+	setupLogging(&logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	runMerge(cfg)
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+
+	assert.Contains(t, logs.String(), "--- "+cfg.outputFile+"\n+++ "+cfg.outputFile+"\n@@ -1 +1,16 @@\n")
+	assert.Contains(t, logs.String(), "\n-previous: output\n")
+	assert.Contains(t, logs.String(), "\n+test1:\n")
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "previous: output\n", string(content))
+}
+
+// TestEnd2EndDiffStdout verifies that --diff keeps the diff off standard output when the merged document is written there
+func TestEnd2EndDiffStdout(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.outputFile = stdStream
+	cfg.annotate = "none"
+	cfg.diffOutput = true
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	var logs bytes.Buffer
+	stdout := os.Stdout
+	os.Stdout = writer
+	setupLogging(&logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	runMerge(cfg)
+	os.Stdout = stdout
+	writer.Close()
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+
+	result, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	expected, err := os.ReadFile("testdata/test1/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+	assert.Contains(t, logs.String(), "--- -\n+++ -\n@@ -0,0 +1,16 @@\n+test1:\n")
+}
+
+// TestParseFlagsStandardOutput verifies the documented spellings of writing to standard output parse from the command line
+func TestParseFlagsStandardOutput(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	for _, arguments := range [][]string{{"--output=-"}, {"-o-"}, {"--output", "-"}, {"-o", "-"}, {"--output", "-", "--log-file", "-", "get", "test1"}} {
+		os.Args = append([]string{"hierarchy"}, arguments...)
+		cfg := parseFlags()
+		assert.Equal(t, stdStream, cfg.outputFile, arguments)
+	}
+}
+
+// TestFailParseFlagsStandardOutput verifies that the usage printed for invalid arguments accepts a separate '-' like the parser.
+// It spawns a new process to determine the exit code of the application.
+// It uses the environment variable TEST_FAIL_PARSE_FLAGS to signal the actual execution of the functionality
+func TestFailParseFlagsStandardOutput(t *testing.T) {
+	if os.Getenv("TEST_FAIL_PARSE_FLAGS") == "1" {
+		os.Args = []string{"hierarchy", "-o", "-", "--output-format=bogus"}
+		parseFlags()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailParseFlagsStandardOutput")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_PARSE_FLAGS=1")
+	output, err := cmd.CombinedOutput()
+	assert.Contains(t, string(output), "enum value must be one of")
+	assert.NotContains(t, string(output), "expected argument for flag")
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitUsage {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitUsage)
+}
+
+// TestFailParseFlagsUnknown verifies that an unknown flag prints the usage and fails with exitUsage (2)
+// instead of the exit code 1 of kingpin.
+// It uses the environment variable TEST_FAIL_PARSE_FLAGS_UNKNOWN to signal the actual execution of the functionality
+func TestFailParseFlagsUnknown(t *testing.T) {
+	if arguments := os.Getenv("TEST_FAIL_PARSE_FLAGS_UNKNOWN"); arguments != "" {
+		os.Args = append([]string{"hierarchy"}, strings.Fields(arguments)...)
+		parseFlags()
+		return
+	}
+
+	for _, arguments := range []string{"--bogus", "get --bogus test1"} {
+		cmd := exec.Command(os.Args[0], "-test.run=TestFailParseFlagsUnknown")
+		cmd.Env = append(os.Environ(), "TEST_FAIL_PARSE_FLAGS_UNKNOWN="+arguments)
+		output, err := cmd.CombinedOutput()
+		assert.Contains(t, string(output), "unknown long flag '--bogus'", arguments)
+		assert.Contains(t, string(output), "usage: hierarchy", arguments)
+		if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != exitUsage {
+			t.Errorf("process with %q ran with err %v, want exit status %d.", arguments, err, exitUsage)
+		}
+	}
+}
+
+// TestFailParseFlagsJsonnetSandbox verifies that --jsonnet is rejected with --sandbox, since its imports are not sandboxed
+func TestFailParseFlagsJsonnetSandbox(t *testing.T) {
+	if os.Getenv("TEST_FAIL_PARSE_FLAGS_JSONNET_SANDBOX") == "1" {
+		os.Args = []string{"hierarchy", "--sandbox", "--jsonnet"}
+		parseFlags()
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailParseFlagsJsonnetSandbox")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_PARSE_FLAGS_JSONNET_SANDBOX=1")
+	output, err := cmd.CombinedOutput()
+	assert.Contains(t, string(output), "--jsonnet cannot be used with --sandbox")
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != exitUsage {
+		t.Errorf("process ran with err %v, want exit status %d.", err, exitUsage)
+	}
+}
+
+// TestJoinStreamValues verifies that only flags taking a value are joined with a separate '-'
+func TestJoinStreamValues(t *testing.T) {
+	application := kingpin.New("hierarchy", "")
+	application.Flag("output", "").Short('o').String()
+	application.Flag("dry-run", "").Bool()
+	application.Command("get", "").Flag("format", "").String()
+
+	assert.Equal(t, []string{"--output=-", "-o-", "--dry-run", "-", "get", "--format=-", "--", "--output", "-"},
+		joinStreamValues([]string{"--output", "-", "-o", "-", "--dry-run", "-", "get", "--format", "-", "--", "--output", "-"}, application.Model()))
+}
+
+// TestLogWriter verifies where log messages are written with --log-file
+func TestLogWriter(t *testing.T) {
+	cfg := cfgDefaults
+	logs, _, err := logWriter(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Stdout, logs)
+
+	cfg.logFile = "-"
+	logs, _, err = logWriter(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Stderr, logs)
+
+	cfg.logFile = ""
+	cfg.command = "pr-report"
+	logs, _, err = logWriter(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Stderr, logs)
+
+	for _, cfg := range []config{{dryRun: true}, {command: "explain"}} {
+		logs, _, err = logWriter(cfg)
+		assert.NoError(t, err)
+		assert.Equal(t, os.Stderr, logs)
+	}
+
+	cfg.logFile = filepath.Join(t.TempDir(), "hierarchy.log")
+	logs, closeLogs, err := logWriter(cfg)
+	assert.NoError(t, err)
+	setupLogging(logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	appLog.Info("Hierarchy")
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	assert.NoError(t, closeLogs())
+	content, err := os.ReadFile(cfg.logFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "msg=Hierarchy")
+
+	cfg.logFile = filepath.Join(t.TempDir(), "missing", "hierarchy.log")
+	_, _, err = logWriter(cfg)
+	assert.Error(t, err)
+}
+
 // TestFailHierarchyMissingEnvironmentVariable ensures that the application is correctly failing
 // If an environment variable specified in `hierarchy.lst` is not found.
 // It spawns a new process to determine the exit code of the application.
-// Anything other than a 1 is a problem
+// Anything other than exitVariable (5) is a problem
 // It uses the environment variable TEST_FAIL_EMPTY to signal the actual execution of the functionality
 // Hierarchy will always fail if an environment variable as part of the hierarchy is not found.
 // This is intentional, to prevent unexpected behaviors.
@@ -135,11 +438,11 @@ func TestFailHierarchyMissingEnvironmentVariable(t *testing.T) {
 	cmd.Env = append(os.Environ(), "TEST_FAIL_EMPTY=1")
 	output, err := cmd.CombinedOutput()
 	fmt.Printf("%s\n", output)
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitVariable {
 		fmt.Printf("Process correctly failed with %v\n", e)
 		return
 	}
-	t.Fatalf("process ran with err %v, want exit status 1.", err)
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitVariable)
 }
 
 // TestEnd2EndHierarchyEnvironmentVariablesSuccess runs through the full functionality end-to-end,
@@ -148,7 +451,7 @@ func TestFailHierarchyMissingEnvironmentVariable(t *testing.T) {
 func TestEnd2EndHierarchyEnvironmentVariablesSuccess(t *testing.T) {
 	cfg := cfgDefaults
 	cfg.basePath = "testdata/hierarchy-with-env"
-	cfg.outputFile = "output.yaml"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
 
 	// set the test environment variable
 	os.Setenv("JSON", "json")
@@ -159,11 +462,11 @@ func TestEnd2EndHierarchyEnvironmentVariablesSuccess(t *testing.T) {
 	// Merge files
 	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
 
-	expected, err := ioutil.ReadFile("testdata/hierarchy-with-env/result/expected.yaml")
+	expected, err := os.ReadFile("testdata/hierarchy-with-env/result/expected.yaml")
 	if err != nil {
 		t.Fatalf("Error reading file with expected test results: %v", err)
 	}
-	result, err := ioutil.ReadFile(cfg.outputFile)
+	result, err := os.ReadFile(cfg.outputFile)
 	if err != nil {
 		t.Fatalf("Error reading output file: %v", err)
 	}
@@ -178,6 +481,7 @@ func TestMissingHierarchySuccess(t *testing.T) {
 
 	cfg := cfgDefaults
 	cfg.basePath = "testdata/no-hierarchy"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
 
 	// process the hierarchy and get the list of include files
 	hierarchy := processHierarchy(cfg)
@@ -185,11 +489,11 @@ func TestMissingHierarchySuccess(t *testing.T) {
 	// Lets do the deed
 	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
 
-	expected, err := ioutil.ReadFile("testdata/no-hierarchy/result/expected.yaml")
+	expected, err := os.ReadFile("testdata/no-hierarchy/result/expected.yaml")
 	if err != nil {
 		t.Fatalf("Error reading file with expected test results: %v", err)
 	}
-	result, err := ioutil.ReadFile(cfg.outputFile)
+	result, err := os.ReadFile(cfg.outputFile)
 	if err != nil {
 		t.Fatalf("Error reading output file: %v", err)
 	}
@@ -215,18 +519,18 @@ func TestFailMissingHierarchy(t *testing.T) {
 	cmd.Env = append(os.Environ(), "TEST_FAIL_MISSING_HIERARCHY=1")
 	output, err := cmd.CombinedOutput()
 	fmt.Printf("%s\n", output)
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
 		fmt.Printf("Process correctly failed with %v\n", e)
 		return
 	}
-	t.Fatalf("process ran with err %v, want exit status 1.", err)
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
 
 }
 
 // TestFailContentMissingEnvironmentVariable ensures that the application is correctly failing
 // If an environment variable specified in the yaml content is not defined.
 // It spawns a new process to determine the exit code of the application.
-// Anything other than a 1 is a problem
+// Anything other than exitVariable (5) is a problem
 // It uses the environment variable TEST_FAIL_EMPTY to signal the actual execution of the functionality
 func TestFailContentMissingEnvironmentVariable(t *testing.T) {
 	if os.Getenv("TEST_FAIL_EMPTY") == "1" {
@@ -247,11 +551,11 @@ func TestFailContentMissingEnvironmentVariable(t *testing.T) {
 	cmd.Env = append(os.Environ(), "TEST_FAIL_EMPTY=1", "EXISTING_VARIABLE1=one", "EXISTING_VARIABLE2=two")
 	output, err := cmd.CombinedOutput()
 	fmt.Printf("%s\n", output)
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitVariable {
 		fmt.Printf("Process correctly failed with %v\n", e)
 		return
 	}
-	t.Fatalf("process ran with err %v, want exit status 1.", err)
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitVariable)
 }
 
 // TestContentMissingEnvironmentVariableSuccess runs through the full functionality end-to-end
@@ -261,6 +565,7 @@ func TestFailContentMissingEnvironmentVariable(t *testing.T) {
 func TestContentMissingEnvironmentVariableSuccess(t *testing.T) {
 	cfg := cfgDefaults
 	cfg.basePath = "testdata/content-with-env/"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
 
 	// process the hierarchy and get the list of include files
 	hierarchy := processHierarchy(cfg)
@@ -272,13 +577,857 @@ func TestContentMissingEnvironmentVariableSuccess(t *testing.T) {
 	// merge files in hierarchy
 	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, cfg.skipEnvVarContent, cfg.failMissingEnvVar)
 
-	expected, err := ioutil.ReadFile("testdata/content-with-env/result/expected.yaml")
+	expected, err := os.ReadFile("testdata/content-with-env/result/expected.yaml")
 	if err != nil {
 		t.Fatalf("Error reading file with expected test results: %v", err)
 	}
-	result, err := ioutil.ReadFile(cfg.outputFile)
+	result, err := os.ReadFile(cfg.outputFile)
 	if err != nil {
 		t.Fatalf("Error reading output file: %v", err)
 	}
 	assert.Equal(t, string(expected), string(result))
 }
+
+// TestReadPreviousOutput verifies that the content of an existing output file is returned for the diff
+// and that a missing output file is treated as empty
+func TestReadPreviousOutput(t *testing.T) {
+	assert.Equal(t, "", readPreviousOutput("testdata/does-not-exist.yaml"))
+
+	expected, err := os.ReadFile("testdata/test1/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	assert.Equal(t, string(expected), readPreviousOutput("testdata/test1/result/expected.yaml"))
+}
+
+// TestEnd2EndBestEffortSuccess runs through the full functionality end-to-end
+// It tests that unparsable files in a layer marked with '?' are skipped
+// It compares the generated final file with one stored in git
+func TestEnd2EndBestEffortSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/best-effort"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	// process the hierarchy and get the list of include files
+	hierarchy := processHierarchy(cfg)
+	assert.Equal(t, layer{path: "testdata/broken", bestEffort: true}, hierarchy[1])
+
+	// merge files in hierarchy
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/best-effort/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestFailParseErrorStrictLayer ensures that the application is correctly failing
+// if a file in a layer without the best-effort marker cannot be parsed.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitParse (3) is a problem
+func TestFailParseErrorStrictLayer(t *testing.T) {
+	if os.Getenv("TEST_FAIL_PARSE") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/strict-broken"
+
+		hierarchy := processHierarchy(cfg)
+		_, _ = mergeFiles(hierarchy, cfg.filterExtension)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailParseErrorStrictLayer")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_PARSE=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitParse {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitParse)
+}
+
+// TestListHierarchy verifies the merge order printed by `--dry-run`
+func TestListHierarchy(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/best-effort"
+
+	expected := `# testdata/default
+#   testdata/default/defaults.json
+#   testdata/default/defaults.yml
+# testdata/broken
+#   testdata/broken/broken.yaml
+#   testdata/broken/valid.yaml
+# testdata/best-effort
+#   testdata/best-effort/five.yaml
+`
+	var result bytes.Buffer
+	listHierarchy(&result, processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, expected, result.String())
+}
+
+// TestStripKeys verifies that metadata keys are removed at every level, including lists of maps
+func TestStripKeys(t *testing.T) {
+	data := map[string]interface{}{
+		"_comment": "top level",
+		"app": map[string]interface{}{
+			"x-hierarchy-owner": "team-a",
+			"name":              "demo",
+			"ports": []interface{}{
+				map[string]interface{}{"_comment": "http", "port": 80},
+			},
+		},
+	}
+	expected := map[string]interface{}{
+		"app": map[string]interface{}{
+			"name": "demo",
+			"ports": []interface{}{
+				map[string]interface{}{"port": 80},
+			},
+		},
+	}
+
+	stripKeys(data, regexp.MustCompile(`^(x-hierarchy-.*|_comment)$`))
+	assert.Equal(t, expected, data)
+}
+
+// TestEnd2EndTransformSuccess verifies that --strip-keys and the transforms run in order before the output is written
+func TestEnd2EndTransformSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.stripKeys = "^(json|jsondefault)$"
+	cfg.transforms = []string{"relocate=test1.test1B:test4", "redact=test2.**"}
+
+	runMerge(cfg)
+
+	content, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, `test1:
+    test1A:
+        one: 1
+        two: 2
+        three: 3
+    test1C: 4
+test2:
+    list2A: '[REDACTED]'
+    test2A: '[REDACTED]'
+test3: this better be there!
+test4: one bee
+`, string(content))
+}
+
+// TestParseHierarchyLine verifies the handling of comments, quotes, and the best-effort marker in the hierarchy file
+func TestParseHierarchyLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		entry      string
+		bestEffort bool
+	}{
+		{"../default\n", "../default", false},
+		{"  ../yaml # different yaml files\n", "../yaml", false},
+		{"# only a comment ${NOT_A_VARIABLE}\n", "", false},
+		{"../json#no space before the comment", "../json", false},
+		{`"../with space#1" # quoted`, "../with space#1", false},
+		{`"  padded  "`, "  padded  ", false},
+		{`../mixed" quoted #"/path`, "../mixed quoted #/path", false},
+		{"? ../generated # best-effort", "../generated", true},
+		{`?"?literal"`, "?literal", true},
+		{"\t\n", "", false},
+		{"../windows\r\n", "../windows", false},
+		{`my\ dir/with\ spaces`, "my dir/with spaces", false},
+		{`../escaped\#hash # comment`, "../escaped#hash", false},
+		{`../it\'s\ quoted`, "../it's quoted", false},
+		{`\?not-best-effort`, "?not-best-effort", false},
+		{`"say \"hi\" \\o/"`, `say "hi" \o/`, false},
+		{`'single \"quotes\" #1'`, `single \"quotes\" #1`, false},
+		{`trailing\ `, "trailing ", false},
+		{`..\defaults\windows`, `..\defaults\windows`, false},
+	}
+	for _, test := range tests {
+		entry, prefix, bestEffort, err := parseHierarchyLine(test.line)
+		assert.NoError(t, err, test.line)
+		assert.Equal(t, test.entry, entry, test.line)
+		assert.Empty(t, prefix, test.line)
+		assert.Equal(t, test.bestEffort, bestEffort, test.line)
+	}
+
+	_, _, _, err := parseHierarchyLine(`"../unterminated # comment`)
+	assert.Error(t, err)
+
+	_, _, _, err = parseHierarchyLine(`'../unterminated`)
+	assert.Error(t, err)
+
+	_, _, _, err = parseHierarchyLine("../dangling\\\n")
+	assert.Error(t, err)
+}
+
+// TestParseHierarchyLinePrefix verifies that the key prefix follows '=>' outside of quotes
+func TestParseHierarchyLinePrefix(t *testing.T) {
+	tests := []struct {
+		line   string
+		entry  string
+		prefix string
+	}{
+		{"services/payments => payments.\n", "services/payments", "payments"},
+		{"?services/orders=>shop.orders # best-effort", "services/orders", "shop.orders"},
+		{`"odd=>name" => odd`, "odd=>name", "odd"},
+		{"services/payments # => payments.", "services/payments", ""},
+	}
+	for _, test := range tests {
+		entry, prefix, _, err := parseHierarchyLine(test.line)
+		assert.NoError(t, err, test.line)
+		assert.Equal(t, test.entry, entry, test.line)
+		assert.Equal(t, test.prefix, prefix, test.line)
+	}
+
+	for _, line := range []string{"services/payments =>", "services/payments => .", "services/payments => a..b", "services/payments => a. b"} {
+		_, _, _, err := parseHierarchyLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+// TestEnd2EndQuotedHierarchySuccess runs through the full functionality end-to-end
+// It tests a quoted hierarchy entry containing spaces and '#'
+// It compares the generated final file with one stored in git
+func TestEnd2EndQuotedHierarchySuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/quoted"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	hierarchy := processHierarchy(cfg)
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/quoted/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestValidateOutput validates the merged result of testdata/test1 against a matching and a non-matching schema
+func TestValidateOutput(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	output := renderOutput(data, false, false)
+
+	violations, err := validateOutput("testdata/schema/valid.json", output)
+	assert.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = validateOutput("testdata/schema/invalid.json", output)
+	assert.NoError(t, err)
+	expected := []schema.Error{
+		{Path: ".", Message: "missing required property \"test4\""},
+		{Path: "test1.test1A.three", Message: "value 3 is greater than maximum 2"},
+		{Path: "test1.test1B", Message: "value one bee is not one of [two bee]"},
+		{Path: "test3", Message: "expected type integer, got string"},
+	}
+	assert.Equal(t, expected, violations)
+
+	_, err = validateOutput("testdata/schema/missing.json", output)
+	assert.Error(t, err)
+}
+
+// TestEnd2EndLayerSchemaSuccess runs through the full functionality end-to-end
+// It tests a hierarchy directory with a .schema.json, which must not be merged itself
+// It compares the generated final file with one stored in git
+func TestEnd2EndLayerSchemaSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/layer-schema"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	hierarchy := processHierarchy(cfg)
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/layer-schema/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestFailLayerSchema ensures that the application is correctly failing
+// if a file does not match the .schema.json of its hierarchy directory.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailLayerSchema(t *testing.T) {
+	if os.Getenv("TEST_FAIL_LAYER_SCHEMA") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/layer-schema-fail"
+
+		hierarchy := processHierarchy(cfg)
+		_, _ = mergeFiles(hierarchy, cfg.filterExtension)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailLayerSchema")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_LAYER_SCHEMA=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
+
+// TestProcessHierarchyAbsolutePath verifies that absolute paths in the hierarchy are not joined with the base path
+func TestProcessHierarchyAbsolutePath(t *testing.T) {
+	absPath, err := filepath.Abs("testdata/default")
+	if err != nil {
+		t.Fatalf("Error resolving path: %v", err)
+	}
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	err = os.WriteFile(filepath.Join(cfg.basePath, cfg.hierarchyFile), []byte(absPath+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("Error writing hierarchy file: %v", err)
+	}
+
+	assert.Equal(t, []layer{{path: absPath}}, processHierarchy(cfg))
+}
+
+// TestIsWithinBase verifies the detection of directories outside of the base path
+func TestIsWithinBase(t *testing.T) {
+	assert.True(t, isWithinBase("testdata/test1", "testdata/test1"))
+	assert.True(t, isWithinBase("testdata/", "testdata/test1/result"))
+	assert.True(t, isWithinBase("testdata/test1", "testdata/test1/..data"))
+	assert.False(t, isWithinBase("testdata/test1", "testdata/default"))
+	assert.False(t, isWithinBase("testdata/test1", "testdata/test1/../.."))
+	assert.False(t, isWithinBase("testdata/test1", "/etc"))
+}
+
+// TestNormalizeEntryPath verifies that slashes are path separators on every platform
+func TestNormalizeEntryPath(t *testing.T) {
+	tests := map[string]string{
+		"../defaults/linux": filepath.Join("..", "defaults", "linux"),
+		"envs/dev/app":      filepath.Join("envs", "dev", "app"),
+		"../defaults":       filepath.Join("..", "defaults"),
+	}
+	for entry, expected := range tests {
+		path, err := normalizeEntryPath(entry)
+		assert.NoError(t, err, entry)
+		assert.Equal(t, expected, path, entry)
+	}
+}
+
+// TestProcessHierarchyCRLF verifies that a hierarchy file written on Windows, with CRLF line endings,
+// is read the same on every platform
+func TestProcessHierarchyCRLF(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	for _, dir := range []string{"defaults", filepath.Join("envs", "dev")} {
+		if err := os.MkdirAll(filepath.Join(cfg.basePath, dir), 0700); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+	}
+	content := "#! requires >=0.0.0\r\ndefaults\r\nenvs/dev # development\r\n"
+	if err := os.WriteFile(filepath.Join(cfg.basePath, cfg.hierarchyFile), []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing hierarchy file: %v", err)
+	}
+
+	assert.Equal(t, []layer{
+		{path: filepath.Join(cfg.basePath, "defaults")},
+		{path: filepath.Join(cfg.basePath, "envs", "dev")},
+	}, processHierarchy(cfg))
+}
+
+// TestProcessHierarchyBackslash verifies that a backslash is part of a directory name on Linux and macOS,
+// whether it is kept as is, escaped, or quoted
+func TestProcessHierarchyBackslash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("backslashes are path separators on Windows")
+	}
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	if err := os.Mkdir(filepath.Join(cfg.basePath, `dev\app`), 0700); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	content := "dev\\app\ndev\\\\app\n'dev\\app'\n"
+	if err := os.WriteFile(filepath.Join(cfg.basePath, cfg.hierarchyFile), []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing hierarchy file: %v", err)
+	}
+
+	path := filepath.Join(cfg.basePath, `dev\app`)
+	assert.Equal(t, []layer{{path: path}, {path: path}, {path: path}}, processHierarchy(cfg))
+}
+
+// TestParseHierarchyDirective verifies that only lines starting with "#!" are directives
+func TestParseHierarchyDirective(t *testing.T) {
+	name, argument, ok := parseHierarchyDirective("  #! requires >=1.4.0, <2 \n")
+	assert.True(t, ok)
+	assert.Equal(t, "requires", name)
+	assert.Equal(t, ">=1.4.0, <2", argument)
+
+	for _, line := range []string{"# requires >=1.4.0\n", "../defaults #! requires >=1.4.0\n", "#!\n"} {
+		_, _, ok := parseHierarchyDirective(line)
+		assert.False(t, ok, line)
+	}
+}
+
+// TestFailRequiresVersion ensures that the application is correctly failing
+// if the hierarchy file requires a different version of hierarchy.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailRequiresVersion(t *testing.T) {
+	if os.Getenv("TEST_FAIL_REQUIRES") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/requires"
+
+		processHierarchy(cfg)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailRequiresVersion")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_REQUIRES=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
+
+// TestFailRestrictToBase ensures that the application is correctly failing
+// if `--restrict-to-base` is set and the hierarchy uses '..' to leave the base path.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitPath (4) is a problem
+func TestFailRestrictToBase(t *testing.T) {
+	if os.Getenv("TEST_FAIL_RESTRICT") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/test1"
+		cfg.restrictToBase = true
+
+		processHierarchy(cfg)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailRestrictToBase")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_RESTRICT=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
+
+// TestFailRestrictToBaseVariable ensures that the application is correctly failing
+// if `--restrict-to-base` is set and an environment variable in the hierarchy expands to a path outside of the base path.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitPath (4) is a problem
+func TestFailRestrictToBaseVariable(t *testing.T) {
+	if os.Getenv("TEST_FAIL_RESTRICT_VARIABLE") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = t.TempDir()
+		cfg.restrictToBase = true
+		err := os.WriteFile(filepath.Join(cfg.basePath, cfg.hierarchyFile), []byte("${HIERARCHY_TEST_DIR}\n"), 0600)
+		if err != nil {
+			t.Fatalf("Error writing hierarchy file: %v", err)
+		}
+
+		processHierarchy(cfg)
+
+		return
+	}
+
+	absPath, err := filepath.Abs("testdata/default")
+	if err != nil {
+		t.Fatalf("Error resolving path: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailRestrictToBaseVariable")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_RESTRICT_VARIABLE=1", "HIERARCHY_TEST_DIR="+absPath)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
+
+// TestFailSandboxSymlink ensures that the application is correctly failing
+// if `--sandbox` is set and a file in the hierarchy is a symlink pointing outside of the base path.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitPath (4) is a problem
+func TestFailSandboxSymlink(t *testing.T) {
+	if os.Getenv("TEST_FAIL_SANDBOX") != "" {
+		basePath := os.Getenv("TEST_FAIL_SANDBOX")
+		root, err := sandbox.Open(basePath)
+		if err != nil {
+			t.Fatalf("Error opening sandbox: %v", err)
+		}
+		inputFS = fsutil.Sandbox(root)
+
+		mergeFiles([]layer{{path: filepath.Join(basePath, "layer")}}, defaultFileFilter)
+
+		return
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	basePath := t.TempDir()
+	secret, err := filepath.Abs("testdata/default/defaults.yml")
+	if err != nil {
+		t.Fatalf("Error resolving path: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(basePath, "layer"), 0700); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := os.Symlink(secret, filepath.Join(basePath, "layer", "linked.yaml")); err != nil {
+		t.Fatalf("Error creating symlink: %v", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailSandboxSymlink")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_SANDBOX="+basePath)
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
+
+// TestLint verifies that the lint command reports all problems of the hierarchy and its files
+func TestLint(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/lint"
+
+	issues := []string{}
+	for _, issue := range lint(cfg) {
+		issues = append(issues, issue.String())
+	}
+	assert.Equal(t, []string{
+		"testdata/lint/hierarchy.lst:3: error: missing closing quote",
+		"testdata/lint/hierarchy.lst:4: error: directory testdata/lint/missing not found",
+		"testdata/lint/hierarchy.lst:7: error: hierarchy >=99.0.0 is required, this is " + version.Version,
+		"testdata/lint/hierarchy.lst:8: warning: unknown directive frobnicate",
+		"testdata/lint/hierarchy.lst:9: error: unknown compatibility level \"0\"",
+		"testdata/lint/defaults/duplicate.yaml:4: error: key app.replicas already defined at line 2",
+		"testdata/lint/defaults/duplicate.yaml:7: error: key ports[0].name already defined at line 6",
+		"testdata/lint/optional/broken.yaml:1: warning: did not find expected ',' or ']'\n> 1 | app: [unclosed",
+		"testdata/lint/list.json:1: error: cannot unmarshal !!seq into map[string]interface {}\n> 1 | [1, 2]",
+		"testdata/lint/unused: warning: directory is not in the hierarchy",
+	}, issues)
+}
+
+// TestLintRemoteSources verifies that remote sources are not fetched or looked up as directories,
+// only the syntax of their entries is checked
+func TestLintRemoteSources(t *testing.T) {
+	cfg := writeRemoteHierarchy(t,
+		"https://example.invalid/config.yaml",
+		"git::https://example.invalid/config.git//base?ref=v1",
+		"oci://example.invalid/config:v1",
+		"consul://config/app",
+		"s3://bucket/config",
+		"git::https://example.invalid/config.git?branch=main",
+		"oci://example.invalid",
+		"https:///config.yaml",
+	)
+
+	issues := []string{}
+	for _, issue := range lint(cfg) {
+		issues = append(issues, issue.String())
+	}
+	hierarchyFile := filepath.Join(cfg.basePath, cfg.hierarchyFile)
+	assert.Equal(t, []string{
+		hierarchyFile + `:6: error: unknown parameter "branch" of git source git::https://example.invalid/config.git?branch=main`,
+		hierarchyFile + ":7: error: invalid OCI artifact oci://example.invalid, expected oci://<registry>/<repository>[:<tag>|@<digest>]",
+		hierarchyFile + ":8: error: no host in URL https:///config.yaml",
+	}, issues)
+}
+
+// TestFindDuplicateKeys verifies that repeated merge keys are not reported as duplicates
+func TestFindDuplicateKeys(t *testing.T) {
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte("a: &a {x: 1}\nb: &b {y: 1}\nc:\n  <<: *a\n  <<: *b\n"), &document)
+	assert.NoError(t, err)
+	assert.Empty(t, findDuplicateKeys(&document, ""))
+}
+
+// TestFailLint ensures that the application is correctly failing
+// if the lint command finds an error.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailLint(t *testing.T) {
+	if os.Getenv("TEST_FAIL_LINT") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/lint"
+
+		runLint(cfg, io.Discard)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailLint")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_LINT=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
+
+// TestUnmarshalInput verifies that duplicate keys are reported with the file, the key path and both lines
+func TestUnmarshalInput(t *testing.T) {
+	data, err := unmarshalInput("empty.yaml", []byte(""))
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	data, err = unmarshalInput("valid.yaml", []byte("a:\n  b: 1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": 1}}, data)
+
+	_, err = unmarshalInput("dup.yaml", []byte("a:\n  b: 1\n  c: 2\n  b: 3\n"))
+	assert.EqualError(t, err, "dup.yaml:4: key a.b already defined at line 2")
+
+	_, err = unmarshalInput("broken.yaml", []byte("a:\n  b: 1\n c: 2\nd: 3\ne: 4\nf: 5\n"))
+	assert.EqualError(t, err, "broken.yaml:2: did not find expected key")
+	assert.Equal(t, "  1 | a:\n> 2 |   b: 1\n  3 |  c: 2\n  4 | d: 3", err.(*parseError).context)
+
+	_, err = unmarshalInput("list.yaml", []byte("- 1\n"))
+	assert.EqualError(t, err, "list.yaml:1: cannot unmarshal !!seq into map[string]interface {}")
+
+	_, err = unmarshalInput("token.yaml", []byte("a: @x\n"))
+	assert.EqualError(t, err, "token.yaml: found character that cannot start any token")
+	assert.Empty(t, err.(*parseError).context)
+}
+
+// TestMergeFilesInMemory verifies that the hierarchy and its files are read through inputFS
+func TestMergeFilesInMemory(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst":     {Data: []byte("../defaults\n./\n")},
+		"base/app.yaml":          {Data: []byte("app:\n  replicas: 2\n")},
+		"base/README.md":         {Data: []byte("not merged\n")},
+		"defaults/defaults.yaml": {Data: []byte("app:\n  replicas: 1\n  image: demo\n")},
+	})
+	defer func() { inputFS = fsutil.OS }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+	hierarchy := processHierarchy(cfg)
+	assert.Equal(t, []layer{{path: "defaults"}, {path: "base"}}, hierarchy)
+
+	data, _ := mergeFiles(hierarchy, defaultFileFilter)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"replicas": 2, "image": "demo"},
+	}, data)
+}
+
+// TestCompatLevel1 verifies the frozen merge semantics of compatibility level 1:
+// later layers override keys even with null or empty values, and replace lists as a whole
+func TestCompatLevel1(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst":     {Data: []byte("#! compat 1\n../defaults\n./\n")},
+		"base/app.yaml":          {Data: []byte("app:\n  image: null\n  replicas: 0\n  ports: [8443]\n")},
+		"defaults/defaults.yaml": {Data: []byte("app:\n  image: demo\n  replicas: 2\n  ports: [80, 443]\n")},
+	})
+	defer func() { inputFS = fsutil.OS; compat = "" }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+	data, _ := mergeFiles(processHierarchy(cfg), defaultFileFilter)
+	assert.Equal(t, "1", compat)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"image": nil, "replicas": 0, "ports": []interface{}{8443}},
+	}, data)
+}
+
+// TestCompatOverride verifies that --compat takes precedence over the level of the hierarchy file
+func TestCompatOverride(t *testing.T) {
+	defer func() { compat = "" }()
+
+	checkHierarchyDirective("hierarchy.lst", "compat", "1")
+	assert.Equal(t, "1", compat)
+
+	compat = "override"
+	checkHierarchyDirective("hierarchy.lst", "compat", "1")
+	assert.Equal(t, "override", compat)
+}
+
+// TestDeterministicOutput merges the same hierarchies repeatedly and verifies that the output,
+// the annotated output, the provenance manifest, and the log messages are identical byte for byte
+func TestDeterministicOutput(t *testing.T) {
+	timestamp := regexp.MustCompile(`time=\S+ | duration=\S+`)
+	defer setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+
+	run := func(basePath string) string {
+		var logs bytes.Buffer
+		setupLogging(&logs, logging.FormatText, logging.Levels{Default: logging.LevelTrace})
+		cfg := cfgDefaults
+		cfg.basePath = basePath
+
+		data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+		stripKeys(data, regexp.MustCompile("^(json|test1[BC]|cpu|memoryGi)"))
+		sources.prune(data)
+		output := renderOutput(data, true, false)
+		node, err := sources.annotate(data, true)
+		assert.NoError(t, err)
+		manifestPath := filepath.Join(t.TempDir(), "provenance.json")
+		assert.NoError(t, sources.writeManifest(manifestPath, cfg.outputFile, output))
+		manifest, err := os.ReadFile(manifestPath)
+		assert.NoError(t, err)
+
+		return output + renderOutput(node, true, false) + string(manifest) + timestamp.ReplaceAllString(logs.String(), "")
+	}
+
+	for _, basePath := range []string{"testdata/test1", "testdata/list-directives", "testdata/aggregate"} {
+		first := run(basePath)
+		for i := 0; i < 20; i++ {
+			assert.Equal(t, first, run(basePath), basePath)
+		}
+	}
+}
+
+// TestFailKeepGoing ensures that `--keep-going` reports all failures of the hierarchy and its files
+// before exiting.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitError (1), for failures of different classes, is a problem
+func TestFailKeepGoing(t *testing.T) {
+	if os.Getenv("TEST_FAIL_KEEP_GOING") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/keep-going"
+		cfg.failMissingPath = true
+		keepGoing = true
+
+		mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+		exitOnFailures()
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailKeepGoing")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_KEEP_GOING=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	for _, message := range []string{
+		`msg="Hierarchy directory not found" component=resolver path=testdata/keep-going/missing`,
+		`msg="Environment variable not defined" component=substitution name=HIERARCHY_KEEP_GOING_UNDEFINED`,
+		`msg="Invalid line in hierarchy file"`,
+		`msg="Invalid input file" component=merger path=testdata/broken/broken.yaml`,
+		`msg="Importing file" component=merger path=testdata/default/defaults.yml`,
+		`msg="Stopping after all failures were reported" count=5`,
+	} {
+		assert.Contains(t, string(output), message)
+	}
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitError {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitError)
+}
+
+// TestEnd2EndSortKeys verifies that --sort-keys writes the keys in alphabetical order at every level
+func TestEnd2EndSortKeys(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/starlark"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    name: demo\n    replicas: 3\n    debug: true\n", string(content))
+
+	cfg.sortKeys = true
+	runMerge(cfg)
+	content, err = os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    debug: true\n    name: demo\n    replicas: 3\n", string(content))
+}
+
+// TestEnd2EndScalars verifies that numbers and booleans are written as they were in the input files
+func TestEnd2EndScalars(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/scalars"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    commit: 12345e7\n    version: 1.20\n    zip: 02134\n    id: 123456789012345678901234567890\n    debug: False\n    ratio: 0.50\n", string(content))
+}
+
+// TestEnd2EndKeyPrefix verifies that the files of a hierarchy entry with a key prefix are merged under it
+func TestEnd2EndKeyPrefix(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/prefix"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "payments:\n    currency: USD\n    retries: 3\norders:\n    queue: orders\n", string(content))
+
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	if assert.Len(t, sources.expiries, 1) {
+		assert.Equal(t, "payments.retries", sources.expiries[0].key, "expiry directives are below the prefix")
+	}
+}
+
+// TestEnd2EndNestByDirectory verifies that the files of every directory are merged under the name of the directory,
+// unless the entry has a key prefix
+func TestEnd2EndNestByDirectory(t *testing.T) {
+	defer func() { nestByDirectory = false }()
+	nestByDirectory = true
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/nest"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev:\n    replicas: 1\n    debug: true\nprod:\n    replicas: 3\n    host: db.prod\ncommon:\n    image: app\n", string(content))
+}
+
+// TestEnd2EndNestByFile verifies that every file is merged under its name, below the name of its directory
+func TestEnd2EndNestByFile(t *testing.T) {
+	defer func() { nestByDirectory, nestByFile = false, false }()
+	nestByFile = true
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/nest"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n    replicas: 3\n    debug: true\ndatabase:\n    host: db.prod\ncommon:\n    app:\n        image: app\n", string(content))
+
+	nestByDirectory = true
+	runMerge(cfg)
+	content, err = os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "dev:\n    app:\n        replicas: 1\n        debug: true\nprod:\n    app:\n        replicas: 3\n    database:\n        host: db.prod\ncommon:\n    app:\n        image: app\n", string(content))
+}
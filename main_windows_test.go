@@ -0,0 +1,59 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNormalizeEntryPathBackslash verifies that backslashes and slashes are path separators on Windows
+func TestNormalizeEntryPathBackslash(t *testing.T) {
+	tests := map[string]string{
+		`..\defaults\windows`: `..\defaults\windows`,
+		`envs/dev\app`:        `envs\dev\app`,
+	}
+	for entry, expected := range tests {
+		path, err := normalizeEntryPath(entry)
+		assert.NoError(t, err, entry)
+		assert.Equal(t, expected, path, entry)
+	}
+}
+
+// TestNormalizeEntryPathDrive verifies the handling of drive letters on Windows
+func TestNormalizeEntryPathDrive(t *testing.T) {
+	tests := map[string]string{
+		`C:\config\defaults`: `C:\config\defaults`,
+		`D:/config/defaults`: `D:\config\defaults`,
+		`\\server\share\app`: `\\server\share\app`,
+	}
+	for entry, expected := range tests {
+		path, err := normalizeEntryPath(entry)
+		assert.NoError(t, err, entry)
+		assert.Equal(t, expected, path, entry)
+	}
+
+	_, err := normalizeEntryPath(`C:config`)
+	assert.EqualError(t, err, "path C:config is relative to the current directory of drive C:")
+}
+
+// TestIsWithinBaseDrive verifies that a directory on another drive is outside of the base path
+func TestIsWithinBaseDrive(t *testing.T) {
+	assert.True(t, isWithinBase(`C:\config`, `C:\config\defaults`))
+	assert.False(t, isWithinBase(`C:\config`, `D:\config\defaults`))
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/metrics"
+)
+
+// mergeStatsEnv names the file a merge child writes its statistics to, see writeMergeStats
+const mergeStatsEnv = "HIERARCHY_MERGE_STATS"
+
+// mergeStats are counted while merging, so the parent of a merge child can export them as metrics
+type mergeStats struct {
+	Files                int `json:"files"`
+	SubstitutionFailures int `json:"substitutionFailures"`
+	Secrets              int `json:"secrets"`
+	// Diff is the diff of the output file with the secrets redacted, sent to the webhook if secrets were resolved
+	Diff string `json:"diff,omitempty"`
+}
+
+// stats counts the merged files, undefined environment variables, and resolved secrets of this process
+var stats mergeStats
+
+// mergeMetrics are the metrics of the merges run by --daemon, --watch, and serve
+type mergeMetrics struct {
+	registry             *metrics.Registry
+	merges               *metrics.Counter
+	failures             *metrics.Counter
+	duration             *metrics.Histogram
+	files                *metrics.Counter
+	substitutionFailures *metrics.Counter
+	lastSuccess          *metrics.Gauge
+}
+
+// childMetrics are exposed at /metrics of serve, and of --metrics.listen with --daemon or --watch
+var childMetrics = newMergeMetrics()
+
+func newMergeMetrics() *mergeMetrics {
+	registry := metrics.NewRegistry()
+	return &mergeMetrics{
+		registry:             registry,
+		merges:               registry.Counter("hierarchy_merges_total", "Merges by result, success or failure.", "result"),
+		failures:             registry.Counter("hierarchy_merge_failures_total", "Failed merges by exit code.", "exit_code"),
+		duration:             registry.Histogram("hierarchy_merge_duration_seconds", "Duration of merges.", metrics.DefaultBuckets),
+		files:                registry.Counter("hierarchy_files_merged_total", "Files merged by successful merges."),
+		substitutionFailures: registry.Counter("hierarchy_substitution_failures_total", "Environment variables that were not defined."),
+		lastSuccess:          registry.Gauge("hierarchy_last_success_timestamp_seconds", "Unix time of the last successful merge."),
+	}
+}
+
+// run runs a merge child, records its result, duration, and statistics, and returns the statistics of a successful merge
+func (m *mergeMetrics) run(cmd *exec.Cmd) (mergeStats, error) {
+	var childStats mergeStats
+	statsFile, err := os.CreateTemp("", "hierarchy-stats-")
+	if err != nil {
+		return childStats, err
+	}
+	statsFile.Close()
+	defer os.Remove(statsFile.Name())
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, mergeStatsEnv+"="+statsFile.Name())
+
+	start := time.Now()
+	err = cmd.Run()
+	m.duration.Observe(time.Since(start).Seconds())
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		m.merges.Inc("failure")
+		m.failures.Inc(strconv.Itoa(exitErr.ExitCode()))
+		// The merge stops at an undefined variable with --fail.missingvariable
+		if exitErr.ExitCode() == exitVariable {
+			m.substitutionFailures.Inc()
+		}
+		return childStats, err
+	}
+	if err != nil {
+		return childStats, err
+	}
+	m.merges.Inc("success")
+	m.lastSuccess.Set(float64(time.Now().UnixNano()) / 1e9)
+	if content, err := os.ReadFile(statsFile.Name()); err == nil && json.Unmarshal(content, &childStats) == nil {
+		m.files.Add(float64(childStats.Files))
+		m.substitutionFailures.Add(float64(childStats.SubstitutionFailures))
+	}
+	return childStats, nil
+}
+
+// writeMergeStats writes the statistics of a merge child to the file named by mergeStatsEnv, if it is set
+func writeMergeStats() {
+	file := os.Getenv(mergeStatsEnv)
+	if len(file) == 0 {
+		return
+	}
+	content, err := json.Marshal(stats)
+	if err == nil {
+		err = os.WriteFile(filepath.Clean(file), content, 0600)
+	}
+	if err != nil {
+		appLog.Warn("Cannot write merge statistics", "path", file, "error", err)
+	}
+}
+
+// serveMetrics serves the metrics of the merges at /metrics until ctx is done.
+// Metrics are not essential for merging, so a failure is only logged.
+func serveMetrics(ctx context.Context, address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", childMetrics.registry)
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	appLog.Info("Serving metrics", "address", address)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		appLog.Warn("Cannot serve metrics", "address", address, "error", err)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMergeMetrics verifies that the results and statistics of merge children are recorded
+func TestMergeMetrics(t *testing.T) {
+	merge := fakeBinary(t, "merge", "[ -n \"$FAIL\" ] && exit \"$FAIL\"\nprintf '{\"files\":3,\"substitutionFailures\":1,\"secrets\":2}' > \"$"+mergeStatsEnv+"\"\n")
+
+	m := newMergeMetrics()
+	childStats, err := m.run(exec.Command(merge))
+	assert.NoError(t, err)
+	assert.Equal(t, mergeStats{Files: 3, SubstitutionFailures: 1, Secrets: 2}, childStats)
+	_, err = m.run(exec.Command(merge))
+	assert.NoError(t, err)
+	failing := exec.Command(merge)
+	failing.Env = append(os.Environ(), "FAIL=5")
+	_, err = m.run(failing)
+	assert.Error(t, err)
+
+	var b strings.Builder
+	assert.NoError(t, m.registry.WriteText(&b))
+	for _, line := range []string{
+		`hierarchy_merges_total{result="failure"} 1`,
+		`hierarchy_merges_total{result="success"} 2`,
+		`hierarchy_merge_failures_total{exit_code="5"} 1`,
+		`hierarchy_merge_duration_seconds_count 3`,
+		`hierarchy_files_merged_total 6`,
+		`hierarchy_substitution_failures_total 3`,
+	} {
+		assert.Contains(t, b.String(), line+"\n")
+	}
+	assert.Contains(t, b.String(), "hierarchy_last_success_timestamp_seconds 1.")
+}
+
+// TestWriteMergeStats verifies that a merge child writes its statistics to the file named by the parent
+func TestWriteMergeStats(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "stats.json")
+	t.Setenv(mergeStatsEnv, file)
+	stats = mergeStats{Files: 2}
+	defer func() { stats = mergeStats{} }()
+
+	writeMergeStats()
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"files":2,"substitutionFailures":0,"secrets":0}`, string(content))
+}
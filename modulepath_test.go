@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// badWindowsNames are the file names reserved on Windows, which module zips reject
+var badWindowsNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// checkFilePath checks a slash-separated file path with the rules of module.CheckFilePath of golang.org/x/mod,
+// which the go command applies to every file of a module zip
+func checkFilePath(path string) error {
+	switch {
+	case !utf8.ValidString(path):
+		return fmt.Errorf("%q: invalid UTF-8", path)
+	case path == "":
+		return fmt.Errorf("empty path")
+	case strings.Contains(path, "//"):
+		return fmt.Errorf("%q: double slash", path)
+	case strings.HasSuffix(path, "/"):
+		return fmt.Errorf("%q: trailing slash", path)
+	}
+	for _, element := range strings.Split(path, "/") {
+		if strings.Count(element, ".") == len(element) {
+			return fmt.Errorf("%q: invalid path element %q", path, element)
+		}
+		if strings.HasSuffix(element, ".") {
+			return fmt.Errorf("%q: trailing dot in path element", path)
+		}
+		for _, r := range element {
+			allowed := unicode.IsLetter(r)
+			if r < utf8.RuneSelf {
+				allowed = '0' <= r && r <= '9' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || strings.ContainsRune("!#$%&()+,-.=@[]^_{}~ ", r)
+			}
+			if !allowed {
+				return fmt.Errorf("%q: invalid char %q", path, r)
+			}
+		}
+		short, _, _ := strings.Cut(element, ".")
+		for _, bad := range badWindowsNames {
+			if strings.EqualFold(bad, short) {
+				return fmt.Errorf("%q: %q disallowed as path element component on Windows", path, short)
+			}
+		}
+	}
+	return nil
+}
+
+// TestCheckFilePath verifies that file names module zips reject are found
+func TestCheckFilePath(t *testing.T) {
+	for _, path := range []string{"testdata/with space#1/five.yaml", "testdata/with special#chars/six.yaml", "testdata/.order"} {
+		assert.NoError(t, checkFilePath(path), path)
+	}
+	for _, path := range []string{"testdata/with 'special' chars/six.yaml", "testdata/a:b", `testdata/a\b`, "testdata/aux.yaml", "testdata/trailing.", "testdata//double", "testdata/../up"} {
+		assert.Error(t, checkFilePath(path), path)
+	}
+}
+
+// TestTestdataFilePaths verifies that every file in testdata can be part of a module zip,
+// otherwise 'go install' and module proxies cannot download a tagged version
+func TestTestdataFilePaths(t *testing.T) {
+	output, err := exec.Command("git", "ls-files", "-z", "testdata").Output()
+	if err != nil {
+		t.Skip("git ls-files is not available:", err)
+	}
+	for _, path := range strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00") {
+		assert.NoError(t, checkFilePath(path))
+	}
+}
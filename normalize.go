@@ -0,0 +1,252 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/transform"
+)
+
+// normalizeDirectivesKey is the top-level key holding normalization directives.
+// It maps dot-separated key path globs to the kind of value found there, e.g.
+//
+//	x-hierarchy-normalize:
+//	  "**.timeout": duration
+//	  app.cache.size: size
+//
+// Dates are normalized to RFC 3339, durations to seconds, and sizes to bytes.
+const normalizeDirectivesKey = "x-hierarchy-normalize"
+
+// normalizers parse a value of a kind and return it in its canonical form
+var normalizers = map[string]func(value interface{}) (interface{}, error){
+	"date":     normalizeDate,
+	"duration": normalizeDuration,
+	"size":     normalizeSize,
+}
+
+// dateLayouts are the accepted date formats. Dates with dots put the day first, dates with slashes the month.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2 January 2006",
+}
+
+// durationUnits are the seconds of a duration unit
+var durationUnits = map[string]float64{
+	"ns": 1e-9, "us": 1e-6, "µs": 1e-6, "ms": 1e-3,
+	"s": 1, "sec": 1, "secs": 1, "second": 1, "seconds": 1,
+	"m": 60, "min": 60, "mins": 60, "minute": 60, "minutes": 60,
+	"h": 3600, "hr": 3600, "hrs": 3600, "hour": 3600, "hours": 3600,
+	"d": 86400, "day": 86400, "days": 86400,
+	"w": 604800, "week": 604800, "weeks": 604800,
+}
+
+// sizeUnits are the bytes of a size unit, K, M, G, T, and P are decimal, Ki, Mi, Gi, Ti, and Pi are binary
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"k": 1e3, "kb": 1e3, "m": 1e6, "mb": 1e6, "g": 1e9, "gb": 1e9, "t": 1e12, "tb": 1e12, "p": 1e15, "pb": 1e15,
+	"ki": 1 << 10, "kib": 1 << 10, "mi": 1 << 20, "mib": 1 << 20, "gi": 1 << 30, "gib": 1 << 30,
+	"ti": 1 << 40, "tib": 1 << 40, "pi": 1 << 50, "pib": 1 << 50,
+}
+
+// durationPartPattern matches a number followed by a unit, e.g. "1,5 h" or "30m"
+var durationPartPattern = regexp.MustCompile(`^\s*(\d+(?:[.,]\d+)?)\s*([a-zµ]+)`)
+
+// isoDurationPattern matches ISO 8601 durations like P1DT2H30M
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+(?:[.,]\d+)?)W)?(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// sizePattern matches a number with an optional unit, e.g. "1,5 GB" or "512Mi"
+var sizePattern = regexp.MustCompile(`^\s*(\d+(?:[.,]\d+)?)\s*([a-z]*)\s*$`)
+
+// applyNormalizeDirectives rewrites the values of keys matching the globs declared under normalizeDirectivesKey
+// in their canonical form and removes the directives from the data
+func applyNormalizeDirectives(data map[string]interface{}) {
+	directives, ok := data[normalizeDirectivesKey].(map[string]interface{})
+	delete(data, normalizeDirectivesKey)
+	if !ok {
+		return
+	}
+
+	for _, glob := range sortedKeys(directives) {
+		kind := fmt.Sprint(directives[glob])
+		normalize, ok := normalizers[kind]
+		if !ok {
+			mergerLog.Warn("Unknown normalize directive ignored",
+				"key", glob,
+				"kind", kind,
+			)
+			continue
+		}
+		normalizeKeys(data, nil, strings.Split(glob, "."), func(keyPath string, value interface{}) interface{} {
+			normalized, err := normalize(value)
+			if err != nil {
+				mergerLog.Warn("Normalize directive ignored",
+					"key", keyPath,
+					"kind", kind,
+					"error", err,
+				)
+				return value
+			}
+			mergerLog.Debug("Normalizing value",
+				"key", keyPath,
+				"kind", kind,
+				"value", value,
+				"result", normalized,
+			)
+			return normalized
+		})
+	}
+}
+
+// normalizeKeys replaces every scalar at a key path matching the glob, including the items of scalar lists
+func normalizeKeys(node map[string]interface{}, prefix []string, glob []string, normalize func(keyPath string, value interface{}) interface{}) {
+	for _, key := range sortedKeys(node) {
+		keys := append(append([]string{}, prefix...), key)
+		switch value := node[key].(type) {
+		case map[string]interface{}:
+			normalizeKeys(value, keys, glob, normalize)
+		case []interface{}:
+			if transform.MatchKeys(glob, keys) && isScalarList(value) {
+				for i, item := range value {
+					if item != nil {
+						value[i] = normalize(fmt.Sprintf("%s[%d]", strings.Join(keys, "."), i), item)
+					}
+				}
+			}
+		case nil:
+		default:
+			if transform.MatchKeys(glob, keys) {
+				node[key] = normalize(strings.Join(keys, "."), value)
+			}
+		}
+	}
+}
+
+// normalizeDate converts YAML timestamps, Unix timestamps, and dates in any of the dateLayouts to RFC 3339.
+// Dates without a time zone are in UTC.
+func normalizeDate(value interface{}) (interface{}, error) {
+	switch date := value.(type) {
+	case time.Time:
+		return date.Format(time.RFC3339), nil
+	case int:
+		return time.Unix(int64(date), 0).UTC().Format(time.RFC3339), nil
+	case string:
+		for _, layout := range dateLayouts {
+			if parsed, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+				return parsed.Format(time.RFC3339), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot parse %v as a date", value)
+}
+
+// normalizeDuration converts numbers of seconds, Go durations like 1h30m, durations with units like "2 days",
+// and ISO 8601 durations like PT1H30M to seconds
+func normalizeDuration(value interface{}) (interface{}, error) {
+	if seconds, ok := toFloat(value); ok {
+		return wholeNumber(seconds), nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot parse %v as a duration", value)
+	}
+	text = strings.TrimSpace(text)
+
+	if match := isoDurationPattern.FindStringSubmatch(text); match != nil && text != "P" && !strings.HasSuffix(text, "T") {
+		seconds := 0.0
+		for i, unit := range []float64{604800, 86400, 3600, 60, 1} {
+			if len(match[i+1]) > 0 {
+				number, _ := parseLocalizedNumber(match[i+1])
+				seconds += number * unit
+			}
+		}
+		return wholeNumber(seconds), nil
+	}
+
+	rest := strings.ToLower(text)
+	seconds := 0.0
+	for len(strings.TrimSpace(rest)) > 0 {
+		match := durationPartPattern.FindStringSubmatch(rest)
+		if match == nil {
+			return nil, fmt.Errorf("cannot parse %q as a duration", text)
+		}
+		unit, ok := durationUnits[match[2]]
+		if !ok {
+			return nil, fmt.Errorf("unknown duration unit %q in %q", match[2], text)
+		}
+		number, _ := parseLocalizedNumber(match[1])
+		seconds += number * unit
+		rest = strings.TrimPrefix(rest[len(match[0]):], ",")
+	}
+	if rest == strings.ToLower(text) {
+		return nil, fmt.Errorf("cannot parse %q as a duration", text)
+	}
+	return wholeNumber(seconds), nil
+}
+
+// normalizeSize converts numbers of bytes and sizes with decimal or binary units like "1,5 GB" or 512Mi to bytes
+func normalizeSize(value interface{}) (interface{}, error) {
+	if bytes, ok := toFloat(value); ok {
+		return int(math.Round(bytes)), nil
+	}
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot parse %v as a size", value)
+	}
+	match := sizePattern.FindStringSubmatch(strings.ToLower(text))
+	if match == nil {
+		return nil, fmt.Errorf("cannot parse %q as a size", text)
+	}
+	unit, ok := sizeUnits[match[2]]
+	if !ok {
+		return nil, fmt.Errorf("unknown size unit %q in %q", match[2], text)
+	}
+	number, _ := parseLocalizedNumber(match[1])
+	return int(math.Round(number * unit)), nil
+}
+
+// parseLocalizedNumber parses a number with a decimal point or a decimal comma
+func parseLocalizedNumber(text string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+}
+
+// wholeNumber returns an int if the number has no fraction, so whole seconds are written without a decimal point
+func wholeNumber(number float64) interface{} {
+	if number == math.Trunc(number) && math.Abs(number) < 1<<53 {
+		return int(number)
+	}
+	return number
+}
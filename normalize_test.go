@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndNormalizeDirectivesSuccess runs through the full functionality end-to-end
+// It tests durations, sizes, and dates written in mixed styles by several layers
+// It compares the generated final file with one stored in git
+func TestEnd2EndNormalizeDirectivesSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/normalize"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+
+	hierarchy := processHierarchy(cfg)
+	mergeFilesInHierarchy(hierarchy, cfg.filterExtension, cfg.outputFile, false, false)
+
+	expected, err := os.ReadFile("testdata/normalize/result/expected.yaml")
+	if err != nil {
+		t.Fatalf("Error reading file with expected test results: %v", err)
+	}
+	result, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	assert.Equal(t, string(expected), string(result))
+}
+
+// TestNormalizers verifies the accepted styles of every kind and that invalid values are rejected
+func TestNormalizers(t *testing.T) {
+	tests := []struct {
+		kind     string
+		value    interface{}
+		expected interface{}
+	}{
+		{kind: "date", value: time.Date(2021, 6, 30, 0, 0, 0, 0, time.UTC), expected: "2021-06-30T00:00:00Z"},
+		{kind: "date", value: "2021-06-30T12:00:00+02:00", expected: "2021-06-30T12:00:00+02:00"},
+		{kind: "date", value: "30.06.2021", expected: "2021-06-30T00:00:00Z"},
+		{kind: "date", value: "06/30/2021", expected: "2021-06-30T00:00:00Z"},
+		{kind: "date", value: "30 Jun 2021", expected: "2021-06-30T00:00:00Z"},
+		{kind: "date", value: 1625011200, expected: "2021-06-30T00:00:00Z"},
+		{kind: "date", value: "30/06/2021"},
+		{kind: "duration", value: 90, expected: 90},
+		{kind: "duration", value: "1h30m", expected: 5400},
+		{kind: "duration", value: "2 days", expected: 172800},
+		{kind: "duration", value: "1 hour, 30 minutes", expected: 5400},
+		{kind: "duration", value: "1,5h", expected: 5400},
+		{kind: "duration", value: "500ms", expected: 0.5},
+		{kind: "duration", value: "P1DT1H", expected: 90000},
+		{kind: "duration", value: "PT"},
+		{kind: "duration", value: "soon"},
+		{kind: "duration", value: "5 fortnights"},
+		{kind: "duration", value: ""},
+		{kind: "size", value: 1024, expected: 1024},
+		{kind: "size", value: "10KB", expected: 10000},
+		{kind: "size", value: "10 KiB", expected: 10240},
+		{kind: "size", value: "1.5G", expected: 1500000000},
+		{kind: "size", value: "1,5 gib", expected: 1610612736},
+		{kind: "size", value: "512", expected: 512},
+		{kind: "size", value: "10 XB"},
+		{kind: "size", value: true},
+	}
+	for _, test := range tests {
+		result, err := normalizers[test.kind](test.value)
+		if test.expected == nil {
+			assert.Error(t, err, "%s %v", test.kind, test.value)
+			continue
+		}
+		if assert.NoError(t, err, "%s %v", test.kind, test.value) {
+			assert.Equal(t, test.expected, result, "%s %v", test.kind, test.value)
+		}
+	}
+}
+
+// TestNormalizeDirectivesIgnoreInvalidValues verifies that unknown kinds and values that cannot be parsed are kept
+func TestNormalizeDirectivesIgnoreInvalidValues(t *testing.T) {
+	data := map[string]interface{}{
+		normalizeDirectivesKey: map[string]interface{}{
+			"app.timeout": "duration",
+			"app.size":    "volume",
+		},
+		"app": map[string]interface{}{
+			"timeout": "soon",
+			"size":    "big",
+		},
+	}
+	applyNormalizeDirectives(data)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{
+			"timeout": "soon",
+			"size":    "big",
+		},
+	}, data)
+}
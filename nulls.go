@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// nullPolicy is what an explicit null value of a key in an input file means, see --null-policy:
+// 'keep' merges it as a literal null, 'delete-key' removes the key, and 'error' fails the merge
+var nullPolicy = "keep"
+
+// nullKey is a key with an explicit null value in an input file
+type nullKey struct {
+	key  string
+	line int
+}
+
+// findNullKeys returns every key of the mappings of a document with a null value.
+// Lists are replaced as a whole, so the keys of mappings in lists are not included.
+func findNullKeys(node *yaml.Node, prefix string) []nullKey {
+	nulls := []nullKey{}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			nulls = append(nulls, findNullKeys(child, prefix)...)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" {
+				continue
+			}
+			keyPath := key.Value
+			if prefix != "" {
+				keyPath = prefix + "." + key.Value
+			}
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				nulls = append(nulls, nullKey{key: keyPath, line: key.Line})
+				continue
+			}
+			nulls = append(nulls, findNullKeys(value, keyPath)...)
+		}
+	}
+	return nulls
+}
+
+// checkNullKeys fails with every key of an input file with a null value, for --null-policy=error
+func checkNullKeys(file string, document *yaml.Node) error {
+	messages := []string{}
+	for _, null := range findNullKeys(document, "") {
+		messages = append(messages, fmt.Sprintf("%s:%d: key %s is null", file, null.line, null.key))
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "\n"))
+	}
+	return nil
+}
+
+// deleteNullKeys removes the keys of an input file with a null value from the merged data, for --null-policy=delete-key
+func deleteNullKeys(data map[string]interface{}, document *yaml.Node) {
+	for _, null := range findNullKeys(document, "") {
+		keys := strings.Split(null.key, ".")
+		node, ok := data, true
+		if len(keys) > 1 {
+			node, ok = lookupKey(data, strings.Join(keys[:len(keys)-1], ".")).(map[string]interface{})
+		}
+		if ok {
+			mergerLog.Debug("Deleting null key", "key", null.key)
+			delete(node, keys[len(keys)-1])
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndNullPolicy verifies that an explicit null is kept as a literal null, or deletes the key
+func TestEnd2EndNullPolicy(t *testing.T) {
+	defer func() { nullPolicy = "keep" }()
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/nulls"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	for policy, expected := range map[string]string{
+		"keep":       "app:\n    name: demo\n    debug: null\n    replicas: 3\n",
+		"delete-key": "app:\n    name: demo\n    replicas: 3\n",
+	} {
+		nullPolicy = policy
+		runMerge(cfg)
+		content, err := os.ReadFile(cfg.outputFile)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content), policy)
+	}
+}
+
+// TestCheckNullKeys verifies that every null key is reported with its line, except the ones in lists
+func TestCheckNullKeys(t *testing.T) {
+	_, document, err := parseInput("app.yaml", []byte("app:\n  debug: ~\n  hosts: [a, null]\n  env:\n    - {name: x, value: null}\n  log:\n    level:\n"))
+	assert.NoError(t, err)
+	err = checkNullKeys("app.yaml", document)
+	if assert.Error(t, err) {
+		assert.Equal(t, "app.yaml:2: key app.debug is null\napp.yaml:7: key app.log.level is null", err.Error())
+	}
+}
+
+// TestFailNullPolicyError ensures that an explicit null fails the merge with exitValidation (7) with --null-policy=error
+// It spawns a new process to determine the exit code of the application.
+func TestFailNullPolicyError(t *testing.T) {
+	if os.Getenv("TEST_FAIL_NULL_POLICY") == "1" {
+		nullPolicy = "error"
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/nulls"
+		cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+		runMerge(cfg)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailNullPolicyError")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_NULL_POLICY=1")
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
@@ -0,0 +1,340 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// gcsAddress is the address of the Cloud Storage JSON API
+	gcsAddress = "https://storage.googleapis.com"
+	// azureBlobAddress is the address of the Blob Storage of an account by name
+	azureBlobAddress = "https://%s.blob.core.windows.net"
+)
+
+// objectStore lists and reads the objects of a bucket or container
+type objectStore interface {
+	// list returns the names of the objects directly below prefix, which is empty or ends with '/'
+	list(prefix string) ([]string, error)
+	get(name string) ([]byte, error)
+}
+
+// parseObjectStoreSource returns the store and prefix of an entry, which is
+// 's3://<bucket>/<prefix>', 'gs://<bucket>/<prefix>', or 'az://<account>/<container>/<prefix>'
+func parseObjectStoreSource(cfg config, entry string) (objectStore, string, error) {
+	scheme, location, _ := strings.Cut(entry, "://")
+	bucket, prefix, _ := strings.Cut(location, "/")
+	var store objectStore
+	switch scheme {
+	case "s3":
+		store = &s3Bucket{aws: newAWSClient(cfg), bucket: bucket}
+	case "gs":
+		store = &gcsBucket{gcp: newGCPClient(), bucket: bucket}
+	case "az":
+		var container string
+		container, prefix, _ = strings.Cut(prefix, "/")
+		if len(container) == 0 {
+			return nil, "", fmt.Errorf("no container in %s, expected az://<account>/<container>/<prefix>", entry)
+		}
+		store = &azureContainer{azure: newAzureClient(azureStorageResource), account: bucket, container: container}
+	default:
+		return nil, "", fmt.Errorf("unknown object store %s", scheme)
+	}
+	if len(bucket) == 0 {
+		return nil, "", fmt.Errorf("no bucket in %s", entry)
+	}
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	return store, prefix, nil
+}
+
+// fetchObjectStoreSource downloads the objects directly below the prefix of an entry into dir,
+// like the files of a directory. Only objects matching --filter and per-directory schemas are downloaded.
+func fetchObjectStoreSource(cfg config, entry string, dir string) error {
+	store, prefix, err := parseObjectStoreSource(cfg, entry)
+	if err != nil {
+		return err
+	}
+	filter, err := regexp.Compile(cfg.filterExtension)
+	if err != nil {
+		return err
+	}
+	names, err := store.list(prefix)
+	if err != nil {
+		return errors.Wrapf(err, "Error listing %s", entry)
+	}
+	for _, name := range names {
+		file := path.Base(name)
+		if !filter.MatchString(file) && file != layerSchemaFile && file != orderFile && file != ignoreFile {
+			continue
+		}
+		content, err := store.get(name)
+		if err != nil {
+			return errors.Wrapf(err, "Error reading %s", name)
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readObjectResponse returns the body of a successful response,
+// or the status and the message of an XML or JSON error response
+func readObjectResponse(response *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return body, nil
+	}
+	var xmlError struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	var jsonError struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	switch {
+	case xml.Unmarshal(body, &xmlError) == nil && len(xmlError.Code) > 0:
+		return nil, fmt.Errorf("%s: %s %s", response.Status, xmlError.Code, xmlError.Message)
+	case json.Unmarshal(body, &jsonError) == nil && len(jsonError.Error.Message) > 0:
+		return nil, fmt.Errorf("%s: %s", response.Status, jsonError.Error.Message)
+	}
+	return nil, errors.New(response.Status)
+}
+
+// s3Bucket reads objects of an S3 bucket with the credentials and region of the AWS secret references, see --aws.region.
+// With --aws.endpoint, e.g. of MinIO or LocalStack, buckets are addressed by path.
+type s3Bucket struct {
+	aws    *awsClient
+	bucket string
+}
+
+// escapeObjectPath escapes all characters of an object name except unreserved ones and '/', as Signature Version 4 expects
+func escapeObjectPath(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// do sends a signed GET request for an object key, or the bucket if the key is empty
+func (s *s3Bucket) do(key string, query url.Values) ([]byte, error) {
+	if _, err := s.aws.load(); err != nil {
+		return nil, err
+	}
+	address := "https://" + s.bucket + ".s3." + s.aws.region + ".amazonaws.com/" + escapeObjectPath(key)
+	if len(s.aws.endpoint) > 0 {
+		address = strings.TrimSuffix(s.aws.endpoint, "/") + "/" + escapeObjectPath(s.bucket) + "/" + escapeObjectPath(key)
+	}
+	if len(query) > 0 {
+		address += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	request, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	emptyHash := sha256.Sum256(nil)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(emptyHash[:]))
+	if err := s.aws.sign(request, nil, "s3"); err != nil {
+		return nil, err
+	}
+	response, err := s.aws.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return readObjectResponse(response)
+}
+
+func (s *s3Bucket) list(prefix string) ([]string, error) {
+	names := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		body, err := s.do("", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			names = append(names, object.Key)
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *s3Bucket) get(name string) ([]byte, error) {
+	return s.do(name, nil)
+}
+
+// gcsBucket reads objects of a Cloud Storage bucket with Application Default Credentials
+type gcsBucket struct {
+	gcp    *gcpClient
+	bucket string
+}
+
+// do sends an authorized GET request to a path of the bucket in the JSON API
+func (g *gcsBucket) do(path string, query url.Values) ([]byte, error) {
+	token, err := g.gcp.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(http.MethodGet,
+		gcsAddress+"/storage/v1/b/"+url.PathEscape(g.bucket)+"/o"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := g.gcp.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return readObjectResponse(response)
+}
+
+func (g *gcsBucket) list(prefix string) ([]string, error) {
+	names := []string{}
+	query := url.Values{"prefix": {prefix}, "delimiter": {"/"}, "fields": {"items(name),nextPageToken"}}
+	for {
+		body, err := g.do("", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, object := range result.Items {
+			names = append(names, object.Name)
+		}
+		if len(result.NextPageToken) == 0 {
+			return names, nil
+		}
+		query.Set("pageToken", result.NextPageToken)
+	}
+}
+
+func (g *gcsBucket) get(name string) ([]byte, error) {
+	return g.do("/"+url.PathEscape(name), url.Values{"alt": {"media"}})
+}
+
+// azureContainer reads blobs of a Blob Storage container with the credentials of DefaultAzureCredential
+type azureContainer struct {
+	azure     *azureClient
+	account   string
+	container string
+}
+
+// do sends an authorized GET request to a path of the container
+func (a *azureContainer) do(path string, query url.Values) ([]byte, error) {
+	token, err := a.azure.accessToken()
+	if err != nil {
+		return nil, err
+	}
+	address := fmt.Sprintf(azureBlobAddress, a.account) + "/" + url.PathEscape(a.container) + path
+	if len(query) > 0 {
+		address += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	// Bearer tokens require version 2017-11-09 or later
+	request.Header.Set("X-Ms-Version", "2021-08-06")
+	response, err := a.azure.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return readObjectResponse(response)
+}
+
+func (a *azureContainer) list(prefix string) ([]string, error) {
+	names := []string{}
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}, "delimiter": {"/"}}
+	for {
+		body, err := a.do("", query)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Blobs []struct {
+				Name string `xml:"Name"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, err
+		}
+		for _, blob := range result.Blobs {
+			names = append(names, blob.Name)
+		}
+		if len(result.NextMarker) == 0 {
+			return names, nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+func (a *azureContainer) get(name string) ([]byte, error) {
+	return a.do("/"+escapeObjectPath(name), nil)
+}
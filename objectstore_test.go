@@ -0,0 +1,186 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseObjectStoreSource verifies that buckets, containers, and prefixes are found in entries
+func TestParseObjectStoreSource(t *testing.T) {
+	store, prefix, err := parseObjectStoreSource(cfgDefaults, "s3://configs/org/defaults/")
+	assert.NoError(t, err)
+	assert.Equal(t, "configs", store.(*s3Bucket).bucket)
+	assert.Equal(t, "org/defaults/", prefix)
+
+	store, prefix, err = parseObjectStoreSource(cfgDefaults, "gs://configs")
+	assert.NoError(t, err)
+	assert.Equal(t, "configs", store.(*gcsBucket).bucket)
+	assert.Equal(t, "", prefix)
+
+	store, prefix, err = parseObjectStoreSource(cfgDefaults, "az://account/configs/org")
+	assert.NoError(t, err)
+	assert.Equal(t, "account", store.(*azureContainer).account)
+	assert.Equal(t, "configs", store.(*azureContainer).container)
+	assert.Equal(t, "org/", prefix)
+
+	for _, entry := range []string{"s3://", "gs:///org", "az://account", "az://account/"} {
+		_, _, err := parseObjectStoreSource(cfgDefaults, entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+// TestS3Source verifies that objects below a prefix are listed across pages, signed, and merged like a directory
+func TestS3Source(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") || len(r.Header.Get("X-Amz-Content-Sha256")) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/configs/" && query.Get("list-type") == "2" && query.Get("prefix") == "org/" && query.Get("delimiter") == "/":
+			if query.Get("continuation-token") == "" {
+				fmt.Fprint(w, `<ListBucketResult><Contents><Key>org/app.yaml</Key></Contents><Contents><Key>org/README.md</Key></Contents>`+
+					`<IsTruncated>true</IsTruncated><NextContinuationToken>page 2</NextContinuationToken></ListBucketResult>`)
+			} else if query.Get("continuation-token") == "page 2" {
+				fmt.Fprint(w, `<ListBucketResult><Contents><Key>org/team a.yaml</Key></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`)
+			}
+		case r.URL.Path == "/configs/org/app.yaml":
+			fmt.Fprint(w, "app:\n  name: demo\n")
+		case r.URL.EscapedPath() == "/configs/org/team%20a.yaml":
+			fmt.Fprint(w, "app:\n  team: a\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+		}
+	}))
+	defer server.Close()
+	useAWS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+
+	cfg := writeRemoteHierarchy(t, "s3://configs/org")
+	cfg.awsEndpoint = server.URL
+	cfg.awsRegion = "us-east-1"
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{"name": "demo", "team": "a"}, data["app"])
+
+	_, err := (&s3Bucket{aws: newAWSClient(cfg), bucket: "configs"}).get("org/missing.yaml")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "404 Not Found: NoSuchKey")
+	}
+}
+
+// TestGCSSource verifies that objects below a prefix are read with the token of the metadata server
+func TestGCSSource(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`)
+	}))
+	defer metadata.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer metadata-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/storage/v1/b/configs/o":
+			if r.URL.Query().Get("prefix") == "org/" && r.URL.Query().Get("delimiter") == "/" {
+				fmt.Fprint(w, `{"items":[{"name":"org/app.yaml"}]}`)
+			}
+		case "/storage/v1/b/configs/o/org%2Fapp.yaml":
+			if r.URL.Query().Get("alt") == "media" {
+				fmt.Fprint(w, "app:\n  name: demo\n")
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"No such object: configs/org/missing.yaml"}}`)
+		}
+	}))
+	defer server.Close()
+	previous := gcsAddress
+	gcsAddress = server.URL
+	defer func() { gcsAddress = previous }()
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(metadata.URL, "http://"))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("HOME", t.TempDir())
+
+	dir := t.TempDir()
+	assert.NoError(t, fetchObjectStoreSource(cfgDefaults, "gs://configs/org/", dir))
+	content, err := os.ReadFile(filepath.Join(dir, "app.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n  name: demo\n", string(content))
+
+	_, err = (&gcsBucket{gcp: newGCPClient(), bucket: "configs"}).get("org/missing.yaml")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "No such object")
+	}
+}
+
+// TestAzureBlobSource verifies that blobs below a prefix are read with a token of the managed identity for Blob Storage
+func TestAzureBlobSource(t *testing.T) {
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resource") != "https://storage.azure.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"storage-token","expires_in":"3599","token_type":"Bearer"}`)
+	}))
+	defer imds.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer storage-token" || len(r.Header.Get("X-Ms-Version")) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/account/configs":
+			if query.Get("comp") == "list" && query.Get("prefix") == "org/" && query.Get("marker") == "" {
+				fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>org/app.yaml</Name></Blob><BlobPrefix><Name>org/teams/</Name></BlobPrefix></Blobs><NextMarker>2</NextMarker></EnumerationResults>`)
+			} else if query.Get("marker") == "2" {
+				fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>org/schema.json</Name></Blob></Blobs><NextMarker/></EnumerationResults>`)
+			}
+		case "/account/configs/org/app.yaml":
+			fmt.Fprint(w, "app:\n  name: demo\n")
+		case "/account/configs/org/schema.json":
+			fmt.Fprint(w, `{"type": "object"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	previousBlob := azureBlobAddress
+	azureBlobAddress = server.URL + "/%s"
+	defer func() { azureBlobAddress = previousBlob }()
+	useAzure(t, azureHosts{"169.254.169.254": imds.URL})
+
+	dir := t.TempDir()
+	assert.NoError(t, fetchObjectStoreSource(cfgDefaults, "az://account/configs/org", dir))
+	assert.FileExists(t, filepath.Join(dir, "app.yaml"))
+	assert.FileExists(t, filepath.Join(dir, "schema.json"))
+
+	err := fetchObjectStoreSource(cfgDefaults, "az://account/missing/org", t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ociSourcePrefix starts hierarchy entries of OCI artifacts, e.g. 'oci://ghcr.io/org/config:v1.2.0'
+const ociSourcePrefix = "oci://"
+
+// Media types of manifests accepted from registries
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociTitleAnnotation names the file of a layer pushed with 'oras push'
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+// ociManifest is an image manifest, whose layers are the files or archives of the artifact
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Layers    []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// parseOCISource splits an entry into the registry, the repository, and the tag or digest, which defaults to 'latest'
+func parseOCISource(entry string) (string, string, string, error) {
+	reference := strings.TrimPrefix(entry, ociSourcePrefix)
+	registry, repository, ok := strings.Cut(reference, "/")
+	if !ok || len(registry) == 0 || len(repository) == 0 {
+		return "", "", "", fmt.Errorf("invalid OCI artifact %s, expected oci://<registry>/<repository>[:<tag>|@<digest>]", entry)
+	}
+	tag := "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, tag = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	if len(repository) == 0 || len(tag) == 0 {
+		return "", "", "", fmt.Errorf("invalid OCI artifact %s, expected oci://<registry>/<repository>[:<tag>|@<digest>]", entry)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	return registry, repository, tag, nil
+}
+
+// ociRegistry pulls from a registry with the distribution API, authenticating with the
+// credentials of 'docker login' or 'oras login' if the registry asks for them
+type ociRegistry struct {
+	client     *http.Client
+	registry   string
+	repository string
+	token      string
+}
+
+// fetchOCISource pulls an artifact into dir. Layers with a file name annotation, as pushed by 'oras push', are
+// stored under that name, tar archives are extracted. The digest of every layer is verified.
+func fetchOCISource(cfg config, entry string, dir string) error {
+	registry, repository, tag, err := parseOCISource(entry)
+	if err != nil {
+		return err
+	}
+	client, err := newHTTPSourceClient(cfg)
+	if err != nil {
+		return err
+	}
+	r := &ociRegistry{client: client, registry: registry, repository: repository}
+
+	body, err := r.get("manifests/"+tag, strings.Join(ociManifestTypes, ", "))
+	if err != nil {
+		return err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return errors.Wrap(err, "Invalid manifest")
+	}
+	supported := len(manifest.MediaType) == 0
+	for _, mediaType := range ociManifestTypes {
+		supported = supported || mediaType == manifest.MediaType
+	}
+	if !supported {
+		return fmt.Errorf("unsupported manifest type %s, expected an artifact or image manifest", manifest.MediaType)
+	}
+	for _, layer := range manifest.Layers {
+		blob, err := r.get("blobs/"+layer.Digest, "")
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(blob)
+		if layer.Digest != "sha256:"+hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("digest of layer %s does not match its content", layer.Digest)
+		}
+		if err := storeOCILayer(layer.MediaType, layer.Annotations[ociTitleAnnotation], blob, dir); err != nil {
+			return errors.Wrapf(err, "Error storing layer %s", layer.Digest)
+		}
+	}
+	return nil
+}
+
+// storeOCILayer extracts a tar archive, optionally compressed with gzip, or writes a file under its title
+func storeOCILayer(mediaType string, title string, blob []byte, dir string) error {
+	archive := strings.Contains(mediaType, "tar")
+	if !archive {
+		name := filepath.Base(filepath.FromSlash(title))
+		if len(title) == 0 || name != title || name == "." || name == ".." {
+			return fmt.Errorf("layer of type %s has no valid file name", mediaType)
+		}
+		return os.WriteFile(filepath.Join(dir, name), blob, 0644)
+	}
+	var r io.Reader = bytes.NewReader(blob)
+	if strings.Contains(mediaType, "gzip") {
+		decompressed, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		r = decompressed
+	}
+	return extractTar(r, dir)
+}
+
+// get sends a GET request to a path below the repository, authenticating once if the registry asks for it
+func (r *ociRegistry) get(path string, accept string) ([]byte, error) {
+	do := func() (*http.Response, error) {
+		request, err := http.NewRequest(http.MethodGet, "https://"+r.registry+"/v2/"+r.repository+"/"+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			request.Header.Set("Accept", accept)
+		}
+		if len(r.token) > 0 {
+			request.Header.Set("Authorization", r.token)
+		}
+		return r.client.Do(request)
+	}
+	response, err := do()
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusUnauthorized && len(r.token) == 0 {
+		challenge := response.Header.Get("WWW-Authenticate")
+		response.Body.Close()
+		if r.token, err = r.authenticate(challenge); err != nil {
+			return nil, errors.Wrapf(err, "Error authenticating at %s", r.registry)
+		}
+		if response, err = do(); err != nil {
+			return nil, err
+		}
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return nil, fmt.Errorf("GET %s: %s: %s %s", path, response.Status, failure.Errors[0].Code, failure.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("GET %s: %s", path, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// authenticate returns the Authorization header answering a challenge of the registry.
+// A Bearer challenge is answered with a token of the token service, requested anonymously if there are no credentials.
+func (r *ociRegistry) authenticate(challenge string) (string, error) {
+	scheme, parameters, _ := strings.Cut(challenge, " ")
+	username, password := ociCredentials(r.registry)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if len(username) == 0 {
+			return "", errors.New("no credentials found, log in with 'docker login' or 'oras login'")
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	fields := parseChallenge(parameters)
+	realm, err := url.Parse(fields["realm"])
+	if err != nil || len(fields["realm"]) == 0 {
+		return "", fmt.Errorf("invalid realm in authentication challenge %q", challenge)
+	}
+	query := realm.Query()
+	query.Set("service", fields["service"])
+	if len(fields["scope"]) > 0 {
+		query.Set("scope", fields["scope"])
+	} else {
+		query.Set("scope", "repository:"+r.repository+":pull")
+	}
+	realm.RawQuery = query.Encode()
+	request, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if len(username) > 0 {
+		request.SetBasicAuth(username, password)
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&result)
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token service returned %s", response.Status)
+	}
+	if len(result.Token) == 0 {
+		result.Token = result.AccessToken
+	}
+	return "Bearer " + result.Token, nil
+}
+
+// parseChallenge returns the parameters of a WWW-Authenticate header, e.g. 'realm="https://ghcr.io/token",service="ghcr.io"'
+func parseChallenge(parameters string) map[string]string {
+	fields := map[string]string{}
+	for len(parameters) > 0 {
+		var name, value string
+		name, parameters, _ = strings.Cut(parameters, "=")
+		if strings.HasPrefix(parameters, `"`) {
+			value, parameters, _ = strings.Cut(parameters[1:], `"`)
+		} else {
+			value, parameters, _ = strings.Cut(parameters, ",")
+		}
+		fields[strings.ToLower(strings.TrimSpace(name))] = value
+		parameters = strings.TrimLeft(parameters, ", ")
+	}
+	return fields
+}
+
+// ociCredentials returns the username and password of a registry stored by 'docker login' or 'oras login'
+// in the config file of Docker, $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+// Credential helpers are not supported.
+func ociCredentials(registry string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if len(dir) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(content, &config) != nil {
+		return "", ""
+	}
+	for _, name := range []string{registry, "https://" + registry, "https://index.docker.io/v1/"} {
+		auth, ok := config.Auths[name]
+		if !ok || (name == "https://index.docker.io/v1/" && registry != "registry-1.docker.io") {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return "", ""
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return username, password
+	}
+	return "", ""
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseOCISource verifies that the registry, repository, and tag or digest are found in entries
+func TestParseOCISource(t *testing.T) {
+	for entry, expected := range map[string][]string{
+		"oci://ghcr.io/org/config:v1.2.0":      {"ghcr.io", "org/config", "v1.2.0"},
+		"oci://localhost:5000/config":          {"localhost:5000", "config", "latest"},
+		"oci://docker.io/org/config@sha256:ab": {"registry-1.docker.io", "org/config", "sha256:ab"},
+	} {
+		registry, repository, tag, err := parseOCISource(entry)
+		assert.NoError(t, err, entry)
+		assert.Equal(t, expected, []string{registry, repository, tag}, entry)
+	}
+	for _, entry := range []string{"oci://ghcr.io", "oci://ghcr.io/", "oci:///org/config", "oci://ghcr.io/org/config:"} {
+		_, _, _, err := parseOCISource(entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+// TestParseChallenge verifies that quoted and unquoted parameters of an authentication challenge are parsed
+func TestParseChallenge(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"realm":   "https://ghcr.io/token",
+		"service": "ghcr.io",
+		"scope":   "repository:org/config:pull,push",
+	}, parseChallenge(`realm="https://ghcr.io/token",service=ghcr.io, scope="repository:org/config:pull,push"`))
+}
+
+// ociBlob returns the digest of a blob
+func ociBlob(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeRegistry serves an artifact with a file pushed by oras and a compressed archive, after a token was requested with the credentials.
+// A tampered registry serves other content than the digests of the manifest.
+func fakeRegistry(t *testing.T, tampered bool) *httptest.Server {
+	blobs := map[string][]byte{}
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+	team := []byte("app:\n  team: payments\n")
+	assert.NoError(t, archive.WriteHeader(&tar.Header{Name: "team.yaml", Mode: 0644, Size: int64(len(team)), Typeflag: tar.TypeReg}))
+	_, _ = archive.Write(team)
+	assert.NoError(t, archive.Close())
+	assert.NoError(t, compressed.Close())
+	file := []byte("app:\n  name: demo\n")
+	blobs[ociBlob(file)] = file
+	blobs[ociBlob(buffer.Bytes())] = buffer.Bytes()
+	if tampered {
+		for digest := range blobs {
+			blobs[digest] = []byte("app:\n  name: tampered\n")
+		}
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"artifactType":"application/vnd.hierarchy.config.v1","layers":[`+
+		`{"mediaType":"application/yaml","digest":"%s","annotations":{"org.opencontainers.image.title":"app.yaml"}},`+
+		`{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":"%s"}]}`, ociBlob(file), ociBlob(buffer.Bytes()))
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			username, password, _ := r.BasicAuth()
+			if username != "ci" || password != "s3cr3t" || r.URL.Query().Get("scope") != "repository:org/config:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token":"registry-token"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:org/config:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v2/org/config/manifests/v1.2.0" && strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json"):
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			fmt.Fprint(w, manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/org/config/blobs/") && blobs[strings.TrimPrefix(r.URL.Path, "/v2/org/config/blobs/")] != nil:
+			_, _ = w.Write(blobs[strings.TrimPrefix(r.URL.Path, "/v2/org/config/blobs/")])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`)
+		}
+	}))
+	return server
+}
+
+// TestOCISource verifies that the files and archives of an artifact are pulled with the credentials of docker login
+func TestOCISource(t *testing.T) {
+	server := fakeRegistry(t, false)
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	// "ci:s3cr3t"
+	auths := `{"auths":{"` + registry + `":{"auth":"Y2k6czNjcjN0"}}}`
+	assert.NoError(t, os.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(auths), 0600))
+
+	cfg := writeRemoteHierarchy(t, "oci://"+registry+"/org/config:v1.2.0")
+	cfg.httpInsecureSkipVerify = true
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{"name": "demo", "team": "payments"}, data["app"])
+
+	err := fetchOCISource(cfg, "oci://"+registry+"/org/config:v2.0.0", t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "MANIFEST_UNKNOWN")
+	}
+
+	assert.NoError(t, os.Remove(filepath.Join(dockerConfig, "config.json")))
+	err = fetchOCISource(cfg, "oci://"+registry+"/org/config:v1.2.0", t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "401 Unauthorized")
+	}
+}
+
+// TestOCISourceDigest verifies that a layer whose content does not match its digest is rejected
+func TestOCISourceDigest(t *testing.T) {
+	server := fakeRegistry(t, true)
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "https://")
+	dockerConfig := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dockerConfig)
+	assert.NoError(t, os.WriteFile(filepath.Join(dockerConfig, "config.json"), []byte(`{"auths":{"`+registry+`":{"auth":"Y2k6czNjcjN0"}}}`), 0600))
+
+	cfg := cfgDefaults
+	cfg.httpInsecureSkipVerify = true
+	err := fetchOCISource(cfg, "oci://"+registry+"/org/config:v1.2.0", t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not match its content")
+	}
+}
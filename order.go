@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// orderFile is the optional file of a hierarchy directory listing the names of files to merge first, in order
+const orderFile = ".order"
+
+// fileOrder is the order the files of a directory are merged in without an orderFile, see --file-order
+var fileOrder = "lexical"
+
+// sortFiles sorts the files of a directory by name, with fileOrder natural numbers in names compare by their value,
+// so 2-bar.yaml is merged before 10-foo.yaml
+func sortFiles(files []string) {
+	if fileOrder != "natural" {
+		sort.Strings(files)
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return naturalLess(filepath.Base(files[i]), filepath.Base(files[j]))
+	})
+}
+
+// naturalLess compares two names byte by byte, except for runs of digits which compare by their value.
+// Equal values with a different number of leading zeros compare by their length, and equal names by bytes.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return a[i] < b[j]
+			}
+			i++
+			j++
+			continue
+		}
+		startA, startB := i, j
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		numberA := strings.TrimLeft(a[startA:i], "0")
+		numberB := strings.TrimLeft(b[startB:j], "0")
+		if len(numberA) != len(numberB) {
+			return len(numberA) < len(numberB)
+		}
+		if numberA != numberB {
+			return numberA < numberB
+		}
+		if i-startA != j-startB {
+			return i-startA < j-startB
+		}
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+// isDigit reports whether c is an ASCII digit
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// readOrderFile returns the file names listed in the orderFile of a directory, one per line.
+// Empty lines and lines starting with '#' are ignored. A directory without an orderFile has no names.
+func readOrderFile(dir string) ([]string, error) {
+	content, err := inputFS.ReadFile(filepath.Join(dir, orderFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		name := strings.TrimSpace(line)
+		if len(name) == 0 || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// orderFiles moves the files listed in the orderFile of a directory to the front, in the order they are listed.
+// The other files follow in their sorted order. A name which is not a file to merge of the directory is an error,
+// so a renamed file does not silently change the merge order.
+func orderFiles(dir string, files []string, names []string) ([]string, error) {
+	byName := map[string]int{}
+	for i, file := range files {
+		byName[filepath.Base(file)] = i
+	}
+	listed := map[int]bool{}
+	ordered := make([]string, 0, len(files))
+	for _, name := range names {
+		i, ok := byName[name]
+		if !ok {
+			return nil, errors.Errorf("%s lists %s, which is not a file to merge of the directory", filepath.Join(dir, orderFile), name)
+		}
+		if listed[i] {
+			return nil, errors.Errorf("%s lists %s more than once", filepath.Join(dir, orderFile), name)
+		}
+		listed[i] = true
+		ordered = append(ordered, files[i])
+	}
+	for i, file := range files {
+		if !listed[i] {
+			ordered = append(ordered, file)
+		}
+	}
+	return ordered, nil
+}
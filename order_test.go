@@ -0,0 +1,98 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNaturalLess verifies that numbers in names compare by their value
+func TestNaturalLess(t *testing.T) {
+	assert.True(t, naturalLess("2-bar.yaml", "10-foo.yaml"))
+	assert.False(t, naturalLess("10-foo.yaml", "2-bar.yaml"))
+	assert.True(t, naturalLess("app2.yaml", "app10.yaml"))
+	assert.True(t, naturalLess("app.yaml", "app1.yaml"))
+	assert.True(t, naturalLess("a10.yaml", "b2.yaml"))
+	assert.True(t, naturalLess("02-bar.yaml", "002-bar.yaml"))
+	assert.False(t, naturalLess("app.yaml", "app.yaml"))
+}
+
+// TestGetFilesFileOrder verifies the lexical and natural order of the files of a directory
+func TestGetFilesFileOrder(t *testing.T) {
+	defer func() { fileOrder = "lexical" }()
+	assert.Equal(t, []string{
+		"testdata/order/natural/1-base.yaml",
+		"testdata/order/natural/10-foo.yaml",
+		"testdata/order/natural/2-bar.yaml",
+	}, getFiles("testdata/order/natural", defaultFileFilter))
+
+	fileOrder = "natural"
+	assert.Equal(t, []string{
+		"testdata/order/natural/1-base.yaml",
+		"testdata/order/natural/2-bar.yaml",
+		"testdata/order/natural/10-foo.yaml",
+	}, getFiles("testdata/order/natural", defaultFileFilter))
+}
+
+// TestGetFilesOrderFile verifies that the files listed in the .order file are merged first, in order
+func TestGetFilesOrderFile(t *testing.T) {
+	assert.Equal(t, []string{
+		"testdata/order/listed/defaults.yaml",
+		"testdata/order/listed/app.yaml",
+		"testdata/order/listed/overrides.yaml",
+	}, getFiles("testdata/order/listed", defaultFileFilter))
+
+	_, err := orderFiles("dir", []string{"dir/app.yaml"}, []string{"app.yaml", "app.yaml"})
+	assert.EqualError(t, err, "dir/.order lists app.yaml more than once")
+}
+
+// TestEnd2EndFileOrder verifies that the last file in natural order wins
+func TestEnd2EndFileOrder(t *testing.T) {
+	defer func() { fileOrder = "lexical" }()
+	fileOrder = "natural"
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/order/natural"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "level: foo\nbase: true\nbar: true\nfoo: true\n", string(content))
+}
+
+// TestFailOrderFileUnknown ensures that a .order file listing a file which does not exist fails with exitParse (3)
+// It spawns a new process to determine the exit code of the application.
+func TestFailOrderFileUnknown(t *testing.T) {
+	if os.Getenv("TEST_FAIL_ORDER_FILE") == "1" {
+		getFiles("testdata/order/unknown", defaultFileFilter)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailOrderFileUnknown")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_ORDER_FILE=1")
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitParse {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitParse)
+}
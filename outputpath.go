@@ -0,0 +1,68 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// outputPathTokens are the date tokens of the output path and their layout, see expandOutputPath
+var outputPathTokens = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'j': "002",
+	'Z': "MST",
+}
+
+// expandOutputPath replaces ${VAR} placeholders in the output path with environment variables
+// and strftime-like tokens like %Y-%m-%d with the time of the run, so jobs can write
+// per-environment and per-timestamp files. '%s' is the Unix time and '%%' a literal '%'.
+// Unknown tokens are kept as they are. A missing variable fails, so nothing is written to a literal placeholder.
+func expandOutputPath(path string, now time.Time) string {
+	if path == stdStream {
+		return path
+	}
+	path = replaceEnvironmentVariables(path, true)
+
+	var expanded strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			expanded.WriteByte(path[i])
+			continue
+		}
+		token := path[i+1]
+		switch {
+		case token == '%':
+			expanded.WriteByte('%')
+		case token == 's':
+			expanded.WriteString(strconv.FormatInt(now.Unix(), 10))
+		case len(outputPathTokens[token]) > 0:
+			expanded.WriteString(now.Format(outputPathTokens[token]))
+		default:
+			expanded.WriteString(path[i : i+2])
+		}
+		i++
+	}
+	return expanded.String()
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExpandOutputPath verifies that environment variables and date tokens are replaced in the output path
+func TestExpandOutputPath(t *testing.T) {
+	t.Setenv("HIERARCHY_TEST_ENV", "prod")
+	now := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	tests := map[string]string{
+		"./output.yaml":                     "./output.yaml",
+		"-":                                 "-",
+		"out/${HIERARCHY_TEST_ENV}.yaml":    "out/prod.yaml",
+		"out/%Y-%m-%d/%H%M%S.yaml":          "out/2021-02-03/040506.yaml",
+		"out/%y%j-%Z-%s.yaml":               "out/21034-UTC-1612325106.yaml",
+		"out/100%%-%q-%":                    "out/100%-%q-%",
+		"out/${hierarchy_test_env}-%Y.yaml": "out/prod-2021.yaml",
+	}
+	for path, expected := range tests {
+		assert.Equal(t, expected, expandOutputPath(path, now), path)
+	}
+}
+
+// TestExpandOutputPathEscape verifies that '%%' keeps a literal '%' in front of a token letter,
+// so existing output paths with a '%' can be kept by doubling it
+func TestExpandOutputPathEscape(t *testing.T) {
+	now := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	tests := map[string]string{
+		"out/%%Y.yaml":          "out/%Y.yaml",
+		"out/%%%Y.yaml":         "out/%2021.yaml",
+		"out/%%%%d.yaml":        "out/%%d.yaml",
+		"out/discount-50%.yaml": "out/discount-50%.yaml",
+	}
+	for path, expected := range tests {
+		assert.Equal(t, expected, expandOutputPath(path, now), path)
+	}
+}
+
+// TestFailOutputPathMissingVariable ensures that a missing variable in the output path fails with exitVariable (5)
+// It spawns a new process to determine the exit code of the application.
+func TestFailOutputPathMissingVariable(t *testing.T) {
+	if os.Getenv("TEST_FAIL_OUTPUT_PATH") == "1" {
+		expandOutputPath("out/${HIERARCHY_TEST_UNDEFINED}.yaml", time.Now())
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailOutputPathMissingVariable")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_OUTPUT_PATH=1")
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitVariable {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitVariable)
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// outputsManifest maps key paths of the merged document to output files of their own, e.g.
+//
+//	outputs:
+//	  - key: app
+//	    file: app.yaml
+//	  - key: infra.network
+//	    file: infra/network.yaml
+type outputsManifest struct {
+	Outputs []namedOutput `yaml:"outputs"`
+}
+
+// namedOutput is a file with the value of a dot-separated key path of the merged document
+type namedOutput struct {
+	Key  string `yaml:"key"`
+	File string `yaml:"file"`
+}
+
+// loadOutputsManifest reads the manifest and returns its outputs, with files relative to the manifest
+func loadOutputsManifest(path string) ([]namedOutput, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest outputsManifest
+	if err := yaml.Unmarshal(content, &manifest); err != nil {
+		return nil, newParseError(path, content, err)
+	}
+	files := map[string]bool{}
+	for i, output := range manifest.Outputs {
+		if len(output.Key) == 0 || len(output.File) == 0 {
+			return nil, fmt.Errorf("output %d of the outputs manifest needs a key and file", i+1)
+		}
+		output.File = expandOutputPath(output.File, time.Now())
+		if !filepath.IsAbs(output.File) {
+			output.File = filepath.Join(filepath.Dir(path), output.File)
+		}
+		if files[output.File] {
+			return nil, fmt.Errorf("output file %s is listed more than once in the outputs manifest", output.File)
+		}
+		files[output.File] = true
+		manifest.Outputs[i] = output
+	}
+	return manifest.Outputs, nil
+}
+
+// renderNamedOutputs returns the content of every output file, the value of its key in the format of the output
+func renderNamedOutputs(outputs []namedOutput, document string, format string) (map[string]string, error) {
+	data, err := parseParts(document)
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string]string{}
+	for _, output := range outputs {
+		value := lookupNode(data, output.Key)
+		if value == nil {
+			return nil, fmt.Errorf("key %s of output file %s is not in the merged document", output.Key, output.File)
+		}
+		content, err := renderValue(value, format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error rendering output file %s", output.File)
+		}
+		contents[output.File] = content
+	}
+	return contents, nil
+}
+
+// parseParts parses the merged document for the parts written to files of their own, keeping the order of its keys
+// and the formatting of its numbers and booleans. The parts are written in the default style without comments or aliases,
+// like the output file before, so the annotations of the output file are not copied.
+func parseParts(document string) (*yaml.Node, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(document), &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	resetStyle(root.Content[0])
+	return root.Content[0], nil
+}
+
+// resetStyle removes the style, comments, and anchors of a node and all nodes below it, and expands aliases,
+// since a part may not contain the anchor an alias refers to
+func resetStyle(node *yaml.Node) {
+	if node.Kind == yaml.AliasNode {
+		*node = *copyNode(node.Alias)
+	}
+	node.Style = 0
+	node.Anchor = ""
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// copyNode returns a copy of a node and all nodes below it
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+	return &copied
+}
+
+// lookupNode returns the value of a dot-separated key path below a mapping node, or nil if the key path does not exist
+func lookupNode(node *yaml.Node, keyPath string) *yaml.Node {
+	for _, key := range strings.Split(keyPath, ".") {
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var value *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				value = node.Content[i+1]
+			}
+		}
+		if value == nil {
+			return nil
+		}
+		node = value
+	}
+	return node
+}
+
+// renderValue returns a part of the merged document in the format of the output
+func renderValue(value interface{}, format string) (string, error) {
+	content, err := outputStyle.marshal(value)
+	if err != nil || format == "yaml" {
+		return string(content), err
+	}
+	return formatOutput(format, string(content))
+}
+
+// outputExtensions are the file extensions of the output formats
+var outputExtensions = map[string]string{
+	"yaml":        ".yaml",
+	"tfvars.json": ".tfvars.json",
+	"tfvars":      ".tfvars",
+}
+
+// renderSplitOutputs returns the content of one file per top-level key of the merged document in a directory,
+// named after the key with the extension of the output format
+func renderSplitOutputs(dir string, document string, format string) (map[string]string, error) {
+	data, err := parseParts(document)
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string]string{}
+	for i := 0; data.Kind == yaml.MappingNode && i+1 < len(data.Content); i += 2 {
+		key, value := data.Content[i].Value, data.Content[i+1]
+		if len(key) == 0 || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+			return nil, fmt.Errorf("top-level key %q cannot be used as a file name", key)
+		}
+		file := filepath.Join(dir, key+outputExtensions[format])
+		content, err := renderValue(value, format)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error rendering output file %s", file)
+		}
+		contents[file] = content
+	}
+	return contents, nil
+}
+
+// writeFilesTogether writes all files to temporary files next to them first, and only replaces the files
+// once all of them were written, so they never drift from each other because of a failed write.
+// Directories of the files are created.
+func writeFilesTogether(contents map[string]string) error {
+	files := make([]string, 0, len(contents))
+	for file := range contents {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	temporary := map[string]string{}
+	defer func() {
+		for _, name := range temporary {
+			os.Remove(name)
+		}
+	}()
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		temp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp-*")
+		if err != nil {
+			return err
+		}
+		temporary[file] = temp.Name()
+		_, err = temp.WriteString(contents[file])
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(temp.Name(), 0660)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := os.Rename(temporary[file], file); err != nil {
+			return err
+		}
+		delete(temporary, file)
+	}
+	return nil
+}
+
+// writeNamedOutputs writes the output files of the manifest of --outputs from the validated YAML document
+func writeNamedOutputs(cfg config, document string) {
+	outputs, err := loadOutputsManifest(cfg.outputsManifest)
+	checkForErrorCode(errors.Wrapf(err, "Error reading outputs manifest %s", cfg.outputsManifest), exitParse)
+	contents, err := renderNamedOutputs(outputs, document, cfg.outputFormat)
+	checkForErrorCode(err, exitWrite)
+	for _, output := range outputs {
+		outputLog.Info("Writing output file", "path", output.File, "key", output.Key)
+	}
+	checkForErrorCode(writeFilesTogether(contents), exitWrite)
+}
+
+// writeSplitOutputs writes one file per top-level key of the validated YAML document to --output-dir, see --split-by-top-level-key
+func writeSplitOutputs(cfg config, document string) {
+	contents, err := renderSplitOutputs(cfg.outputDir, document, cfg.outputFormat)
+	checkForErrorCode(err, exitWrite)
+	outputLog.Info("Writing split output files", "path", cfg.outputDir, "count", len(contents))
+	checkForErrorCode(writeFilesTogether(contents), exitWrite)
+}
+
+// checkSplitOutputDir makes sure the split output files are not written to a directory of the hierarchy,
+// where the next merge would read them back in as inputs
+func checkSplitOutputDir(outputDir string, hierarchy []layer) error {
+	if len(outputDir) == 0 {
+		return errors.New("--split-by-top-level-key needs --output-dir")
+	}
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	for _, includeLayer := range hierarchy {
+		if path, err := filepath.Abs(includeLayer.path); err == nil && path == dir {
+			return fmt.Errorf("--output-dir %s is a directory of the hierarchy, whose files would be merged by the next run", outputDir)
+		}
+	}
+	return nil
+}
+
+// renderDocuments returns a multi-document YAML stream with the value of every key path as a document of its own, in order.
+// A list is written as one document per element, so a list of Kubernetes manifests becomes a stream of manifests.
+// Keys which are not in the merged document or null are skipped.
+func renderDocuments(document string, keys []string) (string, error) {
+	data, err := parseParts(document)
+	if err != nil {
+		return "", err
+	}
+	var stream strings.Builder
+	for _, key := range keys {
+		value := lookupNode(data, key)
+		if value == nil {
+			continue
+		}
+		values := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			values = value.Content
+		}
+		for _, value := range values {
+			if value.Tag == "!!null" {
+				continue
+			}
+			content, err := outputStyle.marshal(value)
+			if err != nil {
+				return "", err
+			}
+			stream.WriteString("---\n")
+			stream.Write(content)
+		}
+	}
+	return stream.String(), nil
+}
+
+// outputPaths are the files and directories written by a merge, which are never inputs of a merge,
+// even if they are written to a directory of the hierarchy
+type outputPaths struct {
+	files map[string]bool
+	dirs  []string
+}
+
+// mergeOutputs are the paths written by the current merge, which getFiles leaves out
+var mergeOutputs outputPaths
+
+// newOutputPaths returns the paths written by a merge of the directories of a hierarchy: the output, provenance, log,
+// and PID files, the files of --outputs and the passthrough files copied next to the output file,
+// and the directories of --output-dir and --blobs.dir
+func newOutputPaths(cfg config, dirs []string) outputPaths {
+	o := outputPaths{files: map[string]bool{}}
+	addFile := func(file string) {
+		if len(file) > 0 && file != stdStream {
+			if path, err := filepath.Abs(file); err == nil {
+				o.files[path] = true
+			}
+		}
+	}
+	addDir := func(dir string) {
+		if path, err := filepath.Abs(dir); err == nil {
+			o.dirs = append(o.dirs, path)
+		}
+	}
+	for _, file := range []string{cfg.outputFile, cfg.provenanceFile, cfg.logFile, cfg.pidFile} {
+		addFile(file)
+	}
+	if len(cfg.outputsManifest) > 0 {
+		outputs, _ := loadOutputsManifest(cfg.outputsManifest)
+		for _, output := range outputs {
+			addFile(output.File)
+		}
+	}
+	outputDir := filepath.Dir(cfg.outputFile)
+	if cfg.outputFile == stdStream {
+		outputDir = "."
+	}
+	if cfg.passthrough == "copy" {
+		for _, dir := range dirs {
+			for _, file := range collectPassthroughFiles([]layer{{path: dir}}) {
+				addFile(filepath.Join(outputDir, filepath.FromSlash(file.name)))
+			}
+		}
+	}
+	if cfg.splitByTopLevelKey && len(cfg.outputDir) > 0 {
+		addDir(cfg.outputDir)
+	}
+	if cfg.blobsThreshold > 0 {
+		if filepath.IsAbs(cfg.blobsDir) {
+			addDir(cfg.blobsDir)
+		} else {
+			addDir(filepath.Join(outputDir, cfg.blobsDir))
+		}
+	}
+	return o
+}
+
+// contains reports whether a file is written by the merge, including the temporary files of writeFilesTogether
+func (o outputPaths) contains(file string) bool {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	if o.files[path] {
+		return true
+	}
+	name := filepath.Base(path)
+	if i := strings.LastIndex(name, ".tmp-"); strings.HasPrefix(name, ".") && i > 0 && o.files[filepath.Join(filepath.Dir(path), name[1:i])] {
+		return true
+	}
+	for _, dir := range o.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndNamedOutputs verifies that every key of the manifest is written to its file next to the manifest
+func TestEnd2EndNamedOutputs(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "outputs.yaml")
+	content := "outputs:\n  - key: app\n    file: out/app.yaml\n  - key: app.name\n    file: name.json\n"
+	assert.NoError(t, os.WriteFile(manifest, []byte(content), 0644))
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/jsonnet"
+	cfg.outputFile = filepath.Join(dir, "output.yaml")
+	cfg.outputsManifest = manifest
+	cfg.annotate = "none"
+
+	runMerge(cfg)
+
+	app, err := os.ReadFile(filepath.Join(dir, "out", "app.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "name: demo\nreplicas: 2\n", string(app))
+	name, err := os.ReadFile(filepath.Join(dir, "name.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "demo\n", string(name))
+	_, err = os.Stat(cfg.outputFile)
+	assert.NoError(t, err, "the output file is still written")
+}
+
+// TestLoadOutputsManifestErrors verifies that outputs without a key or file and duplicate files are rejected
+func TestLoadOutputsManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+	for content, message := range map[string]string{
+		"outputs:\n  - key: app\n": "output 1 of the outputs manifest needs a key and file",
+		"outputs:\n  - {key: a, file: a.yaml}\n  - {key: b, file: ./a.yaml}\n": "is listed more than once",
+		"outputs: [": "did not find expected node content",
+	} {
+		manifest := filepath.Join(dir, "outputs.yaml")
+		assert.NoError(t, os.WriteFile(manifest, []byte(content), 0644))
+		_, err := loadOutputsManifest(manifest)
+		if assert.Error(t, err, content) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+}
+
+// TestRenderNamedOutputs verifies that outputs are converted to the output format, keep their formatting, and missing keys are reported
+func TestRenderNamedOutputs(t *testing.T) {
+	document := "app:\n  name: demo\n  database: null\n"
+	contents, err := renderNamedOutputs([]namedOutput{{Key: "app", File: "app.tfvars.json"}}, document, "tfvars.json")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.tfvars.json": "{\n  \"name\": \"demo\",\n  \"database\": null\n}\n"}, contents)
+
+	contents, err = renderNamedOutputs([]namedOutput{{Key: "app", File: "app.yaml"}}, "app:\n  version: 1.10\n  id: '0123'\n  sha: 12345e7\n  name: demo\n", "yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.yaml": "version: 1.10\nid: \"0123\"\nsha: 12345e7\nname: demo\n"}, contents, "keys, numbers, and strings are written as they are")
+
+	_, err = renderNamedOutputs([]namedOutput{{Key: "app.database", File: "database.yaml"}}, document, "yaml")
+	assert.NoError(t, err, "keys with a null value exist")
+	_, err = renderNamedOutputs([]namedOutput{{Key: "infra", File: "infra.yaml"}}, document, "yaml")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "key infra of output file infra.yaml is not in the merged document")
+	}
+}
+
+// TestWriteFilesTogether verifies that no file is replaced if any of them cannot be written
+func TestWriteFilesTogether(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.yaml")
+	assert.NoError(t, os.WriteFile(app, []byte("old\n"), 0644))
+	blocked := filepath.Join(dir, "blocked")
+	assert.NoError(t, os.WriteFile(blocked, []byte{}, 0644))
+
+	err := writeFilesTogether(map[string]string{app: "new\n", filepath.Join(blocked, "infra.yaml"): "new\n"})
+	assert.Error(t, err)
+	content, err := os.ReadFile(app)
+	assert.NoError(t, err)
+	assert.Equal(t, "old\n", string(content))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "temporary files are removed")
+
+	assert.NoError(t, writeFilesTogether(map[string]string{app: "new\n"}))
+	content, err = os.ReadFile(app)
+	assert.NoError(t, err)
+	assert.Equal(t, "new\n", string(content))
+}
+
+// TestEnd2EndSplitOutputs verifies that every top-level key is written to a file named after it
+func TestEnd2EndSplitOutputs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/list-directives"
+	cfg.outputFile = filepath.Join(dir, "output.yaml")
+	cfg.outputDir = filepath.Join(dir, "out")
+	cfg.splitByTopLevelKey = true
+	cfg.outputFormat = "tfvars.json"
+
+	runMerge(cfg)
+
+	entries, err := os.ReadDir(cfg.outputDir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"network.tfvars.json"}, names)
+	content, err := os.ReadFile(filepath.Join(cfg.outputDir, "network.tfvars.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"allowlist\": [\n    \"10.0.0.1\",\n    \"10.0.0.2\",\n    \"10.0.0.3\"\n  ],\n  \"ports\": [\n    80,\n    443,\n    8080,\n    8443\n  ],\n  \"blocklist\": [\n    \"evil.example.com\"\n  ]\n}\n", string(content))
+}
+
+// TestEnd2EndOutputsNotMerged verifies that files written by a merge into a directory of the hierarchy are not merged by the next one
+func TestEnd2EndOutputsNotMerged(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base")
+	assert.NoError(t, os.Mkdir(base, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(base, "hierarchy.lst"), []byte("./\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(base, "app.yaml"), []byte("app:\n    name: demo\n"), 0644))
+	manifest := filepath.Join(dir, "outputs.yaml")
+	assert.NoError(t, os.WriteFile(manifest, []byte("outputs:\n  - key: app\n    file: base/app-only.yaml\n"), 0644))
+	cfg := cfgDefaults
+	cfg.basePath = base
+	cfg.outputFile = filepath.Join(base, "output.yaml")
+	cfg.outputsManifest = manifest
+	cfg.annotate = "none"
+
+	for i := 0; i < 2; i++ {
+		runMerge(cfg)
+		content, err := os.ReadFile(cfg.outputFile)
+		assert.NoError(t, err)
+		assert.Equal(t, "app:\n    name: demo\n", string(content))
+	}
+	content, err := os.ReadFile(filepath.Join(base, "app-only.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "name: demo\n", string(content))
+}
+
+// TestCheckSplitOutputDir verifies that split output files need a directory outside the hierarchy
+func TestCheckSplitOutputDir(t *testing.T) {
+	hierarchy := []layer{{path: "testdata/list-directives"}, {path: "."}}
+	assert.EqualError(t, checkSplitOutputDir("", hierarchy), "--split-by-top-level-key needs --output-dir")
+	assert.EqualError(t, checkSplitOutputDir("./", hierarchy),
+		"--output-dir ./ is a directory of the hierarchy, whose files would be merged by the next run")
+	assert.Error(t, checkSplitOutputDir("testdata/list-directives/", hierarchy))
+	assert.NoError(t, checkSplitOutputDir("out", hierarchy))
+}
+
+// TestRenderSplitOutputs verifies that top-level keys with dots are written as they are and keys with separators are rejected
+func TestRenderSplitOutputs(t *testing.T) {
+	contents, err := renderSplitOutputs("out", "app: &app {name: demo}\nexample.com: [a]\nworker: *app\n", "yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		filepath.Join("out", "app.yaml"):         "name: demo\n",
+		filepath.Join("out", "example.com.yaml"): "- a\n",
+		filepath.Join("out", "worker.yaml"):      "name: demo\n",
+	}, contents, "aliases are expanded, since the anchor is in another file")
+
+	for _, document := range []string{"a/b: 1\n", "..: 1\n", `"": 1` + "\n"} {
+		_, err = renderSplitOutputs("out", document, "yaml")
+		if assert.Error(t, err, document) {
+			assert.Contains(t, err.Error(), "cannot be used as a file name")
+		}
+	}
+}
+
+// TestRenderDocuments verifies that subtrees and the elements of lists become documents of their own in order
+func TestRenderDocuments(t *testing.T) {
+	document := `manifests:
+  - {kind: ConfigMap, metadata: {name: app}}
+  - {kind: Service, metadata: {name: app}}
+app:
+  name: demo
+disabled: null
+`
+	stream, err := renderDocuments(document, []string{"app", "manifests", "disabled", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, `---
+name: demo
+---
+kind: ConfigMap
+metadata:
+    name: app
+---
+kind: Service
+metadata:
+    name: app
+`, stream)
+}
+
+// TestEnd2EndDocuments verifies that the output file is a multi-document stream
+func TestEnd2EndDocuments(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/starlark"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.documents = []string{"app.name", "app.replicas"}
+
+	runMerge(cfg)
+
+	content, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "---\ndemo\n---\n3\n", string(content))
+}
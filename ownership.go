@@ -0,0 +1,146 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/transform"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ownership maps key paths to the teams owning them, like a CODEOWNERS file maps files to their owners.
+// Each team owns directories relative to the base path, the final value of an owned key
+// must be set by a file in one of them.
+type ownership struct {
+	// Teams maps team names to the directories they own
+	Teams map[string][]string `yaml:"teams"`
+	// Owners are matched in order, the last rule matching a key path wins
+	Owners []ownershipRule `yaml:"owners"`
+}
+
+// ownershipRule assigns the key paths matching a glob to teams.
+// In the glob, '*' matches a single key and '**' any number of keys, e.g. 'payments.**'.
+type ownershipRule struct {
+	Keys  string   `yaml:"keys"`
+	Teams []string `yaml:"teams"`
+}
+
+// ownershipViolation is an owned key whose final value was set by a file outside of the owning teams' directories
+type ownershipViolation struct {
+	key   string
+	file  string
+	teams []string
+}
+
+// loadOwnership reads an ownership file and checks that every rule refers to known teams
+func loadOwnership(file string) (*ownership, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	o := &ownership{}
+	if err := yaml.Unmarshal(content, o); err != nil {
+		return nil, newParseError(file, content, err)
+	}
+	for _, rule := range o.Owners {
+		if len(rule.Keys) == 0 {
+			return nil, fmt.Errorf("%s: ownership rule without keys", file)
+		}
+		for _, team := range rule.Teams {
+			if _, ok := o.Teams[team]; !ok {
+				return nil, fmt.Errorf("%s: unknown team %q owning %s", file, team, rule.Keys)
+			}
+		}
+	}
+	return o, nil
+}
+
+// owners returns the teams owning a key path, or nil if it is not owned
+func (o *ownership) owners(keyPath string) []string {
+	var teams []string
+	for _, rule := range o.Owners {
+		if transform.MatchKeys(strings.Split(rule.Keys, "."), strings.Split(keyPath, ".")) {
+			teams = rule.Teams
+		}
+	}
+	return teams
+}
+
+// check returns the owned keys of the provenance, whose final value was set by a file
+// outside of the owning teams' directories. Relative directories are relative to the base path.
+func (o *ownership) check(p *provenance, basePath string) []ownershipViolation {
+	keyPaths := make([]string, 0, len(p.keys))
+	for keyPath := range p.keys {
+		keyPaths = append(keyPaths, keyPath)
+	}
+	sort.Strings(keyPaths)
+
+	violations := []ownershipViolation{}
+	for _, keyPath := range keyPaths {
+		teams := o.owners(keyPath)
+		if len(teams) == 0 {
+			continue
+		}
+		files := p.keys[keyPath]
+		file := files[len(files)-1]
+		if !o.ownsFile(teams, basePath, file) {
+			violations = append(violations, ownershipViolation{key: keyPath, file: file, teams: teams})
+		}
+	}
+	return violations
+}
+
+// ownsFile reports whether the file is in a directory of one of the teams
+func (o *ownership) ownsFile(teams []string, basePath string, file string) bool {
+	for _, team := range teams {
+		for _, dir := range o.Teams[team] {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(basePath, dir)
+			}
+			if isWithinBase(dir, filepath.Dir(file)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOwnership fails if the final value of an owned key was set outside of the owning teams' directories
+func checkOwnership(ownersFile string, sources *provenance, basePath string) {
+	o, err := loadOwnership(ownersFile)
+	checkForError(errors.Wrapf(err, "Error loading ownership file %s", ownersFile))
+	violations := o.check(sources, basePath)
+	for _, violation := range violations {
+		outputLog.Error("Owned key set outside of the owning teams",
+			"key", violation.key,
+			"path", violation.file,
+			"teams", strings.Join(violation.teams, ","),
+		)
+	}
+	if len(violations) > 0 {
+		fail(outputLog, exitValidation, "Merged output violates key ownership",
+			"owners", ownersFile,
+			"count", len(violations),
+		)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOwnershipViolations verifies that owned keys set outside of the owning teams' directories are reported,
+// with the last matching rule deciding the owners
+func TestOwnershipViolations(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	o, err := loadOwnership("testdata/ownership/owners.yaml")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ownershipViolation{
+		{key: "test1.json", file: "testdata/json/three.json", teams: []string{"platform", "app"}},
+		{key: "test1.test1A.three", file: "testdata/yaml/one.yaml", teams: []string{"platform"}},
+		{key: "test2.list2A", file: "testdata/yaml/one.yaml", teams: []string{"platform"}},
+		{key: "test2.test2A", file: "testdata/test1/four.yaml", teams: []string{"platform"}},
+	}, o.check(sources, cfg.basePath))
+}
+
+// TestLoadOwnershipUnknownTeam verifies that rules must refer to teams defined in the file
+func TestLoadOwnershipUnknownTeam(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "owners.yaml")
+	if err := os.WriteFile(file, []byte("teams:\n  app: [./]\nowners:\n  - keys: app.**\n    teams: [payments]\n"), 0600); err != nil {
+		t.Fatalf("Error writing ownership file: %v", err)
+	}
+	_, err := loadOwnership(file)
+	assert.EqualError(t, err, file+`: unknown team "payments" owning app.**`)
+}
+
+// TestFailOwnership ensures that the application is correctly failing
+// if an owned key is set outside of the owning teams' directories.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitValidation (7) is a problem
+func TestFailOwnership(t *testing.T) {
+	if os.Getenv("TEST_FAIL_OWNERSHIP") == "1" {
+		cfg := cfgDefaults
+		cfg.basePath = "testdata/test1"
+
+		_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+		checkOwnership("testdata/ownership/owners.yaml", sources, cfg.basePath)
+
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailOwnership")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_OWNERSHIP=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitValidation {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitValidation)
+}
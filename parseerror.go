@@ -0,0 +1,100 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Number of lines shown before and after the offending line of a parse error
+const parseErrorContextLines = 2
+
+// yamlErrorLine matches the line number in errors of yaml.v3, e.g. "yaml: line 3: did not find expected key"
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// parseError is an error parsing an input file.
+// yaml.v3 only reports the line, not the column, so the surrounding lines are kept as context.
+type parseError struct {
+	file    string
+	line    int
+	message string
+	// context shows the offending line and its neighbours with line numbers, or is empty if the line is unknown
+	context string
+}
+
+func (e *parseError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.message)
+	}
+	return fmt.Sprintf("%s: %s", e.file, e.message)
+}
+
+// newParseError converts an error of yaml.v3 to a parseError with the surrounding content of the file
+func newParseError(file string, content []byte, err error) *parseError {
+	message := err.Error()
+	if typeError, ok := err.(*yaml.TypeError); ok && len(typeError.Errors) > 0 {
+		message = typeError.Errors[0]
+		if len(typeError.Errors) > 1 {
+			message += fmt.Sprintf(" (and %d more)", len(typeError.Errors)-1)
+		}
+	}
+	e := &parseError{file: file, message: message}
+	if match := yamlErrorLine.FindStringSubmatch(message); match != nil {
+		e.line, _ = strconv.Atoi(match[1])
+		e.message = strings.TrimPrefix(message, match[0])
+	} else {
+		e.message = strings.TrimPrefix(message, "yaml: ")
+	}
+	e.context = contentContext(string(content), e.line)
+	return e
+}
+
+// contentContext returns the lines around line, e.g.
+//
+//	  2 | a:
+//	> 3 |  b: 1
+//	  4 | c: 2
+func contentContext(content string, line int) string {
+	// Files with Windows line endings have no carriage returns in the context
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(content, "\r\n", "\n"), "\n"), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	first := line - parseErrorContextLines
+	if first < 1 {
+		first = 1
+	}
+	last := line + parseErrorContextLines
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(strconv.Itoa(last))
+	var context strings.Builder
+	for number := first; number <= last; number++ {
+		marker := " "
+		if number == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&context, "%s %*d | %s\n", marker, width, number, strings.TrimRight(lines[number-1], "\r"))
+	}
+	return strings.TrimRight(context.String(), "\n")
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// passthroughDir is the directory of a layer holding files that are passed through without merging,
+// e.g. certificates or scripts
+const passthroughDir = "files"
+
+// passthroughFile is a file of a layer's passthroughDir
+type passthroughFile struct {
+	// name is the slash-separated path relative to the passthroughDir
+	name string
+	// path is the path of the file in the layer
+	path string
+}
+
+// collectPassthroughFiles returns the files of all passthroughDirs in the hierarchy sorted by name.
+// A file of a later layer replaces the file with the same name of an earlier layer.
+func collectPassthroughFiles(hierarchy []layer) []passthroughFile {
+	files := map[string]string{}
+	for _, includeLayer := range hierarchy {
+		collectPassthroughDir(files, filepath.Join(includeLayer.path, passthroughDir), "", nil)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]passthroughFile, 0, len(names))
+	for _, name := range names {
+		result = append(result, passthroughFile{name: name, path: files[name]})
+	}
+	return result
+}
+
+// collectPassthroughDir adds the files below dir to files, a missing dir has no files.
+// Symlinked directories are followed unless they point to one of the parents of dir, which would loop forever.
+func collectPassthroughDir(files map[string]string, dir string, prefix string, parents []os.FileInfo) {
+	info, err := inputFS.Stat(dir)
+	if err != nil {
+		return
+	}
+	for _, parent := range parents {
+		if os.SameFile(parent, info) {
+			fail(mergerLog, exitPath, "Symlink loop in passthrough directory", "path", dir)
+			return
+		}
+	}
+	parents = append(parents, info)
+	entries, err := inputFS.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		filePath := filepath.Join(dir, entry.Name())
+		name := path.Join(prefix, entry.Name())
+		symlink, isDir := isSymlinkedDir(filePath, entry)
+		if symlink && !followSymlinks {
+			mergerLog.Debug("Ignoring symlink", "path", filePath)
+			continue
+		}
+		if isDir {
+			collectPassthroughDir(files, filePath, name, parents)
+			continue
+		}
+		if previous, ok := files[name]; ok {
+			mergerLog.Debug("Replacing passthrough file", "name", name, "path", filePath, "replaced", previous)
+		}
+		files[name] = filePath
+	}
+}
+
+// embedPassthroughFiles sets the content of the files as strings below the dot-separated key path, keyed by their names
+func embedPassthroughFiles(data map[string]interface{}, keyPath string, files []passthroughFile, sources *provenance) {
+	node := data
+	for _, key := range strings.Split(keyPath, ".") {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			node[key] = child
+		}
+		node = child
+	}
+	for _, file := range files {
+		content, err := inputFS.ReadFile(file.path)
+		checkForErrorCode(err, exitPath)
+		mergerLog.Info("Embedding file", "path", file.path, "key", keyPath, "name", file.name)
+		sources.addFile(file.path, content)
+		node[file.name] = string(content)
+	}
+}
+
+// copyPassthroughFiles copies the files into dir, keeping their names relative to the passthroughDir
+func copyPassthroughFiles(dir string, files []passthroughFile, sources *provenance) {
+	for _, file := range files {
+		content, err := inputFS.ReadFile(file.path)
+		checkForErrorCode(err, exitPath)
+		target := filepath.Join(dir, filepath.FromSlash(file.name))
+		outputLog.Info("Copying file", "path", file.path, "target", target)
+		sources.addFile(file.path, content)
+		err = os.MkdirAll(filepath.Dir(target), 0770)
+		checkForErrorCode(err, exitWrite)
+		err = os.WriteFile(target, content, 0660)
+		checkForErrorCode(err, exitWrite)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestCollectPassthroughFiles verifies that files of later layers replace files with the same name
+func TestCollectPassthroughFiles(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/passthrough"
+
+	files := collectPassthroughFiles(processHierarchy(cfg))
+	assert.Equal(t, []passthroughFile{
+		{name: "scripts/start.sh", path: "testdata/passthrough/defaults/files/scripts/start.sh"},
+		{name: "tls.crt", path: "testdata/passthrough/files/tls.crt"},
+	}, files)
+}
+
+// TestEnd2EndPassthroughEmbedSuccess verifies that the files are embedded as strings below the key
+func TestEnd2EndPassthroughEmbedSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/passthrough"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.passthrough = "embed"
+	cfg.passthroughKey = "app.files"
+
+	runMerge(cfg)
+
+	content, err := os.ReadFile(cfg.outputFile)
+	if err != nil {
+		t.Fatalf("Error reading output file: %v", err)
+	}
+	var result map[string]interface{}
+	assert.NoError(t, yaml.Unmarshal(content, &result))
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{
+			"name":     "demo",
+			"replicas": 2,
+			"files": map[string]interface{}{
+				"scripts/start.sh": "#!/bin/sh\nexec demo\n",
+				"tls.crt":          "-----BEGIN CERTIFICATE-----\nteam\n-----END CERTIFICATE-----\n",
+			},
+		},
+	}, result)
+}
+
+// TestEnd2EndPassthroughCopySuccess verifies that the files are copied next to the output file
+func TestEnd2EndPassthroughCopySuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/passthrough"
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	cfg.passthrough = "copy"
+
+	runMerge(cfg)
+
+	for name, source := range map[string]string{
+		"tls.crt":          "testdata/passthrough/files/tls.crt",
+		"scripts/start.sh": "testdata/passthrough/defaults/files/scripts/start.sh",
+	} {
+		expected, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatalf("Error reading passthrough file: %v", err)
+		}
+		result, err := os.ReadFile(filepath.Join(filepath.Dir(cfg.outputFile), name))
+		if err != nil {
+			t.Fatalf("Error reading copied file: %v", err)
+		}
+		assert.Equal(t, string(expected), string(result))
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache keeps the merged results of a long-running hierarchy process,
+// so requests with identical inputs are not merged again.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+)
+
+// logger receives the messages of the package, see SetLogger
+var logger logging.Logger = logging.Discard
+
+// SetLogger sets the logger of the package, which logs nothing by default. A nil logger turns logging off again.
+// It is meant to be called once before the package is used, it must not be called concurrently with it.
+func SetLogger(l logging.Logger) {
+	if l == nil {
+		l = logging.Discard
+	}
+	logger = l
+}
+
+// Key identifies a merged result
+type Key struct {
+	// Hierarchy is a digest of the hierarchy the result was merged from, see Digest
+	Hierarchy string
+	// Facts is a canonical encoding of the facts used for the request, see NewKey
+	Facts string
+	// Query selects the part of the result that was requested
+	Query string
+}
+
+// NewKey creates a key from a hierarchy digest, the facts of a request, and its query.
+// The order of the facts does not matter.
+func NewKey(hierarchy string, facts map[string]string, query string) Key {
+	names := make([]string, 0, len(facts))
+	for name := range facts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		// Separate with NUL bytes, which can't appear in environment variables or query parameters
+		h.Write([]byte(name + "\x00" + facts[name] + "\x00"))
+	}
+	return Key{Hierarchy: hierarchy, Facts: hex.EncodeToString(h.Sum(nil)), Query: query}
+}
+
+// Digest returns a digest of the hierarchy, i.e. the ordered list of its directories
+func Digest(layers []string) string {
+	h := sha256.New()
+	for _, layer := range layers {
+		h.Write([]byte(layer + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Files is the state of the files and directories a result was merged from
+type Files map[string]fileState
+
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// Stat records the current state of files and directories.
+// Call it before merging, so changes made during the merge invalidate the result.
+// Include the directories of the hierarchy to notice added and removed files.
+func Stat(paths ...string) Files {
+	files := Files{}
+	for _, path := range paths {
+		files[path] = stat(path)
+	}
+	return files
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// Changed reports whether any file differs from its recorded state, e.g. to poll for changes
+func (f Files) Changed() bool {
+	for path, state := range f {
+		if stat(path) != state {
+			return true
+		}
+	}
+	return false
+}
+
+type entry struct {
+	value   []byte
+	files   Files
+	expires time.Time
+}
+
+// Cache stores merged results for a limited time. It is safe for concurrent use.
+type Cache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[Key]entry
+}
+
+// New creates a cache keeping results for ttl. A ttl of 0 keeps them until a file changes.
+func New(ttl time.Duration) *Cache {
+	return &Cache{ttl: ttl, now: time.Now, entries: map[Key]entry{}}
+}
+
+// Get returns the result stored for key, unless it expired or one of its files changed
+func (c *Cache) Get(key Key) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		logger.Debug("Dropped expired result", "hierarchy", key.Hierarchy)
+		delete(c.entries, key)
+		return nil, false
+	}
+	if e.files.Changed() {
+		logger.Debug("Dropped result merged from changed files", "hierarchy", key.Hierarchy)
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Put stores the result for key together with the state of the files it was merged from
+func (c *Cache) Put(key Key, value []byte, files Files) {
+	c.PutFor(key, value, files, 0)
+}
+
+// PutFor stores the result for key like Put, but keeps it for at most ttl, e.g. when it was merged from
+// inputs which are not files and can change without notice. A ttl of 0 is the ttl of the cache.
+func (c *Cache) PutFor(key Key, value []byte, files Files, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 && (ttl <= 0 || c.ttl < ttl) {
+		ttl = c.ttl
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.now().Add(ttl)
+	}
+	c.entries[key] = entry{value: value, files: files, expires: expires}
+}
+
+// Invalidate removes all results merged from path, e.g. when a file watcher reports a change
+func (c *Cache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	for key, e := range c.entries {
+		if _, ok := e.files[path]; ok {
+			delete(c.entries, key)
+			count++
+		}
+	}
+	if count > 0 {
+		logger.Debug("Invalidated results", "path", path, "count", count)
+	}
+}
+
+// Purge removes all results
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logger.Debug("Purged results", "count", len(c.entries))
+	c.entries = map[Key]entry{}
+}
+
+// Len returns the number of stored results, including expired ones that were not requested since
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewKey verifies that keys only depend on the content of the facts, not their order
+func TestNewKey(t *testing.T) {
+	a := NewKey("h", map[string]string{"env": "dev", "region": "us"}, "")
+	b := NewKey("h", map[string]string{"region": "us", "env": "dev"}, "")
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, NewKey("h", map[string]string{"env": "prod", "region": "us"}, ""))
+	assert.NotEqual(t, a, NewKey("h", map[string]string{"env": "dev", "region": "us"}, "app"))
+	assert.NotEqual(t, Digest([]string{"a", "b"}), Digest([]string{"b", "a"}))
+}
+
+// TestCacheTTL verifies that results expire after the TTL
+func TestCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+	key := NewKey("h", nil, "")
+
+	c.Put(key, []byte("merged"), Stat())
+	value, ok := c.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, "merged", string(value))
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get(key)
+	assert.False(t, ok)
+	assert.Equal(t, 0, c.Len())
+}
+
+// TestCachePutFor verifies that a result kept for a shorter time expires first, and never later than the TTL of the cache
+func TestCachePutFor(t *testing.T) {
+	now := time.Now()
+	c := New(0)
+	c.now = func() time.Time { return now }
+	short := NewKey("short", nil, "")
+	long := NewKey("long", nil, "")
+
+	c.PutFor(short, []byte("remote"), Stat(), time.Minute)
+	c.Put(long, []byte("local"), Stat())
+	now = now.Add(time.Minute)
+	_, ok := c.Get(short)
+	assert.False(t, ok)
+	_, ok = c.Get(long)
+	assert.True(t, ok)
+
+	c = New(time.Minute)
+	c.now = func() time.Time { return now }
+	c.PutFor(short, []byte("remote"), Stat(), time.Hour)
+	now = now.Add(time.Minute)
+	_, ok = c.Get(short)
+	assert.False(t, ok)
+}
+
+// TestCacheFileChange verifies that results are invalidated when a file is changed, added or removed
+func TestCacheFileChange(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.yaml")
+	if err := os.WriteFile(file, []byte("a: 1\n"), 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	c := New(0)
+	key := NewKey("h", nil, "")
+
+	c.Put(key, []byte("merged"), Stat(file))
+	_, ok := c.Get(key)
+	assert.True(t, ok)
+
+	if err := os.WriteFile(file, []byte("a: 22\n"), 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	_, ok = c.Get(key)
+	assert.False(t, ok)
+
+	c.Put(key, []byte("merged"), Stat(file))
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	_, ok = c.Get(key)
+	assert.False(t, ok)
+}
+
+// TestCacheInvalidate verifies that only results depending on a file are invalidated
+func TestCacheInvalidate(t *testing.T) {
+	c := New(0)
+	dev := NewKey("h", map[string]string{"env": "dev"}, "")
+	prod := NewKey("h", map[string]string{"env": "prod"}, "")
+	c.Put(dev, []byte("dev"), Files{"dev.yaml": {}})
+	c.Put(prod, []byte("prod"), Files{"prod.yaml": {}})
+
+	c.Invalidate("dev.yaml")
+	_, ok := c.Get(dev)
+	assert.False(t, ok)
+	_, ok = c.Get(prod)
+	assert.True(t, ok)
+
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+}
+
+// TestSetLogger verifies that dropped results are logged
+func TestSetLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})))
+	defer SetLogger(nil)
+	c := New(time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	key := NewKey("h", nil, "")
+	c.Put(key, []byte("a"), nil)
+	now = now.Add(time.Minute)
+	_, ok := c.Get(key)
+	assert.False(t, ok)
+	c.Put(key, []byte("a"), Files{"a.yaml": {}})
+	c.Invalidate("a.yaml")
+	c.Purge()
+	assert.Equal(t, "level=DEBUG msg=\"Dropped expired result\" hierarchy=h\n"+
+		"level=DEBUG msg=\"Invalidated results\" path=a.yaml count=1\n"+
+		"level=DEBUG msg=\"Purged results\" count=0\n", out.String())
+}
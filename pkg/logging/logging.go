@@ -0,0 +1,235 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging defines the logger used by the hierarchy packages, so programs embedding them
+// can plug in their own logger or turn logging off entirely.
+// It also creates slog loggers, which filter messages by the level of their component.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Logger receives the log messages of the hierarchy packages.
+// The arguments after the message are alternating keys and values, like in log/slog.
+// A *slog.Logger implements Logger as is, other loggers like zap need a small adapter.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+var _ Logger = (*slog.Logger)(nil)
+
+// Discard is a Logger dropping all messages
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...interface{}) {}
+func (discard) Info(string, ...interface{})  {}
+func (discard) Warn(string, ...interface{})  {}
+func (discard) Error(string, ...interface{}) {}
+
+// LevelTrace is more verbose than debug, e.g. for a diff after every merged file
+const LevelTrace = slog.LevelDebug - 4
+
+// LevelQuiet is above all levels, so no message is logged at all
+const LevelQuiet = slog.LevelError + 4
+
+// ComponentKey is the attribute naming the component of a logger, see Levels
+const ComponentKey = "component"
+
+// Components of hierarchy, each of them can log with a different level
+const (
+	// Resolver reads the hierarchy file and resolves its directories
+	Resolver = "resolver"
+	// Merger reads and merges the files in the hierarchy
+	Merger = "merger"
+	// Substitution replaces environment variables
+	Substitution = "substitution"
+	// Output validates and writes the merged result
+	Output = "output"
+)
+
+// Components lists all components in the order of processing
+var Components = []string{Resolver, Merger, Substitution, Output}
+
+// Levels are the minimum levels of messages that are logged
+type Levels struct {
+	// Default is the level of messages without a component, and of components not listed in Components
+	Default slog.Level
+	// Components overrides the level of single components
+	Components map[string]slog.Level
+}
+
+// level returns the minimum level of a component
+func (l Levels) level(component string) slog.Level {
+	if level, ok := l.Components[component]; ok {
+		return level
+	}
+	return l.Default
+}
+
+// ParseLevel parses a level name, i.e. trace, debug, info, warn, error, or quiet
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "trace") {
+		return LevelTrace, nil
+	}
+	if strings.EqualFold(name, "quiet") {
+		return LevelQuiet, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// ParseLevels parses comma-separated component levels, e.g. "merger=debug,output=warn"
+func ParseLevels(s string, defaultLevel slog.Level) (Levels, error) {
+	levels := Levels{Default: defaultLevel, Components: map[string]slog.Level{}}
+	for _, setting := range strings.Split(s, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 || !isComponent(parts[0]) {
+			return levels, fmt.Errorf("invalid component log level %q, expected <%s>=<level>", setting, strings.Join(Components, "|"))
+		}
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return levels, err
+		}
+		levels.Components[parts[0]] = level
+	}
+	return levels, nil
+}
+
+func isComponent(name string) bool {
+	for _, component := range Components {
+		if name == component {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats of the log output
+const (
+	// FormatText writes key=value pairs, one message per line
+	FormatText = "text"
+	// FormatJSON writes a JSON object per line, e.g. for log pipelines
+	FormatJSON = "json"
+)
+
+// Formats lists all formats of the log output
+var Formats = []string{FormatText, FormatJSON}
+
+// New creates a logger writing text or JSON to w, which filters messages by the level of their component.
+// Use logger.With(ComponentKey, Merger) to create the logger of a component.
+func New(w io.Writer, format string, levels Levels) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level:       LevelTrace,
+		ReplaceAttr: replaceAttr,
+	}
+	var handler slog.Handler
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(NewHandler(handler, levels))
+}
+
+// replaceAttr names the trace level, which slog would print as DEBUG-4,
+// and logs errors by their message, without the stack trace of github.com/pkg/errors
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	if err, ok := a.Value.Any().(error); ok {
+		a.Value = slog.StringValue(err.Error())
+	}
+	return a
+}
+
+// NewHandler wraps a handler, so it only receives messages at or above the level of their component.
+// The wrapped handler should accept all levels.
+func NewHandler(next slog.Handler, levels Levels) slog.Handler {
+	return &componentHandler{next: next, levels: levels}
+}
+
+type componentHandler struct {
+	next      slog.Handler
+	levels    Levels
+	component string
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.levels.level(h.component) && h.next.Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.next.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, attr := range attrs {
+		if attr.Key == ComponentKey {
+			component = attr.Value.String()
+		}
+	}
+	return &componentHandler{next: h.next.WithAttrs(attrs), levels: h.levels, component: component}
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{next: h.next.WithGroup(name), levels: h.levels, component: h.component}
+}
+
+// String lists the levels, e.g. for debug output
+func (l Levels) String() string {
+	settings := []string{"default=" + levelName(l.Default)}
+	components := make([]string, 0, len(l.Components))
+	for component := range l.Components {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		settings = append(settings, component+"="+levelName(l.Components[component]))
+	}
+	return strings.Join(settings, ",")
+}
+
+func levelName(level slog.Level) string {
+	switch level {
+	case LevelTrace:
+		return "trace"
+	case LevelQuiet:
+		return "quiet"
+	}
+	return strings.ToLower(level.String())
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseLevels verifies the parsing of component levels
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("merger=debug, output=WARN,resolver=trace,substitution=quiet", slog.LevelInfo)
+	assert.NoError(t, err)
+	assert.Equal(t, Levels{
+		Default:    slog.LevelInfo,
+		Components: map[string]slog.Level{Merger: slog.LevelDebug, Output: slog.LevelWarn, Resolver: LevelTrace, Substitution: LevelQuiet},
+	}, levels)
+	assert.Equal(t, "default=info,merger=debug,output=warn,resolver=trace,substitution=quiet", levels.String())
+
+	_, err = ParseLevels("parser=debug", slog.LevelInfo)
+	assert.EqualError(t, err, `invalid component log level "parser=debug", expected <resolver|merger|substitution|output>=<level>`)
+	_, err = ParseLevels("merger=loud", slog.LevelInfo)
+	assert.EqualError(t, err, `unknown log level "loud"`)
+}
+
+// TestComponentLevels verifies that messages are filtered by the level of their component
+func TestComponentLevels(t *testing.T) {
+	var out bytes.Buffer
+	levels := Levels{Default: slog.LevelInfo, Components: map[string]slog.Level{Merger: LevelTrace, Output: slog.LevelWarn, Substitution: LevelQuiet}}
+	removeTime := func(groups []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return replaceAttr(groups, a)
+	}
+	logger := slog.New(NewHandler(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: LevelTrace, ReplaceAttr: removeTime}), levels))
+
+	logger.Debug("hidden")
+	logger.Info("Hierarchy")
+	logger.With(ComponentKey, Merger).Log(context.Background(), LevelTrace, "Merged file", "path", "a.yaml")
+	logger.With(ComponentKey, Output).Info("hidden")
+	logger.With(ComponentKey, Substitution).Error("hidden")
+	logger.With(ComponentKey, Output).Warn("Careful", "error", errors.New("disk full"))
+
+	assert.Equal(t, "level=INFO msg=Hierarchy\n"+
+		"level=TRACE msg=\"Merged file\" component=merger path=a.yaml\n"+
+		"level=WARN msg=Careful component=output error=\"disk full\"\n", out.String())
+}
+
+// TestJSONFormat verifies that every message is written as a JSON object with its fields
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, FormatJSON, Levels{Default: LevelTrace})
+
+	logger.With(ComponentKey, Merger).Log(context.Background(), LevelTrace, "Completed merging all files", "count", 3, "error", errors.New("none"))
+
+	var message map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &message))
+	delete(message, slog.TimeKey)
+	assert.Equal(t, map[string]interface{}{
+		"level":     "TRACE",
+		"msg":       "Completed merging all files",
+		"component": "merger",
+		"count":     float64(3),
+		"error":     "none",
+	}, message)
+}
+
+// TestDiscard verifies that Discard can be used as a Logger
+func TestDiscard(t *testing.T) {
+	var l Logger = Discard
+	l.Error("dropped", "key", "value")
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics collects counters, gauges, and histograms of a long-running hierarchy process
+// and exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, suitable for merge durations
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry holds metrics in the order they were registered. It is safe for concurrent use.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []*metric
+}
+
+// metric is a named metric with one series for every combination of label values
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+// series holds the value of a counter or gauge, or the observations of a histogram
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// Counter is a value that only increases
+type Counter struct {
+	registry *Registry
+	metric   *metric
+}
+
+// Gauge is a value that can be set
+type Gauge struct {
+	registry *Registry
+	metric   *metric
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	registry *Registry
+	metric   *metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labels []string) *metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// Counter registers a counter with the names of its labels
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	return &Counter{registry: r, metric: r.register(name, help, "counter", nil, labels)}
+}
+
+// Gauge registers a gauge with the names of its labels
+func (r *Registry) Gauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{registry: r, metric: r.register(name, help, "gauge", nil, labels)}
+}
+
+// Histogram registers a histogram with the upper bounds of its buckets and the names of its labels
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{registry: r, metric: r.register(name, help, "histogram", buckets, labels)}
+}
+
+// get returns the series of the label values, creating it on first use. The registry must be locked.
+func (m *metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: labelValues, counts: make([]uint64, len(m.buckets))}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add increases the counter of the label values. Negative values are ignored.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+	c.registry.mutex.Lock()
+	defer c.registry.mutex.Unlock()
+	c.metric.get(labelValues).value += value
+}
+
+// Inc increases the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.registry.mutex.Lock()
+	defer g.registry.mutex.Unlock()
+	g.metric.get(labelValues).value = value
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.registry.mutex.Lock()
+	defer h.registry.mutex.Unlock()
+	s := h.metric.get(labelValues)
+	for i, bound := range h.metric.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// WriteText writes all metrics in the Prometheus text format. Series are sorted by their label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var b strings.Builder
+	for _, m := range r.metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatValue(s.value))
+				continue
+			}
+			for i, bound := range m.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatValue(bound)), s.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP writes all metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(w)
+}
+
+// formatLabels formats label names and values as {name="value",...}, with an optional extra label, e.g. le of a bucket
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue formats a sample value, using the special values of the text format for infinity and NaN
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteText verifies the text format of counters, gauges, and histograms
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	merges := registry.Counter("merges_total", "Merges by result.", "result")
+	last := registry.Gauge("last_success_timestamp_seconds", "Time of the last success.")
+	duration := registry.Histogram("merge_duration_seconds", "Duration of merges.", []float64{0.1, 1})
+
+	merges.Inc("success")
+	merges.Add(2, "success")
+	merges.Inc(`fail"ure`)
+	merges.Add(-1, "success")
+	last.Set(1625054400)
+	duration.Observe(0.05)
+	duration.Observe(0.5)
+	duration.Observe(3)
+
+	var b strings.Builder
+	assert.NoError(t, registry.WriteText(&b))
+	assert.Equal(t, `# HELP merges_total Merges by result.
+# TYPE merges_total counter
+merges_total{result="fail\"ure"} 1
+merges_total{result="success"} 3
+# HELP last_success_timestamp_seconds Time of the last success.
+# TYPE last_success_timestamp_seconds gauge
+last_success_timestamp_seconds 1.6250544e+09
+# HELP merge_duration_seconds Duration of merges.
+# TYPE merge_duration_seconds histogram
+merge_duration_seconds_bucket{le="0.1"} 1
+merge_duration_seconds_bucket{le="1"} 2
+merge_duration_seconds_bucket{le="+Inf"} 3
+merge_duration_seconds_sum 3.55
+merge_duration_seconds_count 3
+`, b.String())
+}
+
+// TestServeHTTP verifies the content type of the metrics endpoint
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("merges_total", "Merges.").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "merges_total 1\n")
+}
+
+// TestLabelCount verifies that the number of label values must match the labels
+func TestLabelCount(t *testing.T) {
+	counter := NewRegistry().Counter("merges_total", "Merges.", "result")
+	assert.Panics(t, func() { counter.Inc() })
+}
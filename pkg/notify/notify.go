@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify lets long-running hierarchy processes report their state
+// to a process supervisor (systemd or the Windows service control manager).
+package notify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Ready tells the service manager that startup is finished
+func Ready() error {
+	return send("READY=1")
+}
+
+// Reloading tells the service manager that the configuration is being reloaded
+func Reloading() error {
+	return send("RELOADING=1")
+}
+
+// Stopping tells the service manager that the process is shutting down
+func Stopping() error {
+	return send("STOPPING=1")
+}
+
+// Watchdog sends a keep-alive ping to the service manager
+func Watchdog() error {
+	return send("WATCHDOG=1")
+}
+
+// WatchdogInterval returns how often Watchdog should be called.
+// It returns 0 if the service manager did not enable the watchdog for this process.
+// The interval is half of the configured timeout, as recommended by sd_watchdog_enabled(3).
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// send writes a state string to the socket in NOTIFY_SOCKET.
+// It is a no-op if the process was not started by a service manager supporting sd_notify.
+func send(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
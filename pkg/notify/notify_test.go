@@ -0,0 +1,67 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReadySendsState verifies that the state string is delivered to the socket in NOTIFY_SOCKET
+func TestReadySendsState(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets not supported: %v", err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	assert.NoError(t, Ready())
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+// TestSendWithoutSocket verifies that notifications are silently dropped outside of a service manager
+func TestSendWithoutSocket(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NoError(t, Watchdog())
+}
+
+// TestWatchdogInterval verifies the parsing of WATCHDOG_USEC and WATCHDOG_PID
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+
+	os.Setenv("WATCHDOG_USEC", "10000000")
+	assert.Equal(t, 5*time.Second, WatchdogInterval())
+
+	os.Setenv("WATCHDOG_PID", "1")
+	assert.Equal(t, time.Duration(0), WatchdogInterval())
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"golang.org/x/sys/windows/svc"
+)
+
+// RunService runs fn under the Windows service control manager.
+// It returns false without calling fn if the process runs in an interactive session.
+// fn must return once stop is closed.
+func RunService(name string, fn func(stop <-chan struct{}) error) (bool, error) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return false, err
+	}
+	h := &handler{fn: fn}
+	if err := svc.Run(name, h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
+
+// handler translates service control requests into closing the stop channel
+type handler struct {
+	fn  func(stop <-chan struct{}) error
+	err error
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- h.fn(stop)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			return h.exitCode()
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(stop)
+				h.err = <-done
+				return h.exitCode()
+			}
+		}
+	}
+}
+
+func (h *handler) exitCode() (bool, uint32) {
+	if h.err != nil {
+		return true, 1
+	}
+	return false, 0
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package resolver replaces references like ${vault:secret/data/app#password} in the merged output
+// with values looked up in secret stores or other backends. Each backend resolves the references of a scheme.
+// Built-in backends are added by hierarchy, library users can register their own with Register.
+package resolver
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+)
+
+// logger receives the messages of the package, see SetLogger
+var logger logging.Logger = logging.Discard
+
+// SetLogger sets the logger of the package, which logs nothing by default. A nil logger turns logging off again.
+// It is meant to be called once before the package is used, it must not be called concurrently with it.
+func SetLogger(l logging.Logger) {
+	if l == nil {
+		l = logging.Discard
+	}
+	logger = l
+}
+
+// Reference matches references of any scheme, e.g. ${vault:secret/data/app#password}.
+// The first group is the scheme, the second one the reference within the backend.
+var Reference = regexp.MustCompile(`\$\{([a-z][a-z0-9-]*):([^}]+)\}`)
+
+// ValueResolver looks up the value of a reference without its scheme
+type ValueResolver interface {
+	Resolve(reference string) (string, error)
+}
+
+// Func adapts a function to a ValueResolver
+type Func func(reference string) (string, error)
+
+// Resolve calls the function
+func (f Func) Resolve(reference string) (string, error) {
+	return f(reference)
+}
+
+// Resolvers maps schemes to the resolvers of their references
+type Resolvers map[string]ValueResolver
+
+var (
+	mutex      sync.RWMutex
+	registered = Resolvers{}
+)
+
+// Register adds a resolver for the references of a scheme. It panics if the scheme is invalid or already registered.
+// Registered resolvers take precedence over the built-in ones of hierarchy, so a backend can be replaced.
+func Register(scheme string, resolver ValueResolver) {
+	if !Reference.MatchString("${" + scheme + ":x}") {
+		panic(fmt.Sprintf("resolver: invalid scheme %q", scheme))
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := registered[scheme]; ok {
+		panic("resolver: Register called twice for " + scheme)
+	}
+	registered[scheme] = resolver
+}
+
+// Registered returns a copy of all registered resolvers
+func Registered() Resolvers {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	resolvers := Resolvers{}
+	for scheme, resolver := range registered {
+		resolvers[scheme] = resolver
+	}
+	return resolvers
+}
+
+// Schemes returns the schemes of the resolvers in sorted order
+func (r Resolvers) Schemes() []string {
+	schemes := make([]string, 0, len(r))
+	for scheme := range r {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Replace replaces all references with a known scheme in a string with their values.
+// Every reference is resolved once. A reference that cannot be resolved is kept and passed to failed.
+// References of unknown schemes are left alone.
+// The values are inserted as they are, so references in structured documents are best replaced in their single strings.
+func (r Resolvers) Replace(str string, failed func(scheme string, reference string, err error)) string {
+	resolved := map[string]bool{}
+	for _, match := range Reference.FindAllStringSubmatch(str, -1) {
+		resolver, ok := r[match[1]]
+		if !ok || resolved[match[0]] {
+			continue
+		}
+		resolved[match[0]] = true
+		value, err := resolver.Resolve(match[2])
+		if err != nil {
+			logger.Debug("Failed to resolve reference", "scheme", match[1], "reference", match[2], "error", err)
+			if failed != nil {
+				failed(match[1], match[2], err)
+			}
+			continue
+		}
+		logger.Debug("Resolved reference", "scheme", match[1], "reference", match[2])
+		str = strings.ReplaceAll(str, match[0], value)
+	}
+	return str
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resolver
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplace verifies that references of known schemes are resolved once and failures are reported
+func TestReplace(t *testing.T) {
+	calls := map[string]int{}
+	resolvers := Resolvers{
+		"cmdb": Func(func(reference string) (string, error) {
+			calls[reference]++
+			if reference == "missing" {
+				return "", errors.New("not found")
+			}
+			return "value of " + reference, nil
+		}),
+	}
+	failures := []string{}
+	output := resolvers.Replace("a: ${cmdb:host}\nb: ${cmdb:host}\nc: ${cmdb:missing}\nd: ${vault:x#y}\ne: ${HOME}\n",
+		func(scheme string, reference string, err error) {
+			failures = append(failures, scheme+":"+reference+": "+err.Error())
+		})
+	assert.Equal(t, "a: value of host\nb: value of host\nc: ${cmdb:missing}\nd: ${vault:x#y}\ne: ${HOME}\n", output)
+	assert.Equal(t, []string{"cmdb:missing: not found"}, failures)
+	assert.Equal(t, map[string]int{"host": 1, "missing": 1}, calls)
+}
+
+// TestRegister verifies that resolvers are registered once per valid scheme
+func TestRegister(t *testing.T) {
+	Register("test-registry", Func(func(reference string) (string, error) { return reference, nil }))
+	assert.Contains(t, Registered().Schemes(), "test-registry")
+	assert.Panics(t, func() { Register("test-registry", nil) })
+	assert.Panics(t, func() { Register("Invalid_Scheme", nil) })
+
+	output := Registered().Replace("${test-registry:value}", nil)
+	assert.Equal(t, "value", output)
+}
+
+// TestSetLogger verifies that resolved references are logged without their values
+func TestSetLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})))
+	defer SetLogger(nil)
+	resolvers := Resolvers{
+		"cmdb": Func(func(reference string) (string, error) { return "s3cr3t", nil }),
+		"down": Func(func(reference string) (string, error) { return "", errors.New("unavailable") }),
+	}
+	assert.Equal(t, "s3cr3t ${down:x}", resolvers.Replace("${cmdb:app/password} ${down:x}", nil))
+	assert.Equal(t, "level=DEBUG msg=\"Resolved reference\" scheme=cmdb reference=app/password\n"+
+		"level=DEBUG msg=\"Failed to resolve reference\" scheme=down reference=x error=unavailable\n", out.String())
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"os"
+)
+
+// osRoot uses os.Root, which guarantees that no file outside of the directory is opened
+type osRoot struct {
+	root *os.Root
+}
+
+func openRootFS(dir string) (rootFS, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &osRoot{root: root}, nil
+}
+
+func (r *osRoot) open(rel string) (*os.File, error) {
+	return r.root.Open(rel)
+}
+
+func (r *osRoot) stat(rel string) (os.FileInfo, error) {
+	return r.root.Stat(rel)
+}
+
+func (r *osRoot) lstat(rel string) (os.FileInfo, error) {
+	return r.root.Lstat(rel)
+}
+
+func (r *osRoot) close() error {
+	return r.root.Close()
+}
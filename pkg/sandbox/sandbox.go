@@ -0,0 +1,135 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sandbox confines file access to a directory, including the targets of symlinks.
+// It uses os.Root, which also rejects symlinks with absolute targets and concurrent changes to the tree.
+package sandbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOutside is returned for paths outside of the sandbox directory
+var ErrOutside = errors.New("path is outside of the sandbox")
+
+// Root gives access to the files below a directory
+type Root struct {
+	dir string
+	fs  rootFS
+}
+
+// Open creates a sandbox rooted at dir
+func Open(dir string) (*Root, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := openRootFS(absDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Root{dir: absDir, fs: fs}, nil
+}
+
+// Close releases the sandbox
+func (r *Root) Close() error {
+	return r.fs.close()
+}
+
+// Open opens a file for reading. The name is relative to the working directory, like for os.Open.
+func (r *Root) Open(name string) (*os.File, error) {
+	rel, err := r.rel(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.fs.open(rel)
+}
+
+// Stat returns the file info of a file, following symlinks within the sandbox
+func (r *Root) Stat(name string) (os.FileInfo, error) {
+	rel, err := r.rel(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.fs.stat(rel)
+}
+
+// Lstat returns the file info of a file without following a symlink as its last element, like os.Lstat
+func (r *Root) Lstat(name string) (os.FileInfo, error) {
+	rel, err := r.rel(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.fs.lstat(rel)
+}
+
+// ReadFile reads a whole file, like os.ReadFile
+func (r *Root) ReadFile(name string) ([]byte, error) {
+	file, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// ReadDir lists a directory sorted by file name, like os.ReadDir
+func (r *Root) ReadDir(name string) ([]os.DirEntry, error) {
+	dir, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// rel returns the path of name relative to the sandbox directory
+func (r *Root) rel(name string) (string, error) {
+	absName, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(r.dir, absName)
+	if err != nil || isOutside(rel) {
+		return "", &os.PathError{Op: "open", Path: name, Err: ErrOutside}
+	}
+	return rel, nil
+}
+
+// isOutside reports whether a cleaned relative path leaves its parent directory
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel)
+}
+
+// rootFS is implemented by os.Root
+type rootFS interface {
+	open(rel string) (*os.File, error)
+	stat(rel string) (os.FileInfo, error)
+	lstat(rel string) (os.FileInfo, error)
+	close() error
+}
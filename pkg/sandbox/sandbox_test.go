@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setup creates a sandbox directory with a file, a subdirectory,
+// and symlinks pointing inside and outside of the sandbox
+func setup(t *testing.T) (string, string) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "base")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{filepath.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+	}
+	for _, f := range []string{filepath.Join(dir, "sub", "b.yaml"), filepath.Join(dir, "sub", "a.yaml"), filepath.Join(outside, "secret.yaml")} {
+		if err := os.WriteFile(f, []byte(filepath.Base(f)), 0600); err != nil {
+			t.Fatalf("Error writing file: %v", err)
+		}
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "inside-link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "outside-link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	return dir, outside
+}
+
+// TestSandboxInside verifies that files and symlinks inside of the sandbox can be read
+func TestSandboxInside(t *testing.T) {
+	dir, _ := setup(t)
+	root, err := Open(dir)
+	assert.NoError(t, err)
+	defer root.Close()
+
+	content, err := root.ReadFile(filepath.Join(dir, "inside-link", "a.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.yaml", string(content))
+
+	entries, err := root.ReadDir(filepath.Join(dir, "sub"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a.yaml", entries[0].Name())
+
+	info, err := root.Stat(dir)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	info, err = root.Lstat(filepath.Join(dir, "inside-link"))
+	assert.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+}
+
+// TestSandboxOutside verifies that neither '..' nor symlinks can be used to leave the sandbox
+func TestSandboxOutside(t *testing.T) {
+	dir, outside := setup(t)
+	root, err := Open(dir)
+	assert.NoError(t, err)
+	defer root.Close()
+
+	_, err = root.ReadFile(filepath.Join(outside, "secret.yaml"))
+	assert.True(t, errors.Is(err, ErrOutside))
+
+	_, err = root.ReadFile(filepath.Join(dir, "..", "outside", "secret.yaml"))
+	assert.True(t, errors.Is(err, ErrOutside))
+
+	_, err = root.ReadFile(filepath.Join(dir, "outside-link", "secret.yaml"))
+	assert.Error(t, err)
+
+	_, err = root.Stat(filepath.Join(dir, "outside-link"))
+	assert.Error(t, err)
+}
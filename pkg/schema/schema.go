@@ -0,0 +1,548 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package schema validates decoded YAML or JSON documents against a JSON Schema.
+// It implements the validation keywords of JSON Schema draft 2020-12 that are commonly used for configuration:
+// type, enum, const, properties, required, additionalProperties, patternProperties, propertyNames,
+// minProperties, maxProperties, dependentRequired, dependentSchemas, prefixItems, items, contains,
+// minContains, maxContains, minItems, maxItems, uniqueItems,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+// minLength, maxLength, pattern, allOf, anyOf, oneOf, not, if, then, else, and local $ref.
+// A schema using another validation keyword, like unevaluatedProperties, fails to parse instead of being ignored.
+// Annotations and unknown keywords, like format, are ignored.
+package schema
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// logger receives the messages of the package, see SetLogger
+var logger logging.Logger = logging.Discard
+
+// SetLogger sets the logger of the package, which logs nothing by default. A nil logger turns logging off again.
+// It is meant to be called once before the package is used, it must not be called concurrently with it.
+func SetLogger(l logging.Logger) {
+	if l == nil {
+		l = logging.Discard
+	}
+	logger = l
+}
+
+// Schema is a parsed JSON Schema document
+type Schema struct {
+	root interface{}
+}
+
+// Error is a violation of the schema at a dot-separated path of the document
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Load reads a JSON Schema from a JSON or YAML file
+func Load(file string) (*Schema, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	logger.Debug("Loaded schema", "file", file)
+	return s, nil
+}
+
+// Parse reads a JSON Schema from JSON or YAML content
+func Parse(content []byte) (*Schema, error) {
+	var root interface{}
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("schema must be an object or a boolean")
+	}
+	if err := checkKeywords(root, "#"); err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// unsupported are the validation keywords which are not implemented, and the keywords of earlier drafts
+// which draft 2020-12 replaced, with a hint which keyword to use instead
+var unsupported = map[string]string{
+	"unevaluatedProperties": "",
+	"unevaluatedItems":      "",
+	"$dynamicRef":           "",
+	"$recursiveRef":         "",
+	"dependencies":          ", use dependentRequired or dependentSchemas",
+	"additionalItems":       ", use items together with prefixItems",
+}
+
+// checkKeywords fails if the schema at the JSON pointer location, or one of its subschemas, uses an unsupported keyword
+func checkKeywords(schema interface{}, location string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	keywords := sortedKeys(s)
+	for _, keyword := range keywords {
+		if hint, ok := unsupported[keyword]; ok {
+			return fmt.Errorf("unsupported keyword %q at %s%s", keyword, location, hint)
+		}
+	}
+	if _, ok := s["items"].([]interface{}); ok {
+		return fmt.Errorf("unsupported array of schemas in \"items\" at %s, use prefixItems", location)
+	}
+	for _, keyword := range keywords {
+		value := s[keyword]
+		switch keyword {
+		case "additionalProperties", "propertyNames", "items", "contains", "not", "if", "then", "else":
+			if err := checkKeywords(value, location+"/"+keyword); err != nil {
+				return err
+			}
+		case "allOf", "anyOf", "oneOf", "prefixItems":
+			list, _ := value.([]interface{})
+			for i, sub := range list {
+				if err := checkKeywords(sub, location+"/"+keyword+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		case "properties", "patternProperties", "dependentSchemas", "$defs", "definitions":
+			subs, _ := value.(map[string]interface{})
+			for _, name := range sortedKeys(subs) {
+				token := strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+				if err := checkKeywords(subs[name], location+"/"+keyword+"/"+token); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns all violations of the schema, or nil if the document is valid
+func (s *Schema) Validate(document interface{}) []Error {
+	v := validator{root: s.root, following: map[string]bool{}}
+	v.validate(s.root, document, "")
+	logger.Debug("Validated document", "violations", len(v.errors))
+	return v.errors
+}
+
+// validator collects the violations of a single validation run
+type validator struct {
+	root   interface{}
+	errors []Error
+	// following holds the references being followed at a path, a reference followed again at the same path is a cycle
+	following map[string]bool
+}
+
+func (v *validator) fail(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "."
+	}
+	v.errors = append(v.errors, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// valid reports whether the value matches the schema without recording any violations
+func (v *validator) valid(schema interface{}, value interface{}, path string) bool {
+	sub := validator{root: v.root, following: v.following}
+	sub.validate(schema, value, path)
+	return len(sub.errors) == 0
+}
+
+func (v *validator) validate(schema interface{}, value interface{}, path string) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.fail(path, "no value is allowed")
+		}
+		return
+	case map[string]interface{}:
+		if ref, ok := s["$ref"].(string); ok {
+			key := path + "\x00" + ref
+			if v.following[key] {
+				v.fail(path, "reference %q refers to itself", ref)
+				return
+			}
+			target, err := v.resolve(ref)
+			if err != nil {
+				v.fail(path, "%v", err)
+				return
+			}
+			v.following[key] = true
+			v.validate(target, value, path)
+			delete(v.following, key)
+		}
+		v.validateGeneric(s, value, path)
+		v.validateCombinators(s, value, path)
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			v.validateObject(s, typed, path)
+		case []interface{}:
+			v.validateArray(s, typed, path)
+		case string:
+			v.validateString(s, typed, path)
+		default:
+			if number, ok := toFloat(value); ok {
+				v.validateNumber(s, number, path)
+			}
+		}
+	}
+}
+
+// resolve returns the schema referenced by a local JSON pointer, e.g. "#/$defs/port"
+func (v *validator) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("only local references are supported, got %q", ref)
+	}
+	target := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		node, ok := target.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+		if target, ok = node[token]; !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+	return target, nil
+}
+
+func (v *validator) validateGeneric(s map[string]interface{}, value interface{}, path string) {
+	if types, ok := s["type"]; ok {
+		allowed := []string{}
+		switch t := types.(type) {
+		case string:
+			allowed = append(allowed, t)
+		case []interface{}:
+			for _, item := range t {
+				allowed = append(allowed, fmt.Sprint(item))
+			}
+		}
+		actual := typeOf(value)
+		match := false
+		for _, t := range allowed {
+			if t == actual || (t == "number" && actual == "integer") {
+				match = true
+			}
+		}
+		if !match {
+			v.fail(path, "expected type %s, got %s", strings.Join(allowed, " or "), actual)
+		}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		match := false
+		for _, item := range enum {
+			if equal(item, value) {
+				match = true
+			}
+		}
+		if !match {
+			v.fail(path, "value %v is not one of %v", value, enum)
+		}
+	}
+	if constant, ok := s["const"]; ok && !equal(constant, value) {
+		v.fail(path, "value %v is not %v", value, constant)
+	}
+}
+
+func (v *validator) validateCombinators(s map[string]interface{}, value interface{}, path string) {
+	if allOf, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(sub, value, path)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		match := false
+		for _, sub := range anyOf {
+			if v.valid(sub, value, path) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			v.fail(path, "value does not match any schema of anyOf")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.valid(sub, value, path) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "value matches %d schemas of oneOf instead of exactly one", matches)
+		}
+	}
+	if not, ok := s["not"]; ok && v.valid(not, value, path) {
+		v.fail(path, "value must not match the schema of not")
+	}
+	if condition, ok := s["if"]; ok {
+		if v.valid(condition, value, path) {
+			if then, ok := s["then"]; ok {
+				v.validate(then, value, path)
+			}
+		} else if otherwise, ok := s["else"]; ok {
+			v.validate(otherwise, value, path)
+		}
+	}
+}
+
+func (v *validator) validateObject(s map[string]interface{}, object map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, name := range required {
+			if _, ok := object[fmt.Sprint(name)]; !ok {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+	}
+	if min, ok := toFloat(s["minProperties"]); ok && float64(len(object)) < min {
+		v.fail(path, "expected at least %v properties, got %d", min, len(object))
+	}
+	if max, ok := toFloat(s["maxProperties"]); ok && float64(len(object)) > max {
+		v.fail(path, "expected at most %v properties, got %d", max, len(object))
+	}
+	if dependentRequired, ok := s["dependentRequired"].(map[string]interface{}); ok {
+		for _, name := range sortedKeys(dependentRequired) {
+			required, _ := dependentRequired[name].([]interface{})
+			if _, ok := object[name]; !ok {
+				continue
+			}
+			for _, other := range required {
+				if _, ok := object[fmt.Sprint(other)]; !ok {
+					v.fail(path, "property %q requires property %q", name, other)
+				}
+			}
+		}
+	}
+	if dependentSchemas, ok := s["dependentSchemas"].(map[string]interface{}); ok {
+		for _, name := range sortedKeys(dependentSchemas) {
+			if _, ok := object[name]; ok {
+				v.validate(dependentSchemas[name], object, path)
+			}
+		}
+	}
+
+	properties, _ := s["properties"].(map[string]interface{})
+	patterns, _ := s["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+
+	propertyNames, hasPropertyNames := s["propertyNames"]
+
+	// Sort the keys and patterns, so the violations are reported in a stable order
+	keys := sortedKeys(object)
+	patternKeys := sortedKeys(patterns)
+
+	for _, key := range keys {
+		value := object[key]
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		if hasPropertyNames {
+			v.validate(propertyNames, key, keyPath)
+		}
+		matched := false
+		if sub, ok := properties[key]; ok {
+			matched = true
+			v.validate(sub, value, keyPath)
+		}
+		for _, pattern := range patternKeys {
+			sub := patterns[pattern]
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				v.fail(path, "invalid pattern %q: %v", pattern, err)
+				continue
+			}
+			if re.MatchString(key) {
+				matched = true
+				v.validate(sub, value, keyPath)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.fail(keyPath, "property is not allowed")
+			} else {
+				v.validate(additional, value, keyPath)
+			}
+		}
+	}
+}
+
+func (v *validator) validateArray(s map[string]interface{}, array []interface{}, path string) {
+	if min, ok := toFloat(s["minItems"]); ok && float64(len(array)) < min {
+		v.fail(path, "expected at least %v items, got %d", min, len(array))
+	}
+	if max, ok := toFloat(s["maxItems"]); ok && float64(len(array)) > max {
+		v.fail(path, "expected at most %v items, got %d", max, len(array))
+	}
+	if unique, ok := s["uniqueItems"].(bool); ok && unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	// items only applies to the items after the ones of prefixItems
+	prefixItems, _ := s["prefixItems"].([]interface{})
+	items, hasItems := s["items"]
+	for i, item := range array {
+		itemPath := path + "[" + strconv.Itoa(i) + "]"
+		if i < len(prefixItems) {
+			v.validate(prefixItems[i], item, itemPath)
+		} else if hasItems {
+			v.validate(items, item, itemPath)
+		}
+	}
+	if contains, ok := s["contains"]; ok {
+		matches := 0
+		for i, item := range array {
+			if v.valid(contains, item, path+"["+strconv.Itoa(i)+"]") {
+				matches++
+			}
+		}
+		min, ok := toFloat(s["minContains"])
+		if !ok {
+			min = 1
+		}
+		if float64(matches) < min {
+			v.fail(path, "expected at least %v items matching contains, got %d", min, matches)
+		}
+		if max, ok := toFloat(s["maxContains"]); ok && float64(matches) > max {
+			v.fail(path, "expected at most %v items matching contains, got %d", max, matches)
+		}
+	}
+}
+
+func (v *validator) validateString(s map[string]interface{}, str string, path string) {
+	length := float64(utf8.RuneCountInString(str))
+	if min, ok := toFloat(s["minLength"]); ok && length < min {
+		v.fail(path, "expected at least %v characters, got %v", min, length)
+	}
+	if max, ok := toFloat(s["maxLength"]); ok && length > max {
+		v.fail(path, "expected at most %v characters, got %v", max, length)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(path, "invalid pattern %q: %v", pattern, err)
+		} else if !re.MatchString(str) {
+			v.fail(path, "value %q does not match pattern %q", str, pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(s map[string]interface{}, number float64, path string) {
+	if min, ok := toFloat(s["minimum"]); ok && number < min {
+		v.fail(path, "value %v is less than minimum %v", number, min)
+	}
+	if max, ok := toFloat(s["maximum"]); ok && number > max {
+		v.fail(path, "value %v is greater than maximum %v", number, max)
+	}
+	if min, ok := toFloat(s["exclusiveMinimum"]); ok && number <= min {
+		v.fail(path, "value %v must be greater than %v", number, min)
+	}
+	if max, ok := toFloat(s["exclusiveMaximum"]); ok && number >= max {
+		v.fail(path, "value %v must be less than %v", number, max)
+	}
+	if factor, ok := toFloat(s["multipleOf"]); ok && factor > 0 {
+		if quotient := number / factor; quotient != math.Trunc(quotient) {
+			v.fail(path, "value %v is not a multiple of %v", number, factor)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a decoded object in sorted order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// typeOf returns the JSON Schema type name of a decoded value
+func typeOf(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case float64:
+		if typed == math.Trunc(typed) {
+			return "integer"
+		}
+		return "number"
+	}
+	if _, ok := toFloat(value); ok {
+		return "integer"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// equal compares two decoded values, treating numbers of different types with the same value as equal
+func equal(a interface{}, b interface{}) bool {
+	x, aIsNumber := toFloat(a)
+	y, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// toFloat converts the numeric types produced by the YAML decoder to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case uint64:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schema
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func mustParse(t *testing.T, content string) *Schema {
+	s, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Error parsing schema: %v", err)
+	}
+	return s
+}
+
+func decode(t *testing.T, content string) interface{} {
+	var document interface{}
+	if err := yaml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatalf("Error parsing document: %v", err)
+	}
+	return document
+}
+
+// TestValidateSuccess verifies that a matching document has no violations
+func TestValidateSuccess(t *testing.T) {
+	s := mustParse(t, `{
+		"type": "object",
+		"required": ["name", "ports"],
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"ratio": {"type": "number", "exclusiveMaximum": 1},
+			"ports": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/port"}},
+			"mode": {"oneOf": [{"const": "fast"}, {"const": "safe"}]}
+		},
+		"additionalProperties": false,
+		"$defs": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}}
+	}`)
+
+	document := decode(t, "name: demo\nratio: 0.5\nports: [80, 443]\nmode: safe\n")
+	assert.Empty(t, s.Validate(document))
+}
+
+// TestValidateViolations verifies that all violations are reported with their path
+func TestValidateViolations(t *testing.T) {
+	s := mustParse(t, `
+type: object
+required: [name]
+properties:
+  replicas: {type: integer, multipleOf: 2}
+  ports: {type: array, items: {type: integer, maximum: 1024}, uniqueItems: true}
+  labels:
+    type: object
+    patternProperties:
+      "^x-": {type: string, maxLength: 3}
+    additionalProperties: false
+  mode: {anyOf: [{enum: [fast]}, {enum: [safe]}]}
+  legacy: {not: {type: boolean}}
+`)
+
+	document := decode(t, `
+replicas: 3
+ports: [80, 8080, 80]
+labels:
+  x-team: platform
+  owner: me
+mode: slow
+legacy: true
+`)
+	expected := []Error{
+		{Path: ".", Message: "missing required property \"name\""},
+		{Path: "labels.owner", Message: "property is not allowed"},
+		{Path: "labels.x-team", Message: "expected at most 3 characters, got 8"},
+		{Path: "legacy", Message: "value must not match the schema of not"},
+		{Path: "mode", Message: "value does not match any schema of anyOf"},
+		{Path: "ports", Message: "items 0 and 2 are equal"},
+		{Path: "ports[1]", Message: "value 8080 is greater than maximum 1024"},
+		{Path: "replicas", Message: "value 3 is not a multiple of 2"},
+	}
+	assert.Equal(t, expected, s.Validate(document))
+}
+
+// TestValidateTypes verifies the mapping of decoded values to JSON Schema types
+func TestValidateTypes(t *testing.T) {
+	s := mustParse(t, `{"type": ["integer", "null"]}`)
+	assert.Empty(t, s.Validate(1))
+	assert.Empty(t, s.Validate(2.0))
+	assert.Empty(t, s.Validate(nil))
+	assert.Equal(t, []Error{{Path: ".", Message: "expected type integer or null, got number"}}, s.Validate(2.5))
+	assert.Equal(t, []Error{{Path: ".", Message: "expected type integer or null, got string"}}, s.Validate("1"))
+}
+
+// TestValidateReferenceCycle verifies that references referring to themselves are violations,
+// while recursive schemas descending into the document are followed
+func TestValidateReferenceCycle(t *testing.T) {
+	s := mustParse(t, `{"$ref": "#"}`)
+	assert.Equal(t, []Error{{Path: ".", Message: `reference "#" refers to itself`}}, s.Validate(decode(t, "a: 1\n")))
+
+	s = mustParse(t, `{
+		"$defs": {
+			"a": {"anyOf": [{"$ref": "#/$defs/b"}]},
+			"b": {"allOf": [{"$ref": "#/$defs/a"}]}
+		},
+		"properties": {"loop": {"$ref": "#/$defs/a"}}
+	}`)
+	errors := s.Validate(decode(t, "loop: 1\n"))
+	if assert.NotEmpty(t, errors) {
+		assert.Equal(t, "loop", errors[0].Path)
+	}
+
+	s = mustParse(t, `{
+		"type": "object",
+		"properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#"}}}
+	}`)
+	assert.Empty(t, s.Validate(decode(t, "name: root\nchildren: [{name: a, children: [{name: b}]}]\n")))
+	assert.Len(t, s.Validate(decode(t, "name: root\nchildren: [{name: 1}]\n")), 1)
+}
+
+// TestParseInvalidSchema verifies that only objects and booleans are accepted as schema
+func TestParseInvalidSchema(t *testing.T) {
+	_, err := Parse([]byte("[]"))
+	assert.Error(t, err)
+
+	s := mustParse(t, "false")
+	assert.Len(t, s.Validate("anything"), 1)
+}
+
+// TestValidateConditionalsAndDependencies verifies the keywords applying subschemas depending on the document
+func TestValidateConditionalsAndDependencies(t *testing.T) {
+	s := mustParse(t, `
+type: object
+properties:
+  tls:
+    type: object
+    if: {properties: {enabled: {const: true}}, required: [enabled]}
+    then: {required: [cert]}
+    else: {maxProperties: 1}
+  labels:
+    propertyNames: {pattern: "^[a-z]+$"}
+dependentRequired:
+  username: [password]
+dependentSchemas:
+  replicas: {properties: {mode: {const: cluster}}}
+`)
+
+	assert.Empty(t, s.Validate(decode(t, "tls: {enabled: true, cert: a.pem}\nusername: u\npassword: p\nlabels: {team: a}\n")))
+	assert.Empty(t, s.Validate(decode(t, "tls: {enabled: false}\nreplicas: 3\nmode: cluster\n")))
+
+	document := decode(t, `
+tls: {enabled: true}
+username: u
+replicas: 3
+mode: single
+labels: {Team: a}
+`)
+	expected := []Error{
+		{Path: ".", Message: "property \"username\" requires property \"password\""},
+		{Path: "mode", Message: "value single is not cluster"},
+		{Path: "labels.Team", Message: "value \"Team\" does not match pattern \"^[a-z]+$\""},
+		{Path: "tls", Message: "missing required property \"cert\""},
+	}
+	assert.Equal(t, expected, s.Validate(document))
+	assert.Equal(t, []Error{{Path: "tls", Message: "expected at most 1 properties, got 2"}},
+		s.Validate(decode(t, "tls: {enabled: false, cert: a.pem}\n")))
+}
+
+// TestValidateArrayKeywords verifies prefixItems, items after them, and contains with its bounds
+func TestValidateArrayKeywords(t *testing.T) {
+	s := mustParse(t, `
+type: array
+prefixItems: [{type: string}, {type: integer}]
+items: {type: boolean}
+`)
+	assert.Empty(t, s.Validate(decode(t, "[a, 1, true, false]\n")))
+	assert.Equal(t, []Error{
+		{Path: "[1]", Message: "expected type integer, got string"},
+		{Path: "[2]", Message: "expected type boolean, got integer"},
+	}, s.Validate(decode(t, "[a, b, 3]\n")))
+
+	s = mustParse(t, `{"contains": {"const": "admin"}}`)
+	assert.Empty(t, s.Validate(decode(t, "[user, admin]\n")))
+	assert.Equal(t, []Error{{Path: ".", Message: "expected at least 1 items matching contains, got 0"}},
+		s.Validate(decode(t, "[user]\n")))
+
+	s = mustParse(t, `{"contains": {"type": "integer"}, "minContains": 0, "maxContains": 1}`)
+	assert.Empty(t, s.Validate(decode(t, "[a]\n")))
+	assert.Equal(t, []Error{{Path: ".", Message: "expected at most 1 items matching contains, got 2"}},
+		s.Validate(decode(t, "[1, 2]\n")))
+}
+
+// TestParseUnsupportedKeywords verifies that a schema using a validation keyword which is not implemented
+// fails to parse instead of being ignored, while properties named like a keyword are accepted
+func TestParseUnsupportedKeywords(t *testing.T) {
+	for content, expected := range map[string]string{
+		`{"unevaluatedProperties": false}`:                            `unsupported keyword "unevaluatedProperties" at #`,
+		`{"properties": {"a/b": {"unevaluatedItems": false}}}`:        `unsupported keyword "unevaluatedItems" at #/properties/a~1b`,
+		`{"$defs": {"x": {"anyOf": [{"dependencies": {}}]}}}`:         `unsupported keyword "dependencies" at #/$defs/x/anyOf/0, use dependentRequired or dependentSchemas`,
+		`{"items": {"not": {"$dynamicRef": "#node"}}}`:                `unsupported keyword "$dynamicRef" at #/items/not`,
+		`{"type": "array", "items": [{"type": "string"}]}`:            `unsupported array of schemas in "items" at #, use prefixItems`,
+		`{"prefixItems": [true], "additionalItems": {"type": "int"}}`: `unsupported keyword "additionalItems" at #, use items together with prefixItems`,
+	} {
+		_, err := Parse([]byte(content))
+		if assert.Error(t, err, content) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+
+	_, err := Parse([]byte(`{"properties": {"unevaluatedProperties": {"enum": [{"dependencies": 1}]}}}`))
+	assert.NoError(t, err)
+}
+
+// TestSetLogger verifies that validations are logged with the number of violations
+func TestSetLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})))
+	defer SetLogger(nil)
+	s := mustParse(t, `{"required": ["name"]}`)
+	assert.Len(t, s.Validate(map[string]interface{}{}), 1)
+	assert.Equal(t, "level=DEBUG msg=\"Validated document\" violations=1\n", out.String())
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the values of redacted keys
+const Redacted = "[REDACTED]"
+
+func init() {
+	Register("redact", newRedact)
+	Register("coerce", newCoerce)
+	Register("relocate", newRelocate)
+	Register("defaults", newDefaults)
+	Register("exec", newExec)
+}
+
+// newRedact replaces the values of keys matching any of the comma-separated globs with Redacted
+func newRedact(argument string) (Transform, error) {
+	if len(argument) == 0 {
+		return nil, fmt.Errorf("expected comma-separated key path globs, e.g. '**.password'")
+	}
+	globs := strings.Split(argument, ",")
+	return func(node map[string]interface{}, _ Sources) (map[string]interface{}, error) {
+		err := walkLeaves(node, "", func(keyPath string, value interface{}) (interface{}, error) {
+			for _, glob := range globs {
+				if Match(glob, keyPath) {
+					return Redacted, nil
+				}
+			}
+			return value, nil
+		})
+		return node, err
+	}, nil
+}
+
+// newCoerce converts the values of keys matching a glob to a type, e.g. 'app.port:int'.
+// Types are string, int, float, and bool.
+func newCoerce(argument string) (Transform, error) {
+	glob, kind, ok := strings.Cut(argument, ":")
+	convert, known := map[string]func(value interface{}) (interface{}, error){
+		"string": coerceString,
+		"int":    coerceInt,
+		"float":  coerceFloat,
+		"bool":   coerceBool,
+	}[kind]
+	if !ok || !known || len(glob) == 0 {
+		return nil, fmt.Errorf("expected '<glob>:<type>' with a type of string, int, float, or bool, got %q", argument)
+	}
+	return func(node map[string]interface{}, _ Sources) (map[string]interface{}, error) {
+		err := walkLeaves(node, "", func(keyPath string, value interface{}) (interface{}, error) {
+			if value == nil || !Match(glob, keyPath) {
+				return value, nil
+			}
+			converted, err := convert(value)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", keyPath, err)
+			}
+			return converted, nil
+		})
+		return node, err
+	}, nil
+}
+
+func coerceString(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, fmt.Errorf("cannot convert %v to string", value)
+	}
+	return fmt.Sprint(value), nil
+}
+
+func coerceInt(value interface{}) (interface{}, error) {
+	switch number := value.(type) {
+	case int:
+		return number, nil
+	case float64:
+		if number == math.Trunc(number) {
+			return int(number), nil
+		}
+	case string:
+		if parsed, err := strconv.Atoi(strings.TrimSpace(number)); err == nil {
+			return parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to int", value)
+}
+
+func coerceFloat(value interface{}) (interface{}, error) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), nil
+	case float64:
+		return number, nil
+	case string:
+		if parsed, err := strconv.ParseFloat(strings.TrimSpace(number), 64); err == nil {
+			return parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot convert %v to float", value)
+}
+
+func coerceBool(value interface{}) (interface{}, error) {
+	switch flag := value.(type) {
+	case bool:
+		return flag, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(flag)) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0":
+			return false, nil
+		}
+	case int:
+		return flag != 0, nil
+	}
+	return nil, fmt.Errorf("cannot convert %v to bool", value)
+}
+
+// newRelocate moves the value of a key path to another one, e.g. 'app.db:app.database'.
+// Nothing is moved if the key path does not exist.
+func newRelocate(argument string) (Transform, error) {
+	from, to, ok := strings.Cut(argument, ":")
+	if !ok || len(from) == 0 || len(to) == 0 {
+		return nil, fmt.Errorf("expected '<from>:<to>' key paths, got %q", argument)
+	}
+	fromKeys, toKeys := strings.Split(from, "."), strings.Split(to, ".")
+	return func(node map[string]interface{}, _ Sources) (map[string]interface{}, error) {
+		parent := node
+		for _, key := range fromKeys[:len(fromKeys)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				return node, nil
+			}
+			parent = child
+		}
+		value, ok := parent[fromKeys[len(fromKeys)-1]]
+		if !ok {
+			return node, nil
+		}
+		delete(parent, fromKeys[len(fromKeys)-1])
+		target := node
+		for _, key := range toKeys[:len(toKeys)-1] {
+			child, ok := target[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				target[key] = child
+			}
+			target = child
+		}
+		target[toKeys[len(toKeys)-1]] = value
+		return node, nil
+	}, nil
+}
+
+// newDefaults sets the keys of a YAML file that are not set in the merged data
+func newDefaults(argument string) (Transform, error) {
+	content, err := os.ReadFile(argument)
+	if err != nil {
+		return nil, err
+	}
+	defaults := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &defaults); err != nil {
+		return nil, fmt.Errorf("%s: %w", argument, err)
+	}
+	return func(node map[string]interface{}, _ Sources) (map[string]interface{}, error) {
+		// Copy the defaults, so a chain applied again starts from the original ones
+		var copied map[string]interface{}
+		if err := yaml.Unmarshal(content, &copied); err != nil {
+			return nil, err
+		}
+		err := mergo.Merge(&node, copied)
+		return node, err
+	}, nil
+}
+
+// execInput is written to the standard input of an exec plugin
+type execInput struct {
+	Data    map[string]interface{} `json:"data"`
+	Sources map[string][]string    `json:"sources"`
+}
+
+// newExec runs a command as a plugin. It receives the merged data and the sources of every leaf key path
+// as a JSON object with the fields data and sources on standard input,
+// and writes the transformed data as a JSON object to standard output.
+func newExec(argument string) (Transform, error) {
+	args := strings.Fields(argument)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("expected a command")
+	}
+	return func(node map[string]interface{}, sources Sources) (map[string]interface{}, error) {
+		input := execInput{Data: node, Sources: map[string][]string{}}
+		if sources != nil {
+			_ = walkLeaves(node, "", func(keyPath string, value interface{}) (interface{}, error) {
+				input.Sources[keyPath] = sources.Sources(keyPath)
+				return value, nil
+			})
+		}
+		stdin, err := json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(stdin)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s: %w: %s", argument, err, strings.TrimSpace(stderr.String()))
+		}
+		decoder := json.NewDecoder(&stdout)
+		decoder.UseNumber()
+		var result map[string]interface{}
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("%s: invalid output: %w", argument, err)
+		}
+		return fromJSON(result).(map[string]interface{}), nil
+	}, nil
+}
+
+// fromJSON converts decoded JSON numbers to the int and float64 values of decoded YAML
+func fromJSON(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			node[key] = fromJSON(child)
+		}
+	case []interface{}:
+		for i, child := range node {
+			node[i] = fromJSON(child)
+		}
+	case json.Number:
+		if number, err := strconv.Atoi(node.String()); err == nil {
+			return number
+		}
+		number, _ := node.Float64()
+		return number
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transform runs middleware over the merged data of a hierarchy, e.g. to redact secrets
+// or move keys, before it is written. Built-in transforms are registered by name,
+// and library users can register their own with Register.
+package transform
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+)
+
+// logger receives the messages of the package, see SetLogger
+var logger logging.Logger = logging.Discard
+
+// SetLogger sets the logger of the package, which logs nothing by default. A nil logger turns logging off again.
+// It is meant to be called once before the package is used, it must not be called concurrently with it.
+func SetLogger(l logging.Logger) {
+	if l == nil {
+		l = logging.Discard
+	}
+	logger = l
+}
+
+// Transform changes the merged data. It may change node in place and return it, or return a new tree.
+type Transform func(node map[string]interface{}, sources Sources) (map[string]interface{}, error)
+
+// Sources reports which files set the final value of a dot-separated key path, in merge order
+type Sources interface {
+	Sources(keyPath string) []string
+}
+
+// Factory creates a transform from the argument of its spec, see Parse
+type Factory func(argument string) (Transform, error)
+
+var (
+	mutex     sync.RWMutex
+	factories = map[string]Factory{}
+)
+
+// Register makes a transform available by name. It panics if the name is already registered.
+func Register(name string, factory Factory) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("transform: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of all registered transforms in sorted order
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Step is a named transform of a chain
+type Step struct {
+	Name      string
+	Transform Transform
+}
+
+// Chain runs transforms in order
+type Chain []Step
+
+// Parse creates a chain from specs like 'redact=**.password', naming a registered transform and its argument
+func Parse(specs []string) (Chain, error) {
+	chain := Chain{}
+	for _, spec := range specs {
+		name, argument, _ := strings.Cut(spec, "=")
+		mutex.RLock()
+		factory, ok := factories[name]
+		mutex.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q, expected one of %s", name, strings.Join(Names(), ", "))
+		}
+		t, err := factory(argument)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", name, err)
+		}
+		chain = append(chain, Step{Name: name, Transform: t})
+	}
+	return chain, nil
+}
+
+// Apply runs the transforms in order, each on the result of the previous one
+func (c Chain) Apply(node map[string]interface{}, sources Sources) (map[string]interface{}, error) {
+	for _, step := range c {
+		result, err := step.Transform(node, sources)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %w", step.Name, err)
+		}
+		if result == nil {
+			result = map[string]interface{}{}
+		}
+		logger.Debug("Applied transform", "name", step.Name)
+		node = result
+	}
+	return node, nil
+}
+
+// Match reports whether a dot-separated key path matches a glob,
+// where '*' matches a single key and '**' any number of keys
+func Match(glob string, keyPath string) bool {
+	return MatchKeys(strings.Split(glob, "."), strings.Split(keyPath, "."))
+}
+
+// MatchKeys reports whether the keys of a path match the keys of a glob, see Match
+func MatchKeys(glob []string, keys []string) bool {
+	if len(glob) == 0 {
+		return len(keys) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(keys); i++ {
+			if MatchKeys(glob[1:], keys[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(keys) == 0 {
+		return false
+	}
+	if matched, err := path.Match(glob[0], keys[0]); err != nil || !matched {
+		return false
+	}
+	return MatchKeys(glob[1:], keys[1:])
+}
+
+// walkLeaves calls visit for every leaf of node with its key path, replacing the leaf with the result.
+// Lists are leaves, because they are replaced as a whole when merging.
+func walkLeaves(node map[string]interface{}, prefix string, visit func(keyPath string, value interface{}) (interface{}, error)) error {
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := key
+		if len(prefix) > 0 {
+			keyPath = prefix + "." + key
+		}
+		if nested, ok := node[key].(map[string]interface{}); ok {
+			if err := walkLeaves(nested, keyPath, visit); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := visit(keyPath, node[key])
+		if err != nil {
+			return err
+		}
+		node[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transform
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticSources returns the same files for every key path
+type staticSources []string
+
+func (s staticSources) Sources(string) []string {
+	return s
+}
+
+// TestMatch verifies the matching of key paths with '*' and '**'
+func TestMatch(t *testing.T) {
+	for glob, expected := range map[string]bool{
+		"payments":            false,
+		"payments.*":          false,
+		"payments.*.host":     true,
+		"payments.**":         true,
+		"**.host":             true,
+		"payments.**.host":    true,
+		"**":                  true,
+		"pay*.database.h?st":  true,
+		"payments.database.*": true,
+	} {
+		assert.Equal(t, expected, Match(glob, "payments.database.host"), glob)
+	}
+}
+
+// TestParse verifies that specs name registered transforms with valid arguments
+func TestParse(t *testing.T) {
+	chain, err := Parse([]string{"redact=**.password", "relocate=a:b"})
+	assert.NoError(t, err)
+	assert.Len(t, chain, 2)
+	assert.Equal(t, "redact", chain[0].Name)
+	assert.Equal(t, "relocate", chain[1].Name)
+
+	_, err = Parse([]string{"unknown=x"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `unknown transform "unknown"`)
+	}
+
+	_, err = Parse([]string{"coerce=app.port:number"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "transform coerce: expected '<glob>:<type>'")
+	}
+}
+
+// TestRegister verifies that library users can register transforms, but not twice with the same name
+func TestRegister(t *testing.T) {
+	Register("test-fail", func(argument string) (Transform, error) {
+		return func(map[string]interface{}, Sources) (map[string]interface{}, error) {
+			return nil, errors.New(argument)
+		}, nil
+	})
+	assert.Contains(t, Names(), "test-fail")
+	assert.Panics(t, func() { Register("test-fail", nil) })
+
+	chain, err := Parse([]string{"test-fail=broken"})
+	assert.NoError(t, err)
+	_, err = chain.Apply(map[string]interface{}{}, nil)
+	assert.EqualError(t, err, "transform test-fail: broken")
+}
+
+// TestChain verifies that the built-in transforms run in order on the merged data
+func TestChain(t *testing.T) {
+	defaults := filepath.Join(t.TempDir(), "defaults.yaml")
+	if err := os.WriteFile(defaults, []byte("app:\n  replicas: 1\n  log: info\n"), 0600); err != nil {
+		t.Fatalf("Error writing defaults file: %v", err)
+	}
+	chain, err := Parse([]string{
+		"relocate=app.db:app.database",
+		"redact=**.password,**.token",
+		"coerce=app.port:int",
+		"coerce=app.debug:bool",
+		"coerce=app.replicas:string",
+		"defaults=" + defaults,
+	})
+	assert.NoError(t, err)
+
+	data, err := chain.Apply(map[string]interface{}{
+		"app": map[string]interface{}{
+			"db":       map[string]interface{}{"host": "db", "password": "secret"},
+			"port":     "8080",
+			"debug":    "yes",
+			"replicas": 3,
+			"token":    []interface{}{"a", "b"},
+		},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{
+			"database": map[string]interface{}{"host": "db", "password": Redacted},
+			"port":     8080,
+			"debug":    true,
+			"replicas": "3",
+			"token":    Redacted,
+			"log":      "info",
+		},
+	}, data)
+
+	_, err = chain.Apply(map[string]interface{}{"app": map[string]interface{}{"port": "http"}}, nil)
+	assert.EqualError(t, err, "transform coerce: key app.port: cannot convert http to int")
+}
+
+// TestExec verifies that plugins receive the data and sources as JSON and return the transformed data
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell scripts are not supported on Windows")
+	}
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	content := strings.Join([]string{
+		"#!/bin/sh",
+		`input=$(cat)`,
+		`case "$input" in *'"app.name":["default/app.yaml"]'*) ;; *) echo "missing sources" >&2; exit 1;; esac`,
+		`echo '{"app":{"name":"demo","replicas":2,"ratio":0.5}}'`,
+	}, "\n")
+	if err := os.WriteFile(script, []byte(content+"\n"), 0700); err != nil {
+		t.Fatalf("Error writing plugin: %v", err)
+	}
+
+	chain, err := Parse([]string{"exec=" + script})
+	assert.NoError(t, err)
+	data, err := chain.Apply(map[string]interface{}{"app": map[string]interface{}{"name": "app"}}, staticSources{"default/app.yaml"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 2, "ratio": 0.5},
+	}, data)
+
+	_, err = chain.Apply(map[string]interface{}{"app": map[string]interface{}{"name": "app"}}, staticSources{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "missing sources")
+	}
+}
+
+// TestSetLogger verifies that every applied transform is logged
+func TestSetLogger(t *testing.T) {
+	var out bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+		if a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}})))
+	defer SetLogger(nil)
+	chain, err := Parse([]string{"redact=password", "relocate=password:secret"})
+	assert.NoError(t, err)
+	_, err = chain.Apply(map[string]interface{}{"password": "s3cr3t"}, staticSources{})
+	assert.NoError(t, err)
+	assert.Equal(t, "level=DEBUG msg=\"Applied transform\" name=redact\n"+
+		"level=DEBUG msg=\"Applied transform\" name=relocate\n", out.String())
+}
@@ -17,10 +17,15 @@ limitations under the License.
 package version
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
 )
 
 // Application build information.
@@ -31,18 +36,140 @@ var (
 	Version   = "v0.1.5"
 )
 
+// Info is the build information of the running binary
+type Info struct {
+	Version   string `json:"version"`
+	Branch    string `json:"branch,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+	ModuleSum string `json:"moduleSum,omitempty"`
+}
+
+// Get returns the build information set at link time.
+// The revision and build date fall back to the version control information
+// embedded by the go tool, the module checksum is only known for binaries built with 'go install'.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Branch:    Branch,
+		Revision:  GitSHA1,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.ModuleSum = build.Main.Sum
+	for _, setting := range build.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.Revision == "":
+			info.Revision = setting.Value
+		case setting.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = setting.Value
+		}
+	}
+	return info
+}
+
+// WriteJSON writes the build information as JSON
+func WriteJSON(w io.Writer) error {
+	content, err := json.MarshalIndent(Get(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
+
 // Print writes application version details to standard output.
 func Print() {
-	fmt.Printf("hierarchy, version %v (branch: %v, revision: %v), build date: %v, go version: %v\n", Version, Branch, GitSHA1, BuildDate, runtime.Version())
+	info := Get()
+	fmt.Printf("hierarchy, version %v (branch: %v, revision: %v), build date: %v, go version: %v\n", info.Version, info.Branch, info.Revision, info.BuildDate, info.GoVersion)
 }
 
 // Log writes application version details to the log
-func Log() {
-	log.WithFields(log.Fields{
-		"version":    Version,
-		"branch":     Branch,
-		"revision":   GitSHA1,
-		"build date": BuildDate,
-		"go version": runtime.Version(),
-	}).Info("Hierarchy")
+func Log(logger logging.Logger) {
+	info := Get()
+	logger.Info("Hierarchy",
+		"version", info.Version,
+		"branch", info.Branch,
+		"revision", info.Revision,
+		"build_date", info.BuildDate,
+		"go_version", info.GoVersion,
+	)
+}
+
+// Satisfies reports whether Version meets all comma-separated constraints, e.g. ">=1.4.0" or ">=1.4, <2".
+// The operators are >=, >, <=, <, and =, a version without operator must match exactly.
+// Missing minor or patch numbers are 0, and pre-release suffixes like -rc1 are ignored.
+func Satisfies(constraints string) (bool, error) {
+	current, err := parse(Version)
+	if err != nil {
+		return false, err
+	}
+	for _, constraint := range strings.Split(constraints, ",") {
+		constraint = strings.TrimSpace(constraint)
+		rest := strings.TrimLeft(constraint, "<>=~!^")
+		operator := constraint[:len(constraint)-len(rest)]
+		required, err := parse(strings.TrimSpace(rest))
+		if err != nil {
+			return false, err
+		}
+		result := compare(current, required)
+		var ok bool
+		switch operator {
+		case ">=":
+			ok = result >= 0
+		case ">":
+			ok = result > 0
+		case "<=":
+			ok = result <= 0
+		case "<":
+			ok = result < 0
+		case "=", "":
+			ok = result == 0
+		default:
+			return false, fmt.Errorf("invalid version constraint %q", constraint)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parse returns the major, minor, and patch numbers of a version like v1.4.0
+func parse(version string) ([3]int, error) {
+	numbers := [3]int{}
+	trimmed := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(parts) > len(numbers) {
+		return numbers, fmt.Errorf("invalid version %q", version)
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return numbers, fmt.Errorf("invalid version %q", version)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+// compare returns -1, 0, or 1 if a is lower than, equal to, or higher than b
+func compare(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
 }
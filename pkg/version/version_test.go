@@ -0,0 +1,76 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWriteJSON verifies that the build information set at link time is written as JSON
+func TestWriteJSON(t *testing.T) {
+	defer func(branch, revision, date string) {
+		Branch, GitSHA1, BuildDate = branch, revision, date
+	}(Branch, GitSHA1, BuildDate)
+	Branch, GitSHA1, BuildDate = "main", "0123abc", "2021-06-01T00:00:00Z"
+
+	var out bytes.Buffer
+	assert.NoError(t, WriteJSON(&out))
+
+	var info Info
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &info))
+	assert.Equal(t, Info{
+		Version:   Version,
+		Branch:    "main",
+		Revision:  "0123abc",
+		BuildDate: "2021-06-01T00:00:00Z",
+		GoVersion: runtime.Version(),
+		ModuleSum: info.ModuleSum,
+	}, info)
+}
+
+// TestSatisfies verifies version constraints against the current version
+func TestSatisfies(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v1.4.2-rc1"
+
+	for constraint, expected := range map[string]bool{
+		">=1.4.0":       true,
+		">= v1.4":       true,
+		">1.4.2":        false,
+		"<=1.4.2":       true,
+		"<2":            true,
+		"1.4.2":         true,
+		"=1.4.1":        false,
+		">=1.5.0":       false,
+		">=1.4, <1.4.2": false,
+		">=1.4, <2.0.0": true,
+	} {
+		ok, err := Satisfies(constraint)
+		assert.NoError(t, err, constraint)
+		assert.Equal(t, expected, ok, constraint)
+	}
+
+	for _, constraint := range []string{"", ">=", "~>1.4", ">=1.x", ">=1.2.3.4"} {
+		_, err := Satisfies(constraint)
+		assert.Error(t, err, constraint)
+	}
+}
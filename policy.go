@@ -0,0 +1,79 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Default query for the deny rules of the policies
+const defaultPolicyQuery = "data.hierarchy.deny"
+
+// evaluatePolicies runs the Rego policies in policyPath against the document using the opa CLI
+// and returns the messages of all deny results. An undefined query result is not a violation.
+func evaluatePolicies(opaBinary string, policyPath string, query string, document interface{}) ([]string, error) {
+	input, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(opaBinary, "eval", "--format", "json", "--data", policyPath, "--stdin-input", query)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "opa eval failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, errors.Wrap(err, "cannot parse opa eval output")
+	}
+
+	messages := []string{}
+	for _, r := range result.Result {
+		for _, expression := range r.Expressions {
+			switch value := expression.Value.(type) {
+			case []interface{}:
+				for _, message := range value {
+					messages = append(messages, fmt.Sprint(message))
+				}
+			case bool:
+				if value {
+					messages = append(messages, query)
+				}
+			case nil:
+			default:
+				messages = append(messages, fmt.Sprint(value))
+			}
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBinary creates a shell script with the given name and body standing in for an external binary,
+// and skips the test on Windows
+func fakeBinary(t *testing.T, name string, body string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	script := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body), 0700); err != nil {
+		t.Fatalf("Error writing fake binary: %v", err)
+	}
+	return script
+}
+
+// fakeCommand creates a script standing in for an external binary.
+// It fails unless it is called with the expected arguments, and otherwise prints the output.
+func fakeCommand(t *testing.T, expectedArgs string, output string) string {
+	return fakeBinary(t, "command", `[ "$*" = "`+expectedArgs+`" ] || { echo "unexpected arguments: $*" >&2; exit 2; }
+cat > /dev/null
+printf '%s' '`+output+`'
+`)
+}
+
+// fakeOpa creates a script standing in for the opa binary, which prints the given output
+func fakeOpa(t *testing.T, output string) string {
+	return fakeCommand(t, "eval --format json --data testdata/policy --stdin-input data.hierarchy.deny", output)
+}
+
+// TestEvaluatePoliciesDeny verifies that all deny messages are returned
+func TestEvaluatePoliciesDeny(t *testing.T) {
+	opa := fakeOpa(t, `{"result":[{"expressions":[{"value":["replicas too low","image not pinned"],"text":"data.hierarchy.deny"}]}]}`)
+
+	messages, err := evaluatePolicies(opa, "testdata/policy", defaultPolicyQuery, map[string]interface{}{"replicas": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"replicas too low", "image not pinned"}, messages)
+}
+
+// TestEvaluatePoliciesUndefined verifies that an empty or undefined result has no violations
+func TestEvaluatePoliciesUndefined(t *testing.T) {
+	opa := fakeOpa(t, `{}`)
+
+	messages, err := evaluatePolicies(opa, "testdata/policy", defaultPolicyQuery, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+// TestEvaluatePoliciesFailure verifies that errors of the opa binary are returned
+func TestEvaluatePoliciesFailure(t *testing.T) {
+	opa := fakeOpa(t, `{}`)
+
+	_, err := evaluatePolicies(opa, "testdata/other", defaultPolicyQuery, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected arguments")
+}
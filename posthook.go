@@ -0,0 +1,47 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// runPostHook runs the command of --post-hook with the shell after the output was written.
+// The command gets the output file as $1 and 'changed' or 'unchanged' as $2, and both in environment variables.
+// Its output goes to standard error, so it cannot mix with the document written to standard output.
+func runPostHook(command string, outputFile string, changed bool) error {
+	state := "unchanged"
+	if changed {
+		state = "changed"
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command, "sh", outputFile, state)
+	}
+	cmd.Env = append(os.Environ(),
+		"HIERARCHY_OUTPUT_FILE="+outputFile,
+		"HIERARCHY_OUTPUT_CHANGED="+strconv.FormatBool(changed),
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestEnd2EndPostHook verifies that the hook gets the output file and whether the output changed since the last run
+func TestEnd2EndPostHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook uses the arguments of sh")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "hook.log")
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/default"
+	cfg.outputFile = filepath.Join(dir, "output.yaml")
+	cfg.postHook = `echo "$1 $2 $HIERARCHY_OUTPUT_CHANGED" >> ` + log
+
+	runMerge(cfg)
+	runMerge(cfg)
+
+	content, err := os.ReadFile(log)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.outputFile+" changed true\n"+cfg.outputFile+" unchanged false\n", string(content))
+}
+
+// TestRunPostHookError verifies that a failing hook is reported
+func TestRunPostHookError(t *testing.T) {
+	assert.NoError(t, runPostHook("exit 0", "output.yaml", true))
+	assert.Error(t, runPostHook("exit 3", "output.yaml", true))
+}
@@ -0,0 +1,432 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
+	"gopkg.in/yaml.v3"
+)
+
+// provenance records where the merged data came from
+type provenance struct {
+	// keys maps every leaf key path of the merged data to the files that set its value in merge order.
+	// The last file is the one that provided the final value.
+	// Lists are leaves, because they are replaced as a whole.
+	keys map[string][]string
+	// files lists all merged files in merge order
+	files []mergedFile
+	// expiries are the expiry directives of the merged files in merge order
+	expiries []expiry
+	// order maps the key path of every mapping to its keys in the order they were first defined,
+	// with list elements as [index]. The top-level keys have the empty key path.
+	order map[string][]string
+	// ordered has the key paths in order
+	ordered map[string]bool
+	// comments maps key paths to the comments of the last file that defined them
+	comments map[string]keyComments
+	// scalars maps the key paths of numbers, booleans, and binary values to their nodes in the last file that defined them,
+	// so they are written as they were, e.g. 0123, 1.10, or !!binary, rather than as the value they were decoded to
+	scalars map[string]*yaml.Node
+	// anchors are the anchors, aliases, and merge keys of the input files, see --anchors
+	anchors anchors
+}
+
+// keepComments enables recording the comments of keys in input files, see --comments
+var keepComments = false
+
+// keyComments are the comments of a key in an input file
+type keyComments struct {
+	head  string
+	line  string
+	foot  string
+	value string
+}
+
+// mergedFile is an input file and the SHA-256 checksum of the content that was merged
+type mergedFile struct {
+	path   string
+	sha256 string
+}
+
+func newProvenance() *provenance {
+	return &provenance{keys: map[string][]string{}, order: map[string][]string{}, ordered: map[string]bool{}, comments: map[string]keyComments{}, scalars: map[string]*yaml.Node{}, anchors: newAnchors()}
+}
+
+// addFile records a merged file and the checksum of its content
+func (p *provenance) addFile(file string, content []byte) {
+	sum := sha256.Sum256(content)
+	p.files = append(p.files, mergedFile{path: file, sha256: hex.EncodeToString(sum[:])})
+}
+
+// record adds the leaf key paths of mergeData to the provenance,
+// if the merged data holds the same value after the merge
+func (p *provenance) record(file string, prefix string, mergeData map[string]interface{}, data map[string]interface{}) {
+	for _, key := range sortedKeys(mergeData) {
+		value := mergeData[key]
+		keyPath := key
+		if len(prefix) > 0 {
+			keyPath = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			merged, ok := data[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			// A scalar or list replaced by a map is no longer a leaf
+			delete(p.keys, keyPath)
+			p.record(file, keyPath, nested, merged)
+			continue
+		}
+		if !reflect.DeepEqual(data[key], value) {
+			continue
+		}
+		// A map replaced by a scalar or list removes all leaves below it
+		for existing := range p.keys {
+			if strings.HasPrefix(existing, keyPath+".") {
+				delete(p.keys, existing)
+			}
+		}
+		p.keys[keyPath] = append(p.keys[keyPath], file)
+	}
+}
+
+// prune forgets key paths that are no longer part of the data,
+// e.g. after directives or metadata keys were removed
+func (p *provenance) prune(data map[string]interface{}) {
+	for keyPath := range p.keys {
+		if !hasKey(data, keyPath) {
+			delete(p.keys, keyPath)
+		}
+	}
+}
+
+// finalSources returns the files that provided the final values of a key and all keys below it, in merge order
+func (p *provenance) finalSources(key string) []string {
+	provided := map[string]bool{}
+	for keyPath, files := range p.keys {
+		if keyPath == key || strings.HasPrefix(keyPath, key+".") {
+			provided[files[len(files)-1]] = true
+		}
+	}
+	sources := []string{}
+	for _, file := range p.files {
+		if provided[file.path] {
+			sources = append(sources, file.path)
+		}
+	}
+	return sources
+}
+
+// Sources returns the files that provided the final values of a key path, see transform.Sources
+func (p *provenance) Sources(keyPath string) []string {
+	return p.finalSources(keyPath)
+}
+
+// annotate converts the data to a YAML node with comments naming the files that provided each key.
+// If allKeys is false, only top-level keys are annotated, otherwise every leaf key.
+func (p *provenance) annotate(data map[string]interface{}, allKeys bool) (*yaml.Node, error) {
+	node, err := p.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	p.annotateMapping(node, "", allKeys)
+	return node, nil
+}
+
+// recordKeys records the keys of the mappings of an input file which were not defined by an earlier file, in the order of the file,
+// and the comments, numbers, booleans, and binary values of all keys of the file, replacing the ones of earlier files
+func (p *provenance) recordKeys(prefix string, node *yaml.Node) {
+	p.anchors.recordAnchor(prefix, node)
+	switch node.Kind {
+	case yaml.ScalarNode:
+		delete(p.scalars, prefix)
+		switch node.Tag {
+		case "!!int", "!!float", "!!bool":
+			if node.Style == 0 {
+				p.scalars[prefix] = node
+			}
+		case "!!binary":
+			p.scalars[prefix] = node
+		}
+	case yaml.DocumentNode, yaml.AliasNode:
+		for _, child := range node.Content {
+			p.recordKeys(prefix, child)
+		}
+		if node.Alias != nil {
+			p.recordKeys(prefix, node.Alias)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			// The keys of merged mappings are defined where they are merged
+			if key == "<<" {
+				p.anchors.recordMerge(prefix, value)
+				merged := []*yaml.Node{value}
+				if value.Kind == yaml.SequenceNode {
+					merged = value.Content
+				}
+				for _, mapping := range merged {
+					if mapping.Alias != nil {
+						mapping = mapping.Alias
+					}
+					p.recordKeys(prefix, mapping)
+				}
+				continue
+			}
+			keyPath := key
+			if len(prefix) > 0 {
+				keyPath = prefix + "." + key
+			}
+			if !p.ordered[keyPath] {
+				p.ordered[keyPath] = true
+				p.order[prefix] = append(p.order[prefix], key)
+			}
+			comments := keyComments{
+				head:  node.Content[i].HeadComment,
+				line:  node.Content[i].LineComment,
+				foot:  node.Content[i].FootComment,
+				value: value.LineComment,
+			}
+			// A mapping is merged rather than set, so it keeps its comments unless the file has its own
+			if keepComments && (value.Kind != yaml.MappingNode || comments != keyComments{}) {
+				p.comments[keyPath] = comments
+			}
+			p.recordKeys(keyPath, value)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			p.recordKeys(fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+	}
+}
+
+// encode converts the merged data to a YAML node with the keys in the order they were first defined in the input files
+// and their recorded comments. Keys no file defined, e.g. the ones added by transforms, follow in alphabetical order.
+// With keepAnchors, values which are unchanged since they were anchored or aliased are written as anchors and aliases again.
+func (p *provenance) encode(data interface{}) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(data); err != nil {
+		return nil, err
+	}
+	p.anchors.start()
+	p.orderMapping(node, "")
+	p.anchors.finish()
+	return node, nil
+}
+
+// sortKeys drops the recorded order of the keys, so they are encoded in alphabetical order at every level
+func (p *provenance) sortKeys() {
+	p.order = map[string][]string{}
+	p.ordered = map[string]bool{}
+}
+
+// orderMapping sorts the keys of the mappings below a node by the recorded order and sets their comments,
+// and restores the recorded numbers, booleans, and binary values below it, and their anchors and aliases with keepAnchors
+func (p *provenance) orderMapping(node *yaml.Node, prefix string) {
+	if keepAnchors && p.anchors.alias(node, prefix) {
+		return
+	}
+	if keepAnchors {
+		defer p.anchors.write(node, prefix)
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if original, ok := p.scalars[prefix]; ok && sameScalar(node, original) {
+			node.Value = original.Value
+			node.Tag = original.Tag
+			node.Style = original.Style
+		}
+	case yaml.MappingNode:
+		var merge []*yaml.Node
+		if keepAnchors {
+			merge = p.anchors.merge(node, prefix)
+		}
+		position := map[string]int{}
+		for i, key := range p.order[prefix] {
+			position[key] = i
+		}
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool {
+			a, aKnown := position[pairs[i][0].Value]
+			b, bKnown := position[pairs[j][0].Value]
+			if aKnown && bKnown {
+				return a < b
+			}
+			return aKnown && !bKnown
+		})
+		node.Content = node.Content[:0]
+		for _, pair := range pairs {
+			keyPath := pair[0].Value
+			if len(prefix) > 0 {
+				keyPath = prefix + "." + pair[0].Value
+			}
+			if comments, ok := p.comments[keyPath]; ok {
+				pair[0].HeadComment = comments.head
+				pair[0].LineComment = comments.line
+				pair[0].FootComment = comments.foot
+				pair[1].LineComment = comments.value
+			}
+			p.orderMapping(pair[1], keyPath)
+			node.Content = append(node.Content, pair[0], pair[1])
+		}
+		// The merge key comes first, so the keys of the mapping override the merged ones for readers
+		node.Content = append(merge, node.Content...)
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			p.orderMapping(child, fmt.Sprintf("%s[%d]", prefix, i))
+		}
+	}
+}
+
+// sameScalar reports whether a node of the merged data still has the value the original node was decoded to,
+// so a value replaced by a later file or a transform is not restored
+func sameScalar(node *yaml.Node, original *yaml.Node) bool {
+	var value interface{}
+	if err := original.Decode(&value); err != nil {
+		return false
+	}
+	decoded := &yaml.Node{}
+	if err := decoded.Encode(value); err != nil {
+		return false
+	}
+	return decoded.Tag == node.Tag && decoded.Value == node.Value
+}
+
+func (p *provenance) annotateMapping(node *yaml.Node, prefix string, allKeys bool) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		keyPath := key.Value
+		if len(prefix) > 0 {
+			keyPath = prefix + "." + key.Value
+		}
+		if value.Kind == yaml.MappingNode && allKeys {
+			p.annotateMapping(value, keyPath, allKeys)
+			continue
+		}
+		sources := p.finalSources(keyPath)
+		if len(sources) == 0 {
+			continue
+		}
+		comment := "from " + strings.Join(sources, ", ")
+		switch value.Kind {
+		case yaml.ScalarNode:
+			value.LineComment = joinComments(value.LineComment, comment, " ")
+		default:
+			key.HeadComment = joinComments(key.HeadComment, comment, "\n")
+		}
+	}
+}
+
+// explain writes the files that set the given key, and all keys below it,
+// with the file providing the final value first.
+// The key is compared like keys are merged with --key-case.
+func (p *provenance) explain(w io.Writer, key string) error {
+	keyPaths := []string{}
+	query := foldKey(key)
+	for keyPath := range p.keys {
+		if folded := foldKey(keyPath); folded == query || strings.HasPrefix(folded, query+".") {
+			keyPaths = append(keyPaths, keyPath)
+		}
+	}
+	if len(keyPaths) == 0 {
+		return fmt.Errorf("key %q not found in merged data", key)
+	}
+	sort.Strings(keyPaths)
+
+	for _, keyPath := range keyPaths {
+		files := p.keys[keyPath]
+		fmt.Fprintln(w, keyPath)
+		fmt.Fprintf(w, "  set by:    %s\n", files[len(files)-1])
+		for i := len(files) - 2; i >= 0; i-- {
+			fmt.Fprintf(w, "  overrides: %s\n", files[i])
+		}
+	}
+	return nil
+}
+
+// manifest is the machine-readable provenance written next to the output file
+// Tool is the build information of the binary that wrote it.
+type manifest struct {
+	Tool   version.Info   `json:"tool"`
+	Output manifestFile   `json:"output"`
+	Files  []manifestFile `json:"files"`
+}
+
+// manifestFile is an entry of the provenance manifest.
+// Keys lists the top-level keys the file provided final values for.
+type manifestFile struct {
+	Path   string   `json:"path"`
+	SHA256 string   `json:"sha256"`
+	Keys   []string `json:"keys,omitempty"`
+}
+
+// writeManifest writes the provenance of the output file as JSON
+func (p *provenance) writeManifest(path string, outputFile string, output string) error {
+	contributed := map[string]map[string]bool{}
+	for keyPath, files := range p.keys {
+		file := files[len(files)-1]
+		if contributed[file] == nil {
+			contributed[file] = map[string]bool{}
+		}
+		contributed[file][strings.Split(keyPath, ".")[0]] = true
+	}
+
+	outputSum := sha256.Sum256([]byte(output))
+	m := manifest{
+		Tool:   version.Get(),
+		Output: manifestFile{Path: outputFile, SHA256: hex.EncodeToString(outputSum[:])},
+		Files:  []manifestFile{},
+	}
+	for _, file := range p.files {
+		keys := []string{}
+		for key := range contributed[file.path] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		m.Files = append(m.Files, manifestFile{Path: file.path, SHA256: file.sha256, Keys: keys})
+	}
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0660)
+}
+
+// joinComments adds a comment to the comment of an input file
+func joinComments(existing string, comment string, separator string) string {
+	if len(existing) == 0 {
+		return comment
+	}
+	return existing + separator + "# " + comment
+}
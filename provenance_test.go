@@ -0,0 +1,239 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
+	"github.com/imdario/mergo"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExplainSuccess verifies that the file providing the final value is reported first,
+// followed by the files it overrode
+func TestExplainSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+
+	expected := `test2.list2A
+  set by:    testdata/yaml/one.yaml
+  overrides: testdata/default/defaults.yml
+`
+	var result bytes.Buffer
+	assert.NoError(t, sources.explain(&result, "test2.list2A"))
+	assert.Equal(t, expected, result.String())
+
+	expected = `test1.test1A.one
+  set by:    testdata/default/defaults.yml
+test1.test1A.three
+  set by:    testdata/yaml/one.yaml
+test1.test1A.two
+  set by:    testdata/default/defaults.yml
+`
+	result.Reset()
+	assert.NoError(t, sources.explain(&result, "test1.test1A"))
+	assert.Equal(t, expected, result.String())
+
+	assert.Error(t, sources.explain(&result, "test1.missing"))
+}
+
+// TestProvenanceReplacedLeaves verifies that leaves disappear when a map is replaced by a scalar and vice versa
+func TestProvenanceReplacedLeaves(t *testing.T) {
+	sources := newProvenance()
+	data := map[string]interface{}{"a": map[string]interface{}{"b": 1}}
+	sources.record("one.yaml", "", data, data)
+	assert.Equal(t, map[string][]string{"a.b": {"one.yaml"}}, sources.keys)
+
+	data = map[string]interface{}{"a": "scalar"}
+	sources.record("two.yaml", "", data, data)
+	assert.Equal(t, map[string][]string{"a": {"two.yaml"}}, sources.keys)
+
+	data = map[string]interface{}{"a": map[string]interface{}{"c": 2}}
+	sources.record("three.yaml", "", data, data)
+	assert.Equal(t, map[string][]string{"a.c": {"three.yaml"}}, sources.keys)
+}
+
+// TestWriteManifest verifies the files, checksums and contributed top-level keys in the provenance manifest
+func TestWriteManifest(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/list-directives"
+
+	_, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	manifestPath := filepath.Join(t.TempDir(), "provenance.json")
+	assert.NoError(t, sources.writeManifest(manifestPath, "output.yaml", "network: {}\n"))
+
+	content, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("Error reading manifest: %v", err)
+	}
+	var result manifest
+	assert.NoError(t, json.Unmarshal(content, &result))
+
+	teamContent, err := os.ReadFile("testdata/list-directives/team.yaml")
+	if err != nil {
+		t.Fatalf("Error reading input file: %v", err)
+	}
+	teamSum := sha256.Sum256(teamContent)
+
+	assert.Equal(t, version.Version, result.Tool.Version)
+	assert.Equal(t, "output.yaml", result.Output.Path)
+	assert.Len(t, result.Files, 2)
+	// The defaults are completely overridden and the directives are not part of the output
+	assert.Equal(t, manifestFile{Path: "testdata/list-directives/defaults/allowlist.yaml", SHA256: result.Files[0].SHA256}, result.Files[0])
+	assert.Equal(t, manifestFile{Path: "testdata/list-directives/team.yaml", SHA256: hex.EncodeToString(teamSum[:]), Keys: []string{"network"}}, result.Files[1])
+}
+
+// TestAnnotateSuccess compares the output annotated with source files with the ones stored in git
+func TestAnnotateSuccess(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/test1"
+
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+
+	for _, mode := range []string{"top", "all"} {
+		expected, err := os.ReadFile("testdata/test1/result/annotated-" + mode + ".yaml")
+		if err != nil {
+			t.Fatalf("Error reading file with expected test results: %v", err)
+		}
+		node, err := sources.annotate(data, mode == "all")
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), renderOutput(node, false, false))
+	}
+}
+
+// TestEncodeKeyOrder verifies that keys are written in the order they were first defined,
+// including merged mappings and mappings in lists, followed by keys no file defined
+func TestEncodeKeyOrder(t *testing.T) {
+	sources := newProvenance()
+	for _, content := range []string{
+		"zeta: 1\nalpha:\n  w: 1\n  x: 2\n",
+		"gamma: &base {m: 1, k: 2}\nalpha:\n  z: 1\n  x: 3\nzeta: 2\nmerged:\n  <<: *base\n  b: 3\n",
+		"list:\n  - {name: a, id: 1}\n",
+	} {
+		_, document, err := parseInput("input.yaml", []byte(content))
+		assert.NoError(t, err)
+		sources.recordKeys("", document)
+	}
+	data := map[string]interface{}{
+		"zeta":   2,
+		"alpha":  map[string]interface{}{"w": 1, "x": 3, "z": 1},
+		"gamma":  map[string]interface{}{"m": 1, "k": 2},
+		"merged": map[string]interface{}{"m": 1, "k": 2, "b": 3},
+		"list":   []interface{}{map[string]interface{}{"name": "a", "id": 1}},
+		"added":  true,
+	}
+
+	node, err := sources.encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `zeta: 2
+alpha:
+    w: 1
+    x: 3
+    z: 1
+gamma:
+    m: 1
+    k: 2
+merged:
+    m: 1
+    k: 2
+    b: 3
+list:
+    - name: a
+      id: 1
+added: true
+`, renderOutput(node, true, false))
+}
+
+// TestEncodeComments verifies that keys keep the comments of the last file that defined them,
+// and that annotations are added to them
+func TestEncodeComments(t *testing.T) {
+	keepComments = true
+	defer func() { keepComments = false }()
+
+	sources := newProvenance()
+	data := map[string]interface{}{}
+	for i, content := range []string{
+		"# The application\napp:\n  # Name of the service\n  name: demo # overridden\n  replicas: 1 # default\n",
+		"app:\n  name: prod # the production name\n",
+	} {
+		mergeData, document, err := parseInput(fmt.Sprintf("%d.yaml", i+1), []byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, mergo.Merge(&data, mergeData, mergo.WithOverride))
+		sources.addFile(fmt.Sprintf("%d.yaml", i+1), []byte(content))
+		sources.record(fmt.Sprintf("%d.yaml", i+1), "", mergeData, data)
+		sources.recordKeys("", document)
+	}
+
+	node, err := sources.encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `# The application
+app:
+    name: prod # the production name
+    replicas: 1 # default
+`, renderOutput(node, true, false))
+
+	node, err = sources.annotate(data, true)
+	assert.NoError(t, err)
+	assert.Equal(t, `# The application
+app:
+    name: prod # the production name # from 2.yaml
+    replicas: 1 # default # from 1.yaml
+`, renderOutput(node, true, false))
+}
+
+// TestEncodeScalars verifies that numbers, booleans, and binary values are written as they were in the last file that defined them,
+// unless their value was replaced
+func TestEncodeScalars(t *testing.T) {
+	sources := newProvenance()
+	data := map[string]interface{}{}
+	for i, content := range []string{
+		"id: 0123\nsha: 12345e7\nversion: 1.10\nbig: 123456789012345678901234567890\nenabled: True\nport: 0x1F\nlist: [1.10, 007]\ncert: !!binary aGVsbG8=\n",
+		"port: 8080\nversion: 1.20\nenabled: \"yes\"\n",
+	} {
+		mergeData, document, err := parseInput(fmt.Sprintf("%d.yaml", i+1), []byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, mergo.Merge(&data, mergeData, mergo.WithOverride))
+		sources.record(fmt.Sprintf("%d.yaml", i+1), "", mergeData, data)
+		sources.recordKeys("", document)
+	}
+	// Replaced after merging, e.g. by a transform
+	data["id"] = 84
+
+	node, err := sources.encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `id: 84
+sha: 12345e7
+version: 1.20
+big: 123456789012345678901234567890
+enabled: "yes"
+port: 8080
+list:
+    - 1.10
+    - 007
+cert: !!binary aGVsbG8=
+`, renderOutput(node, true, false))
+}
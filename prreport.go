@@ -0,0 +1,391 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// reportValueLength limits the length of values shown in the report
+const reportValueLength = 60
+
+// rendering is the merged output of an environment, or the error merging it
+type rendering struct {
+	output map[string]interface{}
+	err    error
+}
+
+// keyChange is a leaf key path that was added, changed, or removed
+type keyChange struct {
+	key    string
+	before interface{}
+	after  interface{}
+	// kind is added, changed, or removed
+	kind string
+}
+
+// environmentReport lists the changes of an environment, i.e. a directory with a hierarchy file
+type environmentReport struct {
+	name    string
+	changes []keyChange
+	// failed explains why the environment cannot be compared
+	failed string
+}
+
+// runPullRequestReport renders every environment at two git refs and writes a Markdown summary of the changes
+func runPullRequestReport(cfg config, w io.Writer) {
+	top, err := gitOutput(cfg.basePath, "rev-parse", "--show-toplevel")
+	checkForErrorCode(errors.Wrap(err, "Base path is not in a git repository"), exitPath)
+	absBase, err := filepath.Abs(cfg.basePath)
+	checkForError(err)
+	absBase, err = filepath.EvalSymlinks(absBase)
+	checkForError(err)
+	rel, err := filepath.Rel(top, absBase)
+	checkForError(err)
+	executable, err := os.Executable()
+	checkForError(err)
+
+	before, err := renderRef(cfg, executable, top, rel, cfg.reportBase)
+	checkForErrorCode(err, exitPath)
+	after, err := renderRef(cfg, executable, top, rel, cfg.reportHead)
+	checkForErrorCode(err, exitPath)
+
+	reports := compareRenderings(before, after)
+	writeReport(w, cfg.reportBase, cfg.reportHead, reports)
+	for _, report := range reports {
+		if after[report.name].err != nil {
+			fatal(appLog, exitError, "Environment cannot be rendered at the head ref",
+				"environment", report.name,
+				"ref", cfg.reportHead,
+				"error", after[report.name].err,
+			)
+		}
+	}
+}
+
+// gitOutput runs git in dir and returns its trimmed standard output
+func gitOutput(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// renderRef extracts the repository at ref and merges every environment below the base path rel
+func renderRef(cfg config, executable string, top string, rel string, ref string) (map[string]rendering, error) {
+	dir, err := os.MkdirTemp("", "hierarchy-report-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	appLog.Info("Rendering environments", "ref", ref)
+	if err := extractRef(top, ref, dir); err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, rel)
+	renderings := map[string]rendering{}
+	for _, name := range findEnvironments(base, cfg.hierarchyFile) {
+		output, err := renderEnvironment(cfg, executable, filepath.Join(base, name))
+		renderings[filepath.ToSlash(name)] = rendering{output: output, err: err}
+	}
+	return renderings, nil
+}
+
+// extractRef writes the files of the repository at ref into dir
+func extractRef(top string, ref string, dir string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", top, "archive", "--format=tar", ref)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	extractErr := extractTar(stdout, dir)
+	// Drain the archive, so git is not blocked on a failed extraction
+	_, _ = io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return errors.Errorf("git archive %s: %v: %s", ref, err, strings.TrimSpace(stderr.String()))
+	}
+	return extractErr
+}
+
+// extractTar writes the directories, files, and symlinks of a tar archive into dir
+func extractTar(r io.Reader, dir string) error {
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !isWithinBase(dir, target) {
+			return errors.Errorf("archive entry %s is outside of the target directory", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0750)
+		case tar.TypeReg:
+			err = writeArchiveFile(archive, target, header.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeArchiveFile writes the current entry of the archive to target
+func writeArchiveFile(archive *tar.Reader, target string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, archive); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// findEnvironments returns the directories below base containing a hierarchy file, relative to base
+func findEnvironments(base string, hierarchyFile string) []string {
+	environments := []string{}
+	_ = filepath.WalkDir(base, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() != hierarchyFile {
+			return nil
+		}
+		if name, err := filepath.Rel(base, filepath.Dir(path)); err == nil {
+			environments = append(environments, name)
+		}
+		return nil
+	})
+	return environments
+}
+
+// renderEnvironment merges an environment in a child process and returns the merged output.
+// Only the settings affecting the merged output are passed on, nothing but the output is written.
+func renderEnvironment(cfg config, executable string, base string) (map[string]interface{}, error) {
+	// The values are joined with the flags, so a value starting with '-' is not taken for a flag
+	args := []string{
+		"--base=" + base,
+		"--file=" + cfg.hierarchyFile,
+		"--filter=" + cfg.filterExtension,
+		"--output=" + stdStream,
+		"--annotate=none",
+		"--log-level=quiet",
+	}
+	if len(cfg.compat) > 0 {
+		args = append(args, "--compat="+cfg.compat)
+	}
+	if len(cfg.stripKeys) > 0 {
+		args = append(args, "--strip-keys="+cfg.stripKeys)
+	}
+	for _, spec := range cfg.transforms {
+		args = append(args, "--transform="+spec)
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(executable, append(args, "merge")...)
+	cmd.Env = []string{mergeChildEnv + "=1"}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "HIERARCHY_") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, errors.Errorf("merge failed with exit code %d", exitErr.ExitCode())
+		}
+		return nil, err
+	}
+	output := map[string]interface{}{}
+	if err := yaml.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// compareRenderings returns the changes of every environment rendered at either ref, sorted by name.
+// Environments without changes are left out.
+func compareRenderings(before map[string]rendering, after map[string]rendering) []environmentReport {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	reports := []environmentReport{}
+	for _, name := range sortedBoolKeys(names) {
+		report := environmentReport{name: name}
+		previous, next := before[name], after[name]
+		switch {
+		case next.err != nil:
+			report.failed = fmt.Sprintf("cannot be rendered at the head ref: %v", next.err)
+		case previous.err != nil:
+			report.failed = fmt.Sprintf("cannot be rendered at the base ref: %v", previous.err)
+		default:
+			report.changes = diffLeaves(leaves(previous.output), leaves(next.output))
+		}
+		if len(report.changes) > 0 || len(report.failed) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// sortedBoolKeys returns the keys of a set in sorted order
+func sortedBoolKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// leaves returns the values of all leaf key paths. Lists are leaves, because they are replaced as a whole.
+func leaves(data map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	var walk func(prefix string, node map[string]interface{})
+	walk = func(prefix string, node map[string]interface{}) {
+		for key, value := range node {
+			if len(prefix) > 0 {
+				key = prefix + "." + key
+			}
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(key, nested)
+				continue
+			}
+			result[key] = value
+		}
+	}
+	walk("", data)
+	return result
+}
+
+// diffLeaves returns the added, changed, and removed leaf key paths sorted by key
+func diffLeaves(before map[string]interface{}, after map[string]interface{}) []keyChange {
+	changes := []keyChange{}
+	for key, value := range after {
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			changes = append(changes, keyChange{key: key, after: value, kind: "added"})
+		case !reflect.DeepEqual(previous, value):
+			changes = append(changes, keyChange{key: key, before: previous, after: value, kind: "changed"})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, keyChange{key: key, before: value, kind: "removed"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].key < changes[j].key })
+	return changes
+}
+
+// writeReport writes a Markdown summary of the changes for a code review
+func writeReport(w io.Writer, base string, head string, reports []environmentReport) {
+	fmt.Fprintf(w, "## Hierarchy changes `%s`...`%s`\n\n", base, head)
+	changed := 0
+	for _, report := range reports {
+		changed += len(report.changes)
+	}
+	if len(reports) == 0 {
+		fmt.Fprintln(w, "No environment changed.")
+		return
+	}
+	fmt.Fprintf(w, "Impact: **%d** keys in **%d** environments.\n\n", changed, len(reports))
+
+	fmt.Fprintln(w, "| Environment | Added | Changed | Removed |")
+	fmt.Fprintln(w, "| --- | ---: | ---: | ---: |")
+	for _, report := range reports {
+		if len(report.failed) > 0 {
+			fmt.Fprintf(w, "| `%s` | %s | | |\n", report.name, markdownCell(report.failed))
+			continue
+		}
+		counts := map[string]int{}
+		for _, change := range report.changes {
+			counts[change.kind]++
+		}
+		fmt.Fprintf(w, "| `%s` | %d | %d | %d |\n", report.name, counts["added"], counts["changed"], counts["removed"])
+	}
+
+	for _, report := range reports {
+		if len(report.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n<details><summary><code>%s</code></summary>\n\n", report.name)
+		fmt.Fprintln(w, "| Key | Before | After |")
+		fmt.Fprintln(w, "| --- | --- | --- |")
+		for _, change := range report.changes {
+			fmt.Fprintf(w, "| `%s` | %s | %s |\n", change.key, reportValue(change.before, change.kind != "added"), reportValue(change.after, change.kind != "removed"))
+		}
+		fmt.Fprintln(w, "\n</details>")
+	}
+}
+
+// reportValue formats a value as compact JSON for a table cell, or an empty cell if it is not set
+func reportValue(value interface{}, set bool) string {
+	if !set {
+		return ""
+	}
+	content, err := json.Marshal(value)
+	text := string(content)
+	if err != nil {
+		text = fmt.Sprint(value)
+	}
+	if len(text) > reportValueLength {
+		text = text[:reportValueLength] + "…"
+	}
+	return "`" + markdownCell(text) + "`"
+}
+
+// markdownCell escapes the characters that would end a table cell
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(text)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExtractRef verifies that the environments of a ref are found in its extracted files
+func TestExtractRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repository := t.TempDir()
+	files := map[string]string{
+		"config/defaults/app.yaml":          "app:\n  replicas: 1\n",
+		"config/prod/hierarchy.lst":         "../defaults\n./\n",
+		"config/prod/app.yaml":              "app:\n  replicas: 3\n",
+		"config/regions/east/hierarchy.lst": "../../defaults\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(repository, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "config"},
+	} {
+		if _, err := gitOutput(repository, args...); err != nil {
+			t.Fatalf("Error preparing repository: %v", err)
+		}
+	}
+
+	dir := t.TempDir()
+	assert.NoError(t, extractRef(repository, "HEAD", dir))
+	content, err := os.ReadFile(filepath.Join(dir, "config/prod/app.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "app:\n  replicas: 3\n", string(content))
+	assert.Equal(t, []string{"prod", filepath.Join("regions", "east")}, findEnvironments(filepath.Join(dir, "config"), "hierarchy.lst"))
+
+	assert.Error(t, extractRef(repository, "missing", t.TempDir()))
+}
+
+// TestExtractTarOutsideOfTarget verifies that archive entries cannot be written outside of the target directory
+func TestExtractTarOutsideOfTarget(t *testing.T) {
+	var archive bytes.Buffer
+	cmd := exec.Command("tar", "-cf", "-", "-P", "../escape")
+	cmd.Dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cmd.Dir), "escape"), []byte("escape"), 0600); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	cmd.Stdout = &archive
+	if err := cmd.Run(); err != nil {
+		t.Skipf("tar cannot create the archive: %v", err)
+	}
+	assert.Error(t, extractTar(&archive, t.TempDir()))
+}
+
+// TestPullRequestReport verifies the changes and the Markdown summary of environments rendered at two refs
+func TestPullRequestReport(t *testing.T) {
+	before := map[string]rendering{
+		"prod":    {output: map[string]interface{}{"app": map[string]interface{}{"replicas": 2, "image": "demo:1", "debug": true}}},
+		"stage":   {output: map[string]interface{}{"app": map[string]interface{}{"replicas": 1}}},
+		"old":     {output: map[string]interface{}{"app": map[string]interface{}{"replicas": 1}}},
+		"staging": {err: errors.New("merge failed with exit code 4")},
+	}
+	after := map[string]rendering{
+		"prod":    {output: map[string]interface{}{"app": map[string]interface{}{"replicas": 3, "image": "demo:1", "hosts": []interface{}{"a|b"}}}},
+		"stage":   {output: map[string]interface{}{"app": map[string]interface{}{"replicas": 1}}},
+		"staging": {output: map[string]interface{}{}},
+		"broken":  {err: errors.New("merge failed with exit code 3")},
+	}
+
+	var report bytes.Buffer
+	writeReport(&report, "main", "feature", compareRenderings(before, after))
+	assert.Equal(t, "## Hierarchy changes `main`...`feature`\n\n"+
+		"Impact: **4** keys in **4** environments.\n\n"+
+		"| Environment | Added | Changed | Removed |\n"+
+		"| --- | ---: | ---: | ---: |\n"+
+		"| `broken` | cannot be rendered at the head ref: merge failed with exit code 3 | | |\n"+
+		"| `old` | 0 | 0 | 1 |\n"+
+		"| `prod` | 1 | 1 | 1 |\n"+
+		"| `staging` | cannot be rendered at the base ref: merge failed with exit code 4 | | |\n"+
+		"\n<details><summary><code>old</code></summary>\n\n"+
+		"| Key | Before | After |\n"+
+		"| --- | --- | --- |\n"+
+		"| `app.replicas` | `1` |  |\n"+
+		"\n</details>\n"+
+		"\n<details><summary><code>prod</code></summary>\n\n"+
+		"| Key | Before | After |\n"+
+		"| --- | --- | --- |\n"+
+		"| `app.debug` | `true` |  |\n"+
+		"| `app.hosts` |  | `[\"a\\|b\"]` |\n"+
+		"| `app.replicas` | `2` | `3` |\n"+
+		"\n</details>\n", report.String())
+
+	report.Reset()
+	unchanged := map[string]rendering{"prod": before["prod"]}
+	writeReport(&report, "main", "feature", compareRenderings(unchanged, unchanged))
+	assert.Equal(t, "## Hierarchy changes `main`...`feature`\n\nNo environment changed.\n", report.String())
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// queryFormats are the formats the get command prints a value in
+var queryFormats = []string{"yaml", "json", "raw"}
+
+// queryStep is a key of a mapping or an index of a list in a query
+type queryStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// String returns the step as it is written in a query, quoting keys containing dots or brackets
+func (s queryStep) String() string {
+	switch {
+	case s.isIndex:
+		return fmt.Sprintf("[%d]", s.index)
+	case strings.ContainsAny(s.key, ".[]"):
+		return "[" + strconv.Quote(s.key) + "]"
+	default:
+		return "." + s.key
+	}
+}
+
+// formatQuery returns the steps as a query, e.g. a.b[2].c
+func formatQuery(steps []queryStep) string {
+	var query strings.Builder
+	for _, step := range steps {
+		query.WriteString(step.String())
+	}
+	return strings.TrimPrefix(query.String(), ".")
+}
+
+// parseQuery parses a query of dot-separated keys and list indices, e.g. a.b[2].c.
+// Keys containing dots or brackets are quoted in brackets, e.g. a["b.c"], negative indices count from the end of a list,
+// a leading dot is optional like in jq, and '.' is the whole document.
+func parseQuery(query string) ([]queryStep, error) {
+	steps := []queryStep{}
+	if query == "." {
+		return steps, nil
+	}
+	for i := 0; i < len(query); {
+		switch {
+		case strings.HasPrefix(query[i:], `["`) || strings.HasPrefix(query[i:], "['"):
+			quote := query[i+1]
+			end := i + 2
+			for ; end < len(query) && query[end] != quote; end++ {
+				if quote == '"' && query[end] == '\\' {
+					end++
+				}
+			}
+			if end+1 >= len(query) || query[end+1] != ']' {
+				return nil, fmt.Errorf("invalid query %q: unterminated key at position %d", query, i+1)
+			}
+			key := query[i+2 : end]
+			if quote == '"' {
+				unquoted, err := strconv.Unquote(query[i+1 : end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid query %q: invalid key %s", query, query[i+1:end+1])
+				}
+				key = unquoted
+			}
+			steps = append(steps, queryStep{key: key})
+			i = end + 2
+		case query[i] == '[':
+			end := strings.IndexByte(query[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid query %q: unterminated '['", query)
+			}
+			index, err := strconv.Atoi(query[i+1 : i+end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid query %q: invalid index %s", query, query[i:i+end+1])
+			}
+			steps = append(steps, queryStep{index: index, isIndex: true})
+			i += end + 1
+		case query[i] == '.' && i+1 < len(query) && query[i+1] != '.' && (query[i+1] != '[' || i == 0):
+			i++
+		case query[i] == '.':
+			return nil, fmt.Errorf("invalid query %q: empty key at position %d", query, i+1)
+		default:
+			end := strings.IndexAny(query[i:], ".[")
+			if end < 0 {
+				end = len(query) - i
+			}
+			steps = append(steps, queryStep{key: query[i : i+end]})
+			i += end
+		}
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("invalid query %q", query)
+	}
+	return steps, nil
+}
+
+// queryNode returns the node of a query below a node, or an error naming the part of the query which does not exist.
+// The keys of the steps are set to their spelling in the document, which differs from the query with --key-case.
+func queryNode(node *yaml.Node, steps []queryStep) (*yaml.Node, error) {
+	for i, step := range steps {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		var found *yaml.Node
+		switch {
+		case step.isIndex && node.Kind == yaml.SequenceNode:
+			index := step.index
+			if index < 0 {
+				index += len(node.Content)
+			}
+			if index < 0 || index >= len(node.Content) {
+				return nil, fmt.Errorf("%s not found, the list has %d items", formatQuery(steps[:i+1]), len(node.Content))
+			}
+			found = node.Content[index]
+		case !step.isIndex && node.Kind == yaml.MappingNode:
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if foldKey(node.Content[j].Value) == foldKey(step.key) {
+					found = node.Content[j+1]
+					steps[i].key = node.Content[j].Value
+				}
+			}
+			if found == nil {
+				return nil, fmt.Errorf("%s not found", formatQuery(steps[:i+1]))
+			}
+		case step.isIndex:
+			return nil, fmt.Errorf("%s not found, %s is not a list", formatQuery(steps[:i+1]), describeParent(steps[:i]))
+		default:
+			return nil, fmt.Errorf("%s not found, %s is not a map", formatQuery(steps[:i+1]), describeParent(steps[:i]))
+		}
+		node = found
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node, nil
+}
+
+// describeParent names the value a query step is applied to
+func describeParent(steps []queryStep) string {
+	if len(steps) == 0 {
+		return "the document"
+	}
+	return formatQuery(steps)
+}
+
+// queryDocument returns the node of a query of a merged YAML document and the steps of the query.
+// An empty document is null.
+func queryDocument(output string, query string) (*yaml.Node, []queryStep, error) {
+	steps, err := parseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	document := yaml.Node{}
+	if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+		return nil, nil, err
+	}
+	root := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	if len(document.Content) > 0 {
+		root = document.Content[0]
+	}
+	node, err := queryNode(root, steps)
+	return node, steps, err
+}
+
+// writeQuery prints the value of a query of a merged YAML document in a format: 'yaml', 'json',
+// or 'raw', which prints strings without quotes and other values as JSON
+func writeQuery(w io.Writer, output string, query string, format string) error {
+	node, _, err := queryDocument(output, query)
+	if err != nil {
+		return err
+	}
+
+	if format == "yaml" {
+		content, err := outputStyle.marshal(node)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+	if scalar := resolveAlias(node); format == "raw" && scalar.Kind == yaml.ScalarNode {
+		literal, _, err := scalarLiteral(scalar)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, literal)
+		return err
+	}
+	content, err := nodeToJSON(node)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, content, "", "  "); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, indented.String())
+	return err
+}
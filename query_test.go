@@ -0,0 +1,86 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseQuery verifies the keys and indices of queries, and that invalid queries fail
+func TestParseQuery(t *testing.T) {
+	for query, expected := range map[string][]queryStep{
+		".":               {},
+		"a":               {{key: "a"}},
+		"a.b[2].c":        {{key: "a"}, {key: "b"}, {index: 2, isIndex: true}, {key: "c"}},
+		".a[-1]":          {{key: "a"}, {index: -1, isIndex: true}},
+		"[0].name":        {{index: 0, isIndex: true}, {key: "name"}},
+		`a["b.c"]['[d]']`: {{key: "a"}, {key: "b.c"}, {key: "[d]"}},
+	} {
+		steps, err := parseQuery(query)
+		assert.NoError(t, err, query)
+		assert.Equal(t, expected, steps, query)
+	}
+
+	for _, query := range []string{"", "a..b", "a.", "a[", "a[x]", "a.[0]", `a["b]`} {
+		_, err := parseQuery(query)
+		assert.Error(t, err, query)
+	}
+}
+
+// TestWriteQuery verifies the formats of values, and the errors of queries which do not exist
+func TestWriteQuery(t *testing.T) {
+	output := "app:\n  name: demo\n  replicas: 3\n  hosts: &hosts\n    - name: web1\n      port: 80\n    - name: web2\n      port: 8080\n  backup: *hosts\n  \"log.level\": debug\n  sha: 0123\n  version: 1.10\n"
+	for _, test := range []struct {
+		query    string
+		format   string
+		expected string
+	}{
+		{"app.name", "yaml", "demo\n"},
+		{"app.name", "json", "\"demo\"\n"},
+		{"app.name", "raw", "demo\n"},
+		{"app.replicas", "raw", "3\n"},
+		{"app.hosts[1].port", "raw", "8080\n"},
+		{"app.backup[-1].name", "raw", "web2\n"},
+		{`app["log.level"]`, "raw", "debug\n"},
+		{"app.hosts[0]", "yaml", "name: web1\nport: 80\n"},
+		{"app.hosts[0]", "json", "{\n  \"name\": \"web1\",\n  \"port\": 80\n}\n"},
+		{"app.sha", "json", "\"0123\"\n"},
+		{"app.sha", "raw", "0123\n"},
+		{"app.version", "json", "1.10\n"},
+	} {
+		var w strings.Builder
+		assert.NoError(t, writeQuery(&w, output, test.query, test.format), test.query)
+		assert.Equal(t, test.expected, w.String(), test.query)
+	}
+
+	for query, message := range map[string]string{
+		"app.missing":        "app.missing not found",
+		"app.hosts[2]":       "app.hosts[2] not found, the list has 2 items",
+		"app.name.first":     "app.name.first not found, app.name is not a map",
+		"app[0]":             "app[0] not found, app is not a list",
+		"missing.app":        "missing not found",
+		`app["log.level"].x`: `app["log.level"].x not found, app["log.level"] is not a map`,
+	} {
+		err := writeQuery(&strings.Builder{}, output, query, "yaml")
+		if assert.Error(t, err, query) {
+			assert.Equal(t, message, err.Error(), query)
+		}
+	}
+}
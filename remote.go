@@ -0,0 +1,200 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// remoteSource fetches the files of a hierarchy entry, which is not a local directory, into the empty directory dir
+type remoteSource func(cfg config, entry string, dir string) error
+
+// remoteSources maps the prefix of hierarchy entries to the source fetching them
+var remoteSources = map[string]remoteSource{
+	"http://":          fetchHTTPSource,
+	"https://":         fetchHTTPSource,
+	gitSourcePrefix:    fetchGitSource,
+	"s3://":            fetchObjectStoreSource,
+	"gs://":            fetchObjectStoreSource,
+	"az://":            fetchObjectStoreSource,
+	ociSourcePrefix:    fetchOCISource,
+	consulSourcePrefix: fetchConsulSource,
+}
+
+// findRemoteSource returns the source fetching a hierarchy entry, if the entry is not a local directory
+func findRemoteSource(entry string) (remoteSource, bool) {
+	for prefix, source := range remoteSources {
+		if strings.HasPrefix(entry, prefix) {
+			return source, true
+		}
+	}
+	return nil, false
+}
+
+// cacheDir returns the directory remote sources are stored in, see --cache-dir
+func cacheDir(cfg config) string {
+	if len(cfg.cacheDir) > 0 {
+		return cfg.cacheDir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "hierarchy")
+	}
+	return filepath.Join(os.TempDir(), "hierarchy")
+}
+
+// fetchRemoteSource fetches a remote hierarchy entry and returns the directory its files are stored in.
+// Directories are named after the digest of their content, so merges running at the same time
+// never see the files of each other half-written. The digest of every entry is recorded in the index
+// of the cache, which answers entries with --offline, and immutable entries without fetching them again.
+func fetchRemoteSource(cfg config, source remoteSource, entry string) (string, error) {
+	if cfg.offline || isImmutableSource(entry) {
+		if path, ok := cachedRemoteSource(cfg, entry); ok {
+			return path, nil
+		}
+		if cfg.offline {
+			return "", errors.New("remote source is not in the cache and cannot be fetched with --offline")
+		}
+	}
+	sources := filepath.Join(cacheDir(cfg), "sources")
+	if err := os.MkdirAll(sources, 0755); err != nil {
+		return "", errors.Wrap(err, "Error creating cache directory")
+	}
+	dir, err := os.MkdirTemp(sources, ".fetch-")
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating cache directory")
+	}
+	defer os.RemoveAll(dir)
+	if err := source(cfg, entry, dir); err != nil {
+		return "", err
+	}
+	digest, err := digestDir(dir)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(sources, digest)
+	if _, err := os.Stat(path); err != nil {
+		if err := os.Rename(dir, path); err != nil {
+			// Another merge stored the same content first
+			if _, statErr := os.Stat(path); statErr != nil {
+				return "", errors.Wrap(err, "Error storing remote source in cache")
+			}
+		}
+	}
+	return path, indexRemoteSource(cfg, entry, digest)
+}
+
+// isImmutableSource reports whether the content of an entry can never change,
+// which are OCI artifacts pinned by digest and git sources pinned by a full commit hash
+func isImmutableSource(entry string) bool {
+	if strings.HasPrefix(entry, ociSourcePrefix) {
+		return strings.Contains(entry, "@sha256:")
+	}
+	if strings.HasPrefix(entry, gitSourcePrefix) {
+		_, _, ref, err := parseGitSource(entry)
+		return err == nil && commitHash.MatchString(ref)
+	}
+	return false
+}
+
+// commitHash matches the full SHA-1 or SHA-256 hash of a git commit
+var commitHash = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// indexPath returns the file of the cache index recording the digest of an entry
+func indexPath(cfg config, entry string) string {
+	sum := sha256.Sum256([]byte(entry))
+	return filepath.Join(cacheDir(cfg), "index", hex.EncodeToString(sum[:]))
+}
+
+// cachedRemoteSource returns the directory of the content last fetched for an entry
+func cachedRemoteSource(cfg config, entry string) (string, bool) {
+	digest, err := os.ReadFile(indexPath(cfg, entry))
+	if err != nil {
+		return "", false
+	}
+	path := filepath.Join(cacheDir(cfg), "sources", strings.TrimSpace(string(digest)))
+	if stat, err := os.Stat(path); err != nil || !stat.IsDir() {
+		return "", false
+	}
+	resolverLog.Debug("Using cached remote source", "source", entry, "path", path)
+	return path, true
+}
+
+// indexRemoteSource records the digest of the content fetched for an entry, replacing the index file atomically
+func indexRemoteSource(cfg config, entry string, digest string) error {
+	index := indexPath(cfg, entry)
+	if err := os.MkdirAll(filepath.Dir(index), 0755); err != nil {
+		return errors.Wrap(err, "Error creating cache directory")
+	}
+	file, err := os.CreateTemp(filepath.Dir(index), ".index-")
+	if err != nil {
+		return errors.Wrap(err, "Error writing cache index")
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(digest + "\n"); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error writing cache index")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "Error writing cache index")
+	}
+	return errors.Wrap(os.Rename(file.Name(), index), "Error writing cache index")
+}
+
+// digestDir returns the SHA-256 of the names and contents of all files in a directory and its subdirectories
+func digestDir(dir string) (string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		// A symlink of a remote source must not read a file of the host
+		if !info.Mode().IsRegular() {
+			return errors.Errorf("%s is not a regular file", filepath.Base(path))
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = checksum(content)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name + "\x00" + files[name] + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
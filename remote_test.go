@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSource returns a remote source writing content, which counts how often it was fetched
+func countingSource(content *string, fetches *int) remoteSource {
+	return func(cfg config, entry string, dir string) error {
+		*fetches++
+		if content == nil {
+			return errors.New("network is unreachable")
+		}
+		return os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(*content), 0644)
+	}
+}
+
+// TestRemoteSourceCache verifies that content is stored by digest and mutable entries are fetched again
+func TestRemoteSourceCache(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.cacheDir = t.TempDir()
+	content, fetches := "app: v1\n", 0
+	source := countingSource(&content, &fetches)
+
+	first, err := fetchRemoteSource(cfg, source, "https://config.example.com/app.yaml")
+	assert.NoError(t, err)
+	second, err := fetchRemoteSource(cfg, source, "https://config.example.com/app.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 2, fetches)
+
+	content = "app: v2\n"
+	third, err := fetchRemoteSource(cfg, source, "https://config.example.com/app.yaml")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, third)
+	assert.DirExists(t, first)
+}
+
+// TestRemoteSourceOffline verifies that --offline uses the content last fetched and fails for entries not in the cache
+func TestRemoteSourceOffline(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.cacheDir = t.TempDir()
+	content, fetches := "app: v1\n", 0
+	online, err := fetchRemoteSource(cfg, countingSource(&content, &fetches), "s3://configs/org")
+	assert.NoError(t, err)
+
+	cfg.offline = true
+	offline, err := fetchRemoteSource(cfg, countingSource(nil, &fetches), "s3://configs/org")
+	assert.NoError(t, err)
+	assert.Equal(t, online, offline)
+	assert.Equal(t, 1, fetches)
+
+	_, err = fetchRemoteSource(cfg, countingSource(nil, &fetches), "s3://configs/other")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "--offline")
+	}
+	assert.Equal(t, 1, fetches)
+}
+
+// TestImmutableRemoteSource verifies that entries pinned by digest or commit are fetched only once
+func TestImmutableRemoteSource(t *testing.T) {
+	assert.True(t, isImmutableSource("oci://ghcr.io/org/config@sha256:0d3f8a3b5e7c9d1f2a4b6c8e0f1a3b5c7d9e1f2a4b6c8d0e2f4a6b8c0d2e4f6a"))
+	assert.True(t, isImmutableSource("git::https://github.com/org/config//base?ref=3f0c2a1b9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a"))
+	assert.False(t, isImmutableSource("oci://ghcr.io/org/config:v1.2.0"))
+	assert.False(t, isImmutableSource("git::https://github.com/org/config//base?ref=3f0c2a1"))
+	assert.False(t, isImmutableSource("https://config.example.com/app.yaml"))
+
+	cfg := cfgDefaults
+	cfg.cacheDir = t.TempDir()
+	content, fetches := "app: v1\n", 0
+	entry := "oci://ghcr.io/org/config@sha256:0d3f8a3b5e7c9d1f2a4b6c8e0f1a3b5c7d9e1f2a4b6c8d0e2f4a6b8c0d2e4f6a"
+	first, err := fetchRemoteSource(cfg, countingSource(&content, &fetches), entry)
+	assert.NoError(t, err)
+	second, err := fetchRemoteSource(cfg, countingSource(&content, &fetches), entry)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, fetches)
+}
+
+// TestRemoteSourceSymlink verifies that remote sources cannot contain symlinks to files of the host
+func TestRemoteSourceSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	cfg := cfgDefaults
+	cfg.cacheDir = t.TempDir()
+	_, err := fetchRemoteSource(cfg, func(cfg config, entry string, dir string) error {
+		return os.Symlink("/etc/hostname", filepath.Join(dir, "host.yaml"))
+	}, "https://config.example.com/index")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "host.yaml is not a regular file")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/resolver"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces the values of secrets in diffs and the output of --dry-run
+const redacted = "<redacted>"
+
+// secretValues are the values of the secrets resolved by this process, which are redacted from diffs and --dry-run
+var secretValues = map[string]bool{}
+
+// newSecretResolvers returns the resolvers of all secret stores by scheme, the built-in ones
+// and those registered by library users, which take precedence. Stores only connect when the first reference is resolved.
+func newSecretResolvers(cfg config) resolver.Resolvers {
+	v := newVaultClient(cfg)
+	a := newAWSClient(cfg)
+	g := newGCPClient()
+	z := newAzureClient(azureKeyVaultResource)
+	e := newExecLookup(cfg.enableExecLookups, cfg.execLookupsAllow)
+	resolvers := resolver.Resolvers{
+		"vault":  resolver.Func(v.resolve),
+		"ssm":    resolver.Func(a.resolveParameter),
+		"aws-sm": resolver.Func(a.resolveSecret),
+		"gcp-sm": resolver.Func(g.resolveSecret),
+		"akv":    resolver.Func(z.resolveSecret),
+		"exec":   resolver.Func(e.resolve),
+	}
+	for scheme, registered := range resolver.Registered() {
+		resolvers[scheme] = registered
+	}
+	return resolvers
+}
+
+// replaceSecretReferences replaces all references to secrets in the keys and strings of a document with their values.
+// References are replaced in the nodes before the document is rendered, so a value is always written as a string,
+// quoted or as a block scalar where needed, whatever characters or lines it contains.
+// Like environment variables, a secret that cannot be read fails if failMissing is set, otherwise the reference is kept.
+func replaceSecretReferences(node *yaml.Node, resolvers resolver.Resolvers, failMissing bool) {
+	// Every secret is read, and every failure logged, once per document
+	values := map[string]string{}
+	failures := map[string]error{}
+	cached := resolver.Resolvers{}
+	for scheme, r := range resolvers {
+		scheme, r := scheme, r
+		cached[scheme] = resolver.Func(func(reference string) (string, error) {
+			key := scheme + ":" + reference
+			if value, ok := values[key]; ok {
+				return value, nil
+			}
+			if err, ok := failures[key]; ok {
+				return "", err
+			}
+			value, err := r.Resolve(reference)
+			if err != nil {
+				failures[key] = err
+				stats.SubstitutionFailures++
+				fields := []interface{}{"reference", key, "error", err}
+				if failMissing {
+					fail(substitutionLog, exitVariable, "Secret not found", fields...)
+				} else {
+					substitutionLog.Warn("Secret not found, skipping", fields...)
+				}
+				return "", err
+			}
+			values[key] = value
+			if len(value) > 0 {
+				secretValues[value] = true
+			}
+			stats.Secrets++
+			return value, nil
+		})
+	}
+	replaceNodeReferences(node, cached)
+}
+
+// replaceNodeReferences replaces the references in the scalars of a node and all nodes below it
+func replaceNodeReferences(node *yaml.Node, resolvers resolver.Resolvers) {
+	if node.Kind == yaml.ScalarNode && resolver.Reference.MatchString(node.Value) {
+		value := resolvers.Replace(node.Value, nil)
+		if value != node.Value {
+			node.Value = value
+			node.Tag = "!!str"
+		}
+		return
+	}
+	for _, child := range node.Content {
+		replaceNodeReferences(child, resolvers)
+	}
+}
+
+// resolveSecrets replaces the secret references of the rendered document. It runs after environment variables
+// were replaced, so the value of a secret is never taken for a variable and ends up in the output as it is.
+func resolveSecrets(cfg config, output string) string {
+	if !resolver.Reference.MatchString(output) {
+		return output
+	}
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(output), &document)
+	checkForError(errors.Wrap(err, "Error parsing output to resolve secret references"))
+	replaceSecretReferences(&document, newSecretResolvers(cfg), cfg.failMissingSecret)
+	content, err := outputStyle.marshal(&document)
+	checkForError(err)
+	return string(content)
+}
+
+// secretForms returns the values of the resolved secrets as they can appear in the output, longest first:
+// as they are, every line of a multi-line value, and quoted with the escapes of JSON and YAML
+func secretForms() []string {
+	forms := map[string]bool{}
+	for value := range secretValues {
+		values := []string{value}
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); len(line) > 0 {
+				values = append(values, line)
+			}
+		}
+		for _, v := range values {
+			forms[v] = true
+			forms[strings.ReplaceAll(v, "'", "''")] = true
+			var quoted bytes.Buffer
+			encoder := json.NewEncoder(&quoted)
+			encoder.SetEscapeHTML(false)
+			if encoder.Encode(v) == nil {
+				escaped := strings.TrimSuffix(quoted.String(), "\n")
+				forms[escaped[1:len(escaped)-1]] = true
+			}
+		}
+	}
+	sorted := make([]string, 0, len(forms))
+	for form := range forms {
+		sorted = append(sorted, form)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// redactSecrets replaces the values of all resolved secrets in text
+func redactSecrets(text string) string {
+	if len(secretValues) == 0 {
+		return text
+	}
+	for _, form := range secretForms() {
+		text = strings.ReplaceAll(text, form, redacted)
+	}
+	return text
+}
+
+// redactDiff replaces the values of all resolved secrets in a unified diff. The value a secret had before cannot be known,
+// so removed lines are redacted after their key if the lines replacing them hold a secret.
+func redactDiff(diff string) string {
+	if len(secretValues) == 0 {
+		return diff
+	}
+	lines := diffLines(diff)
+	i := 0
+	// The file names of the header are kept
+	for ; i < len(lines) && !strings.HasPrefix(lines[i], "@@"); i++ {
+	}
+	for i < len(lines) {
+		if !strings.HasPrefix(lines[i], "-") && !strings.HasPrefix(lines[i], "+") {
+			lines[i] = redactSecrets(lines[i])
+			i++
+			continue
+		}
+		removed := i
+		for ; i < len(lines) && strings.HasPrefix(lines[i], "-"); i++ {
+		}
+		added := i
+		secret := false
+		for ; i < len(lines) && strings.HasPrefix(lines[i], "+"); i++ {
+			if line := redactSecrets(lines[i]); line != lines[i] {
+				lines[i] = line
+				secret = true
+			}
+		}
+		for j := removed; j < added; j++ {
+			if secret {
+				lines[j] = redactLine(lines[j])
+			} else {
+				lines[j] = redactSecrets(lines[j])
+			}
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// redactLine replaces everything after the indentation and the key of a diff line
+func redactLine(line string) string {
+	content := strings.TrimSuffix(line[1:], "\n")
+	prefix := content[:len(content)-len(strings.TrimLeft(content, " \t-"))]
+	if i := strings.Index(content, ": "); i >= 0 {
+		prefix = content[:i+2]
+	}
+	return line[:1] + prefix + redacted + "\n"
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/logging"
+	"github.com/KohlsTechnology/hierarchy/pkg/resolver"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// resolveSecretsIn replaces the secret references of a YAML document and renders it again
+func resolveSecretsIn(t *testing.T, content string, resolvers resolver.Resolvers, failMissing bool) string {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatalf("Error parsing document: %v", err)
+	}
+	replaceSecretReferences(&document, resolvers, failMissing)
+	output, err := outputStyle.marshal(&document)
+	if err != nil {
+		t.Fatalf("Error rendering document: %v", err)
+	}
+	return string(output)
+}
+
+// TestRegisteredSecretResolver verifies that resolvers registered by library users are used next to the built-in ones
+func TestRegisteredSecretResolver(t *testing.T) {
+	resolver.Register("test-cmdb", resolver.Func(func(reference string) (string, error) {
+		return "db-01.example.com", nil
+	}))
+
+	resolvers := newSecretResolvers(cfgDefaults)
+	assert.Equal(t, []string{"akv", "aws-sm", "exec", "gcp-sm", "ssm", "test-cmdb", "vault"}, resolvers.Schemes())
+	assert.Equal(t, "host: db-01.example.com\n", resolveSecretsIn(t, "host: ${test-cmdb:payments/database}\n", resolvers, true))
+}
+
+// TestSecretValuesAreStrings verifies that values starting with YAML indicators, looking like numbers, or spanning lines
+// are written as strings which parse back to the value of the secret
+func TestSecretValuesAreStrings(t *testing.T) {
+	values := map[string]string{
+		"at":      "@dm1n",
+		"alias":   "*ref",
+		"anchor":  "&ref",
+		"tag":     "!secret",
+		"comment": "# not a comment",
+		"octal":   "0123",
+		"bool":    "true",
+		"mapping": "key: value",
+		"pem":     "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+	}
+	calls := 0
+	resolvers := resolver.Resolvers{
+		"test": resolver.Func(func(reference string) (string, error) {
+			calls++
+			return values[reference], nil
+		}),
+	}
+
+	content := "url: https://admin:${test:at}@db.example.com\n"
+	for _, key := range []string{"at", "alias", "anchor", "tag", "comment", "octal", "bool", "mapping", "pem"} {
+		content += key + ": ${test:" + key + "}\n"
+	}
+	stats = mergeStats{}
+	defer func() { stats = mergeStats{} }()
+	output := resolveSecretsIn(t, content, resolvers, true)
+	assert.Equal(t, len(values), calls)
+	assert.Equal(t, len(values), stats.Secrets)
+
+	var document map[string]interface{}
+	if assert.NoError(t, yaml.Unmarshal([]byte(output), &document), output) {
+		for key, value := range values {
+			assert.Equal(t, value, document[key], key)
+		}
+		assert.Equal(t, "https://admin:@dm1n@db.example.com", document["url"])
+	}
+	assert.Contains(t, output, "pem: |\n")
+}
+
+// TestRedactDiff verifies that secrets are redacted in every form they can be written in,
+// and that a removed line replaced by a line holding a secret is redacted after its key
+func TestRedactDiff(t *testing.T) {
+	secretValues = map[string]bool{"n3w-s3cr3t": true, "it's\n\"quoted\"\n": true}
+	defer func() { secretValues = map[string]bool{} }()
+
+	previous := "db:\n  password: old-s3cr3t\n  user: app\nnote: it's\n"
+	current := "db:\n  password: n3w-s3cr3t\n  user: app\nnote: it's\nquoted: 'it''s'\njson: \"it's\\n\\\"quoted\\\"\\n\"\n"
+	expected := "--- out.yaml\n+++ out.yaml\n@@ -1,4 +1,6 @@\n db:\n-  password: <redacted>\n+  password: <redacted>\n" +
+		"   user: app\n note: <redacted>\n+quoted: '<redacted>'\n+json: \"<redacted>\"\n"
+	assert.Equal(t, expected, redactDiff(unifiedDiff("out.yaml", previous, current)))
+	assert.Equal(t, "-- <redacted>\n", redactLine("-- old-s3cr3t\n"))
+}
+
+// TestEnd2EndSecretsRedacted verifies that secrets are resolved after environment variables,
+// and that their values are redacted in the diff, the diff for the webhook, and the output of --dry-run
+func TestEnd2EndSecretsRedacted(t *testing.T) {
+	resolver.Register("test-rotating", resolver.Func(func(reference string) (string, error) {
+		return "n3w-s3cr3t-${TEST_DB_USER}", nil
+	}))
+	t.Setenv("TEST_DB_USER", "app")
+	defer func() {
+		secretValues = map[string]bool{}
+		stats = mergeStats{}
+	}()
+
+	cfg := writeRemoteHierarchy(t, "defaults")
+	if err := os.Mkdir(filepath.Join(cfg.basePath, "defaults"), 0755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	content := "db:\n  password: ${test-rotating:db}\n  user: ${TEST_DB_USER}\n"
+	if err := os.WriteFile(filepath.Join(cfg.basePath, "defaults", "app.yaml"), []byte(content), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.annotate = "none"
+	if err := os.WriteFile(cfg.outputFile, []byte("db:\n    password: old-s3cr3t\n    user: app\n"), 0600); err != nil {
+		t.Fatalf("Error writing output file: %v", err)
+	}
+
+	// Diff of --diff and for the webhook
+	cfg.readOnly = true
+	cfg.diffOutput = true
+	cfg.webhookURL = "http://127.0.0.1:0"
+	var logs bytes.Buffer
+	setupLogging(&logs, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	runMerge(cfg)
+	setupLogging(os.Stdout, logging.FormatText, logging.Levels{Default: slog.LevelInfo})
+	diff := "@@ -1,3 +1,3 @@\n db:\n-    password: <redacted>\n+    password: <redacted>\n     user: app\n"
+	assert.Contains(t, logs.String(), diff)
+	assert.NotContains(t, logs.String(), "s3cr3t")
+	assert.Equal(t, 1, stats.Secrets)
+	assert.Contains(t, stats.Diff, diff)
+	assert.NotContains(t, stats.Diff, "s3cr3t")
+
+	// Output of --dry-run
+	cfg.readOnly = false
+	cfg.diffOutput = false
+	cfg.webhookURL = ""
+	cfg.dryRun = true
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	runMerge(cfg)
+	os.Stdout = stdout
+	writer.Close()
+	result, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Contains(t, string(result), "db:\n    password: <redacted>\n    user: app\n")
+	assert.NotContains(t, string(result), "s3cr3t")
+
+	// The variable in the value of the secret is written as it is
+	cfg.dryRun = false
+	runMerge(cfg)
+	output, err := os.ReadFile(cfg.outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "db:\n    password: n3w-s3cr3t-${TEST_DB_USER}\n    user: app\n", string(output))
+}
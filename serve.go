@@ -0,0 +1,277 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/pkg/cache"
+	"github.com/KohlsTechnology/hierarchy/pkg/notify"
+	"github.com/KohlsTechnology/hierarchy/pkg/version"
+)
+
+// runServe serves the merged document over HTTP until the process is interrupted
+func runServe(cfg config) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	executable, err := os.Executable()
+	checkForError(err)
+	// Every merge runs in a child process writing the document to standard output,
+	// so a broken input only fails the requests until it is fixed
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, executable, os.Args[1:]...)
+		cmd.Env = append(os.Environ(), mergeChildEnv+"=1")
+		return cmd
+	})
+
+	listener, err := net.Listen("tcp", cfg.listenAddress)
+	checkForError(err)
+	// Requests share the context of the process, so open streams end on shutdown
+	server := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		notifyState(notify.Stopping())
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if cfg.grpcListenAddress != "" {
+		grpcListener, err := net.Listen("tcp", cfg.grpcListenAddress)
+		checkForError(err)
+		grpcServer := newGRPCServer(s)
+		// Stop instead of GracefulStop, which would wait for the open WatchConfig streams
+		go func() {
+			<-ctx.Done()
+			grpcServer.Stop()
+		}()
+		go func() {
+			checkForError(grpcServer.Serve(grpcListener))
+		}()
+		appLog.Info("Serving config service over gRPC", "address", grpcListener.Addr().String())
+	}
+
+	appLog.Info("Serving merged document", "address", listener.Addr().String())
+	notifyState(notify.Ready())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		checkForError(err)
+	}
+}
+
+// configServer merges the hierarchy on request and keeps the result until an input changes
+type configServer struct {
+	cfg     config
+	command func(ctx context.Context) *exec.Cmd
+	results *cache.Cache
+	// mutex lets concurrent requests wait for a single merge
+	mutex sync.Mutex
+}
+
+func newConfigServer(cfg config, command func(ctx context.Context) *exec.Cmd) *configServer {
+	return &configServer{cfg: cfg, command: command, results: cache.New(cfg.serveCacheTTL)}
+}
+
+// handler serves the merged document at /config, its updates at /config/watch, the build information at /version,
+// metrics at /metrics, and Spring Cloud Config environments at /{application}/{profile}
+func (s *configServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", s.serveConfig)
+	mux.HandleFunc("/config/watch", s.serveConfigWatch)
+	mux.HandleFunc("/version", serveVersion)
+	mux.Handle("/metrics", childMetrics.registry)
+	mux.HandleFunc("/", s.serveSpringEnvironment)
+	return mux
+}
+
+// merge returns the document merged with the variables, merging again only if an input changed since the last merge
+func (s *configServer) merge(ctx context.Context, variables map[string]string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dirs := watchedDirs(s.cfg, variables)
+	key := cache.NewKey(cache.Digest(dirs), variables, "")
+	if output, ok := s.results.Get(key); ok {
+		return output, nil
+	}
+
+	// Record the state before merging, so changes made during the merge are merged by the next request
+	files := cache.Stat(append(watchedFiles(s.cfg, dirs), dirs...)...)
+	var stdout bytes.Buffer
+	cmd := s.command(ctx)
+	if len(variables) > 0 && cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	for name, value := range variables {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	childStats, err := childMetrics.run(cmd)
+	if err != nil {
+		return nil, err
+	}
+	fields := []interface{}{"watched", len(files)}
+	if len(variables) > 0 {
+		fields = append(fields, "variables", variables)
+	}
+	appLog.Info("Merged hierarchy for requests", fields...)
+	// Changed secrets cannot be noticed, so without --serve.cache-ttl a document resolving them is not kept.
+	// Remote sources which can change are fetched again by the first merge after --remote.refresh-interval.
+	if childStats.Secrets > 0 && s.cfg.serveCacheTTL <= 0 {
+		appLog.Debug("Not keeping merged document resolving secrets", "secrets", childStats.Secrets)
+	} else if len(refetchedSources(s.cfg, variables)) > 0 {
+		s.results.PutFor(key, stdout.Bytes(), files, s.cfg.remoteRefreshInterval)
+	} else {
+		s.results.Put(key, stdout.Bytes(), files)
+	}
+	return stdout.Bytes(), nil
+}
+
+// serveConfig writes the merged document as YAML, or as JSON if the client accepts it
+func (s *configServer) serveConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	output, ok := s.mergeForRequest(w, r, nil)
+	if !ok {
+		return
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/yaml")
+		_, _ = w.Write(output)
+		return
+	}
+	document, err := yamlToJSON(output)
+	if err != nil {
+		appLog.Error("Cannot convert merged document to JSON", "error", err)
+		http.Error(w, "cannot convert merged document to JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(document, '\n'))
+}
+
+// serveConfigWatch streams the merged document as JSON server-sent events, sending it again whenever it changed.
+// The ID of every event is the SHA-256 checksum of the YAML document.
+func (s *configServer) serveConfigWatch(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for output := range s.watch(r.Context(), nil) {
+		document, err := yamlToJSON(output)
+		if err != nil {
+			appLog.Error("Cannot convert merged document to JSON", "error", err)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "id: %x\nevent: config\ndata: %s\n\n", sha256.Sum256(output), document)
+		flusher.Flush()
+	}
+}
+
+// watch sends the merged document and then every changed document until the context is done.
+// The inputs are checked every --watch.interval, failed merges are logged and retried.
+func (s *configServer) watch(ctx context.Context, variables map[string]string) <-chan []byte {
+	documents := make(chan []byte)
+	go func() {
+		defer close(documents)
+		ticker := time.NewTicker(s.cfg.watchInterval)
+		defer ticker.Stop()
+		var last []byte
+		for {
+			output, err := s.merge(ctx, variables)
+			switch {
+			case err != nil && ctx.Err() == nil:
+				appLog.Warn("Merge failed, keeping the previous document", "error", err)
+			case err == nil && (last == nil || !bytes.Equal(output, last)):
+				last = output
+				select {
+				case documents <- output:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return documents
+}
+
+// allowGet reports whether the request is a GET or HEAD request, otherwise it responds with 405
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// mergeForRequest merges the document with the variables, or responds with 500 if the merge failed
+func (s *configServer) mergeForRequest(w http.ResponseWriter, r *http.Request, variables map[string]string) ([]byte, bool) {
+	output, err := s.merge(r.Context(), variables)
+	if err != nil {
+		fields := []interface{}{"error", err}
+		if len(variables) > 0 {
+			fields = append(fields, "variables", variables)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			fields = append(fields, "exit_code", exitErr.ExitCode())
+		}
+		appLog.Error("Merge failed", fields...)
+		http.Error(w, "merge failed", http.StatusInternalServerError)
+		return nil, false
+	}
+	return output, true
+}
+
+// serveVersion writes the build information as JSON
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = version.WriteJSON(w)
+}
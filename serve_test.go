@@ -0,0 +1,267 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// get requests a path from the server and returns the status, content type, and body
+func get(t *testing.T, url string, accept string) (int, string, string) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	request.Header.Set("Accept", accept)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Error requesting %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+	return response.StatusCode, response.Header.Get("Content-Type"), string(body)
+}
+
+// TestServeConfig verifies the content negotiation of /config and that the hierarchy is only merged again after a change
+func TestServeConfig(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "app.yaml")
+	if err := os.WriteFile(file, []byte("app: {}\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	merge := fakeCommand(t, "", "app:\n  replicas: 2\n")
+	merges := 0
+	cfg := cfgDefaults
+	cfg.basePath = base
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		merges++
+		return exec.CommandContext(ctx, merge)
+	})
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	status, contentType, body := get(t, server.URL+"/config", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "application/yaml", contentType)
+	assert.Equal(t, "app:\n  replicas: 2\n", body)
+
+	status, contentType, body = get(t, server.URL+"/config", "application/json")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, "{\"app\":{\"replicas\":2}}\n", body)
+	assert.Equal(t, 1, merges)
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Error changing input file: %v", err)
+	}
+	get(t, server.URL+"/config", "")
+	assert.Equal(t, 2, merges)
+}
+
+// TestServeConfigFailure verifies that a failed merge is reported as a server error
+func TestServeConfigFailure(t *testing.T) {
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, fakeCommand(t, "unexpected", ""))
+	})
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	status, _, body := get(t, server.URL+"/config", "")
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "merge failed\n", body)
+
+	response, err := http.Post(server.URL+"/config", "application/yaml", strings.NewReader(""))
+	assert.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+// TestServeVersion verifies that the build information is served as JSON
+func TestServeVersion(t *testing.T) {
+	server := httptest.NewServer(newConfigServer(cfgDefaults, nil).handler())
+	defer server.Close()
+
+	status, contentType, body := get(t, server.URL+"/version", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "application/json", contentType)
+	assert.Contains(t, body, `"version"`)
+}
+
+// TestServeSpringEnvironment verifies that every profile is merged with its variables and returned as Spring properties
+func TestServeSpringEnvironment(t *testing.T) {
+	merge := fakeBinary(t, "merge", "printf 'app:\\n  name: %s\\n  profile: %s\\n  hosts: [a, b]\\n  empty: null\\n' \"$APPLICATION\" \"$PROFILE\"\n")
+	cfg := cfgDefaults
+	cfg.basePath = t.TempDir()
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	})
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	status, contentType, body := get(t, server.URL+"/demo/stage,prod/main", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "application/json", contentType)
+	assert.JSONEq(t, `{
+		"name": "demo",
+		"profiles": ["stage", "prod"],
+		"label": "main",
+		"version": null,
+		"state": null,
+		"propertySources": [
+			{"name": "hierarchy:demo-prod", "source": {"app.name": "demo", "app.profile": "prod", "app.hosts[0]": "a", "app.hosts[1]": "b", "app.empty": ""}},
+			{"name": "hierarchy:demo-stage", "source": {"app.name": "demo", "app.profile": "stage", "app.hosts[0]": "a", "app.hosts[1]": "b", "app.empty": ""}}
+		]
+	}`, body)
+
+	status, _, _ = get(t, server.URL+"/demo/.profile", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _, _ = get(t, server.URL+"/demo", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+// TestServeConfigWatch verifies that the merged document is streamed again after an input changed
+func TestServeConfigWatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	base := t.TempDir()
+	file := filepath.Join(base, "app.yaml")
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 2\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	merge := fakeBinary(t, "merge", "cat '"+file+"'\n")
+	cfg := cfgDefaults
+	cfg.basePath = base
+	cfg.watchInterval = 10 * time.Millisecond
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	})
+	server := httptest.NewServer(s.handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/config/watch")
+	if err != nil {
+		t.Fatalf("Error requesting stream: %v", err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	events := bufio.NewReader(response.Body)
+	nextData := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Error reading stream: %v", err)
+			}
+			if strings.HasPrefix(line, "data: ") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}
+	assert.Equal(t, `{"app":{"replicas":2}}`, nextData())
+
+	if err := os.WriteFile(file, []byte("app:\n  replicas: 3\n"), 0600); err != nil {
+		t.Fatalf("Error writing input file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatalf("Error changing input file: %v", err)
+	}
+	assert.Equal(t, `{"app":{"replicas":3}}`, nextData())
+}
+
+// TestServeConfigRemoteRefresh verifies that a document merged from a remote source which can change
+// is merged again after --remote.refresh-interval, although no local input changed
+func TestServeConfigRemoteRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	cfg := writeRemoteHierarchy(t, "https://example.invalid/config.yaml")
+	cfg.remoteRefreshInterval = 100 * time.Millisecond
+	counter := filepath.Join(t.TempDir(), "merges")
+	merge := fakeBinary(t, "merge", "echo >> '"+counter+"'\nprintf 'merges: %s\\n' $(wc -l < '"+counter+"')\n")
+	s := newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+		return exec.CommandContext(ctx, merge)
+	})
+
+	for _, expected := range []string{"merges: 1\n", "merges: 1\n"} {
+		output, err := s.merge(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(output))
+	}
+	time.Sleep(cfg.remoteRefreshInterval)
+	output, err := s.merge(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "merges: 2\n", string(output))
+}
+
+// TestServeConfigCacheTTL verifies that a document is merged again after --serve.cache-ttl,
+// and that without it a document resolving secrets is merged again on every request
+func TestServeConfigCacheTTL(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts are not supported on Windows")
+	}
+	counter := filepath.Join(t.TempDir(), "merges")
+	merge := fakeBinary(t, "merge", "echo >> '"+counter+"'\n"+
+		"[ -n \"$SECRETS\" ] && printf '{\"secrets\":1}' > \"$"+mergeStatsEnv+"\"\n"+
+		"printf 'merges: %s\\n' $(wc -l < '"+counter+"')\n")
+	newServer := func(ttl time.Duration, secrets bool) *configServer {
+		os.Remove(counter)
+		cfg := cfgDefaults
+		cfg.basePath = t.TempDir()
+		cfg.serveCacheTTL = ttl
+		return newConfigServer(cfg, func(ctx context.Context) *exec.Cmd {
+			cmd := exec.CommandContext(ctx, merge)
+			if secrets {
+				cmd.Env = append(os.Environ(), "SECRETS=1")
+			}
+			return cmd
+		})
+	}
+	merges := func(s *configServer, expected ...string) {
+		for _, e := range expected {
+			output, err := s.merge(context.Background(), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, "merges: "+e+"\n", string(output))
+		}
+	}
+
+	s := newServer(100*time.Millisecond, false)
+	merges(s, "1", "1")
+	time.Sleep(100 * time.Millisecond)
+	merges(s, "2")
+
+	merges(newServer(0, false), "1", "1")
+	merges(newServer(0, true), "1", "2", "3")
+	merges(newServer(time.Hour, true), "1", "1")
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// sopsBinary is the path and name of the sops binary decrypting encrypted input files, see --sops.binary
+var sopsBinary = "sops"
+
+// isSopsEncrypted reports whether an input file was encrypted with SOPS,
+// which adds its metadata with the message authentication code under the top-level key 'sops'
+func isSopsEncrypted(content []byte) bool {
+	var document struct {
+		Sops struct {
+			Mac string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return false
+	}
+	return len(document.Sops.Mac) > 0
+}
+
+// decryptSops decrypts an input file with the sops CLI, which finds the age, PGP, or cloud KMS keys itself.
+// The content already read is decrypted from a temporary copy, so it does not matter where the file came from.
+func decryptSops(binary string, file string, content []byte) ([]byte, error) {
+	inputType := "yaml"
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		inputType = "json"
+	}
+	encrypted, err := os.CreateTemp("", "hierarchy-sops-*."+inputType)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(encrypted.Name())
+	_, err = encrypted.Write(content)
+	if closeErr := encrypted.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, "--decrypt", "--input-type", inputType, "--output-type", "yaml", encrypted.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "sops decrypt failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
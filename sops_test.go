@@ -0,0 +1,73 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSops creates a script standing in for the sops binary, which prints the decrypted document
+func fakeSops(t *testing.T, inputType string, output string) string {
+	return fakeBinary(t, "sops", `[ "$1 $2 $3 $4 $5" = "--decrypt --input-type `+inputType+` --output-type yaml" ] || { echo "unexpected arguments: $*" >&2; exit 2; }
+grep -q 'sops' "$6" || { echo "not encrypted: $6" >&2; exit 1; }
+printf '%s' '`+output+`'
+`)
+}
+
+// TestIsSopsEncrypted verifies that files are detected by the message authentication code of the SOPS metadata
+func TestIsSopsEncrypted(t *testing.T) {
+	content, err := os.ReadFile("testdata/sops/secrets/app.yaml")
+	if err != nil {
+		t.Fatalf("Error reading encrypted file: %v", err)
+	}
+	assert.True(t, isSopsEncrypted(content))
+	assert.True(t, isSopsEncrypted([]byte(`{"password": "ENC[...]", "sops": {"mac": "ENC[...]", "version": "3.7.1"}}`)))
+	assert.False(t, isSopsEncrypted([]byte("sops:\n  enabled: true\n")))
+	assert.False(t, isSopsEncrypted([]byte("sops: yes\n")))
+	assert.False(t, isSopsEncrypted([]byte("app: [")))
+}
+
+// TestMergeSopsEncrypted verifies that encrypted files are merged decrypted and recorded with the checksum of the file
+func TestMergeSopsEncrypted(t *testing.T) {
+	previous := sopsBinary
+	sopsBinary = fakeSops(t, "yaml", "app:\n  password: s3cr3t\n")
+	defer func() { sopsBinary = previous }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/sops"
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "password": "s3cr3t"},
+	}, data)
+	assert.Equal(t, "testdata/sops/secrets/app.yaml", sources.files[1].path)
+	assert.Equal(t, "57851a1baba7ef634ff8c228c6fbec4fe7ea11d3d7d938396d8b9a5749e72236", sources.files[1].sha256)
+}
+
+// TestDecryptSopsInputType verifies that the input type follows the file extension and errors of sops are reported
+func TestDecryptSopsInputType(t *testing.T) {
+	binary := fakeSops(t, "json", "{}")
+	_, err := decryptSops(binary, "secrets.json", []byte(`{"sops": {"mac": "ENC[...]"}}`))
+	assert.NoError(t, err)
+
+	_, err = decryptSops(binary, "secrets.yaml", []byte("sops:\n  mac: ENC[...]\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "sops decrypt failed: unexpected arguments: --decrypt --input-type yaml")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// springNamePattern restricts applications, profiles, and labels to names that cannot leave the base path
+// when they are used in the hierarchy file
+var springNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// springEnvironment is the response of a Spring Cloud Config server for /{application}/{profile}
+type springEnvironment struct {
+	Name            string                 `json:"name"`
+	Profiles        []string               `json:"profiles"`
+	Label           *string                `json:"label"`
+	Version         *string                `json:"version"`
+	State           *string                `json:"state"`
+	PropertySources []springPropertySource `json:"propertySources"`
+}
+
+// springPropertySource holds the flattened properties of one profile
+type springPropertySource struct {
+	Name   string                 `json:"name"`
+	Source map[string]interface{} `json:"source"`
+}
+
+// serveSpringEnvironment serves /{application}/{profile} and /{application}/{profile}/{label} like a Spring Cloud Config server.
+// The hierarchy is merged once for every comma-separated profile with the variables APPLICATION and PROFILE,
+// so the hierarchy file can select directories with ${APPLICATION} and ${PROFILE}. The label is only returned.
+func (s *configServer) serveSpringEnvironment(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) < 2 || len(segments) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	application := segments[0]
+	profiles := strings.Split(segments[1], ",")
+	names := append([]string{application}, profiles...)
+	if len(segments) == 3 {
+		names = append(names, segments[2])
+	}
+	for _, name := range names {
+		if !springNamePattern.MatchString(name) {
+			http.Error(w, fmt.Sprintf("invalid name %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+	if !allowGet(w, r) {
+		return
+	}
+
+	environment := springEnvironment{Name: application, Profiles: profiles, PropertySources: []springPropertySource{}}
+	if len(segments) == 3 {
+		environment.Label = &segments[2]
+	}
+	// Spring lists the property sources of later profiles first, as they take precedence
+	for i := len(profiles) - 1; i >= 0; i-- {
+		output, ok := s.mergeForRequest(w, r, map[string]string{"APPLICATION": application, "PROFILE": profiles[i]})
+		if !ok {
+			return
+		}
+		document := map[string]interface{}{}
+		if err := yaml.Unmarshal(output, &document); err != nil {
+			appLog.Error("Cannot convert merged document to JSON", "error", err)
+			http.Error(w, "cannot convert merged document to JSON", http.StatusInternalServerError)
+			return
+		}
+		source := map[string]interface{}{}
+		flattenProperties("", document, source)
+		environment.PropertySources = append(environment.PropertySources, springPropertySource{
+			Name:   fmt.Sprintf("hierarchy:%s-%s", application, profiles[i]),
+			Source: source,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(environment)
+}
+
+// flattenProperties converts a document into Spring properties, e.g. app.hosts[0] for the first item of the list app.hosts.
+// Null values become empty strings, as Spring reads them from YAML.
+func flattenProperties(prefix string, value interface{}, properties map[string]interface{}) {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			if len(prefix) > 0 {
+				key = prefix + "." + key
+			}
+			flattenProperties(key, child, properties)
+		}
+	case []interface{}:
+		for i, child := range node {
+			flattenProperties(fmt.Sprintf("%s[%d]", prefix, i), child, properties)
+		}
+	case nil:
+		properties[prefix] = ""
+	default:
+		properties[prefix] = node
+	}
+}
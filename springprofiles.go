@@ -0,0 +1,215 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// springActivationKeys are the keys activating a document of a Spring configuration file for profiles,
+// since Spring Boot 2.4 and before
+var springActivationKeys = [][]string{
+	{"spring", "config", "activate", "on-profile"},
+	{"spring", "profiles"},
+}
+
+// springDocument is a document of a Spring configuration file without its activation
+type springDocument struct {
+	// profiles the document is activated for, none for a document of all profiles
+	profiles []string
+	content  []byte
+}
+
+// springFileProfile returns the profile of a profile-specific Spring configuration file, e.g. prod for application-prod.yml
+func springFileProfile(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if !strings.HasPrefix(name, "application-") {
+		return ""
+	}
+	return strings.TrimPrefix(name, "application-")
+}
+
+// removeKey removes a key path from a mapping and returns its value. Parts of the path may also be written as one key
+// separated by dots, e.g. spring.profiles or spring: {profiles: ...}. Mappings which become empty are removed too.
+func removeKey(mapping *yaml.Node, path []string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		for n := 1; n <= len(path); n++ {
+			if mapping.Content[i].Value != strings.Join(path[:n], ".") {
+				continue
+			}
+			value := mapping.Content[i+1]
+			if n < len(path) {
+				removed := removeKey(value, path[n:])
+				if removed == nil {
+					continue
+				}
+				if len(value.Content) == 0 {
+					mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+				}
+				return removed
+			}
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// springProfiles removes the activation of a document and returns the profiles it is activated for.
+// Profile expressions, e.g. '!prod' or 'prod & eu', cannot be converted to directories.
+func springProfiles(document *yaml.Node) ([]string, error) {
+	for _, key := range springActivationKeys {
+		activation := removeKey(document, key)
+		if activation == nil {
+			continue
+		}
+		items := []string{activation.Value}
+		if activation.Kind == yaml.SequenceNode {
+			items = []string{}
+			for _, item := range activation.Content {
+				items = append(items, item.Value)
+			}
+		}
+		profiles, err := parseEnvironments(items)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported profile expression %q of %s", strings.Join(items, ","), strings.Join(key, "."))
+		}
+		return profiles, nil
+	}
+	if removeKey(document, []string{"spring", "config", "activate", "on-cloud-platform"}) != nil {
+		return nil, errors.New("spring.config.activate.on-cloud-platform cannot be imported")
+	}
+	return nil, nil
+}
+
+// splitSpringFile splits a Spring configuration file into its documents, e.g. separated by '---'.
+// The documents of a profile-specific file, e.g. application-prod.yml, are activated for its profile.
+func splitSpringFile(file string, content []byte) ([]springDocument, error) {
+	fileProfile := springFileProfile(file)
+	documents := []springDocument{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err == io.EOF {
+			return documents, nil
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse %s", file)
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		profiles, err := springProfiles(node.Content[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, node.Line, err)
+		}
+		if len(profiles) == 0 && len(fileProfile) > 0 {
+			profiles = []string{fileProfile}
+		}
+		if node.Content[0].Kind == yaml.MappingNode && len(node.Content[0].Content) == 0 {
+			continue
+		}
+		var document bytes.Buffer
+		encoder := yaml.NewEncoder(&document)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return nil, err
+		}
+		documents = append(documents, springDocument{profiles: profiles, content: document.Bytes()})
+	}
+}
+
+// importSpring splits Spring configuration files into a defaults directory with the documents of all profiles,
+// and a directory per profile with its documents and a hierarchy file. Profile-specific files are imported last,
+// as they take precedence. A directory with more than one file gets an .order file keeping the order of the documents.
+func importSpring(files []string, hierarchyFile string) ([]scaffoldFile, []string, error) {
+	sorted := []string{}
+	for _, specific := range []bool{false, true} {
+		for _, file := range files {
+			if (len(springFileProfile(file)) > 0) == specific {
+				sorted = append(sorted, file)
+			}
+		}
+	}
+
+	directories := []string{"defaults"}
+	names := map[string][]string{}
+	imported := []scaffoldFile{}
+	for _, file := range sorted {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		documents, err := splitSpringFile(file, content)
+		if err != nil {
+			return nil, nil, err
+		}
+		base := filepath.Base(file)
+		for _, document := range documents {
+			profiles := document.profiles
+			if len(profiles) == 0 {
+				profiles = []string{"defaults"}
+			}
+			for _, profile := range profiles {
+				if _, ok := names[profile]; !ok && profile != "defaults" {
+					directories = append(directories, profile)
+				}
+				name := base
+				for n := 2; slices.Contains(names[profile], name); n++ {
+					name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, filepath.Ext(base)), n, filepath.Ext(base))
+				}
+				names[profile] = append(names[profile], name)
+				imported = append(imported, scaffoldFile{path: filepath.Join(profile, name), content: string(document.content)})
+			}
+		}
+	}
+
+	profiles := directories[1:]
+	for _, profile := range profiles {
+		imported = append(imported, scaffoldFile{path: filepath.Join(profile, hierarchyFile), content: environmentHierarchy(profile)})
+	}
+	for _, directory := range directories {
+		if len(names[directory]) > 1 {
+			imported = append(imported, scaffoldFile{path: filepath.Join(directory, orderFile), content: strings.Join(names[directory], "\n") + "\n"})
+		}
+	}
+	return imported, profiles, nil
+}
+
+// runImportSpring imports Spring configuration files into the base path
+func runImportSpring(cfg config) {
+	for _, file := range cfg.importFiles {
+		_, err := os.Stat(file)
+		checkForErrorCode(err, exitPath)
+	}
+	files, profiles, err := importSpring(cfg.importFiles, cfg.hierarchyFile)
+	checkForErrorCode(err, exitParse)
+	_, err = writeScaffold(cfg.basePath, files)
+	checkForErrorCode(err, exitWrite)
+	printMergeCommands(os.Stdout, cfg, profiles)
+}
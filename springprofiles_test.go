@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestSpringProfiles verifies that the activation is removed from a document, nested or written as one key
+func TestSpringProfiles(t *testing.T) {
+	for content, expected := range map[string][]string{
+		"spring:\n  config:\n    activate:\n      on-profile: dev\n  application:\n    name: demo\n": {"dev"},
+		"spring.profiles: stage, prod\n":                  {"stage", "prod"},
+		"spring:\n  profiles:\n    - stage\n    - prod\n": {"stage", "prod"},
+		"app:\n  replicas: 1\n":                           nil,
+	} {
+		var document yaml.Node
+		assert.NoError(t, yaml.Unmarshal([]byte(content), &document))
+		profiles, err := springProfiles(document.Content[0])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, profiles, content)
+		remaining, err := yaml.Marshal(&document)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(remaining), "profile", content)
+		assert.NotContains(t, string(remaining), "activate", content)
+	}
+
+	for _, content := range []string{
+		"spring.profiles: '!prod'\n",
+		"spring.config.activate.on-profile: prod & eu\n",
+		"spring.config.activate.on-cloud-platform: kubernetes\n",
+	} {
+		var document yaml.Node
+		assert.NoError(t, yaml.Unmarshal([]byte(content), &document))
+		_, err := springProfiles(document.Content[0])
+		assert.Error(t, err, content)
+	}
+}
+
+// TestEnd2EndImportSpring verifies that the profiles of imported Spring configuration files merge like in Spring
+func TestEnd2EndImportSpring(t *testing.T) {
+	basePath := t.TempDir()
+	files, profiles, err := importSpring([]string{"testdata/spring/application-prod.yml", "testdata/spring/application.yml"}, "hierarchy.lst")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev", "stage", "prod"}, profiles)
+	_, err = writeScaffold(basePath, files)
+	assert.NoError(t, err)
+
+	order, err := os.ReadFile(filepath.Join(basePath, "prod", orderFile))
+	assert.NoError(t, err)
+	assert.Equal(t, "application.yml\napplication-prod.yml\n", string(order))
+
+	for profile, expected := range map[string]map[string]interface{}{
+		"dev":   {"greeting": "hello dev", "replicas": 1},
+		"stage": {"greeting": "hello", "replicas": 3},
+		"prod":  {"greeting": "hello", "replicas": 5},
+	} {
+		cfg := cfgDefaults
+		cfg.basePath = filepath.Join(basePath, profile)
+		cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+		cfg.annotate = "none"
+		runMerge(cfg)
+
+		content, err := os.ReadFile(cfg.outputFile)
+		assert.NoError(t, err)
+		output := map[string]map[string]interface{}{}
+		assert.NoError(t, yaml.Unmarshal(content, &output))
+		assert.Equal(t, expected, output["app"], profile)
+		assert.Equal(t, map[string]interface{}{"port": 8080}, output["server"], profile)
+		assert.NotContains(t, output, "spring", profile)
+	}
+}
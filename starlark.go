@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// starlarkScriptFile is the Starlark script of a hierarchy directory, which is run after the files of the directory are merged
+const starlarkScriptFile = "transform.star"
+
+// starlarkOptions configures the Starlark scripts mutating the merged document, see --starlark
+type starlarkOptions struct {
+	enabled bool
+	script  string
+	binary  string
+}
+
+// starlark is the configuration of the Starlark scripts
+var starlark = starlarkOptions{binary: "starlark"}
+
+// starlarkCall is appended to every script, so the script only has to define transform(doc).
+// The document is passed as a JSON string literal, and transform may return a new document or None after changing doc in place.
+const starlarkCall = `
+__hierarchy_doc = json.decode(%s)
+__hierarchy_result = transform(__hierarchy_doc)
+print(json.encode(__hierarchy_doc if __hierarchy_result == None else __hierarchy_result))
+`
+
+// runStarlark runs the transform function of a Starlark script on the document with the starlark CLI of go.starlark.net
+// and returns the resulting document. The script is run from a temporary copy with the call appended,
+// so line numbers in errors still match the script, which is reported in place of the copy.
+func runStarlark(binary string, script string, content []byte, data map[string]interface{}) (map[string]interface{}, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	document, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	literal, err := json.Marshal(string(document))
+	if err != nil {
+		return nil, err
+	}
+	program, err := os.CreateTemp("", "hierarchy-*.star")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(program.Name())
+	_, err = program.Write(append(content, []byte(strings.Replace(starlarkCall, "%s", string(literal), 1))...))
+	if closeErr := program.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, program.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.ReplaceAll(strings.TrimSpace(stderr.String()), program.Name(), script)
+		return nil, errors.Wrapf(err, "starlark script %s failed: %s", script, message)
+	}
+	// Only the last line is the document, the script may print before
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	result := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return nil, errors.Wrapf(err, "starlark script %s did not return a document", script)
+	}
+	return result, nil
+}
+
+// changedValues returns the leaves of the new document which are not in the old one or have a different value,
+// so the changes of a script can be recorded like the content of a file
+func changedValues(old map[string]interface{}, new map[string]interface{}) map[string]interface{} {
+	changes := map[string]interface{}{}
+	for key, value := range new {
+		nested, isMap := value.(map[string]interface{})
+		oldNested, wasMap := old[key].(map[string]interface{})
+		if isMap && wasMap {
+			if nestedChanges := changedValues(oldNested, nested); len(nestedChanges) > 0 {
+				changes[key] = nestedChanges
+			}
+			continue
+		}
+		if oldValue, ok := old[key]; !ok || !reflect.DeepEqual(oldValue, value) {
+			changes[key] = value
+		}
+	}
+	return changes
+}
+
+// applyStarlarkScript runs a Starlark script on the document merged so far and records the values it changed.
+// A script which fails is skipped with a warning in a best-effort layer.
+func applyStarlarkScript(script string, data map[string]interface{}, sources *provenance, bestEffort bool) map[string]interface{} {
+	mergerLog.Info("Running Starlark script", "path", script)
+	content, err := inputFS.ReadFile(script)
+	var result map[string]interface{}
+	if err == nil {
+		result, err = runStarlark(starlark.binary, script, content, data)
+	}
+	if err != nil {
+		if bestEffort {
+			mergerLog.Warn("Skipping failed Starlark script in best-effort layer", "path", script, "error", err)
+			return data
+		}
+		fail(mergerLog, exitError, "Starlark script failed", "path", script, "error", err)
+		return data
+	}
+	sources.addFile(script, content)
+	sources.record(script, "", changedValues(data, result), result)
+	return result
+}
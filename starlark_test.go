@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStarlark creates a script standing in for the starlark binary, which records the programs it runs
+// and prints the output of the first pattern found in a program, or fails with an error at its line 2
+func fakeStarlark(t *testing.T, outputs map[string]string) (string, string) {
+	programs := filepath.Join(t.TempDir(), "programs")
+	content := "cat \"$1\" >> \"" + programs + "\"\n"
+	patterns := []string{}
+	for pattern := range outputs {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		content += "grep -q '" + pattern + "' \"$1\" && { echo 'print from script'; printf '%s\\n' '" + outputs[pattern] + "'; exit 0; }\n"
+	}
+	content += "echo \"$1:2:5: key \\\"app\\\" not in dict\" >&2\nexit 1\n"
+	return fakeBinary(t, "starlark", content), programs
+}
+
+// TestMergeStarlark verifies that the script of a directory runs after its files and the global script after all directories,
+// and that the values set by scripts are recorded
+func TestMergeStarlark(t *testing.T) {
+	binary, programs := fakeStarlark(t, map[string]string{
+		`app.pop`:     `{"app": {"name": "demo", "replicas": 6, "url": "https://demo.example.com"}}`,
+		`return {"ap`: `{"apps": {"demo": {"name": "demo", "replicas": 6, "url": "https://demo.example.com"}}}`,
+	})
+	previous := starlark
+	defer func() { starlark = previous }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "testdata/starlark"
+	starlark = starlarkOptions{binary: binary}
+	data, _ := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 3, "debug": true},
+	}, data, "scripts are ignored without --starlark")
+
+	starlark = starlarkOptions{enabled: true, binary: binary}
+	data, sources := mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"app": map[string]interface{}{"name": "demo", "replicas": 6, "url": "https://demo.example.com"},
+	}, data)
+	assert.Equal(t, []string{"testdata/starlark/prod/transform.star"}, sources.finalSources("app.replicas"))
+	assert.Equal(t, []string{"testdata/starlark/defaults/app.yaml"}, sources.finalSources("app.name"))
+	assert.Equal(t, []string{"testdata/starlark/prod/transform.star"}, sources.finalSources("app.url"))
+
+	script, err := os.ReadFile("testdata/starlark/prod/transform.star")
+	assert.NoError(t, err)
+	program, err := os.ReadFile(programs)
+	assert.NoError(t, err)
+	assert.Equal(t, string(script)+`
+__hierarchy_doc = json.decode("{\"app\":{\"debug\":true,\"name\":\"demo\",\"replicas\":3}}")
+__hierarchy_result = transform(__hierarchy_doc)
+print(json.encode(__hierarchy_doc if __hierarchy_result == None else __hierarchy_result))
+`, string(program))
+
+	starlark = starlarkOptions{enabled: true, script: "testdata/starlark/global.star", binary: binary}
+	data, _ = mergeFiles(processHierarchy(cfg), cfg.filterExtension)
+	assert.Equal(t, map[string]interface{}{
+		"apps": map[string]interface{}{
+			"demo": map[string]interface{}{"name": "demo", "replicas": 6, "url": "https://demo.example.com"},
+		},
+	}, data)
+}
+
+// TestRunStarlarkError verifies that errors are reported with the line in the script instead of the temporary copy
+func TestRunStarlarkError(t *testing.T) {
+	binary, _ := fakeStarlark(t, map[string]string{})
+	_, err := runStarlark(binary, "prod/transform.star", []byte("def transform(doc):\n    doc[\"app\"]\n"), nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `starlark script prod/transform.star failed: prod/transform.star:2:5: key "app" not in dict`)
+	}
+}
+
+// TestChangedValues verifies that only new and changed leaves are reported as changes
+func TestChangedValues(t *testing.T) {
+	old := map[string]interface{}{
+		"app":   map[string]interface{}{"name": "demo", "replicas": 2},
+		"ports": []interface{}{80},
+	}
+	new := map[string]interface{}{
+		"app":   map[string]interface{}{"name": "demo", "replicas": 4, "url": "https://demo"},
+		"ports": []interface{}{80},
+		"debug": map[string]interface{}{"enabled": true},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"app":   map[string]interface{}{"replicas": 4, "url": "https://demo"},
+		"debug": map[string]interface{}{"enabled": true},
+	}, changedValues(old, new))
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/fs"
+)
+
+// followSymlinks follows symlinked hierarchy directories, files, and passthrough directories, see --no-follow-symlinks
+var followSymlinks = true
+
+// isSymlink reports whether the last element of path is a symlink
+func isSymlink(path string) bool {
+	info, err := inputFS.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// isSymlinkedDir reports whether a directory entry is a symlink, and whether it points to a directory.
+// A symlink which cannot be followed, e.g. one that loops, is reported as a file, so reading it reports the error.
+func isSymlinkedDir(path string, entry fs.DirEntry) (symlink bool, dir bool) {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return false, entry.IsDir()
+	}
+	info, err := inputFS.Stat(path)
+	return true, err == nil && info.IsDir()
+}
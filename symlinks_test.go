@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupSymlinks creates a base directory with files, a passthrough directory, and symlinks
+// to a file, to a directory inside and outside of the base directory, and a passthrough directory
+func setupSymlinks(t *testing.T) (string, string) {
+	tmp := t.TempDir()
+	base := filepath.Join(tmp, "base")
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{filepath.Join(base, "app", "files"), filepath.Join(base, "shared"), outside} {
+		assert.NoError(t, os.MkdirAll(dir, 0700))
+	}
+	for _, file := range []string{filepath.Join(base, "app", "app.yaml"), filepath.Join(base, "app", "files", "a.txt"), filepath.Join(base, "shared", "b.txt")} {
+		assert.NoError(t, os.WriteFile(file, []byte("name: "+filepath.Base(file)+"\n"), 0600))
+	}
+	links := map[string]string{
+		filepath.Join(base, "app", "link.yaml"):       "app.yaml",
+		filepath.Join(base, "app", "dir.yaml"):        filepath.Join("..", "shared"),
+		filepath.Join(base, "app", "files", "shared"): filepath.Join("..", "..", "shared"),
+		filepath.Join(base, "inside"):                 "app",
+		filepath.Join(base, "outside"):                outside,
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return base, outside
+}
+
+// TestGetFilesSymlinks verifies that symlinked files are merged unless --no-follow-symlinks is set,
+// and symlinked directories never are
+func TestGetFilesSymlinks(t *testing.T) {
+	defer func() { followSymlinks = true }()
+	base, _ := setupSymlinks(t)
+	app := filepath.Join(base, "app")
+
+	assert.Equal(t, []string{filepath.Join(app, "app.yaml"), filepath.Join(app, "link.yaml")}, getFiles(app, defaultFileFilter))
+
+	followSymlinks = false
+	assert.Equal(t, []string{filepath.Join(app, "app.yaml")}, getFiles(app, defaultFileFilter))
+}
+
+// TestCollectPassthroughSymlinks verifies that symlinked passthrough directories are followed unless --no-follow-symlinks is set
+func TestCollectPassthroughSymlinks(t *testing.T) {
+	defer func() { followSymlinks = true }()
+	base, _ := setupSymlinks(t)
+	app := filepath.Join(base, "app")
+
+	assert.Equal(t, []passthroughFile{
+		{name: "a.txt", path: filepath.Join(app, "files", "a.txt")},
+		{name: "shared/b.txt", path: filepath.Join(app, "files", "shared", "b.txt")},
+	}, collectPassthroughFiles([]layer{{path: app}}))
+
+	followSymlinks = false
+	assert.Equal(t, []passthroughFile{
+		{name: "a.txt", path: filepath.Join(app, "files", "a.txt")},
+	}, collectPassthroughFiles([]layer{{path: app}}))
+}
+
+// TestIsWithinBaseSymlinks verifies that symlinks pointing outside of the base path are outside of it
+func TestIsWithinBaseSymlinks(t *testing.T) {
+	base, outside := setupSymlinks(t)
+
+	assert.True(t, isWithinBase(base, filepath.Join(base, "inside")))
+	assert.False(t, isWithinBase(base, filepath.Join(base, "outside")))
+	assert.False(t, isWithinBase(base, outside))
+}
+
+// TestFailPassthroughSymlinkLoop ensures that a symlinked passthrough directory pointing to one of its parents
+// fails with exitPath (4) instead of looping forever
+// It spawns a new process to determine the exit code of the application.
+func TestFailPassthroughSymlinkLoop(t *testing.T) {
+	if os.Getenv("TEST_FAIL_SYMLINK_LOOP") == "1" {
+		base, _ := setupSymlinks(t)
+		app := filepath.Join(base, "app")
+		assert.NoError(t, os.Symlink("..", filepath.Join(app, "files", "loop")))
+		collectPassthroughFiles([]layer{{path: app}})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailPassthroughSymlinkLoop")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_SYMLINK_LOOP=1")
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
+
+// TestFailNoFollowSymlinksHierarchy ensures that a symlinked directory in the hierarchy fails with exitPath (4)
+// with --no-follow-symlinks
+// It spawns a new process to determine the exit code of the application.
+func TestFailNoFollowSymlinksHierarchy(t *testing.T) {
+	if os.Getenv("TEST_FAIL_NO_FOLLOW_SYMLINKS") == "1" {
+		base, _ := setupSymlinks(t)
+		assert.NoError(t, os.WriteFile(filepath.Join(base, "hierarchy.lst"), []byte("inside\n"), 0600))
+		followSymlinks = false
+		cfg := cfgDefaults
+		cfg.basePath = base
+		processHierarchy(cfg)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailNoFollowSymlinksHierarchy")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_NO_FOLLOW_SYMLINKS=1")
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitPath {
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitPath)
+}
@@ -0,0 +1,4 @@
+{
+  domain: 'example.com',
+  replicas(env):: if env == 'prod' then 4 else 1,
+}
@@ -0,0 +1,6 @@
+package hierarchy
+
+deny[msg] {
+  input.replicas < 2
+  msg := "replicas must be at least 2"
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// hclIdentifier matches the names of Terraform variables and map keys that need no quotes
+var hclIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// formatOutput converts the merged YAML document to the format of --output-format
+func formatOutput(format string, output string) (string, error) {
+	switch format {
+	case "tfvars.json":
+		content, err := yamlToJSON([]byte(output))
+		if err != nil {
+			return "", err
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, content, "", "  "); err != nil {
+			return "", err
+		}
+		return indented.String() + "\n", nil
+	case "tfvars":
+		document := yaml.Node{}
+		if err := yaml.Unmarshal([]byte(output), &document); err != nil {
+			return "", err
+		}
+		if len(document.Content) == 0 {
+			return "", nil
+		}
+		return toTfvars(document.Content[0])
+	default:
+		return output, nil
+	}
+}
+
+// toTfvars writes every top-level key of the document as a variable definition of a Terraform .tfvars file,
+// in the order of the document
+func toTfvars(document *yaml.Node) (string, error) {
+	document = resolveAlias(document)
+	if document.ShortTag() == "!!null" {
+		return "", nil
+	}
+	if document.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("tfvars output needs a map at the top level")
+	}
+	var b strings.Builder
+	for _, variable := range mappingPairs(document) {
+		if !hclIdentifier.MatchString(variable.key) {
+			return "", fmt.Errorf("key %q is not a valid Terraform variable name", variable.key)
+		}
+		b.WriteString(variable.key + " = ")
+		if err := writeHCL(&b, variable.value, ""); err != nil {
+			return "", err
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// writeHCL writes a node as an HCL expression, nesting maps and lists of maps on separate lines like 'terraform fmt'.
+// Scalars are written like in JSON, see scalarLiteral.
+func writeHCL(b *strings.Builder, node *yaml.Node, indent string) error {
+	node = resolveAlias(node)
+	switch node.Kind {
+	case yaml.MappingNode:
+		pairs := mappingPairs(node)
+		if len(pairs) == 0 {
+			b.WriteString("{}")
+			return nil
+		}
+		b.WriteString("{\n")
+		for _, pair := range pairs {
+			if hclIdentifier.MatchString(pair.key) {
+				b.WriteString(indent + "  " + pair.key + " = ")
+			} else {
+				b.WriteString(indent + "  " + hclString(pair.key) + " = ")
+			}
+			if err := writeHCL(b, pair.value, indent+"  "); err != nil {
+				return err
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if isScalarSequence(node) {
+			b.WriteString("[")
+			for i, item := range node.Content {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				if err := writeHCL(b, item, indent); err != nil {
+					return err
+				}
+			}
+			b.WriteString("]")
+			return nil
+		}
+		b.WriteString("[\n")
+		for _, item := range node.Content {
+			b.WriteString(indent + "  ")
+			if err := writeHCL(b, item, indent+"  "); err != nil {
+				return err
+			}
+			b.WriteString(",\n")
+		}
+		b.WriteString(indent + "]")
+	default:
+		literal, isString, err := scalarLiteral(node)
+		if err != nil {
+			return err
+		}
+		if isString {
+			literal = hclString(literal)
+		}
+		b.WriteString(literal)
+	}
+	return nil
+}
+
+// isScalarSequence reports whether the sequence node contains neither maps nor lists
+func isScalarSequence(node *yaml.Node) bool {
+	for _, item := range node.Content {
+		if kind := resolveAlias(item).Kind; kind == yaml.MappingNode || kind == yaml.SequenceNode {
+			return false
+		}
+	}
+	return true
+}
+
+// hclString quotes a string for HCL, escaping template sequences, so values are taken literally
+func hclString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	escaped := strings.ReplaceAll(b.String(), "${", "$${")
+	return strings.ReplaceAll(escaped, "%{", "%%{")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mergedDocument is a merged YAML document with nested maps, lists, and strings needing escapes
+const mergedDocument = `app:
+    name: demo
+    ports: [80, 443]
+    ratio: 0.5
+    enabled: true
+    owner: null
+    listeners:
+        - port: 80
+          protocol: http
+    labels:
+        team/name: payments
+    command: echo "${HOME}"\n
+region: us-east-1
+empty: {}
+`
+
+// TestFormatOutputTfvarsJSON verifies that the document is written as indented JSON
+func TestFormatOutputTfvarsJSON(t *testing.T) {
+	output, err := formatOutput("tfvars.json", "app:\n    name: demo\n    ports: [80, 443]\nregion: us-east-1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "app": {
+    "name": "demo",
+    "ports": [
+      80,
+      443
+    ]
+  },
+  "region": "us-east-1"
+}
+`, output)
+}
+
+// TestFormatOutputTfvars verifies the HCL variable definitions in the order of the document, with template sequences in strings escaped
+func TestFormatOutputTfvars(t *testing.T) {
+	output, err := formatOutput("tfvars", mergedDocument)
+	assert.NoError(t, err)
+	assert.Equal(t, `app = {
+  name = "demo"
+  ports = [80, 443]
+  ratio = 0.5
+  enabled = true
+  owner = null
+  listeners = [
+    {
+      port = 80
+      protocol = "http"
+    },
+  ]
+  labels = {
+    "team/name" = "payments"
+  }
+  command = "echo \"$${HOME}\"\\n"
+}
+region = "us-east-1"
+empty = {}
+`, output)
+
+	output, err = formatOutput("tfvars", "sha: 0123\nversion: 1.10\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "sha = \"0123\"\nversion = 1.10\n", output)
+
+	_, err = formatOutput("tfvars", "team/name: payments\n")
+	assert.EqualError(t, err, `key "team/name" is not a valid Terraform variable name`)
+
+	_, err = formatOutput("tfvars", "- payments\n")
+	assert.EqualError(t, err, "tfvars output needs a map at the top level")
+}
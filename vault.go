@@ -0,0 +1,215 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// serviceAccountDir holds the credentials of the service account of a pod, see --vault.auth=kubernetes
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// vaultClient reads secrets from HashiCorp Vault, logging in on the first read.
+// Every secret is read once per merge, no matter how many of its fields are referenced.
+type vaultClient struct {
+	address   string
+	namespace string
+	auth      string
+	authPath  string
+	token     string
+	roleID    string
+	secretID  string
+	role      string
+	client    *http.Client
+	secrets   map[string]map[string]interface{}
+	loginErr  error
+	loggedIn  bool
+}
+
+// newVaultClient configures the client with the --vault flags, falling back to the environment variables of the vault CLI
+func newVaultClient(cfg config) *vaultClient {
+	v := &vaultClient{
+		address:   cfg.vaultAddress,
+		namespace: cfg.vaultNamespace,
+		auth:      cfg.vaultAuth,
+		authPath:  cfg.vaultAuthPath,
+		token:     cfg.vaultToken,
+		roleID:    cfg.vaultRoleID,
+		secretID:  cfg.vaultSecretID,
+		role:      cfg.vaultRole,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		secrets:   map[string]map[string]interface{}{},
+	}
+	if len(v.address) == 0 {
+		v.address = os.Getenv("VAULT_ADDR")
+	}
+	if len(v.namespace) == 0 {
+		v.namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if len(v.authPath) == 0 {
+		v.authPath = v.auth
+	}
+	return v
+}
+
+// login obtains a token with the auth method, or finds the token of the vault CLI
+func (v *vaultClient) login() error {
+	if v.loggedIn {
+		return v.loginErr
+	}
+	v.loggedIn = true
+	if len(v.address) == 0 {
+		v.loginErr = errors.New("no Vault address, set --vault.address or VAULT_ADDR")
+		return v.loginErr
+	}
+	switch v.auth {
+	case "approle":
+		v.token, v.loginErr = v.authenticate(map[string]string{"role_id": v.roleID, "secret_id": v.secretID})
+	case "kubernetes":
+		jwt, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		if err != nil {
+			v.loginErr = errors.Wrap(err, "Error reading service account token")
+			return v.loginErr
+		}
+		v.token, v.loginErr = v.authenticate(map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))})
+	default:
+		if len(v.token) == 0 {
+			v.token = os.Getenv("VAULT_TOKEN")
+		}
+		if len(v.token) == 0 {
+			if home, err := os.UserHomeDir(); err == nil {
+				token, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+				v.token = strings.TrimSpace(string(token))
+			}
+		}
+		if len(v.token) == 0 {
+			v.loginErr = errors.New("no Vault token, set --vault.token, VAULT_TOKEN, or log in with the vault CLI")
+		}
+	}
+	return v.loginErr
+}
+
+// authenticate logs in with the auth method mounted at the auth path and returns the client token
+func (v *vaultClient) authenticate(credentials map[string]string) (string, error) {
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.request(http.MethodPost, "auth/"+v.authPath+"/login", credentials, &response); err != nil {
+		return "", errors.Wrapf(err, "Error logging in to Vault with %s", v.auth)
+	}
+	return response.Auth.ClientToken, nil
+}
+
+// request sends a request to the Vault API and decodes the JSON response
+func (v *vaultClient) request(method string, path string, body interface{}, result interface{}) error {
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(v.address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), content)
+	if err != nil {
+		return err
+	}
+	if len(v.token) > 0 {
+		request.Header.Set("X-Vault-Token", v.token)
+	}
+	if len(v.namespace) > 0 {
+		request.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	response, err := v.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return fmt.Errorf("%s: %s", response.Status, strings.Join(failure.Errors, ", "))
+		}
+		return errors.New(response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// read returns the data of a secret. The data of KV version 2 secrets is nested below 'data' next to 'metadata'.
+func (v *vaultClient) read(path string) (map[string]interface{}, error) {
+	if data, ok := v.secrets[path]; ok {
+		return data, nil
+	}
+	if err := v.login(); err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.request(http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+	data := response.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	v.secrets[path] = data
+	return data, nil
+}
+
+// lookup returns a field of a secret as a string
+func (v *vaultClient) lookup(path string, field string) (string, error) {
+	data, err := v.read(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[field]
+	if !ok || value == nil {
+		return "", fmt.Errorf("field %s not found", field)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// resolve returns the value of a reference to a field of a secret as <path>#<field>
+func (v *vaultClient) resolve(reference string) (string, error) {
+	i := strings.LastIndex(reference, "#")
+	if i < 0 {
+		return "", fmt.Errorf("expected <path>#<field>")
+	}
+	return v.lookup(reference[:i], reference[i+1:])
+}
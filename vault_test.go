@@ -0,0 +1,142 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVault serves a KV version 2 secret at secret/data/app, a KV version 1 secret at kv/app,
+// and the approle and kubernetes logins, counting the reads of each secret
+func fakeVault(t *testing.T, reads map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.URL.Path == "/v1/auth/approle/login" && body["role_id"] == "role" && body["secret_id"] == "secret":
+			fmt.Fprint(w, `{"auth":{"client_token":"approle-token"}}`)
+		case r.URL.Path == "/v1/auth/k8s/login" && body["role"] == "app" && body["jwt"] == "pod-token":
+			fmt.Fprint(w, `{"auth":{"client_token":"kubernetes-token"}}`)
+		case r.Header.Get("X-Vault-Token") == "":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		case r.URL.Path == "/v1/secret/data/app":
+			reads[r.URL.Path]++
+			fmt.Fprint(w, `{"data":{"data":{"password":"s3cr3t","port":5432},"metadata":{"version":3}}}`)
+		case r.URL.Path == "/v1/kv/app":
+			reads[r.URL.Path]++
+			fmt.Fprint(w, `{"data":{"token":"abc"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[]}`)
+		}
+	}))
+}
+
+// TestResolveVault verifies that fields of KV version 1 and 2 secrets are replaced and every secret is read once
+func TestResolveVault(t *testing.T) {
+	reads := map[string]int{}
+	server := fakeVault(t, reads)
+	defer server.Close()
+
+	cfg := cfgDefaults
+	cfg.vaultAddress = server.URL
+	cfg.vaultAuth = "approle"
+	cfg.vaultRoleID = "role"
+	cfg.vaultSecretID = "secret"
+	output := resolveSecretsIn(t, `password: ${vault:secret/data/app#password}
+port: ${vault:secret/data/app#port}
+token: ${vault:kv/app#token}
+missing: ${vault:kv/missing#token}
+`, newSecretResolvers(cfg), false)
+	assert.Equal(t, `password: s3cr3t
+port: "5432"
+token: abc
+missing: ${vault:kv/missing#token}
+`, output)
+	assert.Equal(t, map[string]int{"/v1/secret/data/app": 1, "/v1/kv/app": 1}, reads)
+}
+
+// TestVaultLogin verifies the token and kubernetes auth methods
+func TestVaultLogin(t *testing.T) {
+	server := fakeVault(t, map[string]int{})
+	defer server.Close()
+
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "cli-token")
+	cfg := cfgDefaults
+	v := newVaultClient(cfg)
+	value, err := v.lookup("kv/app", "token")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value)
+	assert.Equal(t, "cli-token", v.token)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("pod-token\n"), 0600); err != nil {
+		t.Fatalf("Error writing service account token: %v", err)
+	}
+	previous := serviceAccountDir
+	serviceAccountDir = dir
+	defer func() { serviceAccountDir = previous }()
+	cfg.vaultAuth = "kubernetes"
+	cfg.vaultAuthPath = "k8s"
+	cfg.vaultRole = "app"
+	v = newVaultClient(cfg)
+	_, err = v.lookup("secret/data/app", "password")
+	assert.NoError(t, err)
+	assert.Equal(t, "kubernetes-token", v.token)
+
+	cfg.vaultRole = "other"
+	_, err = newVaultClient(cfg).lookup("secret/data/app", "password")
+	assert.EqualError(t, err, "Error logging in to Vault with kubernetes: 403 Forbidden: permission denied")
+
+	_, err = newVaultClient(cfgDefaults).lookup("secret/data/app", "user")
+	assert.EqualError(t, err, "field user not found")
+}
+
+// TestFailMissingSecret ensures that the application is correctly failing
+// if a secret cannot be read and --fail.missingsecret is set.
+// It spawns a new process to determine the exit code of the application.
+// Anything other than exitVariable (5) is a problem
+func TestFailMissingSecret(t *testing.T) {
+	if os.Getenv("TEST_FAIL_SECRET") == "1" {
+		cfg := cfgDefaults
+		cfg.vaultAddress = "http://127.0.0.1:1"
+		cfg.vaultToken = "token"
+		resolveSecretsIn(t, "password: ${vault:secret/data/app#password}\n", newSecretResolvers(cfg), true)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFailMissingSecret")
+	cmd.Env = append(os.Environ(), "TEST_FAIL_SECRET=1")
+	output, err := cmd.CombinedOutput()
+	fmt.Printf("%s\n", output)
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == exitVariable {
+		fmt.Printf("Process correctly failed with %v\n", e)
+		return
+	}
+	t.Fatalf("process ran with err %v, want exit status %d.", err, exitVariable)
+}
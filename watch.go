@@ -0,0 +1,195 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long --watch waits after a file system event for more events of the same change,
+// e.g. an editor writing a file in several steps, before it checks the inputs
+const watchSettle = 100 * time.Millisecond
+
+// inputWatcher delivers the file system events of the directories of the inputs of --watch,
+// so a change is merged without waiting for the next poll. The events only trigger a check of the inputs,
+// so the files written by the merge do not trigger another one.
+type inputWatcher struct {
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool
+}
+
+// newInputWatcher creates a watcher for file system events. If the events are not available,
+// e.g. when the limit of inotify watches is reached, the inputs are only polled.
+func newInputWatcher() *inputWatcher {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		appLog.Warn("Cannot watch the inputs for file system events, polling them", "error", err)
+		return &inputWatcher{dirs: map[string]bool{}}
+	}
+	return &inputWatcher{watcher: watcher, dirs: map[string]bool{}}
+}
+
+// watch watches the directories and the directories of the files, and stops watching the ones no longer needed.
+// Directories are watched rather than files, so files replaced by a rename or symlink swap, like the ones of
+// mounted ConfigMaps, and added files are noticed.
+func (w *inputWatcher) watch(dirs []string, files []string) {
+	if w == nil || w.watcher == nil {
+		return
+	}
+	wanted := map[string]bool{}
+	for _, dir := range dirs {
+		wanted[filepath.Clean(dir)] = true
+	}
+	for _, file := range files {
+		wanted[filepath.Dir(file)] = true
+	}
+	for dir := range w.dirs {
+		if !wanted[dir] {
+			_ = w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range wanted {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			appLog.Debug("Cannot watch directory for file system events", "path", dir, "error", err)
+			continue
+		}
+		w.dirs[dir] = true
+	}
+}
+
+// events returns the channel of file system events, which is nil and never ready without a watcher
+func (w *inputWatcher) events() <-chan fsnotify.Event {
+	if w == nil || w.watcher == nil {
+		return nil
+	}
+	return w.watcher.Events
+}
+
+// errors returns the channel of errors of the watcher, which is nil and never ready without a watcher
+func (w *inputWatcher) errors() <-chan error {
+	if w == nil || w.watcher == nil {
+		return nil
+	}
+	return w.watcher.Errors
+}
+
+// close stops watching
+func (w *inputWatcher) close() {
+	if w != nil && w.watcher != nil {
+		_ = w.watcher.Close()
+	}
+}
+
+// watchedLayers returns the layers of the hierarchy, with the entries of remote sources as their path.
+// Variables, e.g. the profile of a request, take precedence over the environment variables of the same name.
+// Unlike processHierarchy, it never fails or fetches remote sources, broken inputs are reported by the merge.
+func watchedLayers(cfg config, variables map[string]string) []layer {
+	hierarchyFilePath := filepath.Join(cfg.basePath, cfg.hierarchyFile)
+	hierarchy := []layer{}
+	if content, err := inputFS.ReadFile(hierarchyFilePath); err == nil {
+		hierarchy, _ = lintHierarchyFile(cfg, hierarchyFilePath, replaceVariables(string(content), variables))
+	}
+	return hierarchy
+}
+
+// watchedDirs returns the base path and the local directories of the hierarchy, see watchedLayers
+func watchedDirs(cfg config, variables map[string]string) []string {
+	dirs := []string{cfg.basePath}
+	seen := map[string]bool{cfg.basePath: true}
+	for _, includeLayer := range watchedLayers(cfg, variables) {
+		if !includeLayer.remote && !seen[includeLayer.path] {
+			seen[includeLayer.path] = true
+			dirs = append(dirs, includeLayer.path)
+		}
+	}
+	return dirs
+}
+
+// refetchedSources returns the entries of the remote sources of the hierarchy whose content can change,
+// which are fetched again every --remote.refresh-interval by --watch and serve, see watchedLayers.
+// Nothing is fetched with --offline, and entries pinned to a digest or commit never change.
+func refetchedSources(cfg config, variables map[string]string) []string {
+	entries := []string{}
+	if cfg.offline || cfg.remoteRefreshInterval <= 0 {
+		return entries
+	}
+	for _, includeLayer := range watchedLayers(cfg, variables) {
+		if includeLayer.remote && !isImmutableSource(includeLayer.path) {
+			entries = append(entries, includeLayer.path)
+		}
+	}
+	return entries
+}
+
+// watchedFiles returns the inputs of a merge: the hierarchy file, the files in the directories,
+// the passthrough files, and the schema, policy, and ownership files. All files written by the merge are left out,
+// so writing them does not trigger another merge.
+func watchedFiles(cfg config, dirs []string) []string {
+	hierarchyFilePath := filepath.Join(cfg.basePath, cfg.hierarchyFile)
+	paths := []string{hierarchyFilePath}
+	outputs := newOutputPaths(cfg, dirs)
+	skip := func(path string) bool {
+		return path == hierarchyFilePath || outputs.contains(path)
+	}
+	for _, dir := range dirs {
+		entries, err := inputFS.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !entry.IsDir() && !skip(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if cfg.passthrough != "none" {
+		for _, dir := range dirs {
+			for _, file := range collectPassthroughFiles([]layer{{path: dir}}) {
+				if !skip(file.path) {
+					paths = append(paths, file.path)
+				}
+			}
+		}
+	}
+	for _, file := range []string{cfg.schemaFile, cfg.cueSchema, cfg.policyPath, cfg.ownersFile} {
+		if len(file) > 0 {
+			paths = append(paths, file)
+		}
+	}
+	return paths
+}
+
+// replaceVariables replaces the given variables in a string, leaving all others to replaceEnvironmentVariables
+func replaceVariables(str string, variables map[string]string) string {
+	re := regexp.MustCompile(`\$\{([A-Za-z][][A-Za-z_0-9.]*)\}`)
+	return re.ReplaceAllStringFunc(str, func(varName string) string {
+		if value, ok := variables[strings.ToUpper(re.FindStringSubmatch(varName)[1])]; ok && len(value) > 0 {
+			return value
+		}
+		return varName
+	})
+}
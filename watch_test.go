@@ -0,0 +1,133 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/KohlsTechnology/hierarchy/internal/fsutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatchedFiles verifies that all inputs of a merge are watched, except the files written by it
+func TestWatchedFiles(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst":     {Data: []byte("../defaults\n../missing\n./\n")},
+		"base/app.yaml":          {Data: []byte("app: {}\n")},
+		"base/output.yaml":       {Data: []byte("app: {}\n")},
+		"base/sub/ignored.yaml":  {Data: []byte("app: {}\n")},
+		"defaults/defaults.yaml": {Data: []byte("app: {}\n")},
+	})
+	defer func() { inputFS = fsutil.OS }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+	cfg.outputFile = "base/output.yaml"
+	cfg.schemaFile = "schema.json"
+
+	dirs := watchedDirs(cfg, nil)
+	assert.Equal(t, []string{"base", "defaults"}, dirs)
+	assert.Equal(t, []string{
+		"base/hierarchy.lst",
+		"base/app.yaml",
+		"defaults/defaults.yaml",
+		"schema.json",
+	}, watchedFiles(cfg, dirs))
+}
+
+// TestWatchedFilesSkipOutputs verifies that no file written by a merge into a directory of the hierarchy is watched
+func TestWatchedFilesSkipOutputs(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst":          {Data: []byte("./\n")},
+		"base/app.yaml":               {Data: []byte("app: {}\n")},
+		"base/files/app.conf":         {Data: []byte("a=b\n")},
+		"base/app.conf":               {Data: []byte("a=b\n")},
+		"base/.output.yaml.tmp-12345": {Data: []byte("app: {}\n")},
+		"base/hierarchy.pid":          {Data: []byte("1\n")},
+		"base/hierarchy.log":          {Data: []byte("\n")},
+		"base/provenance.json":        {Data: []byte("{}\n")},
+		"base/app.yaml.blob":          {Data: []byte("blob\n")},
+	})
+	defer func() { inputFS = fsutil.OS }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+	cfg.outputFile = "base/output.yaml"
+	cfg.provenanceFile = "base/provenance.json"
+	cfg.pidFile = "base/hierarchy.pid"
+	cfg.logFile = "base/hierarchy.log"
+	cfg.passthrough = "copy"
+
+	dirs := watchedDirs(cfg, nil)
+	assert.Equal(t, []string{
+		"base/hierarchy.lst",
+		"base/app.yaml",
+		"base/app.yaml.blob",
+		"base/files/app.conf",
+	}, watchedFiles(cfg, dirs))
+
+	cfg.blobsThreshold = 1
+	cfg.blobsDir = "blobs"
+	outputs := newOutputPaths(cfg, dirs)
+	assert.True(t, outputs.contains("base/blobs/app.tls.keystore"))
+	assert.False(t, outputs.contains("base/blobs.yaml"))
+}
+
+// TestRefetchedSources verifies that remote sources are not watched as directories,
+// and only the ones which can change are fetched again
+func TestRefetchedSources(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst": {Data: []byte("https://example.invalid/config.yaml\n" +
+			"git::https://example.invalid/config.git?ref=0123456789abcdef0123456789abcdef01234567\n" +
+			"oci://example.invalid/config:v1\n" +
+			"oci://example.invalid/config@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n" +
+			"./\n")},
+	})
+	defer func() { inputFS = fsutil.OS }()
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+	cfg.remoteRefreshInterval = time.Minute
+
+	assert.Equal(t, []string{"base"}, watchedDirs(cfg, nil))
+	assert.Equal(t, []string{"https://example.invalid/config.yaml", "oci://example.invalid/config:v1"}, refetchedSources(cfg, nil))
+
+	cfg.offline = true
+	assert.Empty(t, refetchedSources(cfg, nil))
+	cfg.offline = false
+	cfg.remoteRefreshInterval = 0
+	assert.Empty(t, refetchedSources(cfg, nil))
+}
+
+// TestWatchedDirsVariables verifies that variables of a request take precedence over environment variables
+func TestWatchedDirsVariables(t *testing.T) {
+	inputFS = fsutil.FromFS(fstest.MapFS{
+		"base/hierarchy.lst":      {Data: []byte("../profiles/${profile}\n./\n")},
+		"profiles/prod/app.yaml":  {Data: []byte("app: {}\n")},
+		"profiles/stage/app.yaml": {Data: []byte("app: {}\n")},
+	})
+	defer func() { inputFS = fsutil.OS }()
+	t.Setenv("PROFILE", "stage")
+
+	cfg := cfgDefaults
+	cfg.basePath = "base"
+
+	assert.Equal(t, []string{"base", "profiles/stage"}, watchedDirs(cfg, nil))
+	assert.Equal(t, []string{"base", "profiles/prod"}, watchedDirs(cfg, map[string]string{"PROFILE": "prod"}))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// webhookTimeout limits how long the webhook may take to accept a notification
+var webhookTimeout = 10 * time.Second
+
+// webhookEvent notifies a webhook that the output file changed
+type webhookEvent struct {
+	Output         string    `json:"output"`
+	SHA256         string    `json:"sha256"`
+	PreviousSHA256 string    `json:"previousSha256,omitempty"`
+	Diff           string    `json:"diff"`
+	Time           time.Time `json:"time"`
+}
+
+// newWebhookEvent describes the change from the previous to the current content of the output file.
+// An empty previous content has no checksum.
+func newWebhookEvent(outputFile string, previous []byte, current []byte, now time.Time) webhookEvent {
+	event := webhookEvent{
+		Output: outputFile,
+		SHA256: checksum(current),
+		Diff:   unifiedDiff(outputFile, string(previous), string(current)),
+		Time:   now.UTC(),
+	}
+	if len(previous) > 0 {
+		event.PreviousSHA256 = checksum(previous)
+	}
+	return event
+}
+
+// checksum returns the hex-encoded SHA-256 checksum of content
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// sendWebhook posts the event as JSON, with additional headers given as 'Name: value'
+func sendWebhook(url string, headers []string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok {
+			return errors.Errorf("invalid webhook header %q, expected 'Name: value'", header)
+		}
+		request.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	client := http.Client{Timeout: webhookTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return errors.Errorf("webhook %s returned %s", url, response.Status)
+	}
+	return nil
+}
+
+// notifyOutputChange posts to the webhook if the output file differs from the previous content and returns the current content.
+// A missing output file, e.g. after a failed merge, is not a change. The webhook is not essential, so a failure is only logged.
+// If the merge resolved secrets, the diff with the secrets redacted by the merge is sent instead of the diff of the file.
+func notifyOutputChange(cfg config, previous []byte, childStats mergeStats) []byte {
+	current, err := os.ReadFile(cfg.outputFile)
+	if err != nil || bytes.Equal(previous, current) {
+		return previous
+	}
+	event := newWebhookEvent(cfg.outputFile, previous, current, time.Now())
+	if childStats.Secrets > 0 {
+		event.Diff = childStats.Diff
+	}
+	outputLog.Info("Output changed, notifying webhook", "url", cfg.webhookURL, "sha256", event.SHA256)
+	if err := sendWebhook(cfg.webhookURL, cfg.webhookHeaders, event); err != nil {
+		outputLog.Warn("Cannot notify webhook", "url", cfg.webhookURL, "error", err)
+	}
+	return current
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNotifyOutputChange verifies that the webhook is only notified if the output file changed
+func TestNotifyOutputChange(t *testing.T) {
+	events := []webhookEvent{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var event webhookEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	cfg := cfgDefaults
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.webhookURL = server.URL
+	cfg.webhookHeaders = []string{"Authorization: Bearer secret"}
+
+	// A missing output file is not a change
+	output := notifyOutputChange(cfg, nil, mergeStats{})
+	assert.Empty(t, events)
+
+	for _, content := range []string{"replicas: 2\n", "replicas: 2\n", "replicas: 3\n"} {
+		if err := os.WriteFile(cfg.outputFile, []byte(content), 0600); err != nil {
+			t.Fatalf("Error writing output file: %v", err)
+		}
+		output = notifyOutputChange(cfg, output, mergeStats{})
+	}
+	assert.Equal(t, "replicas: 3\n", string(output))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, cfg.outputFile, events[0].Output)
+		assert.Equal(t, "", events[0].PreviousSHA256)
+		assert.Equal(t, checksum([]byte("replicas: 2\n")), events[0].SHA256)
+		assert.Equal(t, events[0].SHA256, events[1].PreviousSHA256)
+		assert.Equal(t, checksum([]byte("replicas: 3\n")), events[1].SHA256)
+		assert.Equal(t, "--- "+cfg.outputFile+"\n+++ "+cfg.outputFile+"\n@@ -1 +1 @@\n-replicas: 2\n+replicas: 3\n", events[1].Diff)
+	}
+}
+
+// TestSendWebhookFailure verifies that invalid headers and rejected notifications are errors
+func TestSendWebhookFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	assert.Error(t, sendWebhook(server.URL, []string{"Authorization"}, webhookEvent{}))
+	assert.EqualError(t, sendWebhook(server.URL, nil, webhookEvent{}), "webhook "+server.URL+" returned 401 Unauthorized")
+}
+
+// TestNotifyOutputChangeSecrets verifies that the diff redacted by a merge resolving secrets is sent instead of the diff of the file
+func TestNotifyOutputChangeSecrets(t *testing.T) {
+	events := []webhookEvent{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	cfg := cfgDefaults
+	cfg.outputFile = filepath.Join(t.TempDir(), "output.yaml")
+	cfg.webhookURL = server.URL
+	if err := os.WriteFile(cfg.outputFile, []byte("password: s3cr3t\n"), 0600); err != nil {
+		t.Fatalf("Error writing output file: %v", err)
+	}
+	diff := "--- " + cfg.outputFile + "\n+++ " + cfg.outputFile + "\n@@ -0,0 +1 @@\n+password: <redacted>\n"
+	notifyOutputChange(cfg, nil, mergeStats{Secrets: 1, Diff: diff})
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, diff, events[0].Diff)
+		assert.Equal(t, checksum([]byte("password: s3cr3t\n")), events[0].SHA256)
+	}
+}
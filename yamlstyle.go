@@ -0,0 +1,155 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlStyle configures how YAML output is encoded, see the --yaml.* flags
+type yamlStyle struct {
+	// indent is the number of spaces per indentation level
+	indent int
+	// lineWidth wraps strings longer than it as folded block scalars, 0 never wraps
+	lineWidth int
+	// quote is the style of strings, 'auto' quotes only where needed, 'single' or 'double' quotes all
+	quote string
+	// sequences is the style of lists, 'block' or 'flow'
+	sequences string
+}
+
+// outputStyle is the style of the YAML output
+var outputStyle = yamlStyle{indent: 4, quote: "auto", sequences: "block"}
+
+// marshal encodes data, or a YAML node of it, in the style
+func (s yamlStyle) marshal(data interface{}) ([]byte, error) {
+	node, ok := data.(*yaml.Node)
+	if !ok {
+		node = &yaml.Node{}
+		if err := node.Encode(data); err != nil {
+			return nil, err
+		}
+	}
+	folded := map[string]bool{}
+	s.apply(node, folded)
+
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(s.indent)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	if len(folded) == 0 {
+		return content.Bytes(), nil
+	}
+	return []byte(s.wrapFolded(content.String(), folded)), nil
+}
+
+// apply sets the style of the strings and lists below a node, and collects the strings written as folded block scalars
+func (s yamlStyle) apply(node *yaml.Node, folded map[string]bool) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.MappingNode:
+		for i, child := range node.Content {
+			// Keys keep their style
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			s.apply(child, folded)
+		}
+	case yaml.SequenceNode:
+		if s.sequences == "flow" {
+			node.Style |= yaml.FlowStyle
+			// Block scalars cannot be written in flow style
+			s.lineWidth = 0
+		}
+		for _, child := range node.Content {
+			s.apply(child, folded)
+		}
+	case yaml.ScalarNode:
+		if node.Tag != "!!str" || node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			return
+		}
+		if s.isFoldable(node.Value) {
+			node.Style = yaml.FoldedStyle
+			folded[node.Value] = true
+			return
+		}
+		switch s.quote {
+		case "single":
+			node.Style = yaml.SingleQuotedStyle
+		case "double":
+			node.Style = yaml.DoubleQuotedStyle
+		}
+	}
+}
+
+// isFoldable reports whether a string is longer than the line width and can be wrapped at its spaces.
+// Folded block scalars join lines with a single space, so the string must be one line with single spaces between words.
+func (s yamlStyle) isFoldable(value string) bool {
+	return s.lineWidth > 0 && len(value) > s.lineWidth &&
+		strings.Contains(value, " ") &&
+		!strings.ContainsAny(value, "\n\t\r") &&
+		!strings.Contains(value, "  ") &&
+		strings.TrimSpace(value) == value
+}
+
+// wrapFolded wraps the content lines of the folded block scalars of the strings at spaces to the line width.
+// The encoder writes every folded string on one line, indented below the line ending in '>' or '>-'.
+func (s yamlStyle) wrapFolded(content string, folded map[string]bool) string {
+	lines := strings.SplitAfter(content, "\n")
+	var wrapped strings.Builder
+	for i, line := range lines {
+		text := strings.TrimRight(line, "\n")
+		value := strings.TrimLeft(text, " ")
+		if i == 0 || !folded[value] || !isFoldedHeader(lines[i-1]) {
+			wrapped.WriteString(line)
+			continue
+		}
+		indent := text[:len(text)-len(value)]
+		width := len(indent)
+		for j, word := range strings.Split(value, " ") {
+			switch {
+			case j == 0:
+				wrapped.WriteString(indent + word)
+			case width+1+len(word) > s.lineWidth:
+				wrapped.WriteString("\n" + indent + word)
+				width = len(indent)
+			default:
+				wrapped.WriteString(" " + word)
+				width++
+			}
+			width += len(word)
+		}
+		wrapped.WriteString(line[len(text):])
+	}
+	return wrapped.String()
+}
+
+// isFoldedHeader reports whether a line starts a folded block scalar, ignoring a comment after the indicator
+func isFoldedHeader(line string) bool {
+	line = strings.TrimRight(line, "\n")
+	if comment := strings.Index(line, " #"); comment >= 0 {
+		line = line[:comment]
+	}
+	return strings.HasSuffix(line, ">") || strings.HasSuffix(line, ">-")
+}
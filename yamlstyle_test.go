@@ -0,0 +1,85 @@
+/*
+Copyright 2021 Kohl's Department Stores, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestYamlStyleMarshal verifies the encoder options of the YAML output
+func TestYamlStyleMarshal(t *testing.T) {
+	data := map[string]interface{}{
+		"name":  "demo",
+		"ports": []interface{}{80, 443},
+		"note":  "the quick brown fox jumps over the lazy dog",
+	}
+	tests := []struct {
+		name     string
+		style    yamlStyle
+		expected string
+	}{
+		{
+			name:     "defaults",
+			style:    yamlStyle{indent: 4, quote: "auto", sequences: "block"},
+			expected: "name: demo\nnote: the quick brown fox jumps over the lazy dog\nports:\n    - 80\n    - 443\n",
+		},
+		{
+			name:     "indent",
+			style:    yamlStyle{indent: 2, quote: "auto", sequences: "block"},
+			expected: "name: demo\nnote: the quick brown fox jumps over the lazy dog\nports:\n  - 80\n  - 443\n",
+		},
+		{
+			name:     "single quotes",
+			style:    yamlStyle{indent: 4, quote: "single", sequences: "block"},
+			expected: "name: 'demo'\nnote: 'the quick brown fox jumps over the lazy dog'\nports:\n    - 80\n    - 443\n",
+		},
+		{
+			name:     "double quotes",
+			style:    yamlStyle{indent: 4, quote: "double", sequences: "block"},
+			expected: "name: \"demo\"\nnote: \"the quick brown fox jumps over the lazy dog\"\nports:\n    - 80\n    - 443\n",
+		},
+		{
+			name:     "flow sequences",
+			style:    yamlStyle{indent: 4, quote: "auto", sequences: "flow"},
+			expected: "name: demo\nnote: the quick brown fox jumps over the lazy dog\nports: [80, 443]\n",
+		},
+		{
+			name:     "line width",
+			style:    yamlStyle{indent: 2, lineWidth: 20, quote: "auto", sequences: "block"},
+			expected: "name: demo\nnote: >-\n  the quick brown\n  fox jumps over the\n  lazy dog\nports:\n  - 80\n  - 443\n",
+		},
+		{
+			name:     "flow sequences and line width",
+			style:    yamlStyle{indent: 2, lineWidth: 20, quote: "auto", sequences: "flow"},
+			expected: "name: demo\nnote: >-\n  the quick brown\n  fox jumps over the\n  lazy dog\nports: [80, 443]\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := test.style.marshal(data)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(content))
+
+			var parsed map[string]interface{}
+			assert.NoError(t, yaml.Unmarshal(content, &parsed))
+			assert.Equal(t, data["note"], parsed["note"])
+		})
+	}
+}